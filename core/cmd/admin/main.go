@@ -0,0 +1,149 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Guilospanck/pqc/core/pkg/admin"
+)
+
+// Operates a running server through its admin API (see the -admin-addr
+// flag of the server)
+
+const usage = `Usage: pqc-admin [-addr http://127.0.0.1:8081] [-token admin.token] <command>
+
+Commands:
+  connections                           lists the connected devices
+  stats                                 shows what the server is doing
+  kick <username> [reason]              disconnects a user from the room
+  ban [-ip] <username> [reason]         bans a user (and its addresses) from the room
+  unban <username>                      lifts the ban of a user
+  announce <text>                       sends a message to everyone connected
+  drain [-disconnect]                   refuses new connections (and closes the others)
+  resume                                accepts new connections again
+
+The token can also be given in PQC_ADMIN_TOKEN.
+`
+
+func main() {
+	addr := flag.String("addr", "http://127.0.0.1:8081", "address of the admin API")
+	tokenFile := flag.String("token", "admin.token", "file with the admin token of the server")
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	token := os.Getenv("PQC_ADMIN_TOKEN")
+	if token == "" {
+		data, err := os.ReadFile(*tokenFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: could not read the admin token: %s\n", err.Error())
+			os.Exit(1)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+
+	client := admin.NewClient(*addr, token)
+
+	var err error
+	switch command, args := flag.Arg(0), flag.Args()[1:]; command {
+	case "connections":
+		err = connections(client)
+	case "stats":
+		err = stats(client)
+	case "kick", "unban":
+		action := userAction(args)
+		if command == "kick" {
+			err = client.Kick(action)
+		} else {
+			err = client.Unban(action)
+		}
+	case "ban":
+		flags := flag.NewFlagSet("ban", flag.ExitOnError)
+		ip := flags.Bool("ip", false, "ban the addresses the user is connected from too")
+		flags.Parse(args)
+		action := userAction(flags.Args())
+		action.IP = *ip
+		err = client.Ban(action)
+	case "announce":
+		if len(args) == 0 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		err = client.Announce(strings.Join(args, " "))
+	case "drain":
+		flags := flag.NewFlagSet("drain", flag.ExitOnError)
+		disconnect := flags.Bool("disconnect", false, "close the connections there are")
+		flags.Parse(args)
+		err = client.Drain(admin.Drain{Disconnect: *disconnect})
+	case "resume":
+		err = client.Resume()
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+// <username> [reason]
+func userAction(args []string) admin.UserAction {
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	return admin.UserAction{Username: args[0], Reason: strings.Join(args[1:], " ")}
+}
+
+func connections(client *admin.Client) error {
+	all, err := client.Connections()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "USERNAME\tDEVICE\tADDRESS\tSINCE\tPRESENCE\tREGISTERED\tIDENTITY KEY\tEXCHANGE KEY")
+	for _, c := range all {
+		identity := c.IdentityKey
+		if identity == "" {
+			identity = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%t\t%s\t%s\n", c.Username, c.Device, c.RemoteAddr, c.Since.Local().Format(time.DateTime), c.Presence, c.Registered, identity, c.ExchangeKey)
+	}
+
+	return w.Flush()
+}
+
+func stats(client *admin.Client) error {
+	s, err := client.Stats()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Started\t%s (up %s)\n", s.Started.Local().Format(time.DateTime), s.Uptime)
+	fmt.Fprintf(w, "Connections\t%d (%d opened since started)\n", s.Connections, s.ConnectionsOpened)
+	fmt.Fprintf(w, "Users online\t%d\n", s.UsersOnline)
+	fmt.Fprintf(w, "Usernames held\t%d\n", s.Accounts)
+	fmt.Fprintf(w, "Registered users\t%d\n", s.Registered)
+	fmt.Fprintf(w, "Messages relayed\t%d\n", s.MessagesRelayed)
+	fmt.Fprintf(w, "Key log size\t%d\n", s.KeyLogSize)
+	fmt.Fprintf(w, "Draining\t%t\n", s.Draining)
+	fmt.Fprintf(w, "FIPS\t%t\n", s.FIPS)
+	for _, room := range s.Rooms {
+		fmt.Fprintf(w, "Room %s\tepoch %d, %d devices, %d banned, %d muted, invite-only %t, topic %q\n", room.Name, room.Epoch, room.Members, room.Banned, room.Muted, room.InviteOnly, room.Topic)
+	}
+
+	return w.Flush()
+}
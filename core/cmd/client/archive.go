@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Guilospanck/pqc/core/pkg/archive"
+	"github.com/Guilospanck/pqc/core/pkg/types"
+	"github.com/Guilospanck/pqc/core/pkg/ui"
+)
+
+// Local, encrypted history of the chat. Until the user unlocks it with
+// its passphrase, changes (new messages, edits and deletions) are kept in
+// memory and saved once it is unlocked.
+type localHistory struct {
+	path    string
+	archive *archive.Archive
+	pending []func(a *archive.Archive) error
+	mu      sync.Mutex
+}
+
+func newLocalHistory(path string) *localHistory {
+	return &localHistory{path: path, pending: make([]func(a *archive.Archive) error, 0)}
+}
+
+// Date format of the `since:` and `until:` search filters
+const searchDateFormat = "2006-01-02"
+
+// Keeps the chat messages emitted to the UI, and their edits
+func (client *WSClient) recordToHistory(msg ui.UIMessage) {
+	if msg.Message == nil {
+		return
+	}
+	payload := *msg.Message
+
+	switch msg.Type {
+	case types.MessageTypeMessage, types.MessageTypeMail, types.MessageTypeOwnMessage, types.MessageTypeDirectMessage:
+		entry := archive.Entry{ID: payload.ID, Kind: msg.Type, From: payload.Sender, Text: payload.Body, Time: payload.Timestamp}
+		client.changeHistory(func(a *archive.Archive) error { return a.Add(entry) })
+
+	case types.MessageTypeMessageEdited:
+		client.changeHistory(func(a *archive.Archive) error { return a.Edit(payload.Target, payload.Body) })
+
+	case types.MessageTypeMessageDeleted:
+		client.changeHistory(func(a *archive.Archive) error { return a.Delete(payload.Target) })
+	}
+}
+
+// Messages typed by the user aren't emitted to the UI, we keep them here
+func (client *WSClient) recordSentMessage(payload types.ChatMessage) {
+	if client.history == nil {
+		return
+	}
+
+	entry := archive.Entry{ID: payload.ID, Kind: "sent", From: payload.Sender, Text: payload.Body, Time: payload.Timestamp}
+	client.changeHistory(func(a *archive.Archive) error { return a.Add(entry) })
+}
+
+func (client *WSClient) changeHistory(change func(a *archive.Archive) error) {
+	h := client.history
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.archive == nil {
+		h.pending = append(h.pending, change)
+		return
+	}
+
+	client.applyHistoryChange(h.archive, change)
+}
+
+func (client *WSClient) applyHistoryChange(a *archive.Archive, change func(a *archive.Archive) error) {
+	// The message may be older than the history (or than what it keeps)
+	if err := change(a); err != nil && !errors.Is(err, archive.ErrNotFound) {
+		log.Printf("[%s] Could not save to the history: %s\n", client.conn.Metadata.Username, err.Error())
+	}
+}
+
+func (client *WSClient) unlockHistory(passphrase string) {
+	if client.history == nil {
+		ui.EmitToUI(types.MessageTypeError, "No history file: start the client with -history <file>.", ALERT_COLOR)
+		return
+	}
+
+	h := client.history
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.archive != nil {
+		ui.EmitToUI(types.MessageTypeError, "The history is already unlocked.", ALERT_COLOR)
+		return
+	}
+
+	a, err := archive.Open(h.path, passphrase)
+	if err != nil {
+		if !errors.Is(err, archive.ErrWrongPassphrase) {
+			log.Printf("[%s] Could not open history: %s\n", client.conn.Metadata.Username, err.Error())
+		}
+		ui.EmitToUI(types.MessageTypeError, "Could not unlock the history: "+err.Error(), ALERT_COLOR)
+		return
+	}
+
+	for _, change := range h.pending {
+		client.applyHistoryChange(a, change)
+	}
+	h.pending = nil
+	h.archive = a
+
+	ui.EmitToUI(types.MessageTypeHistoryUnlocked, strconv.Itoa(a.Len()), "")
+}
+
+func (client *WSClient) searchHistory(query archive.Query) {
+	var a *archive.Archive
+	if client.history != nil {
+		client.history.mu.Lock()
+		a = client.history.archive
+		client.history.mu.Unlock()
+	}
+
+	if a == nil {
+		ui.EmitToUI(types.MessageTypeError, "The history is locked: unlock it with /unlock <passphrase>.", ALERT_COLOR)
+		return
+	}
+
+	results, err := json.Marshal(a.Search(query))
+	if err != nil {
+		log.Printf("[%s] Could not marshal search results: %s\n", client.conn.Metadata.Username, err.Error())
+		return
+	}
+
+	ui.EmitToUI(types.MessageTypeHistoryResults, string(results), "")
+}
+
+// Parses the arguments of /search: `from:<username>`, `since:<date>`,
+// `until:<date>` and `page:<n>` filters, everything else is searched for.
+func parseSearch(args string) (archive.Query, error) {
+	var query archive.Query
+	words := make([]string, 0)
+
+	for _, token := range splitQuoted(args) {
+		filter, value, _ := strings.Cut(token, ":")
+
+		switch filter {
+		case "from":
+			query.From = value
+
+		case "since", "until":
+			date, err := time.ParseInLocation(searchDateFormat, value, time.Local)
+			if err != nil {
+				return query, fmt.Errorf("invalid date %q (expected YYYY-MM-DD)", value)
+			}
+			if filter == "since" {
+				query.Since = date
+			} else {
+				// Until the end of that day
+				query.Until = date.AddDate(0, 0, 1)
+			}
+
+		case "page":
+			page, err := strconv.Atoi(value)
+			if err != nil || page < 1 {
+				return query, fmt.Errorf("invalid page %q", value)
+			}
+			query.Page = page
+
+		default:
+			words = append(words, token)
+		}
+	}
+
+	query.Text = strings.Join(words, " ")
+	return query, nil
+}
+
+// Splits on spaces, except inside double quotes (which are removed):
+// `from:"Amazing Koala" hi` -> [`from:Amazing Koala`, `hi`]
+func splitQuoted(args string) []string {
+	tokens := make([]string, 0)
+	var current strings.Builder
+	quoted := false
+
+	for _, r := range args {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == ' ' && !quoted:
+			if current.Len() > 0 {
+				tokens = append(tokens, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+
+	if current.Len() > 0 {
+		tokens = append(tokens, current.String())
+	}
+
+	return tokens
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"log"
+
+	"github.com/Guilospanck/pqc/core/pkg/cryptography"
+	"github.com/Guilospanck/pqc/core/pkg/mnemonic"
+	"github.com/Guilospanck/pqc/core/pkg/types"
+	"github.com/Guilospanck/pqc/core/pkg/ui"
+)
+
+// Shows the recovery phrase of our identity seed, from which
+// the identity keys can be derived again on another machine.
+func (client *WSClient) exportIdentity() {
+	if client.conn.Keys.Seed == nil {
+		ui.EmitToUI(types.MessageTypeError, "There is no identity to export yet.", ALERT_COLOR)
+		return
+	}
+
+	phrase, err := mnemonic.Encode(client.conn.Keys.Seed)
+	if err != nil {
+		log.Printf("[%s] Could not encode recovery phrase: %s\n", client.conn.Metadata.Username, err.Error())
+		return
+	}
+
+	ui.EmitToUI(types.MessageTypeRecoveryPhrase, phrase, ALERT_COLOR)
+}
+
+// Replaces our identity keys with the ones derived from a recovery phrase.
+// If we are connected, we reconnect so the server (and everyone else)
+// learns the restored keys.
+//
+// Only the keys come back: on another machine we are a new device, without
+// the reconnect token of the lost one. To be the same user again, it has to
+// log in, or be approved by another device of the user. A guest whose only
+// device was lost can't get its username back.
+func (client *WSClient) restoreIdentity(phrase string) {
+	seed, err := mnemonic.Decode(phrase)
+	if err != nil {
+		ui.EmitToUI(types.MessageTypeError, "Invalid recovery phrase: "+err.Error(), ALERT_COLOR)
+		return
+	}
+
+	keys, err := cryptography.KeysFromSeed(seed)
+	if err != nil {
+		log.Printf("[%s] Could not derive keys from recovery phrase: %s\n", client.conn.Metadata.Username, err.Error())
+		return
+	}
+
+	client.conn.Keys = keys
+	// Prekeys are signed by the identity, so they have to be created again
+	client.keyRing = nil
+	client.saveIdentity()
+
+	log.Printf("[%s] Identity restored from recovery phrase\n", client.conn.Metadata.Username)
+	ui.EmitToUI(types.MessageTypeIdentityRestored, client.conn.Metadata.Username, "")
+
+	client.accountMu.Lock()
+	loggedIn := client.credentials != nil
+	client.accountMu.Unlock()
+	if !loggedIn {
+		ui.EmitToUI(types.MessageTypeError, "Only the keys were restored: to come back as the user you were, /login to your account or have another of its devices approve this one (-link).", ALERT_COLOR)
+	}
+
+	if client.isConnected {
+		client.conn.Conn.Close()
+	}
+}
@@ -2,15 +2,31 @@ package main
 
 import (
 	"context"
+	"crypto/mlkem"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"math/rand/v2"
 	"net/http"
 	"os"
 	"slices"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/Guilospanck/pqc/core/pkg/chat"
 	"github.com/Guilospanck/pqc/core/pkg/cryptography"
+	"github.com/Guilospanck/pqc/core/pkg/devices"
+	"github.com/Guilospanck/pqc/core/pkg/group"
+	"github.com/Guilospanck/pqc/core/pkg/keystore"
+	"github.com/Guilospanck/pqc/core/pkg/pqxdh"
+	"github.com/Guilospanck/pqc/core/pkg/sas"
+	"github.com/Guilospanck/pqc/core/pkg/transparency"
+	"github.com/Guilospanck/pqc/core/pkg/transport"
 	"github.com/Guilospanck/pqc/core/pkg/types"
 	"github.com/Guilospanck/pqc/core/pkg/ui"
 	"github.com/Guilospanck/pqc/core/pkg/ws"
@@ -26,14 +42,71 @@ type WSClient struct {
 	cancelFunc      context.CancelFunc
 	isConnected     bool
 	deadLetterQueue chan string // we save non-delivered non-encrypted messages here
+	keyMonitor      *transparency.Monitor
+	keyRing         *pqxdh.KeyRing
+	pendingMail     map[string][]pendingMessage // messages waiting for the prekey bundles of their recipient
+	mailMu          sync.Mutex
+	group           *group.Group
+	groupKeyPackage *group.KeyPackage // while waiting to be added to the group
+	groupLeafKey    *mlkem.DecapsulationKey768
+	groupMu         sync.Mutex
+	verifications   map[string]*sas.Session // ongoing key verifications, by transaction id
+	verifyMu        sync.Mutex
+	sealedSender    bool                         // hide from the server who sends our prekey messages
+	ownPublication  *transparency.KeyPublication // our keys in the key log, proves who we are in sealed messages
+	tlsConfig       *tls.Config                  // connect with wss:// if set
+	history         *localHistory                // nil if the user doesn't keep a history
+	readReceipts    bool                         // tell senders when the user saw their messages
+	unread          *messageIndex[string]        // senders of the messages the user didn't see yet
+	known           *messageIndex[knownMessage]  // messages that can still be edited or deleted
+	lastSent        atomic.Value                 // id of the last message we sent to the room
+	lastReceived    atomic.Value                 // id of the last message someone else sent to the room
+	reactions       *messageIndex[reactionSet]
+	reactionsMu     sync.Mutex
+	presence        types.Presence // our status
+	autoAway        bool           // set away because the user was idle, not by the user
+	lastActivity    time.Time
+	awayAfter       time.Duration // idle time before being set away, 0 to never be
+	presenceMu      sync.Mutex
+	typingSent      time.Time   // when we last told others we are typing, zero if we aren't
+	typingStop      *time.Timer // tells others we stopped typing
+	typingMu        sync.Mutex
+	reconnectToken  string // proves who we are when connecting again
+	invite          string // gets us into a private server, only shown until it does
+	loginRequired   bool   // the server only lets us register or log in
+	serverHistory   bool   // the server keeps a history of the room, from readable copies of our messages
+	noHistoryCopies bool   // never send those copies, the server then can't replay our messages
+	tokenMu         sync.Mutex
+	credentials     *types.Credentials // of the user we logged in as, if any
+	pendingLogin    *types.Credentials // sent, waiting for the server to answer
+	accountMu       sync.Mutex
+	rooms           map[string]types.RoomInfo // topic, roles and mutes, kept up to date
+	roomsMu         sync.Mutex
+	retryAfter      atomic.Int64    // how long the server going away asked us to wait, in seconds
+	reconnectDelay  atomic.Int64    // replaces the backoff of the next reconnect, if set
+	reconnectNow    atomic.Bool     // skips the backoff of the next reconnect
+	identity        *keystore.Store // keeps this device across restarts, if set
+	identityMu      sync.Mutex
 }
 
 func NewClient() *WSClient {
+	conn := ws.NewEmptyConnection()
+	conn.Metadata.Device = devices.NewID()
+
 	return &WSClient{
-		conn:            ws.NewEmptyConnection(),
+		conn:            conn,
 		reconnect:       make(chan struct{}, 1),
 		isConnected:     false,
 		deadLetterQueue: make(chan string, 10),
+		keyMonitor:      transparency.NewMonitor(),
+		pendingMail:     make(map[string][]pendingMessage),
+		verifications:   make(map[string]*sas.Session),
+		unread:          newMessageIndex[string](UNREAD_MESSAGES_KEPT),
+		known:           newMessageIndex[knownMessage](KNOWN_MESSAGES_KEPT),
+		reactions:       newMessageIndex[reactionSet](KNOWN_MESSAGES_KEPT),
+		presence:        types.Presence{Status: types.PresenceOnline},
+		lastActivity:    time.Now(),
+		rooms:           make(map[string]types.RoomInfo),
 	}
 }
 
@@ -63,8 +136,14 @@ func (client *WSClient) connectionManager() {
 			return
 		}
 
-		// exponential backoff
+		// exponential backoff, unless the server told us when to come back
 		wait := time.Duration(1<<attempts) * time.Second
+		if delay := client.reconnectDelay.Swap(0); delay > 0 {
+			wait = time.Duration(delay)
+		}
+		if client.reconnectNow.Swap(false) {
+			wait = 0
+		}
 		time.Sleep(wait)
 
 		log.Printf("Attempt #%d/5 to reconnect to server\n", attempts)
@@ -80,6 +159,9 @@ func (client *WSClient) connectionManager() {
 
 func (client *WSClient) connectToWSServer() error {
 	url := "ws://localhost:8080/ws"
+	if client.tlsConfig != nil {
+		url = "wss://localhost:8080/ws"
+	}
 	log.Printf("Connecting to %s\n", url)
 
 	// Reset connection channels because
@@ -91,16 +173,83 @@ func (client *WSClient) connectToWSServer() error {
 		requestHeader.Set("username", client.conn.Metadata.Username)
 		requestHeader.Set("color", client.conn.Metadata.Color)
 	}
+	requestHeader.Set("device", client.conn.Metadata.Device)
+	client.tokenMu.Lock()
+	if client.reconnectToken != "" {
+		requestHeader.Set("reconnect-token", client.reconnectToken)
+	}
+	if client.invite != "" {
+		requestHeader.Set("invite", client.invite)
+	}
+	client.tokenMu.Unlock()
+	if cryptography.FIPS() {
+		requestHeader.Set("fips", "on")
+	}
+
+	// Only a device being added to an existing user doesn't know its color yet.
+	// The server holds the dial until another device approves it.
+	if client.conn.Metadata.Color == "" && client.conn.Metadata.Username != "" {
+		ui.EmitToUI(types.MessageTypeDevicePending, client.conn.Metadata.Device, "")
+	}
 
-	conn, res, err := websocket.DefaultDialer.Dial(url, requestHeader)
+	dialer := *websocket.DefaultDialer
+	dialer.TLSClientConfig = client.tlsConfig
+
+	conn, res, err := dialer.Dial(url, requestHeader)
 	if err != nil {
 		log.Printf("Dial error: %s\n", err.Error())
+
+		// Refused (a device that is already connected can try again later,
+		// in case its session there is gone)
+		if res != nil && (res.StatusCode == http.StatusForbidden || res.StatusCode == http.StatusConflict) {
+			// An invite refused once won't be accepted later
+			client.tokenMu.Lock()
+			client.invite = ""
+			client.tokenMu.Unlock()
+
+			reason, _ := io.ReadAll(res.Body)
+			ui.EmitToUI(types.MessageTypeError, strings.TrimSpace(string(reason)), ALERT_COLOR)
+		}
+
+		// Asked for approval too often, it will try again later
+		if res != nil && res.StatusCode == http.StatusTooManyRequests {
+			reason, _ := io.ReadAll(res.Body)
+			ui.EmitToUI(types.MessageTypeError, strings.TrimSpace(string(reason)), ALERT_COLOR)
+		}
+
+		var certErr *tls.CertificateVerificationError
+		if errors.As(err, &certErr) {
+			ui.EmitToUI(types.MessageTypeError, "Could not verify the certificate of the server: "+certErr.Err.Error(), ALERT_COLOR)
+		}
 		return err
 	}
+	// A server that doesn't know about FIPS mode would accept us anyway
+	if cryptography.FIPS() && res.Header.Get("fips") != "on" {
+		conn.Close()
+		ui.EmitToUI(types.MessageTypeError, "The server is not in FIPS mode.", ALERT_COLOR)
+		return errors.New("server not in FIPS mode")
+	}
+
+	// We are in, the reconnect token is enough from now on
+	private := res.Header.Get("private") == "on"
+	if !private {
+		client.tokenMu.Lock()
+		client.invite = ""
+		client.tokenMu.Unlock()
+	}
+
 	client.conn.Conn = conn
 	client.isConnected = true
 	log.Println("Dialing to WS server completed successfully!")
 
+	if cryptography.FIPS() {
+		ui.EmitToUI(types.MessageTypeFIPS, cryptography.FIPSStatus(), "")
+	}
+
+	if client.tlsConfig != nil {
+		client.reportTransport()
+	}
+
 	client.attempts.Store(1)
 
 	// Start a new context
@@ -121,7 +270,22 @@ func (client *WSClient) connectToWSServer() error {
 
 	username := res.Header.Get("username")
 	color := res.Header.Get("color")
-	client.conn.Metadata = ws.WSMetadata{Username: username, Color: color}
+	device := res.Header.Get("device")
+	if previous := client.conn.Metadata.Device; previous != device {
+		ui.EmitToUI(types.MessageTypeError, fmt.Sprintf("Device %s is already connected from somewhere else, this one is now device %s.", previous, device), ALERT_COLOR)
+	}
+	// The server didn't believe we are who we were before (nobody knew who we
+	// were while we had to log in)
+	if requested := client.conn.Metadata; !client.loginRequired && requested.Color != "" && requested.Username != "" && requested.Username != username {
+		ui.EmitToUI(types.MessageTypeError, fmt.Sprintf("Could not reconnect as %s, you are now %s.", requested.Username, username), ALERT_COLOR)
+	}
+	client.loginRequired = res.Header.Get("login-required") == "on"
+	serverHistory := res.Header.Get("history") == "on"
+	if serverHistory && !client.serverHistory && !client.noHistoryCopies {
+		ui.EmitToUI(types.MessageTypeError, "This server keeps a history of the room: it can read the messages you send to it.", ALERT_COLOR)
+	}
+	client.serverHistory = serverHistory
+	client.conn.Metadata = ws.WSMetadata{Username: username, Color: color, Device: device}
 	// Tell UI we're connected with some username and color
 	ui.EmitToUI(types.MessageTypeConnected, username, color)
 
@@ -132,6 +296,7 @@ func (client *WSClient) connectToWSServer() error {
 			return nil
 		}
 	}
+	client.saveIdentity()
 
 	if err := client.exchangeKeys(); err != nil {
 		// If error while exchanging keys, we don't try to reconnect to the server,
@@ -142,11 +307,47 @@ func (client *WSClient) connectToWSServer() error {
 	// Wait for the keys to be exchanged before proceeding.
 	<-client.conn.KeysExchanged
 
+	client.logInAgain(username)
+
+	// Nothing else to do until the user registers or logs in
+	if client.loginRequired {
+		if private {
+			ui.EmitToUI(types.MessageTypeError, "This server is private: /invite <code>, or /login <username> <password>.", ALERT_COLOR)
+		} else {
+			ui.EmitToUI(types.MessageTypeError, "This server only accepts registered users: /register <username> <password> or /login <username> <password>.", ALERT_COLOR)
+		}
+		return nil
+	}
+
+	if err := client.uploadPrekeys(); err != nil {
+		// We can still chat without prekeys, we just can't receive messages while offline
+		log.Printf("[%s] Could not upload prekeys: %s\n", client.conn.Metadata.Username, err.Error())
+	}
+
+	if err := client.joinGroup(); err != nil {
+		// Messages are relayed by the server until we are in the group
+		log.Printf("[%s] Could not join group: %s\n", client.conn.Metadata.Username, err.Error())
+	}
+
+	client.restorePresence()
+
 	client.drainDLQ()
 
 	return nil
 }
 
+// Tells the UI how the connection to the server is protected
+func (client *WSClient) reportTransport() {
+	description, err := transport.Describe(client.conn.Conn.NetConn())
+	if err != nil {
+		log.Printf("Could not get the TLS connection state: %s\n", err.Error())
+		return
+	}
+
+	log.Printf("Connection secured with %s\n", description)
+	ui.EmitToUI(types.MessageTypeTransport, description, "")
+}
+
 // If there are any messages in the client's dead-letter queue (DLQ),
 // we send them to the server.
 func (client *WSClient) drainDLQ() {
@@ -196,6 +397,31 @@ func (client *WSClient) readAndHandleServerMessages() {
 		msg, err := client.conn.ReadMessage()
 		if err != nil {
 			log.Printf("[%s] Error reading from conn: %s\n", client.conn.Metadata.Username, err.Error())
+
+			// Connecting again would end the session that replaced this one,
+			// a ban keeps us out of the room anyway, and a removed device
+			// has to be approved again
+			var closeErr *websocket.CloseError
+			if errors.As(err, &closeErr) && slices.Contains([]int{ws.CloseSessionReplaced, ws.CloseBanned, ws.CloseDeviceRemoved}, closeErr.Code) {
+				client.stayDisconnected(closeErr.Text)
+				return
+			}
+
+			// A kick only removes us for now, we come back a bit later
+			if errors.As(err, &closeErr) && closeErr.Code == ws.CloseKicked {
+				client.kicked(closeErr.Text)
+			}
+
+			// We changed our username, we come back under the new one
+			if errors.As(err, &closeErr) && closeErr.Code == ws.CloseRenamed {
+				client.renamed()
+			}
+
+			// The server is shutting down (or draining)
+			if errors.As(err, &closeErr) && closeErr.Code == websocket.CloseGoingAway {
+				client.serverGoingAway()
+			}
+
 			client.triggerReconnect()
 			return
 		}
@@ -205,7 +431,7 @@ func (client *WSClient) readAndHandleServerMessages() {
 			log.Printf("[%s] Error unmarshalling message: %s\n", client.conn.Metadata.Username, err.Error())
 			continue
 		}
-		client.conn.HandleServerMessage(msgJson)
+		client.handleServerMessage(msgJson)
 
 		select {
 		case <-client.ctx.Done():
@@ -216,6 +442,131 @@ func (client *WSClient) readAndHandleServerMessages() {
 	}
 }
 
+func (client *WSClient) handleServerMessage(msg ws.WSMessage) {
+	switch msg.Type {
+	case types.MessageTypeKeyPublished:
+		client.handleKeyPublished(msg)
+	case types.MessageTypeKeyLogTreeHead:
+		client.handleKeyLogTreeHead(msg)
+	case types.MessageTypeKeyLogConsistency:
+		client.handleKeyLogConsistency(msg)
+	case types.MessageTypePrekeysLow:
+		client.handlePrekeysLow(msg)
+	case types.MessageTypePrekeyBundle:
+		client.handlePrekeyBundle(msg)
+	case types.MessageTypePrekeyMessage:
+		client.handlePrekeyMessage(msg)
+	case types.MessageTypeSealedMessage:
+		client.handleSealedMessage(msg)
+	case types.MessageTypeGroupCreate:
+		client.handleGroupCreate(msg)
+	case types.MessageTypeGroupWelcome:
+		client.handleGroupWelcome(msg)
+	case types.MessageTypeGroupProposals:
+		client.handleGroupProposals(msg)
+	case types.MessageTypeGroupCommit:
+		client.handleGroupCommit(msg)
+	case types.MessageTypeGroupCommitRejected:
+		client.handleGroupCommitRejected(msg)
+	case types.MessageTypeGroupMessage:
+		client.handleGroupMessage(msg)
+	case types.MessageTypeDeviceApprovalRequest:
+		client.handleDeviceApprovalRequest(msg)
+	case types.MessageTypeDeviceList:
+		client.handleDeviceList(msg)
+	case types.MessageTypeSASRequest:
+		client.handleSASRequest(msg)
+	case types.MessageTypeSASAccept:
+		client.handleSASAccept(msg)
+	case types.MessageTypeSASReveal:
+		client.handleSASReveal(msg)
+	case types.MessageTypeSASConfirm:
+		client.handleSASConfirm(msg)
+	case types.MessageTypeSASCancel:
+		client.handleSASCancel(msg)
+	case types.MessageTypeMessageAck:
+		client.handleMessageAck(msg)
+	case types.MessageTypeMessageReceipt:
+		client.handleMessageReceipt(msg)
+	case types.MessageTypeUserRenamed:
+		client.handleUserRenamed(msg)
+	case types.MessageTypePresence:
+		client.handlePresence(msg)
+	case types.MessageTypeTyping:
+		client.handleTyping(msg)
+	case types.MessageTypeAccountResult:
+		client.handleAccountResult(msg)
+	case types.MessageTypeRoomInfo:
+		client.handleRoomInfo(msg)
+	case types.MessageTypeModeration:
+		client.handleModeration(msg)
+	case types.MessageTypeReconnectToken:
+		client.tokenMu.Lock()
+		client.reconnectToken = string(msg.Value)
+		client.tokenMu.Unlock()
+		client.saveIdentity()
+	case types.MessageTypeGoingAway:
+		client.handleGoingAway(msg)
+	case types.MessageTypeAnnouncement:
+		ui.EmitToUI(types.MessageTypeAnnouncement, string(msg.Value), ALERT_COLOR)
+	case types.MessageTypeError:
+		ui.EmitToUI(types.MessageTypeError, string(msg.Value), ALERT_COLOR)
+	default:
+		if payload := client.conn.HandleServerMessage(msg); payload != nil {
+			client.receiveChatMessage(*payload, msg.Metadata.Color)
+		}
+	}
+}
+
+// The server ended our session for good (we connected again from somewhere
+// else, were banned or removed), we stay disconnected
+func (client *WSClient) stayDisconnected(reason string) {
+	client.isConnected = false
+	client.cancelFunc()
+
+	ui.EmitToUI(types.MessageTypeError, reason, ALERT_COLOR)
+	ui.EmitToUI(types.MessageTypeDisconnected, client.conn.Metadata.Username, client.conn.Metadata.Color)
+}
+
+// A moderator kicked us, we tell the user and wait a bit before connecting
+// again
+func (client *WSClient) kicked(reason string) {
+	ui.EmitToUI(types.MessageTypeError, fmt.Sprintf("%s Reconnecting in %s.", reason, KICKED_RECONNECT_DELAY), ALERT_COLOR)
+
+	log.Printf("[%s] Kicked, reconnecting in %s\n", client.conn.Metadata.Username, KICKED_RECONNECT_DELAY)
+	client.reconnectDelay.Store(int64(KICKED_RECONNECT_DELAY))
+}
+
+// The server closed our session after we changed our username: we connect
+// again right away, without counting it as a failed attempt
+func (client *WSClient) renamed() {
+	log.Printf("[%s] Renamed, reconnecting now\n", client.conn.Metadata.Username)
+	client.attempts.Store(0)
+	client.reconnectNow.Store(true)
+}
+
+// The server is going away and told us when to come back
+func (client *WSClient) handleGoingAway(msg ws.WSMessage) {
+	var goingAway types.GoingAway
+	if err := json.Unmarshal(msg.Value, &goingAway); err != nil {
+		log.Printf("[%s] Could not unmarshal going away: %s\n", client.conn.Metadata.Username, err.Error())
+		return
+	}
+
+	client.retryAfter.Store(goingAway.RetryAfter)
+	ui.EmitToUI(types.MessageTypeGoingAway, string(msg.Value), ALERT_COLOR)
+}
+
+// Every client of the server reconnects at about the same time, so we wait
+// a random bit more than asked
+func (client *WSClient) serverGoingAway() {
+	wait := time.Duration(client.retryAfter.Swap(0)) * time.Second
+	wait += rand.N(RECONNECT_JITTER)
+
+	log.Printf("[%s] Server going away, reconnecting in %s\n", client.conn.Metadata.Username, wait)
+	client.reconnectDelay.Store(int64(wait))
+}
+
 func (client *WSClient) triggerReconnect() {
 	// If reconnect was already triggered, it won't trigger again
 	select {
@@ -244,31 +595,121 @@ func (client *WSClient) sendEncrypted(message string) {
 		return
 	}
 
-	// Encrypt message
-	nonce, ciphertext, err := cryptography.EncryptMessage(client.conn.Keys.SharedSecret, []byte(text))
+	client.userActive()
+	client.stoppedTyping()
+
+	if client.handleCommand(text) {
+		return
+	}
+
+	payload, err := chat.New(client.conn.Metadata.Username, group.DefaultGroupID, text, "")
+	if err != nil {
+		log.Printf("[%s] Could not create message: %s\n", client.conn.Metadata.Username, err.Error())
+		return
+	}
+
+	if !client.sendChatMessage(payload) {
+		client.deadLetterQueue <- message
+	}
+}
+
+// Sends a chat message to its room: encrypted for the group if we are in it,
+// otherwise to the server, which relays it. Returns false if it has to be
+// sent again once we are connected.
+func (client *WSClient) sendChatMessage(payload types.ChatMessage) bool {
+	if !client.isConnected {
+		return false
+	}
+
+	marshalled, err := chat.Marshal(payload)
+	if err != nil {
+		log.Printf("[%s] Could not marshal message: %s\n", client.conn.Metadata.Username, err.Error())
+		return true
+	}
+
+	msg, ok := client.groupMessage(payload.ID, marshalled)
+	if !ok {
+		// Encrypt message
+		nonce, ciphertext, err := cryptography.EncryptMessage(client.conn.Keys.SharedSecret, marshalled)
+		if err != nil {
+			log.Printf("Could not encrypt message: %s\n", err.Error())
+			return true
+		}
+
+		msg = ws.WSMessage{
+			Type:     types.MessageTypeEncryptedMessage,
+			Value:    ciphertext,
+			Nonce:    nonce,
+			Metadata: ws.WSMetadata{Username: client.conn.Metadata.Username, Color: client.conn.Metadata.Color},
+		}
+	}
+	jsonMsg := msg.Marshal()
+
+	// Send encrypted message
+	if err := client.conn.WriteMessage(string(jsonMsg), websocket.TextMessage); err != nil {
+		log.Printf("Error writing message to server: %s\n", err.Error())
+		client.triggerReconnect()
+		return false
+	}
+
+	if msg.Type == types.MessageTypeGroupMessage {
+		client.sendHistoryCopy(payload.ID, marshalled)
+	}
+
+	client.messageSent(payload)
+	return true
+}
+
+// Sends the server a readable copy of a message we sent to the group, for
+// the history it replays to the devices joining later (which can't decrypt
+// the group messages sent before they joined)
+func (client *WSClient) sendHistoryCopy(id string, marshalled []byte) {
+	if !client.serverHistory || client.noHistoryCopies {
+		return
+	}
+
+	nonce, ciphertext, err := cryptography.EncryptMessage(client.conn.Keys.SharedSecret, marshalled)
 	if err != nil {
-		log.Printf("Could not encrypt message: %s\n", err.Error())
+		log.Printf("Could not encrypt history copy: %s\n", err.Error())
 		return
 	}
 
 	msg := ws.WSMessage{
-		Type:     types.MessageTypeEncryptedMessage,
+		Type:     types.MessageTypeHistoryCopy,
 		Value:    ciphertext,
 		Nonce:    nonce,
 		Metadata: ws.WSMetadata{Username: client.conn.Metadata.Username, Color: client.conn.Metadata.Color},
+		ID:       id,
 	}
-	jsonMsg := msg.Marshal()
+	if err := client.conn.WriteMessage(string(msg.Marshal()), websocket.TextMessage); err != nil {
+		log.Printf("Error writing history copy to server: %s\n", err.Error())
+	}
+}
 
-	if !client.isConnected {
-		client.deadLetterQueue <- message
+// Sends `value`, marshalled as JSON, in a message of type `msgType`
+func (client *WSClient) sendJSONMessage(msgType types.MessageType, value any) {
+	client.sendJSONMessageWithID(msgType, "", value)
+}
+
+// Same as sendJSONMessage, for messages carrying the chat message `id`
+func (client *WSClient) sendJSONMessageWithID(msgType types.MessageType, id string, value any) {
+	marshalled, err := json.Marshal(value)
+	if err != nil {
+		log.Printf("[%s] Could not marshal %s message: %s\n", client.conn.Metadata.Username, msgType, err.Error())
 		return
 	}
 
-	// Send encrypted message
+	msg := ws.WSMessage{
+		Type:     msgType,
+		Value:    marshalled,
+		Nonce:    nil,
+		Metadata: client.conn.Metadata,
+		ID:       id,
+	}
+	jsonMsg := msg.Marshal()
+
 	if err := client.conn.WriteMessage(string(jsonMsg), websocket.TextMessage); err != nil {
-		log.Printf("Error writing message to server: %s\n", err.Error())
-		client.deadLetterQueue <- message
-		client.triggerReconnect()
+		log.Printf("[%s] Error trying to send %s message to server: %s\n", client.conn.Metadata.Username, msgType, err.Error())
 	}
 }
 
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/Guilospanck/pqc/core/pkg/types"
+	"github.com/Guilospanck/pqc/core/pkg/ui"
+)
+
+// Handles the commands typed by the user (other than the quit ones).
+// Returns false if `text` is not a command, so it is sent as a regular message.
+func (client *WSClient) handleCommand(text string) bool {
+	command, args, _ := strings.Cut(text, " ")
+	args = strings.TrimSpace(args)
+
+	switch command {
+	case "/mail":
+		username, message, ok := splitUsername(args)
+		if !ok || message == "" {
+			usage("/mail <username> <message>")
+			return true
+		}
+		client.sendMail(username, message)
+
+	case "/msg":
+		username, message, ok := splitUsername(args)
+		if !ok || message == "" {
+			usage("/msg <username> <message>")
+			return true
+		}
+		client.sendDirectMessage(username, message)
+
+	case "/verify":
+		username, device, ok := splitUsername(args)
+		if !ok {
+			usage("/verify <username> [device]")
+			return true
+		}
+		client.startVerification(username, device)
+
+	case "/match", "/mismatch":
+		if args == "" {
+			usage(command + " <verification>")
+			return true
+		}
+		client.confirmVerification(args, command == "/match")
+
+	case "/edit":
+		if args == "" {
+			usage("/edit <new text>")
+			return true
+		}
+		if id, ok := client.lastSentMessage(); ok {
+			client.editMessage(id, args)
+		}
+
+	case "/delete":
+		if id, ok := client.lastSentMessage(); ok {
+			client.deleteMessage(id)
+		}
+
+	case "/reply":
+		if args == "" {
+			usage("/reply <text>")
+			return true
+		}
+		if id, ok := client.lastReceivedMessage(); ok {
+			client.reply(id, args)
+		}
+
+	case "/react", "/unreact":
+		if args == "" {
+			usage(command + " <emoji>")
+			return true
+		}
+		if id, ok := client.lastReceivedMessage(); ok {
+			client.react(id, args, command == "/react")
+		}
+
+	case "/nick":
+		if args == "" {
+			usage("/nick <username>")
+			return true
+		}
+		client.changeProfile(types.ProfileChange{Username: args})
+
+	case "/color":
+		if args == "" {
+			usage("/color <#rrggbb>")
+			return true
+		}
+		client.changeProfile(types.ProfileChange{Color: args})
+
+	case "/register", "/login":
+		username, password, ok := splitUsername(args)
+		if !ok || password == "" {
+			usage(command + " <username> <password>")
+			return true
+		}
+		msgType := types.MessageTypeAccountLogin
+		if command == "/register" {
+			msgType = types.MessageTypeAccountRegister
+		}
+		client.sendCredentials(msgType, types.Credentials{Username: username, Password: password})
+
+	case "/invite":
+		if args == "" {
+			usage("/invite <code>")
+			return true
+		}
+		client.useInvite(args)
+
+	case "/kick", "/ban", "/ban-ip":
+		username, reason, ok := splitUsername(args)
+		if !ok {
+			usage(command + " <username> [reason]")
+			return true
+		}
+		actions := map[string]types.ModerationAction{"/kick": types.ModerationKick, "/ban": types.ModerationBan, "/ban-ip": types.ModerationBanIP}
+		client.moderate(types.Moderation{Action: actions[command], Target: username, Reason: reason})
+
+	case "/mute":
+		username, rest, ok := splitUsername(args)
+		if !ok || rest == "" {
+			usage("/mute <username> <duration, e.g. 10m> [reason]")
+			return true
+		}
+		client.mute(username, rest)
+
+	case "/unban", "/unmute", "/room-invite", "/room-uninvite":
+		username, _, ok := splitUsername(args)
+		if !ok {
+			usage(command + " <username>")
+			return true
+		}
+		actions := map[string]types.ModerationAction{"/unban": types.ModerationUnban, "/unmute": types.ModerationUnmute, "/room-invite": types.ModerationInvite, "/room-uninvite": types.ModerationUninvite}
+		client.moderate(types.Moderation{Action: actions[command], Target: username})
+
+	case "/op", "/deop":
+		username, _, ok := splitUsername(args)
+		if !ok {
+			usage(command + " <username>")
+			return true
+		}
+		role := types.RoomRoleModerator
+		if command == "/deop" {
+			role = types.RoomRoleMember
+		}
+		client.moderate(types.Moderation{Action: types.ModerationRole, Target: username, Value: role})
+
+	case "/topic":
+		if args == "" {
+			usage("/topic <text>")
+			return true
+		}
+		client.moderate(types.Moderation{Action: types.ModerationTopic, Value: args})
+
+	case "/invite-only":
+		if args != "on" && args != "off" {
+			usage("/invite-only <on|off>")
+			return true
+		}
+		client.moderate(types.Moderation{Action: types.ModerationInviteOnly, Value: args})
+
+	case "/remove":
+		if id, ok := client.lastReceivedMessage(); ok {
+			client.deleteMessage(id)
+		}
+
+	case "/status":
+		status, text, _ := strings.Cut(args, " ")
+		if status == "" {
+			usage("/status <online|away|busy> [text]")
+			return true
+		}
+		client.setStatus(types.Presence{Status: status, Text: strings.TrimSpace(text)})
+
+	case "/backup":
+		client.exportIdentity()
+
+	case "/restore":
+		if args == "" {
+			usage("/restore <recovery phrase>")
+			return true
+		}
+		client.restoreIdentity(args)
+
+	case "/unlock":
+		if args == "" {
+			usage("/unlock <passphrase>")
+			return true
+		}
+		client.unlockHistory(args)
+
+	case "/search":
+		query, err := parseSearch(args)
+		if err != nil {
+			ui.EmitToUI(types.MessageTypeError, err.Error(), ALERT_COLOR)
+			return true
+		}
+		client.searchHistory(query)
+
+	case "/devices":
+		client.sendJSONMessage(types.MessageTypeDeviceList, nil)
+
+	case "/approve", "/deny":
+		if args == "" {
+			usage(command + " <device>")
+			return true
+		}
+		client.approveDevice(args, command == "/approve")
+
+	case "/remove-device":
+		if args == "" {
+			usage("/remove-device <device>")
+			return true
+		}
+		client.removeDevice(args)
+
+	default:
+		return false
+	}
+
+	return true
+}
+
+// Usernames can have spaces, in which case they must be quoted:
+// `"Amazing Koala" hello there` -> ("Amazing Koala", "hello there")
+func splitUsername(args string) (username, rest string, ok bool) {
+	if quoted, found := strings.CutPrefix(args, "\""); found {
+		username, rest, ok = strings.Cut(quoted, "\"")
+	} else {
+		username, rest, _ = strings.Cut(args, " ")
+		ok = username != ""
+	}
+
+	return username, strings.TrimSpace(rest), ok
+}
+
+func usage(command string) {
+	ui.EmitToUI(types.MessageTypeError, "Usage: "+command, ALERT_COLOR)
+}
@@ -20,3 +20,35 @@ var QUIT_COMMANDS = []string{
 
 // How many reconnect attemps we are able to do
 const MAX_ATTEMPTS int = 5
+
+// How many one-time prekeys we upload at once
+const PREKEY_BATCH_SIZE = 20
+
+// Color used for security alerts shown in the UI
+const ALERT_COLOR = "#F85149"
+
+// How many received messages we remember, to send their read receipts
+// once the UI reports them as seen
+const UNREAD_MESSAGES_KEPT = 500
+
+// How many messages we remember the sender of, to check who edits or
+// deletes them
+const KNOWN_MESSAGES_KEPT = 1000
+
+// We tell others we are typing at most this often, and that we stopped
+// typing after TYPING_TIMEOUT without a key pressed
+const TYPING_THROTTLE = 3 * time.Second
+const TYPING_TIMEOUT = 5 * time.Second
+
+// How often we check if the user has been idle long enough to be away
+const IDLE_CHECK_PERIOD = 30 * time.Second
+
+// Random time added to the wait asked by a server going away, so its
+// clients don't all connect again at once
+const RECONNECT_JITTER = 5 * time.Second
+
+// Where the passphrase of the identity file (-identity) is read from
+const IDENTITY_PASSPHRASE_ENV = "PQC_IDENTITY_PASSPHRASE"
+
+// How long we wait before joining the room again after being kicked
+const KICKED_RECONNECT_DELAY = 30 * time.Second
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/Guilospanck/pqc/core/pkg/devices"
+	"github.com/Guilospanck/pqc/core/pkg/types"
+	"github.com/Guilospanck/pqc/core/pkg/ui"
+	"github.com/Guilospanck/pqc/core/pkg/ws"
+)
+
+// Connects as a new device of `username`. One of its devices
+// that is already online has to approve this one.
+func (client *WSClient) linkDevice(username string) {
+	client.conn.Metadata.Username = username
+}
+
+// A device we don't know wants to connect as us
+func (client *WSClient) handleDeviceApprovalRequest(msg ws.WSMessage) {
+	var device devices.Device
+	if err := json.Unmarshal(msg.Value, &device); err != nil {
+		log.Printf("[%s] Could not unmarshal device approval request: %s\n", client.conn.Metadata.Username, err.Error())
+		return
+	}
+
+	log.Printf("[%s] Device %s asked to be approved\n", client.conn.Metadata.Username, device.ID)
+	ui.EmitToUI(types.MessageTypeDeviceApprovalRequest, device.ID, ALERT_COLOR)
+}
+
+func (client *WSClient) handleDeviceList(msg ws.WSMessage) {
+	var list []devices.Device
+	if err := json.Unmarshal(msg.Value, &list); err != nil {
+		log.Printf("[%s] Could not unmarshal device list: %s\n", client.conn.Metadata.Username, err.Error())
+		return
+	}
+
+	lines := make([]string, 0, len(list))
+	for _, device := range list {
+		line := device.ID
+		if device.ID == client.conn.Metadata.Device {
+			line += " (this device)"
+		} else if device.Online {
+			line += " (online)"
+		}
+		lines = append(lines, line)
+	}
+
+	ui.EmitToUI(types.MessageTypeDeviceList, strings.Join(lines, ", "), "")
+}
+
+func (client *WSClient) approveDevice(device string, approved bool) {
+	client.sendJSONMessage(types.MessageTypeDeviceApproval, devices.Approval{Device: device, Approved: approved})
+}
+
+func (client *WSClient) removeDevice(device string) {
+	if device == client.conn.Metadata.Device {
+		ui.EmitToUI(types.MessageTypeError, fmt.Sprintf("%s is this device.", device), ALERT_COLOR)
+		return
+	}
+
+	client.sendJSONMessage(types.MessageTypeDeviceRemove, devices.Removal{Device: device})
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"log"
+
+	"github.com/Guilospanck/pqc/core/pkg/chat"
+	"github.com/Guilospanck/pqc/core/pkg/types"
+	"github.com/Guilospanck/pqc/core/pkg/ui"
+)
+
+// Sends a message only to `username`, end-to-end encrypted like mail: one
+// prekey message for each of its devices, and a copy for each of our other
+// devices. The server can't read it, and keeps it for the offline devices.
+func (client *WSClient) sendDirectMessage(username, text string) {
+	if username == client.conn.Metadata.Username {
+		ui.EmitToUI(types.MessageTypeError, "You can't send a direct message to yourself.", ALERT_COLOR)
+		return
+	}
+
+	payload, err := chat.NewDirect(client.conn.Metadata.Username, username, text)
+	if err != nil {
+		log.Printf("[%s] Could not create direct message: %s\n", client.conn.Metadata.Username, err.Error())
+		return
+	}
+
+	if !client.isConnected {
+		ui.EmitToUI(types.MessageTypeError, "Not connected, message not sent.", ALERT_COLOR)
+		return
+	}
+
+	client.sendEndToEnd(username, pendingMessage{payload: payload})
+	client.sendEndToEnd(client.conn.Metadata.Username, pendingMessage{payload: payload, copy: true})
+}
+
+// A direct message sent to us, or by another device of ours. They only come
+// as prekey messages: receipts are not sent for sealed ones (`withReceipt`).
+func (client *WSClient) receiveDirectMessage(payload types.ChatMessage, withReceipt bool) {
+	username := client.conn.Metadata.Username
+	if payload.Recipient != username && payload.Sender != username {
+		log.Printf("[%s] Ignoring direct message from %s to %s\n", username, payload.Sender, payload.Recipient)
+		return
+	}
+
+	ui.EmitChatMessage(types.MessageTypeDirectMessage, payload, "")
+
+	if withReceipt {
+		client.messageReceived(payload)
+	}
+}
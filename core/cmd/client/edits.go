@@ -0,0 +1,141 @@
+package main
+
+import (
+	"log"
+	"sync/atomic"
+
+	"github.com/Guilospanck/pqc/core/pkg/chat"
+	"github.com/Guilospanck/pqc/core/pkg/group"
+	"github.com/Guilospanck/pqc/core/pkg/types"
+	"github.com/Guilospanck/pqc/core/pkg/ui"
+)
+
+// Who sent a message, and where
+type knownMessage struct {
+	sender string
+	room   string
+}
+
+func (client *WSClient) rememberMessage(payload types.ChatMessage) {
+	client.known.add(payload.ID, knownMessage{sender: payload.Sender, room: payload.Room})
+}
+
+// Shows a chat message relayed by the server or sent to the group,
+// or applies it if it edits or deletes another one
+func (client *WSClient) receiveChatMessage(payload types.ChatMessage, color string) {
+	// Direct messages only come end-to-end, as prekey messages
+	if payload.Recipient != "" {
+		log.Printf("[%s] Ignoring direct message from %s that was not sent end-to-end\n", client.conn.Metadata.Username, payload.Sender)
+		return
+	}
+
+	if payload.Action != "" {
+		client.applyChatAction(payload)
+		return
+	}
+
+	client.rememberMessage(payload)
+
+	// Sent by another device of ours
+	if payload.Sender == client.conn.Metadata.Username {
+		ui.EmitChatMessage(types.MessageTypeOwnMessage, payload, color)
+		return
+	}
+
+	client.lastReceived.Store(payload.ID)
+
+	ui.EmitChatMessage(types.MessageTypeMessage, payload, color)
+	client.messageReceived(payload)
+}
+
+// Only the sender of a message can edit it, and delete it unless a moderator
+// above them does. Anyone can react to it.
+// Every action goes through here, whether it came through the group or was
+// relayed by the server: the server can't check the ones sent to the group.
+func (client *WSClient) applyChatAction(payload types.ChatMessage) {
+	original, ok := client.known.get(payload.Target)
+	if !ok {
+		log.Printf("[%s] Ignoring %s of unknown message %s\n", client.conn.Metadata.Username, payload.Action, payload.Target)
+		return
+	}
+
+	switch payload.Action {
+	case types.ChatActionReact, types.ChatActionUnreact:
+		client.applyReaction(payload)
+		return
+	}
+
+	moderated := payload.Action == types.ChatActionDelete && client.outranks(original.room, payload.Sender, original.sender)
+	if original.sender != payload.Sender && !moderated {
+		log.Printf("[%s] %s tried to %s a message from %s\n", client.conn.Metadata.Username, payload.Sender, payload.Action, original.sender)
+		return
+	}
+
+	switch payload.Action {
+	case types.ChatActionEdit:
+		ui.EmitChatMessage(types.MessageTypeMessageEdited, payload, "")
+
+	case types.ChatActionDelete:
+		client.known.take(payload.Target)
+		client.reactions.take(payload.Target)
+		ui.EmitChatMessage(types.MessageTypeMessageDeleted, payload, "")
+	}
+}
+
+func (client *WSClient) editMessage(id, body string) {
+	client.sendChatAction(types.ChatActionEdit, id, body)
+}
+
+func (client *WSClient) deleteMessage(id string) {
+	client.sendChatAction(types.ChatActionDelete, id, "")
+}
+
+// The last message we sent to the room, for /edit and /delete
+func (client *WSClient) lastSentMessage() (string, bool) {
+	return client.lastMessage(&client.lastSent)
+}
+
+// The last message we received in the room, for /reply and /react
+func (client *WSClient) lastReceivedMessage() (string, bool) {
+	return client.lastMessage(&client.lastReceived)
+}
+
+func (client *WSClient) lastMessage(last *atomic.Value) (string, bool) {
+	id, _ := last.Load().(string)
+	if _, ok := client.known.get(id); !ok {
+		ui.EmitToUI(types.MessageTypeError, "No message yet.", ALERT_COLOR)
+		return "", false
+	}
+
+	return id, true
+}
+
+func (client *WSClient) sendChatAction(action types.ChatAction, id, body string) {
+	original, ok := client.known.get(id)
+	if !ok {
+		ui.EmitToUI(types.MessageTypeError, "Unknown message.", ALERT_COLOR)
+		return
+	}
+
+	ownOnly := action == types.ChatActionEdit || action == types.ChatActionDelete
+	moderated := action == types.ChatActionDelete && client.outranks(original.room, client.conn.Metadata.Username, original.sender)
+	if ownOnly && original.sender != client.conn.Metadata.Username && !moderated {
+		ui.EmitToUI(types.MessageTypeError, "You can only change your own messages.", ALERT_COLOR)
+		return
+	}
+
+	if original.room != group.DefaultGroupID {
+		ui.EmitToUI(types.MessageTypeError, "Only messages sent to the room can be changed.", ALERT_COLOR)
+		return
+	}
+
+	payload, err := chat.NewAction(client.conn.Metadata.Username, original.room, action, id, body)
+	if err != nil {
+		log.Printf("[%s] Could not create %s: %s\n", client.conn.Metadata.Username, action, err.Error())
+		return
+	}
+
+	if !client.sendChatMessage(payload) {
+		ui.EmitToUI(types.MessageTypeError, "Not connected, message not changed.", ALERT_COLOR)
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"crypto/mlkem"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/Guilospanck/pqc/core/pkg/chat"
+	"github.com/Guilospanck/pqc/core/pkg/devices"
+	"github.com/Guilospanck/pqc/core/pkg/group"
+	"github.com/Guilospanck/pqc/core/pkg/types"
+	"github.com/Guilospanck/pqc/core/pkg/ui"
+	"github.com/Guilospanck/pqc/core/pkg/ws"
+)
+
+// Asks to join the group of the room with a fresh key package.
+// Any previous group state is dropped: while we were away the group
+// moved on without us, so we are added again as a new member.
+func (client *WSClient) joinGroup() error {
+	kp, leafKey, err := group.NewKeyPackage(group.DefaultGroupID, client.conn.Metadata.Username, client.conn.Metadata.Device, client.conn.Keys.Signing)
+	if err != nil {
+		log.Printf("[%s] Error generating key package: %s\n", client.conn.Metadata.Username, err.Error())
+		return err
+	}
+
+	client.groupMu.Lock()
+	client.group = nil
+	client.groupKeyPackage = &kp
+	client.groupLeafKey = leafKey
+	client.groupMu.Unlock()
+
+	client.sendJSONMessage(types.MessageTypeGroupKeyPackage, kp)
+	return nil
+}
+
+func (client *WSClient) currentGroup() *group.Group {
+	client.groupMu.Lock()
+	defer client.groupMu.Unlock()
+
+	return client.group
+}
+
+// Returns our key package (and its private key) if it is the one in `kp`
+func (client *WSClient) pendingKeyPackage(kp group.KeyPackage) (*mlkem.DecapsulationKey768, bool) {
+	client.groupMu.Lock()
+	defer client.groupMu.Unlock()
+
+	if client.groupKeyPackage == nil || !bytes.Equal(client.groupKeyPackage.Leaf.EncryptionKey, kp.Leaf.EncryptionKey) {
+		return nil, false
+	}
+
+	return client.groupLeafKey, true
+}
+
+func (client *WSClient) setGroup(g *group.Group) {
+	client.groupMu.Lock()
+	client.group = g
+	client.groupKeyPackage = nil
+	client.groupLeafKey = nil
+	client.groupMu.Unlock()
+
+	client.emitGroupEpoch(g)
+}
+
+func (client *WSClient) emitGroupEpoch(g *group.Group) {
+	log.Printf("[%s] Group %s is at epoch %d with %d members\n", client.conn.Metadata.Username, g.ID, g.Epoch(), len(g.Members()))
+	ui.EmitToUI(types.MessageTypeGroupEpoch, strconv.FormatUint(g.Epoch(), 10), "")
+}
+
+// The server told us we are the first member of the group
+func (client *WSClient) handleGroupCreate(msg ws.WSMessage) {
+	var kp group.KeyPackage
+	if err := json.Unmarshal(msg.Value, &kp); err != nil {
+		log.Printf("[%s] Could not unmarshal group creation: %s\n", client.conn.Metadata.Username, err.Error())
+		return
+	}
+
+	leafKey, ok := client.pendingKeyPackage(kp)
+	if !ok {
+		log.Printf("[%s] Asked to create a group with a key package that is not ours\n", client.conn.Metadata.Username)
+		return
+	}
+
+	client.setGroup(group.Create(kp.GroupID, kp, leafKey, client.conn.Keys.Signing))
+}
+
+func (client *WSClient) handleGroupWelcome(msg ws.WSMessage) {
+	var welcome group.Welcome
+	if err := json.Unmarshal(msg.Value, &welcome); err != nil {
+		log.Printf("[%s] Could not unmarshal welcome: %s\n", client.conn.Metadata.Username, err.Error())
+		return
+	}
+
+	for _, member := range welcome.Tree.Members() {
+		if !client.verifyGroupMember(member) {
+			return
+		}
+	}
+
+	client.groupMu.Lock()
+	kp, leafKey := client.groupKeyPackage, client.groupLeafKey
+	client.groupMu.Unlock()
+
+	if kp == nil {
+		log.Printf("[%s] Received a welcome without having asked to join\n", client.conn.Metadata.Username)
+		return
+	}
+
+	g, err := group.Join(welcome, *kp, leafKey, client.conn.Keys.Signing)
+	if err != nil {
+		log.Printf("[%s] Could not join group %s: %s\n", client.conn.Metadata.Username, welcome.GroupID, err.Error())
+		return
+	}
+
+	client.setGroup(g)
+}
+
+// We were chosen to commit the pending changes of the group
+func (client *WSClient) handleGroupProposals(msg ws.WSMessage) {
+	var proposals group.Proposals
+	if err := json.Unmarshal(msg.Value, &proposals); err != nil {
+		log.Printf("[%s] Could not unmarshal proposals: %s\n", client.conn.Metadata.Username, err.Error())
+		return
+	}
+
+	g := client.currentGroup()
+	if g == nil || g.HasPendingCommit() || g.Epoch() != proposals.Epoch {
+		// The server sends the proposals again once the group settles
+		return
+	}
+
+	adds := make([]group.KeyPackage, 0, len(proposals.Adds))
+	for _, kp := range proposals.Adds {
+		if client.verifyGroupMember(kp.Leaf) {
+			adds = append(adds, kp)
+		}
+	}
+
+	bundle, err := g.Commit(adds, proposals.Removes)
+	if err != nil {
+		log.Printf("[%s] Could not create commit: %s\n", client.conn.Metadata.Username, err.Error())
+		return
+	}
+
+	client.sendJSONMessage(types.MessageTypeGroupCommit, bundle)
+}
+
+func (client *WSClient) handleGroupCommit(msg ws.WSMessage) {
+	var commit group.Commit
+	if err := json.Unmarshal(msg.Value, &commit); err != nil {
+		log.Printf("[%s] Could not unmarshal commit: %s\n", client.conn.Metadata.Username, err.Error())
+		return
+	}
+
+	g := client.currentGroup()
+	if g == nil {
+		return
+	}
+
+	for _, kp := range commit.Adds {
+		if !client.verifyGroupMember(kp.Leaf) {
+			return
+		}
+	}
+
+	err := g.ProcessCommit(commit)
+	switch {
+	case err == nil:
+		client.emitGroupEpoch(g)
+
+	case errors.Is(err, group.ErrRemoved):
+		log.Printf("[%s] We were removed from group %s, joining again\n", client.conn.Metadata.Username, g.ID)
+		client.joinGroup()
+
+	default:
+		// We can't follow the group anymore, so we ask to be added again
+		log.Printf("[%s] Could not process commit: %s. Joining group again\n", client.conn.Metadata.Username, err.Error())
+		client.joinGroup()
+	}
+}
+
+func (client *WSClient) handleGroupCommitRejected(msg ws.WSMessage) {
+	if g := client.currentGroup(); g != nil {
+		log.Printf("[%s] Our commit was rejected\n", client.conn.Metadata.Username)
+		g.DiscardPendingCommit()
+	}
+}
+
+func (client *WSClient) handleGroupMessage(msg ws.WSMessage) {
+	var appMsg group.ApplicationMessage
+	if err := json.Unmarshal(msg.Value, &appMsg); err != nil {
+		log.Printf("[%s] Could not unmarshal group message: %s\n", client.conn.Metadata.Username, err.Error())
+		return
+	}
+
+	g := client.currentGroup()
+	if g == nil {
+		log.Printf("[%s] Received a group message before joining the group\n", client.conn.Metadata.Username)
+		return
+	}
+
+	sender, plaintext, err := g.Decrypt(appMsg)
+	if err != nil {
+		log.Printf("[%s] Could not decrypt group message: %s\n", client.conn.Metadata.Username, err.Error())
+		return
+	}
+
+	if sender.Address() != devices.Address(msg.Metadata.Username, msg.Metadata.Device) {
+		client.alertKeyTransparency(fmt.Sprintf("a group message from %s was relayed as coming from %s", sender.Username, msg.Metadata.Username))
+		return
+	}
+
+	payload, err := chat.Parse(plaintext)
+	if err != nil {
+		log.Printf("[%s] Could not read group message: %s\n", client.conn.Metadata.Username, err.Error())
+		return
+	}
+
+	if payload.Sender != sender.Username {
+		client.alertKeyTransparency(fmt.Sprintf("a group message from %s claims to be from %s", sender.Username, payload.Sender))
+		return
+	}
+
+	client.receiveChatMessage(payload, msg.Metadata.Color)
+}
+
+// Encrypts a message once for the whole group. Returns false if we are
+// not in the group yet, in which case the server has to relay it.
+func (client *WSClient) groupMessage(id string, payload []byte) (ws.WSMessage, bool) {
+	g := client.currentGroup()
+	if g == nil {
+		return ws.WSMessage{}, false
+	}
+
+	appMsg, err := g.Encrypt(payload)
+	if err != nil {
+		log.Printf("[%s] Could not encrypt group message: %s\n", client.conn.Metadata.Username, err.Error())
+		return ws.WSMessage{}, false
+	}
+
+	marshalled, err := json.Marshal(appMsg)
+	if err != nil {
+		return ws.WSMessage{}, false
+	}
+
+	return ws.WSMessage{
+		Type:     types.MessageTypeGroupMessage,
+		Value:    marshalled,
+		Nonce:    nil,
+		Metadata: ws.WSMetadata{Username: client.conn.Metadata.Username, Color: client.conn.Metadata.Color},
+		ID:       id,
+	}, true
+}
+
+// Group members must use the signing key published in the key log
+func (client *WSClient) verifyGroupMember(member group.LeafNode) bool {
+	binding, ok := client.keyMonitor.Binding(member.Username, member.Device)
+	if !ok || !bytes.Equal(binding.SigningKey, member.SigningKey) {
+		client.alertKeyTransparency(fmt.Sprintf("group member %s does not use its published signing key", member.Username))
+		return false
+	}
+
+	return true
+}
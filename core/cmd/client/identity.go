@@ -0,0 +1,96 @@
+package main
+
+import (
+	"log"
+
+	"github.com/Guilospanck/pqc/core/pkg/cryptography"
+	"github.com/Guilospanck/pqc/core/pkg/keystore"
+	"github.com/Guilospanck/pqc/core/pkg/pqxdh"
+	"github.com/Guilospanck/pqc/core/pkg/transparency"
+	"github.com/Guilospanck/pqc/core/pkg/ws"
+)
+
+// Without an identity file, a client is a new device every time it starts:
+// messages sent to it while it was offline are lost with its prekeys.
+
+// Brings back the device saved at `path` (see pkg/keystore), if any.
+// From then on, everything it must remember is saved there.
+func (client *WSClient) openIdentity(path, passphrase string) error {
+	store, state, err := keystore.Open(path, passphrase)
+	if err != nil {
+		return err
+	}
+	client.identity = store
+
+	// A new identity file
+	if state.Device == "" {
+		return nil
+	}
+
+	client.conn.Metadata = ws.WSMetadata{Username: state.Username, Color: state.Color, Device: state.Device}
+	client.reconnectToken = state.ReconnectToken
+
+	// The key log must go on from where we left it
+	if state.KeyLog != nil {
+		monitor, err := transparency.RestoreMonitor(*state.KeyLog)
+		if err != nil {
+			return err
+		}
+		client.keyMonitor = monitor
+	}
+
+	if state.Seed == nil {
+		return nil
+	}
+
+	keys, err := cryptography.KeysFromSeed(state.Seed)
+	if err != nil {
+		return err
+	}
+	client.conn.Keys = keys
+
+	if state.Prekeys != nil {
+		keyRing, err := pqxdh.RestoreKeyRing(keys.Signing, *state.Prekeys)
+		if err != nil {
+			return err
+		}
+		client.keyRing = keyRing
+	}
+
+	log.Printf("[%s] Restored device %s\n", state.Username, state.Device)
+	return nil
+}
+
+// Saves the device, its identity and its prekeys, if the user keeps them
+func (client *WSClient) saveIdentity() {
+	if client.identity == nil {
+		return
+	}
+
+	client.identityMu.Lock()
+	defer client.identityMu.Unlock()
+
+	state := keystore.State{
+		Device:   client.conn.Metadata.Device,
+		Username: client.conn.Metadata.Username,
+		Color:    client.conn.Metadata.Color,
+		Seed:     client.conn.Keys.Seed,
+	}
+
+	client.tokenMu.Lock()
+	state.ReconnectToken = client.reconnectToken
+	client.tokenMu.Unlock()
+
+	if client.keyRing != nil {
+		prekeys := client.keyRing.State()
+		state.Prekeys = &prekeys
+	}
+
+	if keyLog, ok := client.keyMonitor.State(); ok {
+		state.KeyLog = &keyLog
+	}
+
+	if err := client.identity.Save(state); err != nil {
+		log.Printf("[%s] Could not save the identity: %s\n", client.conn.Metadata.Username, err.Error())
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+
+	"github.com/Guilospanck/pqc/core/pkg/cryptography"
+	"github.com/Guilospanck/pqc/core/pkg/types"
+	"github.com/Guilospanck/pqc/core/pkg/ui"
+	"github.com/Guilospanck/pqc/core/pkg/ws"
+
+	"github.com/gorilla/websocket"
+)
+
+// Registers (or logs in as) a user of the server. The credentials are
+// encrypted with the key we exchanged with the server.
+func (client *WSClient) sendCredentials(msgType types.MessageType, credentials types.Credentials) {
+	if !client.isConnected || client.conn.Keys.SharedSecret == nil {
+		ui.EmitToUI(types.MessageTypeError, "Not connected.", ALERT_COLOR)
+		return
+	}
+
+	marshalled, err := json.Marshal(credentials)
+	if err != nil {
+		log.Printf("[%s] Could not marshal credentials: %s\n", client.conn.Metadata.Username, err.Error())
+		return
+	}
+
+	nonce, ciphertext, err := cryptography.EncryptMessage(client.conn.Keys.SharedSecret, marshalled)
+	if err != nil {
+		log.Printf("[%s] Could not encrypt credentials: %s\n", client.conn.Metadata.Username, err.Error())
+		return
+	}
+
+	client.accountMu.Lock()
+	client.pendingLogin = &credentials
+	client.accountMu.Unlock()
+
+	msg := ws.WSMessage{
+		Type:     msgType,
+		Value:    ciphertext,
+		Nonce:    nonce,
+		Metadata: client.conn.Metadata,
+	}
+	jsonMsg := msg.Marshal()
+
+	if err := client.conn.WriteMessage(string(jsonMsg), websocket.TextMessage); err != nil {
+		log.Printf("[%s] Error trying to send %s message to server: %s\n", client.conn.Metadata.Username, msgType, err.Error())
+	}
+}
+
+// We registered or logged in. Unless we were connected as that user
+// already, the server disconnects us and we connect again as them.
+func (client *WSClient) handleAccountResult(msg ws.WSMessage) {
+	var result types.AccountResult
+	if err := json.Unmarshal(msg.Value, &result); err != nil {
+		log.Printf("[%s] Could not unmarshal account result: %s\n", client.conn.Metadata.Username, err.Error())
+		return
+	}
+
+	// Kept to log in again if the server forgets this device
+	client.accountMu.Lock()
+	if client.pendingLogin != nil {
+		client.credentials = &types.Credentials{Username: result.Username, Password: client.pendingLogin.Password}
+		client.pendingLogin = nil
+	}
+	client.accountMu.Unlock()
+
+	if result.Token != "" {
+		log.Printf("[%s] Logged in as %s\n", client.conn.Metadata.Username, result.Username)
+		client.conn.Metadata.Username = result.Username
+		client.conn.Metadata.Color = result.Color
+
+		client.tokenMu.Lock()
+		client.reconnectToken = result.Token
+		client.tokenMu.Unlock()
+
+		// Reconnect right away, without counting it as a failed attempt
+		client.attempts.Store(0)
+	}
+
+	result.Token = ""
+	marshalled, err := json.Marshal(result)
+	if err != nil {
+		log.Printf("[%s] Could not marshal account result: %s\n", client.conn.Metadata.Username, err.Error())
+		return
+	}
+	ui.EmitToUI(types.MessageTypeLoggedIn, string(marshalled), result.Color)
+}
+
+// Connects again with the invite `code`, to get into a private server
+func (client *WSClient) useInvite(code string) {
+	client.tokenMu.Lock()
+	client.invite = code
+	client.tokenMu.Unlock()
+
+	// Otherwise it is shown on the next attempt
+	if client.isConnected {
+		client.attempts.Store(0)
+		client.conn.Conn.Close()
+	}
+}
+
+// The server gave us another username than the user we logged in as
+// (it restarted, or we were away for too long), so we log in again
+func (client *WSClient) logInAgain(username string) {
+	client.accountMu.Lock()
+	credentials := client.credentials
+	client.accountMu.Unlock()
+
+	if credentials == nil || strings.EqualFold(credentials.Username, username) {
+		return
+	}
+
+	log.Printf("[%s] Logging in again as %s\n", username, credentials.Username)
+	client.sendCredentials(types.MessageTypeAccountLogin, *credentials)
+}
@@ -3,9 +3,16 @@ package main
 import (
 	"bufio"
 	"encoding/json"
+	"flag"
 	"log"
 	"os"
+	"time"
+
+	"github.com/Guilospanck/pqc/core/pkg/archive"
+	"github.com/Guilospanck/pqc/core/pkg/cryptography"
 	"github.com/Guilospanck/pqc/core/pkg/logger"
+	"github.com/Guilospanck/pqc/core/pkg/transport"
+	"github.com/Guilospanck/pqc/core/pkg/types"
 	"github.com/Guilospanck/pqc/core/pkg/ui"
 )
 
@@ -13,9 +20,61 @@ func main() {
 	defer log.Println("> Client is gone!")
 	logger.CreateMultiWriterLogger("ws-client-pqc")
 
+	linkTo := flag.String("link", "", "connect as a new device of this (existing) user")
+	sealedSender := flag.Bool("sealed", false, "hide from the server who sends our offline messages")
+	useTLS := flag.Bool("tls", false, "connect with wss:// (TLS 1.3 with X25519MLKEM768 only) instead of ws://")
+	caFile := flag.String("ca", "", "certificate to trust for wss://, e.g. the self-signed one of a development server")
+	historyFile := flag.String("history", "", "keep an encrypted history of the chat in this file (unlocked with /unlock <passphrase>)")
+	readReceipts := flag.Bool("read-receipts", false, "tell senders when we saw their messages (delivery receipts are always sent)")
+	awayAfter := flag.Duration("away-after", 5*time.Minute, "set the user away after this long without typing (0 to never)")
+	invite := flag.String("invite", "", "invite code to get into a private server")
+	identityFile := flag.String("identity", "", "keep this device (its id, identity keys and prekeys) in this file, encrypted with the passphrase in "+IDENTITY_PASSPHRASE_ENV)
+	noHistoryCopies := flag.Bool("no-server-history", false, "never send the server a readable copy of our room messages (it then can't replay them to devices joining later)")
+	fips := flag.Bool("fips", false, "only use FIPS 140-3 approved algorithms (the server must be in FIPS mode too)")
+	flag.Parse()
+
+	if *fips {
+		cryptography.EnableFIPS()
+	}
+	log.Println(cryptography.FIPSStatus())
+
 	wsClient := NewClient()
+	wsClient.sealedSender = *sealedSender
+	wsClient.readReceipts = *readReceipts
+	wsClient.awayAfter = *awayAfter
+	wsClient.invite = *invite
+	wsClient.noHistoryCopies = *noHistoryCopies
+	if *historyFile != "" {
+		wsClient.history = newLocalHistory(*historyFile)
+		ui.Observe(wsClient.recordToHistory)
+	}
+	if *useTLS {
+		tlsConfig, err := transport.ClientConfig(*caFile)
+		if err != nil {
+			log.Fatalf("Could not load TLS configuration: %s\n", err.Error())
+		}
+		wsClient.tlsConfig = tlsConfig
+	}
+	if *identityFile != "" {
+		passphrase := os.Getenv(IDENTITY_PASSPHRASE_ENV)
+		if passphrase == "" {
+			log.Fatalf("-identity needs a passphrase in %s\n", IDENTITY_PASSPHRASE_ENV)
+		}
+		if err := wsClient.openIdentity(*identityFile, passphrase); err != nil {
+			log.Fatalf("Could not open the identity: %s\n", err.Error())
+		}
+	}
+	if *linkTo != "" {
+		if wsClient.conn.Keys.Seed != nil {
+			log.Fatalln("-link is for new devices, this one was restored from its identity file")
+		}
+		wsClient.linkDevice(*linkTo)
+	}
 
 	go wsClient.connectionManager()
+	if wsClient.awayAfter > 0 {
+		go wsClient.awayWhenIdle()
+	}
 
 	readFromStdin(wsClient)
 }
@@ -39,6 +98,64 @@ func readFromStdin(wsClient *WSClient) {
 
 		case "send":
 			wsClient.sendEncrypted(msg.Value)
+
+		case "export_identity":
+			wsClient.exportIdentity()
+
+		case "restore_identity":
+			wsClient.restoreIdentity(msg.Value)
+
+		case "history_unlock":
+			wsClient.unlockHistory(msg.Value)
+
+		case "history_search":
+			var query archive.Query
+			if err := json.Unmarshal([]byte(msg.Value), &query); err != nil {
+				log.Println("Error unmarshalling history search: ", err)
+				continue
+			}
+			wsClient.searchHistory(query)
+
+		case "message_seen":
+			wsClient.messageSeen(msg.Value)
+
+		case "edit_message":
+			var edit types.MessageEdit
+			if err := json.Unmarshal([]byte(msg.Value), &edit); err != nil {
+				log.Println("Error unmarshalling message edit: ", err)
+				continue
+			}
+			wsClient.editMessage(edit.ID, edit.Body)
+
+		case "delete_message":
+			wsClient.deleteMessage(msg.Value)
+
+		case "reply_message":
+			var reply types.MessageReply
+			if err := json.Unmarshal([]byte(msg.Value), &reply); err != nil {
+				log.Println("Error unmarshalling reply: ", err)
+				continue
+			}
+			wsClient.reply(reply.ID, reply.Body)
+
+		case "react", "unreact":
+			var reaction types.MessageReaction
+			if err := json.Unmarshal([]byte(msg.Value), &reaction); err != nil {
+				log.Println("Error unmarshalling reaction: ", err)
+				continue
+			}
+			wsClient.react(reaction.ID, reaction.Emoji, msg.Type == "react")
+
+		case "user_typing":
+			wsClient.userTyping()
+
+		case "set_status":
+			var presence types.Presence
+			if err := json.Unmarshal([]byte(msg.Value), &presence); err != nil {
+				log.Println("Error unmarshalling status: ", err)
+				continue
+			}
+			wsClient.setStatus(presence)
 		}
 	}
 }
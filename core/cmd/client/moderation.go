@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Guilospanck/pqc/core/pkg/group"
+	"github.com/Guilospanck/pqc/core/pkg/types"
+	"github.com/Guilospanck/pqc/core/pkg/ui"
+	"github.com/Guilospanck/pqc/core/pkg/ws"
+)
+
+// Asks the server to moderate the room. It checks we are allowed to,
+// then everyone is told (us included).
+func (client *WSClient) moderate(req types.Moderation) {
+	if !client.isConnected {
+		ui.EmitToUI(types.MessageTypeError, "Not connected.", ALERT_COLOR)
+		return
+	}
+
+	req.Room = group.DefaultGroupID
+	client.sendJSONMessage(types.MessageTypeModerate, req)
+}
+
+// /mute <username> <duration> [reason]
+func (client *WSClient) mute(username, args string) {
+	duration, reason, _ := strings.Cut(args, " ")
+
+	d, err := time.ParseDuration(duration)
+	if err != nil || d < time.Second {
+		usage("/mute <username> <duration, e.g. 10m> [reason]")
+		return
+	}
+
+	client.moderate(types.Moderation{
+		Action:   types.ModerationMute,
+		Target:   username,
+		Reason:   strings.TrimSpace(reason),
+		Duration: int64(d / time.Second),
+	})
+}
+
+// The topic, roles and mutes of a room, sent when we connect
+func (client *WSClient) handleRoomInfo(msg ws.WSMessage) {
+	var info types.RoomInfo
+	if err := json.Unmarshal(msg.Value, &info); err != nil {
+		log.Printf("[%s] Could not unmarshal room info: %s\n", client.conn.Metadata.Username, err.Error())
+		return
+	}
+
+	client.roomsMu.Lock()
+	client.rooms[info.Room] = info
+	client.roomsMu.Unlock()
+
+	ui.EmitToUI(types.MessageTypeRoomInfo, string(msg.Value), "")
+}
+
+// Someone moderated a room. If we are the ones kicked or banned, the server
+// disconnects us right after.
+func (client *WSClient) handleModeration(msg ws.WSMessage) {
+	var event types.Moderation
+	if err := json.Unmarshal(msg.Value, &event); err != nil {
+		log.Printf("[%s] Could not unmarshal moderation: %s\n", client.conn.Metadata.Username, err.Error())
+		return
+	}
+
+	client.roomsMu.Lock()
+	info, ok := client.rooms[event.Room]
+	if ok {
+		switch event.Action {
+		case types.ModerationMute:
+			info.Muted[event.Target] = event.Until
+		case types.ModerationUnmute:
+			delete(info.Muted, event.Target)
+		case types.ModerationTopic:
+			info.Topic = event.Value
+		case types.ModerationInviteOnly:
+			info.InviteOnly = event.Value == "on"
+		case types.ModerationRole:
+			if event.Value == types.RoomRoleMember {
+				delete(info.Roles, event.Target)
+			} else {
+				info.Roles[event.Target] = event.Value
+			}
+		}
+		client.rooms[event.Room] = info
+	}
+	client.roomsMu.Unlock()
+
+	ui.EmitToUI(types.MessageTypeModeration, string(msg.Value), "")
+}
+
+func roleRank(role types.RoomRole) int {
+	switch role {
+	case types.RoomRoleOwner:
+		return 2
+	case types.RoomRoleModerator:
+		return 1
+	}
+
+	return 0
+}
+
+// Whether `actor` is a moderator of `room` with a higher role than `username`,
+// so it can delete its messages. The server checks it too.
+func (client *WSClient) outranks(room, actor, username string) bool {
+	client.roomsMu.Lock()
+	defer client.roomsMu.Unlock()
+
+	info, ok := client.rooms[room]
+	if !ok {
+		return false
+	}
+
+	actorRank := roleRank(info.Roles[actor])
+	return actorRank >= roleRank(types.RoomRoleModerator) && actorRank > roleRank(info.Roles[username])
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/Guilospanck/pqc/core/pkg/chat"
+	"github.com/Guilospanck/pqc/core/pkg/pqxdh"
+	"github.com/Guilospanck/pqc/core/pkg/types"
+	"github.com/Guilospanck/pqc/core/pkg/ui"
+	"github.com/Guilospanck/pqc/core/pkg/ws"
+)
+
+// Publishes our prekeys so others can start sessions with us while we are offline.
+// The first time we also create the key ring and a batch of one-time prekeys;
+// on reconnections the server tells us if it needs more.
+func (client *WSClient) uploadPrekeys() error {
+	oneTime := 0
+	if client.keyRing == nil {
+		keyRing, err := pqxdh.NewKeyRing(client.conn.Keys.Signing)
+		if err != nil {
+			log.Printf("[%s] Error generating prekeys: %s\n", client.conn.Metadata.Username, err.Error())
+			return err
+		}
+
+		client.keyRing = keyRing
+		oneTime = PREKEY_BATCH_SIZE
+	}
+
+	return client.sendPrekeys(oneTime)
+}
+
+func (client *WSClient) sendPrekeys(oneTime int) error {
+	upload, err := client.keyRing.Upload(client.conn.Keys.Public, oneTime)
+	if err != nil {
+		log.Printf("[%s] Error generating one-time prekeys: %s\n", client.conn.Metadata.Username, err.Error())
+		return err
+	}
+	// The private keys of the new prekeys must be kept before anyone uses them
+	client.saveIdentity()
+
+	client.sendJSONMessage(types.MessageTypePrekeyUpload, upload)
+	return nil
+}
+
+func (client *WSClient) handlePrekeysLow(msg ws.WSMessage) {
+	var count pqxdh.PrekeyCount
+	if err := json.Unmarshal(msg.Value, &count); err != nil {
+		log.Printf("[%s] Could not unmarshal prekey count: %s\n", client.conn.Metadata.Username, err.Error())
+		return
+	}
+
+	log.Printf("[%s] Only %d one-time prekeys left on the server, uploading more\n", client.conn.Metadata.Username, count.Remaining)
+	client.sendPrekeys(PREKEY_BATCH_SIZE)
+}
+
+// A message waiting for the prekey bundles of the devices it goes to
+type pendingMessage struct {
+	payload types.ChatMessage
+	copy    bool // for our other devices: the message is for someone else
+}
+
+// Sends a message to `username` even if it is offline: we ask the server
+// for a prekey bundle of each of its devices and finish sending once they arrive.
+func (client *WSClient) sendMail(username, text string) {
+	payload, err := chat.New(client.conn.Metadata.Username, "", text, "")
+	if err != nil {
+		log.Printf("[%s] Could not create mail: %s\n", client.conn.Metadata.Username, err.Error())
+		return
+	}
+
+	client.sendEndToEnd(username, pendingMessage{payload: payload})
+}
+
+func (client *WSClient) sendEndToEnd(username string, pending pendingMessage) {
+	client.mailMu.Lock()
+	client.pendingMail[username] = append(client.pendingMail[username], pending)
+	client.mailMu.Unlock()
+
+	client.sendJSONMessage(types.MessageTypePrekeyBundle, pqxdh.BundleRequest{Username: username})
+}
+
+// Every set of bundles (and their one-time prekeys) is used for exactly one pending message
+func (client *WSClient) takePendingMail(username string) (pendingMessage, bool) {
+	client.mailMu.Lock()
+	defer client.mailMu.Unlock()
+
+	pending := client.pendingMail[username]
+	if len(pending) == 0 {
+		return pendingMessage{}, false
+	}
+
+	client.pendingMail[username] = pending[1:]
+	if len(client.pendingMail[username]) == 0 {
+		delete(client.pendingMail, username)
+	}
+
+	return pending[0], true
+}
+
+func (client *WSClient) handlePrekeyBundle(msg ws.WSMessage) {
+	var bundles pqxdh.Bundles
+	if err := json.Unmarshal(msg.Value, &bundles); err != nil {
+		log.Printf("[%s] Could not unmarshal prekey bundles: %s\n", client.conn.Metadata.Username, err.Error())
+		return
+	}
+
+	pending, ok := client.takePendingMail(bundles.Username)
+	if !ok {
+		log.Printf("[%s] Received unexpected prekey bundles for %s\n", client.conn.Metadata.Username, bundles.Username)
+		return
+	}
+
+	// The server has no prekeys for this user
+	if len(bundles.Bundles) == 0 {
+		if !pending.copy {
+			ui.EmitToUI(types.MessageTypeError, fmt.Sprintf("%s never published prekeys, message not sent.", bundles.Username), ALERT_COLOR)
+		}
+		return
+	}
+
+	// Every device of the recipient gets the same message
+	payload := pending.payload
+	marshalled, err := chat.Marshal(payload)
+	if err != nil {
+		log.Printf("[%s] Could not create mail: %s\n", client.conn.Metadata.Username, err.Error())
+		return
+	}
+
+	for _, bundle := range bundles.Bundles {
+		// Copies only go to our other devices
+		if pending.copy && bundle.Device == client.conn.Metadata.Device {
+			continue
+		}
+
+		client.sendPrekeyMessage(bundle, payload.ID, marshalled)
+	}
+
+	if !pending.copy {
+		client.messageSent(payload)
+	}
+
+	// The server keeps it until they connect
+	if !pending.copy && payload.Recipient != "" && !bundles.Online {
+		ui.EmitToUI(types.MessageTypeError, fmt.Sprintf("%s is offline, message queued.", bundles.Username), ALERT_COLOR)
+	}
+}
+
+// Starts a session with one device of the recipient
+func (client *WSClient) sendPrekeyMessage(bundle pqxdh.Bundle, id string, payload []byte) {
+	if err := bundle.Verify(); err != nil {
+		client.alertKeyTransparency(err.Error())
+		return
+	}
+
+	binding, ok := client.keyMonitor.Binding(bundle.Username, bundle.Device)
+	if !ok || !bytes.Equal(binding.PublicKey, bundle.IdentityKey) || !bytes.Equal(binding.SigningKey, bundle.SigningKey) {
+		client.alertKeyTransparency(fmt.Sprintf("the prekey bundle of %s (device %s) does not match its published keys", bundle.Username, bundle.Device))
+		return
+	}
+
+	initial, err := pqxdh.Initiate(client.conn.Metadata.Username, client.conn.Metadata.Device, client.conn.Keys.Public, client.conn.Keys.Signing, bundle, payload)
+	if err != nil {
+		log.Printf("[%s] Could not start a session with %s: %s\n", client.conn.Metadata.Username, bundle.Username, err.Error())
+		return
+	}
+
+	if bundle.LastResort {
+		log.Printf("[%s] Using the last-resort prekey of %s\n", client.conn.Metadata.Username, bundle.Username)
+	}
+
+	if client.sealedSender {
+		client.sendSealed(bundle, id, initial)
+		return
+	}
+
+	client.sendJSONMessageWithID(types.MessageTypePrekeyMessage, id, initial)
+}
+
+func (client *WSClient) handlePrekeyMessage(msg ws.WSMessage) {
+	var initial pqxdh.InitialMessage
+	if err := json.Unmarshal(msg.Value, &initial); err != nil {
+		log.Printf("[%s] Could not unmarshal prekey message: %s\n", client.conn.Metadata.Username, err.Error())
+		return
+	}
+
+	client.receivePrekeyMessage(initial, true)
+}
+
+// Receipts tell the server who sent the message, so they aren't sent
+// for sealed messages (`withReceipt` is false)
+func (client *WSClient) receivePrekeyMessage(initial pqxdh.InitialMessage, withReceipt bool) {
+	binding, ok := client.keyMonitor.Binding(initial.From, initial.FromDevice)
+	if !ok || !bytes.Equal(binding.PublicKey, initial.SenderIdentityKey) || !bytes.Equal(binding.SigningKey, initial.SenderSigningKey) {
+		client.alertKeyTransparency(fmt.Sprintf("a prekey message from %s was not sent with its published keys", initial.From))
+		return
+	}
+
+	if client.keyRing == nil {
+		log.Printf("[%s] Received a prekey message before having prekeys\n", client.conn.Metadata.Username)
+		return
+	}
+
+	plaintext, err := client.keyRing.Respond(client.conn.Keys.Private, initial)
+	if err != nil {
+		log.Printf("[%s] Could not decrypt prekey message from %s: %s\n", client.conn.Metadata.Username, initial.From, err.Error())
+		return
+	}
+	// Its one-time prekey is gone for good
+	client.saveIdentity()
+
+	payload, err := chat.Parse(plaintext)
+	if err != nil {
+		log.Printf("[%s] Could not read mail from %s: %s\n", client.conn.Metadata.Username, initial.From, err.Error())
+		return
+	}
+
+	if payload.Action != "" {
+		log.Printf("[%s] Ignoring %s sent as mail by %s\n", client.conn.Metadata.Username, payload.Action, initial.From)
+		return
+	}
+
+	if payload.Sender != initial.From {
+		client.alertKeyTransparency(fmt.Sprintf("a mail from %s claims to be from %s", initial.From, payload.Sender))
+		return
+	}
+
+	if payload.Recipient != "" {
+		client.receiveDirectMessage(payload, withReceipt)
+		return
+	}
+
+	ui.EmitChatMessage(types.MessageTypeMail, payload, "")
+
+	if withReceipt {
+		client.messageReceived(payload)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/Guilospanck/pqc/core/pkg/chat"
+	"github.com/Guilospanck/pqc/core/pkg/types"
+	"github.com/Guilospanck/pqc/core/pkg/ui"
+	"github.com/Guilospanck/pqc/core/pkg/ws"
+)
+
+// The user chose a status (/status or `set_status`)
+func (client *WSClient) setStatus(presence types.Presence) {
+	if err := chat.ValidatePresence(presence); err != nil {
+		ui.EmitToUI(types.MessageTypeError, "The status must be online, away or busy, with a short text.", ALERT_COLOR)
+		return
+	}
+
+	client.presenceMu.Lock()
+	client.autoAway = false
+	client.presenceMu.Unlock()
+
+	client.changePresence(presence)
+}
+
+func (client *WSClient) changePresence(presence types.Presence) {
+	presence.Username = client.conn.Metadata.Username
+
+	client.presenceMu.Lock()
+	client.presence = presence
+	client.presenceMu.Unlock()
+
+	client.emitPresence(presence)
+
+	// Otherwise it is sent once we are connected again
+	if client.isConnected {
+		client.sendJSONMessage(types.MessageTypePresence, presence)
+	}
+}
+
+// When we connect the server thinks we are online
+func (client *WSClient) restorePresence() {
+	client.presenceMu.Lock()
+	presence := client.presence
+	client.presenceMu.Unlock()
+
+	if presence.Status == types.PresenceOnline && presence.Text == "" {
+		return
+	}
+
+	presence.Username = client.conn.Metadata.Username
+	client.sendJSONMessage(types.MessageTypePresence, presence)
+}
+
+// The user did something: if we said it was away, it is back
+func (client *WSClient) userActive() {
+	client.presenceMu.Lock()
+	client.lastActivity = time.Now()
+	back := client.autoAway
+	client.autoAway = false
+	presence := client.presence
+	client.presenceMu.Unlock()
+
+	if back {
+		presence.Status = types.PresenceOnline
+		client.changePresence(presence)
+	}
+}
+
+// Sets the user away once it has been idle for `awayAfter`
+func (client *WSClient) awayWhenIdle() {
+	ticker := time.NewTicker(min(IDLE_CHECK_PERIOD, client.awayAfter))
+	defer ticker.Stop()
+
+	for range ticker.C {
+		client.presenceMu.Lock()
+		idle := client.presence.Status == types.PresenceOnline && time.Since(client.lastActivity) >= client.awayAfter
+		if idle {
+			client.autoAway = true
+		}
+		presence := client.presence
+		client.presenceMu.Unlock()
+
+		if idle {
+			log.Printf("[%s] Idle for %s, going away\n", client.conn.Metadata.Username, client.awayAfter)
+			presence.Status = types.PresenceAway
+			client.changePresence(presence)
+		}
+	}
+}
+
+func (client *WSClient) emitPresence(presence types.Presence) {
+	marshalled, err := json.Marshal(presence)
+	if err != nil {
+		log.Printf("[%s] Could not marshal status: %s\n", client.conn.Metadata.Username, err.Error())
+		return
+	}
+
+	ui.EmitToUI(types.MessageTypePresence, string(marshalled), "")
+}
+
+// Someone else changed its status, or we did from another device
+func (client *WSClient) handlePresence(msg ws.WSMessage) {
+	var presence types.Presence
+	if err := json.Unmarshal(msg.Value, &presence); err != nil {
+		log.Printf("[%s] Could not unmarshal status: %s\n", client.conn.Metadata.Username, err.Error())
+		return
+	}
+
+	if presence.Username == client.conn.Metadata.Username {
+		client.presenceMu.Lock()
+		client.presence = presence
+		client.autoAway = false
+		client.presenceMu.Unlock()
+	}
+
+	client.emitPresence(presence)
+}
+
+// The user pressed a key. Others are told we are typing at most once every
+// TYPING_THROTTLE, and that we stopped after TYPING_TIMEOUT without keys.
+func (client *WSClient) userTyping() {
+	client.userActive()
+
+	client.typingMu.Lock()
+	defer client.typingMu.Unlock()
+
+	if time.Since(client.typingSent) >= TYPING_THROTTLE {
+		client.sendTyping(true)
+		client.typingSent = time.Now()
+	}
+
+	if client.typingStop != nil {
+		client.typingStop.Stop()
+	}
+	client.typingStop = time.AfterFunc(TYPING_TIMEOUT, client.stoppedTyping)
+}
+
+// The user sent what it was typing, or stopped for a while
+func (client *WSClient) stoppedTyping() {
+	client.typingMu.Lock()
+	defer client.typingMu.Unlock()
+
+	if client.typingSent.IsZero() {
+		return
+	}
+
+	client.typingSent = time.Time{}
+	if client.typingStop != nil {
+		client.typingStop.Stop()
+		client.typingStop = nil
+	}
+
+	client.sendTyping(false)
+}
+
+func (client *WSClient) sendTyping(typing bool) {
+	if !client.isConnected {
+		return
+	}
+
+	client.sendJSONMessage(types.MessageTypeTyping, types.Typing{Username: client.conn.Metadata.Username, Typing: typing})
+}
+
+func (client *WSClient) handleTyping(msg ws.WSMessage) {
+	var typing types.Typing
+	if err := json.Unmarshal(msg.Value, &typing); err != nil {
+		log.Printf("[%s] Could not unmarshal typing indicator: %s\n", client.conn.Metadata.Username, err.Error())
+		return
+	}
+
+	ui.EmitToUI(types.MessageTypeTyping, string(msg.Value), "")
+}
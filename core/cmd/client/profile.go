@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/Guilospanck/pqc/core/pkg/types"
+	"github.com/Guilospanck/pqc/core/pkg/ui"
+	"github.com/Guilospanck/pqc/core/pkg/ws"
+)
+
+// Asks the server for a new username and/or color
+func (client *WSClient) changeProfile(change types.ProfileChange) {
+	if !client.isConnected {
+		ui.EmitToUI(types.MessageTypeError, "Not connected.", ALERT_COLOR)
+		return
+	}
+
+	client.sendJSONMessage(types.MessageTypeProfileChange, change)
+}
+
+// Someone changed its username or color. If it was us, the server then
+// closes our session (see renamed) and we connect again as the new user.
+func (client *WSClient) handleUserRenamed(msg ws.WSMessage) {
+	var renamed types.UserRenamed
+	if err := json.Unmarshal(msg.Value, &renamed); err != nil {
+		log.Printf("[%s] Could not unmarshal rename: %s\n", client.conn.Metadata.Username, err.Error())
+		return
+	}
+
+	if renamed.Username == client.conn.Metadata.Username {
+		log.Printf("[%s] We are now %s (%s)\n", client.conn.Metadata.Username, renamed.NewUsername, renamed.Color)
+		client.conn.Metadata.Username = renamed.NewUsername
+		client.conn.Metadata.Color = renamed.Color
+	}
+
+	ui.EmitToUI(types.MessageTypeUserRenamed, string(msg.Value), renamed.Color)
+}
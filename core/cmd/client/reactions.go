@@ -0,0 +1,100 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"log"
+	"slices"
+
+	"github.com/Guilospanck/pqc/core/pkg/chat"
+	"github.com/Guilospanck/pqc/core/pkg/group"
+	"github.com/Guilospanck/pqc/core/pkg/types"
+	"github.com/Guilospanck/pqc/core/pkg/ui"
+)
+
+// Who reacted to a message, with each emoji
+type reactionSet map[string][]string
+
+func (client *WSClient) react(id, emoji string, add bool) {
+	if !chat.ValidReaction(emoji) {
+		ui.EmitToUI(types.MessageTypeError, "Reactions are emojis, without spaces.", ALERT_COLOR)
+		return
+	}
+
+	action := types.ChatActionUnreact
+	if add {
+		action = types.ChatActionReact
+	}
+
+	client.sendChatAction(action, id, emoji)
+}
+
+// Replies to the message `id` (in the room)
+func (client *WSClient) reply(id, text string) {
+	original, ok := client.known.get(id)
+	if !ok || original.room != group.DefaultGroupID {
+		ui.EmitToUI(types.MessageTypeError, "Unknown message.", ALERT_COLOR)
+		return
+	}
+
+	payload, err := chat.New(client.conn.Metadata.Username, original.room, text, id)
+	if err != nil {
+		log.Printf("[%s] Could not create reply: %s\n", client.conn.Metadata.Username, err.Error())
+		return
+	}
+
+	if !client.sendChatMessage(payload) {
+		ui.EmitToUI(types.MessageTypeError, "Not connected, reply not sent.", ALERT_COLOR)
+	}
+}
+
+func (client *WSClient) applyReaction(payload types.ChatMessage) {
+	client.reactionsMu.Lock()
+	current, _ := client.reactions.get(payload.Target)
+
+	// A copy, the previous set may still be read
+	reactions := make(reactionSet, len(current))
+	for emoji, users := range current {
+		reactions[emoji] = slices.Clone(users)
+	}
+
+	users := slices.DeleteFunc(reactions[payload.Body], func(user string) bool { return user == payload.Sender })
+	if payload.Action == types.ChatActionReact {
+		users = append(users, payload.Sender)
+	}
+	reactions[payload.Body] = users
+	if len(users) == 0 {
+		delete(reactions, payload.Body)
+	}
+
+	client.reactions.add(payload.Target, reactions)
+	client.reactionsMu.Unlock()
+
+	client.emitReactions(payload.Target, reactions)
+}
+
+func (client *WSClient) emitReactions(id string, reactions reactionSet) {
+	counts := make([]types.ReactionCount, 0, len(reactions))
+	for emoji, users := range reactions {
+		counts = append(counts, types.ReactionCount{
+			Emoji: emoji,
+			Count: len(users),
+			Mine:  slices.Contains(users, client.conn.Metadata.Username),
+		})
+	}
+
+	slices.SortFunc(counts, func(a, b types.ReactionCount) int {
+		if a.Count != b.Count {
+			return b.Count - a.Count
+		}
+		return cmp.Compare(a.Emoji, b.Emoji)
+	})
+
+	marshalled, err := json.Marshal(types.MessageReactions{ID: id, Reactions: counts})
+	if err != nil {
+		log.Printf("[%s] Could not marshal reactions: %s\n", client.conn.Metadata.Username, err.Error())
+		return
+	}
+
+	ui.EmitToUI(types.MessageTypeMessageReactions, string(marshalled), "")
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"slices"
+	"sync"
+
+	"github.com/Guilospanck/pqc/core/pkg/chat"
+	"github.com/Guilospanck/pqc/core/pkg/types"
+	"github.com/Guilospanck/pqc/core/pkg/ui"
+	"github.com/Guilospanck/pqc/core/pkg/ws"
+)
+
+// Something about the last `size` messages, by id. Older ones are forgotten.
+type messageIndex[T any] struct {
+	values map[string]T
+	order  []string // oldest first
+	size   int
+	mu     sync.Mutex
+}
+
+func newMessageIndex[T any](size int) *messageIndex[T] {
+	return &messageIndex[T]{values: make(map[string]T), order: make([]string, 0), size: size}
+}
+
+func (idx *messageIndex[T]) add(id string, value T) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if _, ok := idx.values[id]; ok {
+		idx.values[id] = value
+		return
+	}
+
+	idx.values[id] = value
+	idx.order = append(idx.order, id)
+
+	if len(idx.order) > idx.size {
+		delete(idx.values, idx.order[0])
+		idx.order = idx.order[1:]
+	}
+}
+
+func (idx *messageIndex[T]) get(id string) (T, bool) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	value, ok := idx.values[id]
+	return value, ok
+}
+
+func (idx *messageIndex[T]) take(id string) (T, bool) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	value, ok := idx.values[id]
+	if !ok {
+		return value, false
+	}
+
+	delete(idx.values, id)
+	idx.order = slices.DeleteFunc(idx.order, func(other string) bool { return other == id })
+	return value, true
+}
+
+// A message we wrote to the server
+func (client *WSClient) messageSent(payload types.ChatMessage) {
+	// Our own edits are shown (and saved) right away
+	if payload.Action != "" {
+		client.applyChatAction(payload)
+		return
+	}
+
+	client.rememberMessage(payload)
+	if payload.Room != "" {
+		client.lastSent.Store(payload.ID)
+	}
+
+	client.recordSentMessage(payload)
+	client.emitMessageStatus(types.MessageStatusUpdate{ID: payload.ID, Status: types.MessageStatusSent, Message: &payload})
+}
+
+// Tells its sender that we got a message and, if read receipts are on,
+// keeps it until the UI reports it as seen
+func (client *WSClient) messageReceived(payload types.ChatMessage) {
+	if payload.Sender == client.conn.Metadata.Username {
+		return
+	}
+
+	client.sendReceipt(payload.ID, payload.Sender, types.MessageStatusDelivered)
+
+	if client.readReceipts {
+		client.unread.add(payload.ID, payload.Sender)
+	}
+}
+
+// The UI showed the message `id` to the user
+func (client *WSClient) messageSeen(id string) {
+	if !client.readReceipts {
+		return
+	}
+
+	sender, ok := client.unread.take(id)
+	if !ok {
+		return
+	}
+
+	client.sendReceipt(id, sender, types.MessageStatusRead)
+}
+
+func (client *WSClient) sendReceipt(id, sender string, status types.MessageStatus) {
+	client.sendJSONMessage(types.MessageTypeMessageReceipt, chat.Receipt{ID: id, Status: status, Sender: sender})
+}
+
+func (client *WSClient) handleMessageAck(msg ws.WSMessage) {
+	var ack chat.Ack
+	if err := json.Unmarshal(msg.Value, &ack); err != nil {
+		log.Printf("[%s] Could not unmarshal message ack: %s\n", client.conn.Metadata.Username, err.Error())
+		return
+	}
+
+	client.emitMessageStatus(types.MessageStatusUpdate{ID: ack.ID, Status: types.MessageStatusAccepted})
+}
+
+func (client *WSClient) handleMessageReceipt(msg ws.WSMessage) {
+	var receipt chat.Receipt
+	if err := json.Unmarshal(msg.Value, &receipt); err != nil {
+		log.Printf("[%s] Could not unmarshal receipt: %s\n", client.conn.Metadata.Username, err.Error())
+		return
+	}
+
+	if err := receipt.Validate(); err != nil {
+		log.Printf("[%s] Invalid receipt: %s\n", client.conn.Metadata.Username, err.Error())
+		return
+	}
+
+	client.emitMessageStatus(types.MessageStatusUpdate{ID: receipt.ID, Status: receipt.Status, By: receipt.Recipient})
+}
+
+func (client *WSClient) emitMessageStatus(update types.MessageStatusUpdate) {
+	marshalled, err := json.Marshal(update)
+	if err != nil {
+		log.Printf("[%s] Could not marshal message status: %s\n", client.conn.Metadata.Username, err.Error())
+		return
+	}
+
+	ui.EmitToUI(types.MessageTypeMessageStatus, string(marshalled), "")
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/Guilospanck/pqc/core/pkg/sas"
+	"github.com/Guilospanck/pqc/core/pkg/types"
+	"github.com/Guilospanck/pqc/core/pkg/ui"
+	"github.com/Guilospanck/pqc/core/pkg/ws"
+)
+
+func (client *WSClient) ownSASKeys() sas.Keys {
+	return sas.Keys{
+		IdentityKey: client.conn.Keys.Public,
+		SigningKey:  client.conn.Keys.Signing.Public().(ed25519.PublicKey),
+	}
+}
+
+// The keys we compare are the ones published in the key log
+func (client *WSClient) peerSASKeys(username, device string) (sas.Keys, bool) {
+	binding, ok := client.keyMonitor.Binding(username, device)
+	if !ok {
+		return sas.Keys{}, false
+	}
+
+	return sas.Keys{IdentityKey: binding.PublicKey, SigningKey: binding.SigningKey}, true
+}
+
+func (client *WSClient) verification(id string) (*sas.Session, bool) {
+	client.verifyMu.Lock()
+	defer client.verifyMu.Unlock()
+
+	session, ok := client.verifications[id]
+	return session, ok
+}
+
+func (client *WSClient) endVerification(id string) {
+	client.verifyMu.Lock()
+	defer client.verifyMu.Unlock()
+
+	delete(client.verifications, id)
+}
+
+// Starts the verification of the keys of `username`.
+// If `device` is empty, the first device of the user that answers is verified.
+func (client *WSClient) startVerification(username, device string) {
+	session, request := sas.Start(client.conn.Metadata.Username, client.conn.Metadata.Device, username, device, client.ownSASKeys())
+
+	client.verifyMu.Lock()
+	client.verifications[session.ID] = session
+	client.verifyMu.Unlock()
+
+	log.Printf("[%s] Starting verification %s with %s\n", client.conn.Metadata.Username, session.ID, username)
+	client.sendJSONMessage(types.MessageTypeSASRequest, request)
+}
+
+func (client *WSClient) handleSASRequest(msg ws.WSMessage) {
+	var request sas.Request
+	if err := json.Unmarshal(msg.Value, &request); err != nil {
+		log.Printf("[%s] Could not unmarshal verification request: %s\n", client.conn.Metadata.Username, err.Error())
+		return
+	}
+
+	peerKeys, ok := client.peerSASKeys(request.From, request.FromDevice)
+	if !ok {
+		log.Printf("[%s] No published keys for %s (device %s), ignoring verification\n", client.conn.Metadata.Username, request.From, request.FromDevice)
+		return
+	}
+
+	session, accept := sas.Respond(client.conn.Metadata.Username, client.conn.Metadata.Device, request, client.ownSASKeys(), peerKeys)
+
+	client.verifyMu.Lock()
+	client.verifications[session.ID] = session
+	client.verifyMu.Unlock()
+
+	ui.EmitToUI(types.MessageTypeSASRequest, session.String(), msg.Metadata.Color)
+	client.sendJSONMessage(types.MessageTypeSASAccept, accept)
+}
+
+func (client *WSClient) handleSASAccept(msg ws.WSMessage) {
+	var accept sas.Accept
+	if err := json.Unmarshal(msg.Value, &accept); err != nil {
+		log.Printf("[%s] Could not unmarshal verification accept: %s\n", client.conn.Metadata.Username, err.Error())
+		return
+	}
+
+	session, ok := client.verification(accept.TransactionID)
+	if !ok || accept.From != session.Peer {
+		return
+	}
+
+	// Another device of the user was faster
+	if session.State() != sas.StateRequested {
+		client.sendJSONMessage(types.MessageTypeSASCancel, sas.Cancel{
+			Envelope: sas.Envelope{
+				TransactionID: session.ID,
+				From:          client.conn.Metadata.Username,
+				FromDevice:    client.conn.Metadata.Device,
+				To:            accept.From,
+				ToDevice:      accept.FromDevice,
+			},
+			Reason: "another device answered first",
+		})
+		return
+	}
+
+	peerKeys, ok := client.peerSASKeys(accept.From, accept.FromDevice)
+	if !ok {
+		client.cancelVerification(session, fmt.Sprintf("no published keys for device %s", accept.FromDevice))
+		return
+	}
+
+	reveal, err := session.HandleAccept(accept, peerKeys)
+	if err != nil {
+		client.cancelVerification(session, err.Error())
+		return
+	}
+
+	client.sendJSONMessage(types.MessageTypeSASReveal, reveal)
+	client.emitSASCode(session)
+}
+
+func (client *WSClient) handleSASReveal(msg ws.WSMessage) {
+	var reveal sas.Reveal
+	if err := json.Unmarshal(msg.Value, &reveal); err != nil {
+		log.Printf("[%s] Could not unmarshal verification reveal: %s\n", client.conn.Metadata.Username, err.Error())
+		return
+	}
+
+	session, ok := client.verification(reveal.TransactionID)
+	if !ok || reveal.From != session.Peer || reveal.FromDevice != session.PeerDevice {
+		return
+	}
+
+	if err := session.HandleReveal(reveal); err != nil {
+		client.cancelVerification(session, err.Error())
+		return
+	}
+
+	client.emitSASCode(session)
+}
+
+func (client *WSClient) emitSASCode(session *sas.Session) {
+	text := fmt.Sprintf("%s with %s: %s", session.ID, session, sas.Format(session.Code()))
+	ui.EmitToUI(types.MessageTypeSASCode, text, "")
+}
+
+// The user compared the strings: `match` tells whether they are the same
+func (client *WSClient) confirmVerification(id string, match bool) {
+	session, ok := client.verification(id)
+	if !ok || session.State() != sas.StateComparing {
+		ui.EmitToUI(types.MessageTypeError, fmt.Sprintf("No verification %s is waiting for confirmation.", id), ALERT_COLOR)
+		return
+	}
+
+	if !match {
+		client.cancelVerification(session, "the emojis did not match")
+		client.alertKeyTransparency(fmt.Sprintf("the verification of %s failed: the server might be tampering with its keys", session))
+		return
+	}
+
+	confirm, err := session.Confirm()
+	if err != nil {
+		client.cancelVerification(session, err.Error())
+		return
+	}
+
+	client.sendJSONMessage(types.MessageTypeSASConfirm, confirm)
+	client.finishVerification(session)
+}
+
+func (client *WSClient) handleSASConfirm(msg ws.WSMessage) {
+	var confirm sas.Confirm
+	if err := json.Unmarshal(msg.Value, &confirm); err != nil {
+		log.Printf("[%s] Could not unmarshal verification confirm: %s\n", client.conn.Metadata.Username, err.Error())
+		return
+	}
+
+	session, ok := client.verification(confirm.TransactionID)
+	if !ok || confirm.From != session.Peer || confirm.FromDevice != session.PeerDevice {
+		return
+	}
+
+	if err := session.HandleConfirm(confirm); err != nil {
+		client.cancelVerification(session, err.Error())
+		return
+	}
+
+	client.finishVerification(session)
+}
+
+// Once both users confirmed, the keys are verified
+func (client *WSClient) finishVerification(session *sas.Session) {
+	if session.State() != sas.StateVerified {
+		return
+	}
+
+	client.endVerification(session.ID)
+	log.Printf("[%s] Verified keys of %s\n", client.conn.Metadata.Username, session)
+	ui.EmitToUI(types.MessageTypeSASVerified, session.String(), "")
+}
+
+func (client *WSClient) handleSASCancel(msg ws.WSMessage) {
+	var cancel sas.Cancel
+	if err := json.Unmarshal(msg.Value, &cancel); err != nil {
+		log.Printf("[%s] Could not unmarshal verification cancel: %s\n", client.conn.Metadata.Username, err.Error())
+		return
+	}
+
+	session, ok := client.verification(cancel.TransactionID)
+	if !ok || cancel.From != session.Peer || (session.PeerDevice != "" && cancel.FromDevice != session.PeerDevice) {
+		return
+	}
+
+	client.endVerification(session.ID)
+	session.Cancel(cancel.Reason)
+	ui.EmitToUI(types.MessageTypeSASCancel, fmt.Sprintf("%s with %s: %s", session.ID, session, cancel.Reason), ALERT_COLOR)
+}
+
+func (client *WSClient) cancelVerification(session *sas.Session, reason string) {
+	client.endVerification(session.ID)
+	client.sendJSONMessage(types.MessageTypeSASCancel, session.Cancel(reason))
+	ui.EmitToUI(types.MessageTypeSASCancel, fmt.Sprintf("%s with %s: %s", session.ID, session, reason), ALERT_COLOR)
+}
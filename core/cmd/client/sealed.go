@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/Guilospanck/pqc/core/pkg/pqxdh"
+	"github.com/Guilospanck/pqc/core/pkg/sealed"
+	"github.com/Guilospanck/pqc/core/pkg/transparency"
+	"github.com/Guilospanck/pqc/core/pkg/types"
+	"github.com/Guilospanck/pqc/core/pkg/ui"
+	"github.com/Guilospanck/pqc/core/pkg/ws"
+
+	"github.com/gorilla/websocket"
+)
+
+// Sends a prekey message sealed to the identity key of its recipient device,
+// so the server only learns who it is for. The publication of our own keys
+// goes inside, for the recipient to know (and check) who sent it.
+// It still goes through our own connection: the server can tell which
+// connection sent it, it just has nothing else to go on (see the server side).
+func (client *WSClient) sendSealed(bundle pqxdh.Bundle, id string, initial pqxdh.InitialMessage) {
+	publication := client.ownPublication
+	if publication == nil || !publication.Binding.Equal(client.ownBinding()) {
+		ui.EmitToUI(types.MessageTypeError, "Our keys are not in the key log yet, message not sent.", ALERT_COLOR)
+		return
+	}
+
+	message, err := json.Marshal(initial)
+	if err != nil {
+		log.Printf("[%s] Could not marshal prekey message: %s\n", client.conn.Metadata.Username, err.Error())
+		return
+	}
+
+	envelope, err := sealed.Seal(bundle.IdentityKey, bundle.DeliveryToken, *publication, client.conn.Keys.Signing, message)
+	if err != nil {
+		log.Printf("[%s] Could not seal message to %s: %s\n", client.conn.Metadata.Username, bundle.Username, err.Error())
+		return
+	}
+
+	marshalled, err := json.Marshal(envelope)
+	if err != nil {
+		log.Printf("[%s] Could not marshal sealed message: %s\n", client.conn.Metadata.Username, err.Error())
+		return
+	}
+
+	// Unlike everything else we send, it doesn't carry our metadata
+	// (username, color and device)
+	msg := ws.WSMessage{
+		Type:  types.MessageTypeSealedMessage,
+		Value: marshalled,
+		ID:    id,
+	}
+	if err := client.conn.WriteMessage(string(msg.Marshal()), websocket.TextMessage); err != nil {
+		log.Printf("[%s] Error trying to send sealed message to server: %s\n", client.conn.Metadata.Username, err.Error())
+	}
+}
+
+func (client *WSClient) handleSealedMessage(msg ws.WSMessage) {
+	var envelope sealed.Envelope
+	if err := json.Unmarshal(msg.Value, &envelope); err != nil {
+		log.Printf("[%s] Could not unmarshal sealed message: %s\n", client.conn.Metadata.Username, err.Error())
+		return
+	}
+
+	content, err := sealed.Open(client.conn.Keys.Private, envelope)
+	if err != nil {
+		log.Printf("[%s] Could not open sealed message: %s\n", client.conn.Metadata.Username, err.Error())
+		return
+	}
+
+	// The sender keys must be in the same key log as everyone else's
+	sender := content.Sender
+	if err := client.observeTreeHead(sender.LogKey, sender.TreeHead); err != nil {
+		client.alertKeyTransparency(err.Error())
+		return
+	}
+	if err := client.keyMonitor.VerifyPublication(sender); err != nil {
+		client.alertKeyTransparency(err.Error())
+		return
+	}
+
+	var initial pqxdh.InitialMessage
+	if err := json.Unmarshal(content.Message, &initial); err != nil {
+		log.Printf("[%s] Could not unmarshal sealed prekey message: %s\n", client.conn.Metadata.Username, err.Error())
+		return
+	}
+
+	if initial.From != sender.Binding.Username || initial.FromDevice != sender.Binding.Device {
+		client.alertKeyTransparency(fmt.Sprintf("a sealed message from %s claims to be from %s", sender.Binding.Username, initial.From))
+		return
+	}
+
+	client.receivePrekeyMessage(initial, false)
+}
+
+func (client *WSClient) ownBinding() transparency.Binding {
+	return transparency.Binding{
+		Username:   client.conn.Metadata.Username,
+		Device:     client.conn.Metadata.Device,
+		PublicKey:  client.conn.Keys.Public,
+		SigningKey: client.conn.Keys.Signing.Public().(ed25519.PublicKey),
+	}
+}
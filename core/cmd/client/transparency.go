@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/Guilospanck/pqc/core/pkg/transparency"
+	"github.com/Guilospanck/pqc/core/pkg/types"
+	"github.com/Guilospanck/pqc/core/pkg/ui"
+	"github.com/Guilospanck/pqc/core/pkg/ws"
+)
+
+// A binding was published in the server key log: verify its inclusion
+// proof and that the log is still consistent with what we saw before.
+func (client *WSClient) handleKeyPublished(msg ws.WSMessage) {
+	var publication transparency.KeyPublication
+	if err := json.Unmarshal(msg.Value, &publication); err != nil {
+		log.Printf("[%s] Could not unmarshal key publication: %s\n", client.conn.Metadata.Username, err.Error())
+		return
+	}
+
+	if err := client.observeTreeHead(publication.LogKey, publication.TreeHead); err != nil {
+		client.alertKeyTransparency(err.Error())
+		return
+	}
+
+	if err := client.keyMonitor.VerifyPublication(publication); err != nil {
+		client.alertKeyTransparency(err.Error())
+		return
+	}
+
+	binding := publication.Binding
+	isOurs := bytes.Equal(binding.PublicKey, client.conn.Keys.Public) && bytes.Equal(binding.SigningKey, client.conn.Keys.Signing.Public().(ed25519.PublicKey))
+	isThisDevice := binding.Username == client.conn.Metadata.Username && binding.Device == client.conn.Metadata.Device
+	if isThisDevice && !isOurs {
+		client.alertKeyTransparency(fmt.Sprintf("the server published a key for this device (%s) that is not ours", binding.Device))
+		return
+	}
+	if isThisDevice {
+		client.ownPublication = &publication
+	}
+
+	log.Printf("[%s] Verified key of %s, device %s (index %d, tree size %d)\n", client.conn.Metadata.Username, binding.Username, binding.Device, publication.Index, publication.TreeHead.Size)
+}
+
+func (client *WSClient) handleKeyLogTreeHead(msg ws.WSMessage) {
+	var announcement transparency.TreeHeadAnnouncement
+	if err := json.Unmarshal(msg.Value, &announcement); err != nil {
+		log.Printf("[%s] Could not unmarshal tree head: %s\n", client.conn.Metadata.Username, err.Error())
+		return
+	}
+
+	if err := client.observeTreeHead(announcement.LogKey, announcement.TreeHead); err != nil {
+		client.alertKeyTransparency(err.Error())
+	}
+}
+
+func (client *WSClient) handleKeyLogConsistency(msg ws.WSMessage) {
+	var check transparency.ConsistencyCheck
+	if err := json.Unmarshal(msg.Value, &check); err != nil {
+		log.Printf("[%s] Could not unmarshal consistency proof: %s\n", client.conn.Metadata.Username, err.Error())
+		return
+	}
+
+	if err := client.keyMonitor.VerifyConsistency(check); err != nil {
+		client.alertKeyTransparency(err.Error())
+		return
+	}
+
+	log.Printf("[%s] Key log is consistent between sizes %d and %d\n", client.conn.Metadata.Username, check.First, check.Second)
+
+	// We may trust a bigger tree now
+	client.saveIdentity()
+}
+
+// Verifies a tree head and, if needed, asks the server to prove
+// it is consistent with the ones we have already seen.
+func (client *WSClient) observeTreeHead(logKey []byte, treeHead transparency.SignedTreeHead) error {
+	_, pinned := client.keyMonitor.State()
+
+	check, err := client.keyMonitor.ObserveTreeHead(logKey, treeHead)
+	if err != nil {
+		return err
+	}
+
+	// The first tree head we trust, and the log key we pinned
+	if !pinned {
+		client.saveIdentity()
+	}
+
+	if check != nil {
+		client.sendJSONMessage(types.MessageTypeKeyLogConsistency, check)
+	}
+
+	return nil
+}
+
+func (client *WSClient) alertKeyTransparency(reason string) {
+	log.Printf("[%s] KEY TRANSPARENCY ALERT: %s\n", client.conn.Metadata.Username, reason)
+	ui.EmitToUI(types.MessageTypeKeyTransparencyAlert, reason, ALERT_COLOR)
+}
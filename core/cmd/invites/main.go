@@ -0,0 +1,105 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Guilospanck/pqc/core/pkg/invites"
+)
+
+// Mints, lists and revokes the invites of a private server (see the
+// -invites-file flag of the server). The server sees the changes right away.
+
+const usage = `Usage: invites [-file invites.json] <command>
+
+Commands:
+  mint [-uses 1] [-expires 168h] [-note text]  creates an invite and prints its code
+  list                                         lists the invites that can still be used
+  revoke <id|code>                             revokes an invite
+`
+
+func main() {
+	file := flag.String("file", "invites.json", "invites file of the server")
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	store := invites.NewStore(*file)
+
+	var err error
+	switch command, args := flag.Arg(0), flag.Args()[1:]; command {
+	case "mint":
+		err = mint(store, args)
+	case "list":
+		err = list(store)
+	case "revoke":
+		if len(args) != 1 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		err = store.Revoke(args[0])
+		if err == nil {
+			fmt.Printf("Invite %s revoked\n", args[0])
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+func mint(store *invites.Store, args []string) error {
+	flags := flag.NewFlagSet("mint", flag.ExitOnError)
+	uses := flags.Int("uses", 1, "how many times it can be used (0 for no limit)")
+	expires := flags.Duration("expires", 7*24*time.Hour, "how long it can be used (0 for ever)")
+	note := flags.String("note", "", "who it is for")
+	flags.Parse(args)
+
+	code, invite, err := store.Mint(*uses, *expires, *note)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Invite %s: %s\n", invite.ID, code)
+	fmt.Println("The code is only shown now, the server only keeps its hash.")
+	return nil
+}
+
+func list(store *invites.Store) error {
+	all, err := store.List()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tUSED\tEXPIRES\tNOTE")
+	for _, invite := range all {
+		if invite.Expired() || invite.UsedUp() {
+			continue
+		}
+
+		uses := strconv.Itoa(invite.Used) + "/" + strconv.Itoa(invite.MaxUses)
+		if invite.MaxUses == 0 {
+			uses = strconv.Itoa(invite.Used) + "/-"
+		}
+		expires := "never"
+		if !invite.Expires.IsZero() {
+			expires = invite.Expires.Local().Format(time.DateTime)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", invite.ID, uses, expires, invite.Note)
+	}
+
+	return w.Flush()
+}
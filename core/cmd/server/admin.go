@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Guilospanck/pqc/core/pkg/admin"
+	"github.com/Guilospanck/pqc/core/pkg/cryptography"
+	"github.com/Guilospanck/pqc/core/pkg/group"
+	"github.com/Guilospanck/pqc/core/pkg/types"
+	"github.com/Guilospanck/pqc/core/pkg/ws"
+
+	"github.com/gorilla/websocket"
+)
+
+// Who the admins are in the moderation events
+const ADMIN_ACTOR = "The server"
+
+// Serves the admin API (see pkg/admin) on `addr`, apart from the chat,
+// until the returned server is shut down
+func (srv *WSServer) startAdminServer(addr, token string) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /connections", srv.adminConnections)
+	mux.HandleFunc("GET /stats", srv.adminStats)
+	mux.HandleFunc("POST /kick", srv.adminModerate(types.ModerationKick))
+	mux.HandleFunc("POST /ban", srv.adminModerate(types.ModerationBan))
+	mux.HandleFunc("POST /unban", srv.adminModerate(types.ModerationUnban))
+	mux.HandleFunc("POST /announce", srv.adminAnnounce)
+	mux.HandleFunc("POST /drain", srv.adminDrain)
+	mux.HandleFunc("DELETE /drain", srv.adminResume)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !admin.Authorized(r.Header.Get("Authorization"), token) {
+			log.Printf("Unauthorized admin request from %s: %s %s\n", r.RemoteAddr, r.Method, r.URL.Path)
+			http.Error(w, admin.ErrUnauthorized.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		log.Printf("Admin request: %s %s\n", r.Method, r.URL.Path)
+		mux.ServeHTTP(w, r)
+	})
+
+	server := &http.Server{Addr: addr, Handler: handler}
+
+	go func() {
+		log.Printf("Admin API started at http://%s", addr)
+		if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	return server
+}
+
+func writeJSON(w http.ResponseWriter, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Printf("Could not write admin answer: %s\n", err.Error())
+	}
+}
+
+// Reads the body of `r` into `value`. Answers the request if it can't.
+func readJSON(w http.ResponseWriter, r *http.Request, value any) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, MAX_ADMIN_REQUEST_SIZE)
+	if err := json.NewDecoder(r.Body).Decode(value); err != nil {
+		http.Error(w, "Invalid request: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+
+	return true
+}
+
+func (srv *WSServer) adminConnections(w http.ResponseWriter, r *http.Request) {
+	connections := make([]admin.Connection, 0)
+
+	for _, c := range srv.currentConnections() {
+		username, device := c.Metadata.Username, c.Metadata.Device
+
+		connection := admin.Connection{
+			Username:    username,
+			Color:       c.Metadata.Color,
+			Device:      device,
+			RemoteAddr:  c.Conn.RemoteAddr().String(),
+			Since:       c.Since,
+			Presence:    srv.userPresence(username).Status,
+			Registered:  srv.registered(username),
+			ExchangeKey: admin.Fingerprint(c.Keys.Public),
+		}
+		if binding, _, ok := srv.keyLog.Lookup(username, device); ok {
+			connection.IdentityKey = admin.Fingerprint(binding.PublicKey, binding.SigningKey)
+		}
+
+		connections = append(connections, connection)
+	}
+
+	writeJSON(w, connections)
+}
+
+func (srv *WSServer) adminStats(w http.ResponseWriter, r *http.Request) {
+	connections := srv.currentConnections()
+
+	online := make(map[string]bool)
+	for _, c := range connections {
+		online[c.Metadata.Username] = true
+	}
+
+	srv.mu.RLock()
+	accounts := len(srv.accounts)
+	srv.mu.RUnlock()
+
+	stats := admin.Stats{
+		Started:           srv.started,
+		Uptime:            time.Since(srv.started).Round(time.Second).String(),
+		Connections:       len(connections),
+		UsersOnline:       len(online),
+		Accounts:          accounts,
+		Registered:        srv.users.Len(),
+		ConnectionsOpened: srv.opened.Load(),
+		MessagesRelayed:   srv.relayed.Load(),
+		KeyLogSize:        srv.keyLog.SignedTreeHead().Size,
+		Rooms:             make([]admin.Room, 0, len(srv.rooms)),
+		Draining:          srv.draining.Load(),
+		FIPS:              cryptography.FIPS(),
+	}
+
+	for name, acl := range srv.rooms {
+		room := admin.Room{Name: name}
+
+		acl.mu.Lock()
+		room.Topic = acl.topic
+		room.InviteOnly = acl.inviteOnly
+		room.Banned = len(acl.banned)
+		for _, until := range acl.muted {
+			if time.Now().Before(until) {
+				room.Muted++
+			}
+		}
+		acl.mu.Unlock()
+
+		if g, ok := srv.groups[name]; ok {
+			g.mu.Lock()
+			room.Epoch = g.epoch
+			room.Members = len(g.members)
+			g.mu.Unlock()
+		}
+
+		stats.Rooms = append(stats.Rooms, room)
+	}
+
+	writeJSON(w, stats)
+}
+
+// Kicks, bans or unbans a user from the room, like a moderator would
+// (without being bound by the roles)
+func (srv *WSServer) adminModerate(action types.ModerationAction) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req admin.UserAction
+		if !readJSON(w, r, &req) {
+			return
+		}
+
+		moderation := types.Moderation{
+			Room:   group.DefaultGroupID,
+			Action: action,
+			Actor:  ADMIN_ACTOR,
+			Target: req.Username,
+			Reason: req.Reason,
+		}
+		if action == types.ModerationBan && req.IP {
+			moderation.Action = types.ModerationBanIP
+		}
+
+		if err := srv.moderate(moderation, false); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (srv *WSServer) adminAnnounce(w http.ResponseWriter, r *http.Request) {
+	var req admin.Announcement
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	if err := srv.announce(req.Text); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Sends `text` to everyone connected, from the server
+func (srv *WSServer) announce(text string) error {
+	if text == "" || utf8.RuneCountInString(text) > MAX_ANNOUNCEMENT_LENGTH {
+		return fmt.Errorf("announcements have 1 to %d characters", MAX_ANNOUNCEMENT_LENGTH)
+	}
+
+	log.Printf("Announcement: %s\n", text)
+
+	for _, c := range srv.currentConnections() {
+		msg := ws.WSMessage{
+			Type:     types.MessageTypeAnnouncement,
+			Value:    []byte(text),
+			Nonce:    nil,
+			Metadata: ws.WSMetadata{Username: c.Metadata.Username, Color: c.Metadata.Color},
+		}
+		jsonMsg := msg.Marshal()
+
+		if err := c.WriteMessage(string(jsonMsg), websocket.TextMessage); err != nil {
+			log.Printf("Error trying to send announcement to %s: %s\n", c.Metadata.Username, err.Error())
+		}
+	}
+
+	return nil
+}
+
+func (srv *WSServer) adminDrain(w http.ResponseWriter, r *http.Request) {
+	var req admin.Drain
+	if r.ContentLength != 0 && !readJSON(w, r, &req) {
+		return
+	}
+
+	srv.drain(req.Disconnect)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Refuses new connections and, if asked to, closes the ones there are
+func (srv *WSServer) drain(disconnect bool) {
+	srv.draining.Store(true)
+	log.Printf("Draining (disconnecting everyone: %t)\n", disconnect)
+
+	if !disconnect {
+		return
+	}
+
+	srv.goAway(srv.currentConnections(), "The server is draining.", 0)
+}
+
+func (srv *WSServer) adminResume(w http.ResponseWriter, r *http.Request) {
+	if srv.draining.Swap(false) {
+		log.Println("Accepting new connections again")
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/Guilospanck/pqc/core/pkg/invites"
+)
+
+// Private servers only let in the devices they know already (and the ones
+// their users approve), the ones with an invite and the registered users.
+// The others can only log in, as a username of the allowlist if there is one:
+// registering always needs an invite.
+
+type admission struct {
+	invites   *invites.Store // nil if the server doesn't use invites
+	allowlist string         // file of the usernames that can log in without an invite
+}
+
+func (srv *WSServer) private() bool {
+	return srv.admission.invites != nil || srv.admission.allowlist != ""
+}
+
+// Spends one use of the invite `code`. Returns false if there is none.
+func (srv *WSServer) admitInvite(code string) (bool, error) {
+	if code == "" || srv.admission.invites == nil {
+		return false, nil
+	}
+
+	if err := srv.admission.invites.Use(code); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Whether `username` (whatever its case) is on the allowlist. The file is
+// read every time, so it can be changed while the server runs: one username
+// per line, lines starting with # are comments.
+func (srv *WSServer) allowlisted(username string) bool {
+	if srv.admission.allowlist == "" {
+		return false
+	}
+
+	f, err := os.Open(srv.admission.allowlist)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Could not read the allowlist: %s\n", err.Error())
+		}
+		return false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.EqualFold(line, username) {
+			return true
+		}
+	}
+
+	return false
+}
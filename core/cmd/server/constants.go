@@ -1,5 +1,7 @@
 package main
 
+import "time"
+
 var RANDOM_NAMES = []string{
 	"Amazing Koala",
 	"Curious Rapier",
@@ -123,3 +125,63 @@ var RANDOM_COLORS = []string{
 	"#000075", // navy
 	"#808080", // gray
 }
+
+// When a user has less one-time prekeys than this, we ask for more
+const PREKEY_LOW_WATERMARK = 5
+
+// How many prekey messages we keep for a user while it is offline
+const MAX_MAILBOX_SIZE = 100
+
+// How long a new device waits for one of the user's devices to approve it.
+// INFO: it needs to be less than the handshake timeout of the client
+const DEVICE_APPROVAL_TIMEOUT = 40 * time.Second
+
+// How many approvals can be asked for a username (and from an IP) over
+// DEVICE_APPROVAL_RATE_WINDOW, and how many devices can wait at once
+const DEVICE_APPROVAL_RATE = 3
+const DEVICE_APPROVAL_RATE_WINDOW = 10 * time.Minute
+const MAX_PENDING_DEVICE_APPROVALS = 32
+
+// How many acked messages we remember, for the receipts about them
+const MAX_ACKED_MESSAGES = 10000
+
+// How many messages of the history are replayed to a device that joins,
+// and how old they can be (by default)
+const HISTORY_REPLAY_COUNT = 50
+const HISTORY_REPLAY_WINDOW = 24 * time.Hour
+
+// Usernames chosen with /nick (or in the `username` header)
+const MIN_USERNAME_LENGTH = 2
+const MAX_USERNAME_LENGTH = 32
+
+// How many random usernames we draw before adding a number to one
+const RANDOM_USERNAME_ATTEMPTS = 20
+
+// How long a username is kept after the last device of its user left
+// (or after it was given up), and how often we look for those to release
+const USERNAME_GRACE_PERIOD = time.Hour
+const USERNAME_RELEASE_PERIOD = time.Minute
+
+// How long a failed login (or registration) waits before answering
+const LOGIN_FAILURE_DELAY = time.Second
+
+// How many logins and registrations can be tried from an IP over
+// ACCOUNT_ATTEMPT_RATE_WINDOW (each one hashes a password)
+const ACCOUNT_ATTEMPT_RATE = 10
+const ACCOUNT_ATTEMPT_RATE_WINDOW = time.Minute
+
+// Longest topic (and reason of a moderation)
+const MAX_ROOM_TEXT_LENGTH = 200
+
+// Longest announcement of the admins, and body of an admin request
+const MAX_ANNOUNCEMENT_LENGTH = 1000
+const MAX_ADMIN_REQUEST_SIZE = 64 << 10
+
+// On shutdown, how long clients are told to wait before connecting again,
+// how long we wait for their connections to close, and how often we look
+const SHUTDOWN_RETRY_AFTER = 5 * time.Second
+const SHUTDOWN_TIMEOUT = 10 * time.Second
+const SHUTDOWN_POLL_PERIOD = 50 * time.Millisecond
+
+// What happens when a device connects while it is still connected (see sessionPolicy)
+const DUPLICATE_SESSION_POLICY = sessionKick
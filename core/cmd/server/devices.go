@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/Guilospanck/pqc/core/pkg/devices"
+	"github.com/Guilospanck/pqc/core/pkg/types"
+	"github.com/Guilospanck/pqc/core/pkg/ws"
+)
+
+// A user and the devices allowed to connect as them
+type account struct {
+	color    string
+	devices  []string       // approved device ids, in the order they were added
+	presence types.Presence // reset when the first device of the user connects
+	lastSeen time.Time      // its username is released some time after this
+}
+
+func connectionId(connection *ws.Connection) clientId {
+	return clientId(devices.Address(connection.Metadata.Username, connection.Metadata.Device))
+}
+
+// Finds out who is connecting. Clients without a username get a new account,
+// and a device that never connected as `username` must be approved first.
+// A device that did must show its reconnect token, or it gets a new account.
+func (srv *WSServer) resolveAccount(username, color, device, token string) (string, string, string, bool) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if device == "" {
+		device = devices.NewID()
+	}
+
+	acc, ok := srv.accounts[username]
+
+	switch {
+	case ok && slices.Contains(acc.devices, device) && !srv.validReconnectToken(username, device, token):
+		log.Printf("Invalid reconnect token for device %s of %s, giving a new username\n", device, username)
+		username, ok = "", false
+
+	// An invalid username, or one too close to another in use (or given up
+	// recently), is replaced like a missing one
+	case !ok && username != "" && (validUsername(username) != nil || srv.usernameTaken(username, "")):
+		log.Printf("Username %q can't be used, giving a new one\n", username)
+		username = ""
+	}
+
+	if username == "" {
+		username = srv.getRandomUsername()
+		color = GetRandomColor()
+	}
+
+	if !ok {
+		// Either a new user or one whose username was released (or that
+		// connected before the server restarted)
+		if !colorPattern.MatchString(color) {
+			color = GetRandomColor()
+		}
+
+		srv.accounts[username] = &account{color: color, devices: []string{device}, lastSeen: time.Now()}
+		return username, color, device, false
+	}
+
+	acc.lastSeen = time.Now()
+	return username, acc.color, device, !slices.Contains(acc.devices, device)
+}
+
+// Approved devices of `username`
+func (srv *WSServer) userDevices(username string) []string {
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+
+	acc, ok := srv.accounts[username]
+	if !ok {
+		return nil
+	}
+
+	return slices.Clone(acc.devices)
+}
+
+// Connections of all the online devices of `username`
+func (srv *WSServer) userConnections(username string) []*ws.Connection {
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+
+	connections := make([]*ws.Connection, 0)
+	for _, c := range srv.connections {
+		if c.Metadata.Username == username {
+			connections = append(connections, c)
+		}
+	}
+
+	return connections
+}
+
+// A device waiting for one of the devices of `username` to approve it
+type pendingDevice struct {
+	username string
+	approval chan bool
+}
+
+// Asks every online device of `username` to approve a new device, that
+// connects from `ip`, and waits for the first answer. It runs before the
+// upgrade, so a refused device never becomes a connection.
+// Each username (and each IP) can only ask so often, and only one device of
+// a user waits at a time: nobody can flood a user with approval requests.
+func (srv *WSServer) waitForDeviceApproval(username, device, ip string) (int, string) {
+	approvers := srv.userConnections(username)
+	if len(approvers) == 0 {
+		return http.StatusForbidden, fmt.Sprintf("%s has no device online to approve this one.", username)
+	}
+
+	if !srv.approvalsByUser.allow(username) || !srv.approvalsByIP.allow(ip) {
+		return http.StatusTooManyRequests, "Too many devices asked for approval, try again later."
+	}
+
+	id := clientId(devices.Address(username, device))
+	approval := make(chan bool, 1)
+
+	srv.mu.Lock()
+	if len(srv.pendingDevices) >= MAX_PENDING_DEVICE_APPROVALS {
+		srv.mu.Unlock()
+		return http.StatusServiceUnavailable, "Too many devices are waiting for approval, try again later."
+	}
+	for _, pending := range srv.pendingDevices {
+		if pending.username == username {
+			srv.mu.Unlock()
+			return http.StatusTooManyRequests, fmt.Sprintf("Another device of %s is already waiting for approval.", username)
+		}
+	}
+	srv.pendingDevices[id] = pendingDevice{username: username, approval: approval}
+	srv.mu.Unlock()
+
+	defer func() {
+		srv.mu.Lock()
+		delete(srv.pendingDevices, id)
+		srv.mu.Unlock()
+	}()
+
+	log.Printf("Device %s of %s is waiting for approval\n", device, username)
+	for _, c := range approvers {
+		srv.sendJSONMessage(c, types.MessageTypeDeviceApprovalRequest, devices.Device{ID: device})
+	}
+
+	select {
+	case approved := <-approval:
+		if !approved {
+			return http.StatusForbidden, "This device was not approved."
+		}
+
+	case <-time.After(DEVICE_APPROVAL_TIMEOUT):
+		return http.StatusForbidden, "No device approved this one in time."
+	}
+
+	srv.mu.Lock()
+	if acc, ok := srv.accounts[username]; ok {
+		acc.devices = append(acc.devices, device)
+	}
+	srv.mu.Unlock()
+
+	log.Printf("Device %s of %s was approved\n", device, username)
+	return http.StatusOK, ""
+}
+
+// One of the devices of a user answered an approval request
+func (srv *WSServer) handleDeviceApproval(connection *ws.Connection, msg ws.WSMessage) {
+	var approval devices.Approval
+	if err := json.Unmarshal(msg.Value, &approval); err != nil {
+		log.Printf("Could not unmarshal device approval from %s: %s\n", connection.Metadata.Username, err.Error())
+		return
+	}
+
+	srv.mu.RLock()
+	pending, ok := srv.pendingDevices[clientId(devices.Address(connection.Metadata.Username, approval.Device))]
+	srv.mu.RUnlock()
+
+	if !ok {
+		srv.sendError(connection, fmt.Sprintf("No device %s is waiting for approval.", approval.Device))
+		return
+	}
+
+	// Only the first answer counts
+	select {
+	case pending.approval <- approval.Approved:
+	default:
+	}
+}
+
+func (srv *WSServer) handleDeviceList(connection *ws.Connection) {
+	online := make(map[string]bool)
+	for _, c := range srv.userConnections(connection.Metadata.Username) {
+		online[c.Metadata.Device] = true
+	}
+
+	list := make([]devices.Device, 0)
+	for _, device := range srv.userDevices(connection.Metadata.Username) {
+		list = append(list, devices.Device{ID: device, Online: online[device]})
+	}
+
+	srv.sendJSONMessage(connection, types.MessageTypeDeviceList, list)
+}
+
+// Removes another device of the user: it is disconnected and, if it ever
+// connects again, it must be approved like a new device.
+func (srv *WSServer) handleDeviceRemove(connection *ws.Connection, msg ws.WSMessage) {
+	username := connection.Metadata.Username
+
+	var removal devices.Removal
+	if err := json.Unmarshal(msg.Value, &removal); err != nil {
+		log.Printf("Could not unmarshal device removal from %s: %s\n", username, err.Error())
+		return
+	}
+
+	if removal.Device == connection.Metadata.Device {
+		srv.sendError(connection, "A device can't remove itself.")
+		return
+	}
+
+	srv.mu.Lock()
+	acc, ok := srv.accounts[username]
+	removed := ok && slices.Contains(acc.devices, removal.Device)
+	if removed {
+		acc.devices = slices.DeleteFunc(acc.devices, func(device string) bool { return device == removal.Device })
+	}
+	srv.mu.Unlock()
+
+	if !removed {
+		srv.sendError(connection, fmt.Sprintf("Unknown device %s.", removal.Device))
+		return
+	}
+
+	log.Printf("%s removed its device %s\n", username, removal.Device)
+
+	srv.mu.Lock()
+	delete(srv.reconnectTokens, clientId(devices.Address(username, removal.Device)))
+	srv.mu.Unlock()
+
+	if c, ok := srv.getConnection(clientId(devices.Address(username, removal.Device))); ok {
+		c.Close(ws.CloseDeviceRemoved, "This device was removed by another device of yours.")
+	}
+
+	srv.handleDeviceList(connection)
+}
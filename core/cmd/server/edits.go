@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"log"
+
+	"github.com/Guilospanck/pqc/core/pkg/history"
+	"github.com/Guilospanck/pqc/core/pkg/types"
+	"github.com/Guilospanck/pqc/core/pkg/ws"
+)
+
+var (
+	ErrUnknownMessage  = errors.New("unknown message")
+	ErrNotOwnMessage   = errors.New("you can only change your own messages")
+	ErrHistoryUnusable = errors.New("could not read the history, try again")
+)
+
+// Applies an edit, a deletion or a reaction to our history. Returns an error
+// if it must not be relayed nor recorded: only the sender of a message can
+// edit it, and delete it unless a moderator above them does. Actions on
+// messages that aren't in our history are refused, since we can't check them.
+//
+// This covers the actions we relay and the copies of the ones sent to the
+// group (see handleHistoryCopy). The ones sent to the group reach its members
+// whatever we decide, so for those the check is only done by the clients
+// (see applyChatAction on the client side): ours just keeps our history right.
+func (srv *WSServer) applyChatAction(client *ws.Connection, payload types.ChatMessage) error {
+	store := srv.history.store
+	username := client.Metadata.Username
+
+	original, err := store.Get(payload.Target)
+	if errors.Is(err, history.ErrNotFound) {
+		log.Printf("%s tried to %s unknown message %s\n", username, payload.Action, payload.Target)
+		return ErrUnknownMessage
+	}
+	if err != nil {
+		log.Printf("Could not read the history: %s\n", err.Error())
+		return ErrHistoryUnusable
+	}
+
+	switch payload.Action {
+	case types.ChatActionEdit, types.ChatActionDelete:
+		moderated := payload.Action == types.ChatActionDelete && srv.outranks(payload.Room, username, original.Username)
+		if original.Username != username && !moderated {
+			log.Printf("%s tried to %s a message from %s\n", username, payload.Action, original.Username)
+			return ErrNotOwnMessage
+		}
+
+		if moderated {
+			log.Printf("%s deleted a message from %s\n", username, original.Username)
+		}
+
+		if payload.Action == types.ChatActionEdit {
+			err = store.Edit(payload.Target, payload.Body)
+		} else {
+			err = store.Delete(payload.Target)
+		}
+
+	case types.ChatActionReact, types.ChatActionUnreact:
+		err = store.React(payload.Target, payload.Body, username, payload.Action == types.ChatActionReact)
+	}
+
+	if err != nil {
+		log.Printf("Could not %s message in the history: %s\n", payload.Action, err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Guilospanck/pqc/core/pkg/chat"
+	"github.com/Guilospanck/pqc/core/pkg/cryptography"
+	"github.com/Guilospanck/pqc/core/pkg/group"
+	"github.com/Guilospanck/pqc/core/pkg/types"
+	"github.com/Guilospanck/pqc/core/pkg/ws"
+
+	"github.com/gorilla/websocket"
+)
+
+func sendTestMessage(t *testing.T, srv *WSServer, from *ws.Connection, payload types.ChatMessage) {
+	t.Helper()
+
+	marshalled, err := chat.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.fanOutUserMessage(from, marshalled)
+}
+
+// The next chat message the client end of `connection` gets from the server
+func readTestChat(t *testing.T, client *websocket.Conn, connection *ws.Connection) types.ChatMessage {
+	t.Helper()
+
+	msg := readTestMessage(t, client)
+	if msg.Type != types.MessageTypeEncryptedMessage {
+		t.Fatalf("got a %s message instead of a chat message", msg.Type)
+	}
+
+	decrypted, err := cryptography.DecryptMessage(connection.Keys.SharedSecret, msg.Nonce, msg.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := chat.Parse(decrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payload
+}
+
+func TestChatActions(t *testing.T) {
+	srv := newTestServer(t)
+	alice, aliceClient := connectTestDevice(t, srv, "alice", "a1")
+	bob, bobClient := connectTestDevice(t, srv, "bob", "b1")
+
+	original, err := chat.New("alice", group.DefaultGroupID, "hello", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sendTestMessage(t, srv, alice, original)
+	readTestMessage(t, bobClient)
+	readTestMessage(t, aliceClient) // the ack
+
+	tests := []struct {
+		name    string
+		from    *ws.Connection
+		action  types.ChatAction
+		target  string
+		body    string
+		relayed bool
+	}{
+		{"edit of someone else's message", bob, types.ChatActionEdit, original.ID, "bye", false},
+		{"deletion of someone else's message", bob, types.ChatActionDelete, original.ID, "", false},
+		{"edit of an unknown message", bob, types.ChatActionEdit, "unknown", "bye", false},
+		{"reaction to an unknown message", bob, types.ChatActionReact, "unknown", "👍", false},
+		{"reaction", bob, types.ChatActionReact, original.ID, "👍", true},
+		{"edit", alice, types.ChatActionEdit, original.ID, "hello!", true},
+		{"deletion", alice, types.ChatActionDelete, original.ID, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sender, other, otherClient := aliceClient, bob, bobClient
+			if tt.from == bob {
+				sender, other, otherClient = bobClient, alice, aliceClient
+			}
+
+			action, err := chat.NewAction(tt.from.Metadata.Username, group.DefaultGroupID, tt.action, tt.target, tt.body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sendTestMessage(t, srv, tt.from, action)
+
+			answer := readTestMessage(t, sender)
+			if !tt.relayed {
+				if answer.Type != types.MessageTypeError {
+					t.Errorf("sender got a %s message instead of an error", answer.Type)
+				}
+
+				// What the other user gets next is what comes after it
+				next, err := chat.New(tt.from.Metadata.Username, group.DefaultGroupID, "next", "")
+				if err != nil {
+					t.Fatal(err)
+				}
+				sendTestMessage(t, srv, tt.from, next)
+				readTestMessage(t, sender)
+				action = next
+			} else if answer.Type != types.MessageTypeMessageAck {
+				t.Errorf("sender got a %s message instead of the ack", answer.Type)
+			}
+
+			if got := readTestChat(t, otherClient, other); got.ID != action.ID {
+				t.Errorf("the other user got %s %q", got.Action, got.Body)
+			}
+		})
+	}
+
+	if _, err := srv.history.store.Get(original.ID); err == nil {
+		t.Error("the deleted message is still in the history")
+	}
+}
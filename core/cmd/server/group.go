@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/Guilospanck/pqc/core/pkg/group"
+	"github.com/Guilospanck/pqc/core/pkg/types"
+	"github.com/Guilospanck/pqc/core/pkg/ws"
+
+	"github.com/gorilla/websocket"
+)
+
+// Server side view of a group. The server never learns the group secrets:
+// it only orders commits (one per epoch), asks a member to commit the pending
+// adds/removes and relays the encrypted messages.
+type roomGroup struct {
+	id             string
+	epoch          uint64
+	members        []string // device addresses, in the order they joined
+	pendingAdds    map[string]group.KeyPackage
+	pendingRemoves map[string]struct{}
+	mu             sync.Mutex
+}
+
+func newRoomGroup(id string) *roomGroup {
+	return &roomGroup{
+		id:             id,
+		members:        make([]string, 0),
+		pendingAdds:    make(map[string]group.KeyPackage),
+		pendingRemoves: make(map[string]struct{}),
+	}
+}
+
+// A client wants to join a group. If the group is empty, it creates it,
+// otherwise one of the members will add it.
+func (srv *WSServer) handleGroupKeyPackage(connection *ws.Connection, msg ws.WSMessage) {
+	username := connection.Metadata.Username
+	address := string(connectionId(connection))
+
+	var kp group.KeyPackage
+	if err := json.Unmarshal(msg.Value, &kp); err != nil {
+		log.Printf("Could not unmarshal key package from %s: %s\n", username, err.Error())
+		return
+	}
+
+	binding, _, published := srv.keyLog.Lookup(username, connection.Metadata.Device)
+	if kp.Leaf.Address() != address || !kp.Verify() || !published || !bytes.Equal(binding.SigningKey, kp.Leaf.SigningKey) {
+		log.Printf("Rejected key package of %s\n", username)
+		srv.sendError(connection, "Key package rejected.")
+		return
+	}
+
+	g, ok := srv.groups[kp.GroupID]
+	if !ok {
+		srv.sendError(connection, "Unknown group.")
+		return
+	}
+
+	g.mu.Lock()
+	if len(g.members) == 0 {
+		g.members = append(g.members, address)
+		g.epoch = 0
+		g.mu.Unlock()
+
+		log.Printf("%s created group %s\n", address, g.id)
+		srv.sendJSONMessage(connection, types.MessageTypeGroupCreate, kp)
+		return
+	}
+
+	// A member that lost its group state joins again
+	if slices.Contains(g.members, address) {
+		g.pendingRemoves[address] = struct{}{}
+	}
+	g.pendingAdds[address] = kp
+	g.mu.Unlock()
+
+	srv.scheduleGroupCommit(g)
+}
+
+// Asks the first member that is staying in the group to commit the pending changes
+func (srv *WSServer) scheduleGroupCommit(g *roomGroup) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for len(g.pendingAdds) > 0 || len(g.pendingRemoves) > 0 {
+		for _, member := range g.members {
+			if _, leaving := g.pendingRemoves[member]; leaving {
+				continue
+			}
+
+			committer, ok := srv.getConnection(clientId(member))
+			if !ok {
+				continue
+			}
+
+			proposals := group.Proposals{
+				GroupID: g.id,
+				Epoch:   g.epoch,
+				Adds:    slices.Collect(maps.Values(g.pendingAdds)),
+				Removes: slices.Collect(maps.Keys(g.pendingRemoves)),
+			}
+			srv.sendJSONMessage(committer, types.MessageTypeGroupProposals, proposals)
+			return
+		}
+
+		// Nobody is left to commit: the group starts over with the first pending member
+		log.Printf("Group %s has no members left, starting over\n", g.id)
+		g.members = make([]string, 0)
+		g.pendingRemoves = make(map[string]struct{})
+		g.epoch = 0
+
+		for address, kp := range g.pendingAdds {
+			delete(g.pendingAdds, address)
+
+			if connection, ok := srv.getConnection(clientId(address)); ok {
+				g.members = append(g.members, address)
+				srv.sendJSONMessage(connection, types.MessageTypeGroupCreate, kp)
+				break
+			}
+		}
+	}
+}
+
+// Only the first commit for the current epoch is accepted. It is sent back
+// to every member (the committer included, as a confirmation) and the
+// welcomes go to the new members.
+func (srv *WSServer) handleGroupCommit(connection *ws.Connection, msg ws.WSMessage) {
+	username := connection.Metadata.Username
+	address := string(connectionId(connection))
+
+	var bundle group.CommitBundle
+	if err := json.Unmarshal(msg.Value, &bundle); err != nil {
+		log.Printf("Could not unmarshal commit from %s: %s\n", username, err.Error())
+		return
+	}
+	commit := bundle.Commit
+
+	g, ok := srv.groups[commit.GroupID]
+	if !ok {
+		return
+	}
+
+	g.mu.Lock()
+	if commit.Epoch != g.epoch || commit.Path.Leaf.Address() != address || !slices.Contains(g.members, address) {
+		epoch := g.epoch
+		g.mu.Unlock()
+
+		log.Printf("Rejected commit of %s for epoch %d of group %s (current epoch: %d)\n", username, commit.Epoch, g.id, epoch)
+		srv.sendJSONMessage(connection, types.MessageTypeGroupCommitRejected, group.Proposals{GroupID: g.id, Epoch: epoch})
+		return
+	}
+
+	recipients := slices.Clone(g.members)
+
+	g.epoch++
+	for _, removed := range commit.Removes {
+		g.members = slices.DeleteFunc(g.members, func(member string) bool { return member == removed })
+		delete(g.pendingRemoves, removed)
+	}
+	for _, kp := range commit.Adds {
+		g.members = append(g.members, kp.Leaf.Address())
+		delete(g.pendingAdds, kp.Leaf.Address())
+	}
+	epoch := g.epoch
+	g.mu.Unlock()
+
+	log.Printf("%s moved group %s to epoch %d (+%d/-%d members)\n", username, g.id, epoch, len(commit.Adds), len(commit.Removes))
+
+	for _, recipient := range recipients {
+		if c, ok := srv.getConnection(clientId(recipient)); ok {
+			srv.sendJSONMessage(c, types.MessageTypeGroupCommit, commit)
+		}
+	}
+
+	for _, welcome := range bundle.Welcomes {
+		if c, ok := srv.getConnection(clientId(welcome.To)); ok {
+			srv.sendJSONMessage(c, types.MessageTypeGroupWelcome, welcome)
+		}
+	}
+
+	srv.scheduleGroupCommit(g)
+}
+
+// Group messages are encrypted once by the sender, we just relay them.
+// When we keep a history, the sender also sends us a copy to record (see
+// handleHistoryCopy).
+func (srv *WSServer) handleGroupMessage(connection *ws.Connection, msg ws.WSMessage) {
+	username := connection.Metadata.Username
+	address := string(connectionId(connection))
+
+	var appMsg group.ApplicationMessage
+	if err := json.Unmarshal(msg.Value, &appMsg); err != nil {
+		log.Printf("Could not unmarshal group message from %s: %s\n", username, err.Error())
+		return
+	}
+
+	g, ok := srv.groups[appMsg.GroupID]
+	if !ok {
+		return
+	}
+
+	g.mu.Lock()
+	isMember := slices.Contains(g.members, address)
+	members := slices.Clone(g.members)
+	g.mu.Unlock()
+
+	if !isMember {
+		log.Printf("%s is not a member of group %s\n", address, g.id)
+		return
+	}
+
+	if srv.refuseMuted(connection, appMsg.GroupID) {
+		return
+	}
+	srv.relayed.Add(1)
+
+	relayed := ws.WSMessage{
+		Type:     types.MessageTypeGroupMessage,
+		Value:    msg.Value,
+		Nonce:    nil,
+		Metadata: connection.Metadata,
+	}
+	jsonMsg := relayed.Marshal()
+
+	// Other devices of the sender get it too
+	recipients := make([]string, 0, len(members))
+	for _, member := range members {
+		if member == address {
+			continue
+		}
+
+		c, ok := srv.getConnection(clientId(member))
+		if !ok {
+			continue
+		}
+
+		log.Printf("Relaying group message (epoch %d) from \"%s\" to client \"%s\"\n", appMsg.Epoch, username, member)
+		if err := c.WriteMessage(string(jsonMsg), websocket.TextMessage); err != nil {
+			log.Printf("Error relaying group message to %s: %s\n", member, err.Error())
+			continue
+		}
+		recipients = append(recipients, c.Metadata.Username)
+	}
+
+	srv.ackMessage(connection, msg.ID, recipients...)
+}
+
+// A member disconnected: it will be removed from its groups by the next commit
+func (srv *WSServer) groupMemberLeft(address string) {
+	for _, g := range srv.groups {
+		g.mu.Lock()
+		delete(g.pendingAdds, address)
+		if slices.Contains(g.members, address) {
+			g.pendingRemoves[address] = struct{}{}
+		}
+		g.mu.Unlock()
+
+		srv.scheduleGroupCommit(g)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Guilospanck/pqc/core/pkg/chat"
+	"github.com/Guilospanck/pqc/core/pkg/cryptography"
+	"github.com/Guilospanck/pqc/core/pkg/history"
+	"github.com/Guilospanck/pqc/core/pkg/types"
+	"github.com/Guilospanck/pqc/core/pkg/ws"
+)
+
+// History of the messages of the room, replayed to the devices that join.
+// Messages sent to the group are end-to-end encrypted for the members of its
+// current epoch, which a device joining later can't decrypt: so when we keep
+// a history (replay isn't 0), we tell clients when they connect and they send
+// us a readable copy of every group message, which we record but don't relay
+// (see handleHistoryCopy). We can then read the messages of the room, like
+// the ones sent to us before their client joined the group.
+type serverHistory struct {
+	store    *history.Store
+	replay   int                    // how many messages are replayed on join
+	window   time.Duration          // how old they can be
+	lastSeen map[clientId]time.Time // when each device disconnected
+	mu       sync.Mutex
+}
+
+// Opens the history kept in `file` (in memory if empty), encrypted with the
+// storage keys saved in `keyFile`. If `rotate` is set, the history is
+// re-encrypted under a new storage key.
+func openHistory(file, keyFile string, rotate bool) (*history.Store, error) {
+	var backend history.Backend = history.NewMemoryBackend()
+	if file == "" {
+		keyFile = ""
+	} else {
+		backend = history.NewFileBackend(file)
+	}
+
+	keys, err := history.LoadKeys(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	store := history.NewStore(backend, keys)
+	if rotate {
+		if err := store.RotateKey(); err != nil {
+			return nil, fmt.Errorf("could not rotate storage key: %w", err)
+		}
+		log.Println("History re-encrypted under a new storage key")
+	}
+
+	return store, nil
+}
+
+func newServerHistory(store *history.Store, replay int, window time.Duration) *serverHistory {
+	return &serverHistory{
+		store:    store,
+		replay:   replay,
+		window:   window,
+		lastSeen: make(map[clientId]time.Time),
+	}
+}
+
+// Whether clients must send us a copy of their group messages
+func (h *serverHistory) keeps() bool {
+	return h.replay > 0
+}
+
+// The readable copy of a message a client just sent to the group. We only
+// record it: the group message itself was relayed already.
+func (srv *WSServer) handleHistoryCopy(connection *ws.Connection, msg ws.WSMessage) {
+	username := connection.Metadata.Username
+
+	if !srv.history.keeps() {
+		return
+	}
+
+	decrypted, err := cryptography.DecryptMessage(connection.Keys.SharedSecret, msg.Nonce, msg.Value)
+	if err != nil {
+		log.Printf("Could not decrypt history copy from %s: %s\n", username, err.Error())
+		return
+	}
+
+	payload, err := chat.Parse(decrypted)
+	if err != nil {
+		log.Printf("Could not read history copy from %s: %s\n", username, err.Error())
+		return
+	}
+	payload.Sender = username
+
+	// Only copies of what a member could send to the room
+	g, ok := srv.groups[payload.Room]
+	if !ok || payload.Recipient != "" {
+		return
+	}
+	g.mu.Lock()
+	isMember := slices.Contains(g.members, string(connectionId(connection)))
+	g.mu.Unlock()
+	if !isMember {
+		log.Printf("%s sent a history copy for group %s without being a member\n", username, payload.Room)
+		return
+	}
+
+	// The group message was refused too (we can't tell reactions apart there)
+	if _, muted := srv.mutedUntil(payload.Room, username); muted {
+		return
+	}
+
+	if payload.Action == "" {
+		srv.recordMessage(connection, payload)
+		return
+	}
+
+	// The group message reached the members already (they check it
+	// themselves), this only decides what our history says
+	if err := srv.applyChatAction(connection, payload); err != nil {
+		log.Printf("History copy of %s from %s not applied: %s\n", payload.Action, username, err.Error())
+	}
+}
+
+func (srv *WSServer) recordMessage(client *ws.Connection, payload types.ChatMessage) {
+	msg := history.Message{
+		Username: client.Metadata.Username,
+		Color:    client.Metadata.Color,
+		Payload:  payload,
+		Time:     time.Now(),
+	}
+
+	if err := srv.history.store.Add(msg); err != nil {
+		log.Printf("Could not add message to the history: %s\n", err.Error())
+	}
+}
+
+// Relays to a device that just exchanged keys with us the messages it missed:
+// the ones since it disconnected or, for a new device, the most recent ones.
+func (srv *WSServer) replayHistory(connection *ws.Connection) {
+	h := srv.history
+
+	since := time.Now().Add(-h.window)
+	h.mu.Lock()
+	if left, ok := h.lastSeen[connectionId(connection)]; ok && left.After(since) {
+		since = left
+	}
+	h.mu.Unlock()
+
+	messages, err := h.store.Recent(h.replay, since)
+	if err != nil {
+		log.Printf("Could not read the history: %s\n", err.Error())
+		return
+	}
+
+	if len(messages) > 0 {
+		log.Printf("Replaying %d messages to %s\n", len(messages), connectionId(connection))
+	}
+
+	for _, msg := range messages {
+		relayFromHistory(connection, msg.Payload, msg.Username, msg.Color)
+
+		// Reactions go as if they were just sent
+		for emoji, users := range msg.Reactions {
+			for _, user := range users {
+				reaction, err := chat.NewAction(user, msg.Payload.Room, types.ChatActionReact, msg.Payload.ID, emoji)
+				if err != nil {
+					log.Printf("Could not create reaction: %s\n", err.Error())
+					continue
+				}
+				relayFromHistory(connection, reaction, user, "")
+			}
+		}
+	}
+}
+
+func relayFromHistory(connection *ws.Connection, payload types.ChatMessage, username, color string) {
+	marshalled, err := chat.Marshal(payload)
+	if err != nil {
+		log.Printf("Could not marshal message from the history: %s\n", err.Error())
+		return
+	}
+
+	connection.RelayMessage(string(marshalled), username, color)
+}
+
+func (srv *WSServer) historyDeviceLeft(id clientId) {
+	srv.history.mu.Lock()
+	defer srv.history.mu.Unlock()
+
+	srv.history.lastSeen[id] = time.Now()
+}
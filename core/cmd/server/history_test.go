@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Guilospanck/pqc/core/pkg/chat"
+	"github.com/Guilospanck/pqc/core/pkg/cryptography"
+	"github.com/Guilospanck/pqc/core/pkg/group"
+	"github.com/Guilospanck/pqc/core/pkg/history"
+	"github.com/Guilospanck/pqc/core/pkg/transparency"
+	"github.com/Guilospanck/pqc/core/pkg/types"
+	"github.com/Guilospanck/pqc/core/pkg/users"
+	"github.com/Guilospanck/pqc/core/pkg/ws"
+
+	"github.com/gorilla/websocket"
+)
+
+func newTestServer(t *testing.T) *WSServer {
+	t.Helper()
+
+	keys, err := history.LoadKeys("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userStore, err := users.NewStore(users.NewMemoryBackend())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signingKey, err := transparency.LoadSigningKey("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyLog, err := transparency.NewLog(signingKey, transparency.NewMemoryBackend())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	store := history.NewStore(history.NewMemoryBackend(), keys)
+	return NewServer(ctx, newServerHistory(store, HISTORY_REPLAY_COUNT, HISTORY_REPLAY_WINDOW), userStore, keyLog)
+}
+
+// A device connected to `srv` (that already exchanged keys with it), and
+// the client end of its connection
+func connectTestDevice(t *testing.T, srv *WSServer, username, device string) (*ws.Connection, *websocket.Conn) {
+	t.Helper()
+
+	accepted := make(chan *websocket.Conn, 1)
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		accepted <- conn
+	}))
+	t.Cleanup(httpServer.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(httpServer.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	connection := ws.NewEmptyConnection()
+	connection.Conn = <-accepted
+	connection.Metadata = ws.WSMetadata{Username: username, Color: "#E6194B", Device: device}
+	connection.Keys.Public = []byte(username + device)
+	connection.Keys.SharedSecret = make([]byte, 32)
+	rand.Read(connection.Keys.SharedSecret)
+
+	go connection.WriteLoop(srv.ctx)
+	<-connection.WriteLoopReady
+	srv.addConnection(&connection)
+
+	return &connection, client
+}
+
+// The next message the client end of a connection gets
+func readTestMessage(t *testing.T, client *websocket.Conn) ws.WSMessage {
+	t.Helper()
+
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, data, err := client.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := ws.UnmarshalWSMessage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+// Whether the client end of a connection gets nothing for a while
+func noTestMessage(t *testing.T, client *websocket.Conn) bool {
+	t.Helper()
+
+	client.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	_, data, err := client.ReadMessage()
+	if err == nil {
+		t.Logf("unexpected message: %s", data)
+	}
+	return err != nil
+}
+
+func recordedMessages(t *testing.T, srv *WSServer) []history.Message {
+	t.Helper()
+
+	messages, err := srv.history.store.Recent(HISTORY_REPLAY_COUNT, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return messages
+}
+
+// Clients that are not in the group yet send their messages to the server,
+// which records them and replays them to the devices that join later
+func TestHistoryBeforeTheGroup(t *testing.T) {
+	srv := newTestServer(t)
+	alice, _ := connectTestDevice(t, srv, "alice", "a1")
+	_, bobClient := connectTestDevice(t, srv, "bob", "b1")
+
+	payload, err := chat.New("alice", group.DefaultGroupID, "hello", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	marshalled, err := chat.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.fanOutUserMessage(alice, marshalled)
+
+	if msg := readTestMessage(t, bobClient); msg.Type != types.MessageTypeEncryptedMessage {
+		t.Fatalf("bob got a %s message", msg.Type)
+	}
+
+	messages := recordedMessages(t, srv)
+	if len(messages) != 1 || messages[0].Payload.ID != payload.ID || messages[0].Username != "alice" {
+		t.Fatalf("history = %+v, want the message of alice", messages)
+	}
+
+	carol, carolClient := connectTestDevice(t, srv, "carol", "c1")
+	srv.replayHistory(carol)
+
+	msg := readTestMessage(t, carolClient)
+	decrypted, err := cryptography.DecryptMessage(carol.Keys.SharedSecret, msg.Nonce, msg.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed, err := chat.Parse(decrypted)
+	if err != nil || replayed.ID != payload.ID || replayed.Body != "hello" {
+		t.Errorf("replayed %+v, %v", replayed, err)
+	}
+}
+
+// The readable copy of a group message `from` sends for the history
+func sendTestCopy(t *testing.T, srv *WSServer, from *ws.Connection, payload types.ChatMessage) {
+	t.Helper()
+
+	marshalled, err := chat.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce, ciphertext, err := cryptography.EncryptMessage(from.Keys.SharedSecret, marshalled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.handleHistoryCopy(from, ws.WSMessage{Type: types.MessageTypeHistoryCopy, Value: ciphertext, Nonce: nonce, ID: payload.ID})
+}
+
+// Once in the group, messages are end-to-end encrypted: they are relayed to
+// the other members, and only recorded (then replayed) from the readable
+// copies their senders send us
+func TestHistoryGroupMessages(t *testing.T) {
+	srv := newTestServer(t)
+	alice, aliceClient := connectTestDevice(t, srv, "alice", "a1")
+	bob, bobClient := connectTestDevice(t, srv, "bob", "b1")
+
+	g := srv.groups[group.DefaultGroupID]
+	g.members = []string{string(connectionId(alice)), string(connectionId(bob))}
+	g.epoch = 3
+
+	appMsg := group.ApplicationMessage{
+		GroupID:    group.DefaultGroupID,
+		Epoch:      3,
+		Sender:     0,
+		Nonce:      []byte("nonce"),
+		Ciphertext: []byte("ciphertext of hello"),
+		Signature:  []byte("signature"),
+	}
+	value, err := json.Marshal(appMsg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.handleGroupMessage(alice, ws.WSMessage{Type: types.MessageTypeGroupMessage, Value: value, ID: "1234"})
+
+	relayed := readTestMessage(t, bobClient)
+	if relayed.Type != types.MessageTypeGroupMessage || relayed.Metadata.Username != "alice" {
+		t.Fatalf("bob got a %s message from %s", relayed.Type, relayed.Metadata.Username)
+	}
+	var got group.ApplicationMessage
+	if err := json.Unmarshal(relayed.Value, &got); err != nil || got.Epoch != 3 || string(got.Ciphertext) != "ciphertext of hello" {
+		t.Errorf("bob got %+v, %v", got, err)
+	}
+
+	if ack := readTestMessage(t, aliceClient); ack.Type != types.MessageTypeMessageAck {
+		t.Errorf("alice got a %s message instead of the ack", ack.Type)
+	}
+
+	if messages := recordedMessages(t, srv); len(messages) != 0 {
+		t.Errorf("history = %+v, want nothing before the copy", messages)
+	}
+
+	payload, err := chat.New("alice", group.DefaultGroupID, "hello", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sendTestCopy(t, srv, alice, payload)
+
+	// Bob can't change the message of alice
+	edit, err := chat.NewAction("bob", group.DefaultGroupID, types.ChatActionEdit, payload.ID, "goodbye")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sendTestCopy(t, srv, bob, edit)
+
+	// but she can
+	edit, err = chat.NewAction("alice", group.DefaultGroupID, types.ChatActionEdit, payload.ID, "hello there")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sendTestCopy(t, srv, alice, edit)
+
+	carol, carolClient := connectTestDevice(t, srv, "carol", "c1")
+
+	// Carol isn't in the group yet
+	intruder, err := chat.New("carol", group.DefaultGroupID, "not a member", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sendTestCopy(t, srv, carol, intruder)
+
+	messages := recordedMessages(t, srv)
+	if len(messages) != 1 || messages[0].Payload.ID != payload.ID || messages[0].Username != "alice" {
+		t.Fatalf("history = %+v, want the copy of alice", messages)
+	}
+	if !noTestMessage(t, bobClient) {
+		t.Error("the copy was relayed")
+	}
+
+	srv.replayHistory(carol)
+	replayed := readTestChat(t, carolClient, carol)
+	if replayed.ID != payload.ID || replayed.Body != "hello there" {
+		t.Errorf("replayed %+v", replayed)
+	}
+	if !noTestMessage(t, carolClient) {
+		t.Error("something else was replayed")
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/Guilospanck/pqc/core/pkg/cryptography"
+	"github.com/Guilospanck/pqc/core/pkg/devices"
+	"github.com/Guilospanck/pqc/core/pkg/types"
+	"github.com/Guilospanck/pqc/core/pkg/users"
+	"github.com/Guilospanck/pqc/core/pkg/ws"
+)
+
+// Users can register their username with a password and log in with it from
+// any device. The credentials are encrypted with the key the device exchanged
+// with the server. A registered username is never given to anyone else, so
+// it can only be connected as by logging in (or from a device that did).
+
+// Opens the registered users kept in `file` (in memory if empty)
+func openUsers(file string) (*users.Store, error) {
+	var backend users.Backend = users.NewMemoryBackend()
+	if file != "" {
+		backend = users.NewFileBackend(file)
+	}
+
+	return users.NewStore(backend)
+}
+
+func (srv *WSServer) registered(username string) bool {
+	return srv.users.Exists(username)
+}
+
+// Connections that were not `admitted` in a private server can't register:
+// that needs an invite. They can only log in, as a username of the allowlist
+// if the server has one.
+func (srv *WSServer) handleAccountMessage(connection *ws.Connection, msg ws.WSMessage, admitted bool) {
+	if connection.Keys.SharedSecret == nil {
+		srv.sendError(connection, "Exchange keys with the server first.")
+		return
+	}
+
+	// Every attempt hashes a password, which is slow and takes a lot of memory
+	if !srv.accountsByIP.allow(remoteIP(connection.Conn.RemoteAddr().String())) {
+		log.Printf("%s tried to %s too often\n", connection.Metadata.Username, msg.Type)
+		srv.sendError(connection, "Too many attempts, try again later.")
+		return
+	}
+
+	decrypted, err := cryptography.DecryptMessage(connection.Keys.SharedSecret, msg.Nonce, msg.Value)
+	if err != nil {
+		log.Printf("Could not decrypt credentials from %s: %s\n", connection.Metadata.Username, err.Error())
+		return
+	}
+
+	var credentials types.Credentials
+	if err := json.Unmarshal(decrypted, &credentials); err != nil {
+		log.Printf("Could not unmarshal credentials from %s: %s\n", connection.Metadata.Username, err.Error())
+		return
+	}
+
+	register := msg.Type == types.MessageTypeAccountRegister
+
+	if register && !admitted {
+		log.Printf("%s can't register %q without an invite\n", connection.Metadata.Username, credentials.Username)
+		srv.sendError(connection, "Not registered: this server is private, you need an invite.")
+		return
+	}
+
+	if !register && !admitted && srv.admission.allowlist != "" && !srv.allowlisted(credentials.Username) {
+		log.Printf("%s can't log in as %q without an invite\n", connection.Metadata.Username, credentials.Username)
+		// Looks like a wrong password, the allowlist isn't told
+		time.Sleep(LOGIN_FAILURE_DELAY)
+		srv.sendError(connection, accountError(register, users.ErrInvalidCredentials))
+		return
+	}
+
+	var user users.User
+	if register {
+		user, err = srv.register(connection, credentials)
+	} else {
+		user, err = srv.users.Authenticate(credentials.Username, credentials.Password)
+	}
+	if err != nil {
+		log.Printf("%s could not %s as %q: %s\n", connection.Metadata.Username, msg.Type, credentials.Username, err.Error())
+		// Slows down those guessing passwords
+		time.Sleep(LOGIN_FAILURE_DELAY)
+		srv.sendError(connection, accountError(register, err))
+		return
+	}
+
+	srv.logIn(connection, user, register)
+}
+
+// Registers `credentials`, for a username nobody uses (or the one of the device)
+func (srv *WSServer) register(connection *ws.Connection, credentials types.Credentials) (users.User, error) {
+	if err := validUsername(credentials.Username); err != nil {
+		return users.User{}, err
+	}
+
+	srv.mu.RLock()
+	taken := srv.usernameTaken(credentials.Username, connection.Metadata.Username)
+	srv.mu.RUnlock()
+	if taken {
+		return users.User{}, users.ErrUsernameTaken
+	}
+
+	return srv.users.Register(credentials.Username, credentials.Password, connection.Metadata.Color)
+}
+
+func accountError(register bool, err error) string {
+	switch {
+	case !register:
+		return "Not logged in: wrong username or password."
+	case errors.Is(err, users.ErrUsernameTaken):
+		return "Not registered: this username is already taken."
+	default:
+		return fmt.Sprintf("Not registered: %s.", err.Error())
+	}
+}
+
+// The device of `connection` now belongs to `user`. Unless it is connected
+// as them already, it connects again as them with a new reconnect token.
+func (srv *WSServer) logIn(connection *ws.Connection, user users.User, register bool) {
+	result := types.AccountResult{Username: user.Username, Color: user.Color, Registered: register}
+	device := connection.Metadata.Device
+
+	if current, ok := srv.getConnection(connectionId(connection)); ok && current == connection && user.Username == connection.Metadata.Username {
+		log.Printf("%s (device %s) is now registered\n", user.Username, device)
+		result.Color = connection.Metadata.Color
+		srv.sendJSONMessage(connection, types.MessageTypeAccountResult, result)
+		return
+	}
+
+	srv.mu.Lock()
+	acc, ok := srv.accounts[user.Username]
+	if !ok {
+		acc = &account{color: user.Color}
+		srv.accounts[user.Username] = acc
+	}
+	// Knowing the password is enough, no need to approve the device
+	if !slices.Contains(acc.devices, device) {
+		acc.devices = append(acc.devices, device)
+	}
+	acc.lastSeen = time.Now()
+	result.Color = acc.color
+	srv.mu.Unlock()
+
+	token, err := srv.newReconnectToken(clientId(devices.Address(user.Username, device)))
+	if err != nil {
+		log.Printf("Could not generate reconnect token for %s: %s\n", user.Username, err.Error())
+		srv.sendError(connection, "Could not log in, try again.")
+		return
+	}
+	result.Token = token
+
+	log.Printf("%s (device %s) logged in as %s\n", connection.Metadata.Username, device, user.Username)
+	srv.sendJSONMessage(connection, types.MessageTypeAccountResult, result)
+	connection.Conn.Close()
+}
+
+// Guests, when the server doesn't accept them (or didn't admit them), can
+// only exchange keys and then register or log in. They are not connections
+// yet: nobody sees them and they see nobody.
+func (srv *WSServer) readLoginMessages(connection *ws.Connection, admitted bool) {
+	refusal := "This server doesn't accept guests, /register or /login first."
+	if !admitted {
+		refusal = "This server is private, /login or connect with an invite first."
+	}
+
+	log.Printf("%s (device %s) must log in\n", connection.Metadata.Username, connection.Metadata.Device)
+
+	for {
+		msg, err := connection.ReadMessage()
+		if err != nil {
+			log.Printf("Error reading from conn: %s\n", err.Error())
+			return
+		}
+
+		msgJson, err := ws.UnmarshalWSMessage(msg)
+		if err != nil {
+			log.Printf("Error unmarshalling message: %s\n", err.Error())
+			continue
+		}
+
+		switch msgJson.Type {
+		case types.MessageTypeExchangeKeys:
+			connection.HandleClientMessage(msgJson)
+		case types.MessageTypeAccountRegister, types.MessageTypeAccountLogin:
+			srv.handleAccountMessage(connection, msgJson, admitted)
+		case types.MessageTypeTyping, types.MessageTypePresence:
+			// Sent without the user asking, nobody would see them anyway
+		default:
+			srv.sendError(connection, refusal)
+		}
+	}
+}
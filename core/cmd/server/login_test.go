@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Guilospanck/pqc/core/pkg/cryptography"
+	"github.com/Guilospanck/pqc/core/pkg/types"
+	"github.com/Guilospanck/pqc/core/pkg/ws"
+)
+
+func sendTestCredentials(t *testing.T, srv *WSServer, connection *ws.Connection, msgType types.MessageType, credentials types.Credentials, admitted bool) {
+	t.Helper()
+
+	marshalled, err := json.Marshal(credentials)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nonce, ciphertext, err := cryptography.EncryptMessage(connection.Keys.SharedSecret, marshalled)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv.handleAccountMessage(connection, ws.WSMessage{Type: msgType, Value: ciphertext, Nonce: nonce}, admitted)
+}
+
+func TestAccountAttemptsAreRateLimited(t *testing.T) {
+	srv := newTestServer(t)
+	srv.accountsByIP = newRateLimiter(1, time.Minute)
+
+	connection, client := connectTestDevice(t, srv, "guest", "d1")
+	credentials := types.Credentials{Username: "alice", Password: "wrong password"}
+
+	sendTestCredentials(t, srv, connection, types.MessageTypeAccountLogin, credentials, true)
+	if got := readTestMessage(t, client); string(got.Value) != "Not logged in: wrong username or password." {
+		t.Fatalf("first attempt got %s %q", got.Type, got.Value)
+	}
+
+	sendTestCredentials(t, srv, connection, types.MessageTypeAccountLogin, credentials, true)
+	if got := readTestMessage(t, client); string(got.Value) != "Too many attempts, try again later." {
+		t.Fatalf("second attempt got %s %q", got.Type, got.Value)
+	}
+}
+
+func TestAllowlistOnlyLetsLogIn(t *testing.T) {
+	srv := newTestServer(t)
+	srv.admission.allowlist = filepath.Join(t.TempDir(), "allowlist.txt")
+	if err := os.WriteFile(srv.admission.allowlist, []byte("# admins\nalice\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, username := range []string{"alice", "bob"} {
+		if _, err := srv.users.Register(username, "a long password", "#E6194B"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		msgType  types.MessageType
+		username string
+		admitted bool
+		answer   types.MessageType
+	}{
+		{"allowlisted username registers without an invite", types.MessageTypeAccountRegister, "carol", false, types.MessageTypeError},
+		{"registers with an invite", types.MessageTypeAccountRegister, "dave", true, types.MessageTypeAccountResult},
+		{"allowlisted username logs in without an invite", types.MessageTypeAccountLogin, "alice", false, types.MessageTypeAccountResult},
+		{"other username logs in without an invite", types.MessageTypeAccountLogin, "bob", false, types.MessageTypeError},
+		{"other username logs in with an invite", types.MessageTypeAccountLogin, "bob", true, types.MessageTypeAccountResult},
+	}
+
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			connection, client := connectTestDevice(t, srv, "guest", fmt.Sprintf("d%d", i))
+			credentials := types.Credentials{Username: test.username, Password: "a long password"}
+
+			sendTestCredentials(t, srv, connection, test.msgType, credentials, test.admitted)
+			if got := readTestMessage(t, client); got.Type != test.answer {
+				t.Errorf("got %s %q instead of %s", got.Type, got.Value, test.answer)
+			}
+		})
+	}
+}
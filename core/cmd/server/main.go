@@ -2,15 +2,119 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
+	"github.com/Guilospanck/pqc/core/pkg/admin"
+	"github.com/Guilospanck/pqc/core/pkg/cryptography"
+	"github.com/Guilospanck/pqc/core/pkg/group"
+	"github.com/Guilospanck/pqc/core/pkg/invites"
 	"github.com/Guilospanck/pqc/core/pkg/logger"
+	"github.com/Guilospanck/pqc/core/pkg/transport"
 )
 
 func main() {
 	logger.CreateMultiWriterLogger("ws-server-pqc")
 
-	ctx := context.Background()
+	useTLS := flag.Bool("tls", false, "serve wss:// (TLS 1.3 with X25519MLKEM768 only) instead of ws://")
+	certFile := flag.String("cert", "cert.pem", "TLS certificate, a self-signed one is generated here if it doesn't exist")
+	keyFile := flag.String("key", "key.pem", "TLS private key, generated with the self-signed certificate")
+	historyFile := flag.String("history-file", "", "keep the message history in this file (only in memory if empty)")
+	historyKey := flag.String("history-key", "history.key", "storage keys of the history file, the first one is generated if missing")
+	rotateHistoryKey := flag.Bool("rotate-history-key", false, "re-encrypt the history file under a new storage key")
+	historyReplay := flag.Int("history-replay", HISTORY_REPLAY_COUNT, "how many messages are replayed to a joining device")
+	historyWindow := flag.Duration("history-window", HISTORY_REPLAY_WINDOW, "how old the replayed messages can be")
+	usernameGrace := flag.Duration("username-grace", USERNAME_GRACE_PERIOD, "how long a username is kept once its user is offline (or gave it up)")
+	duplicateSessions := flag.String("duplicate-sessions", string(DUPLICATE_SESSION_POLICY), "when a device connects while it is still connected: kick the old session, reject the new one or add it as another device")
+	keyLogFile := flag.String("key-log-file", "", "keep the key transparency log in this file (only in memory if empty: clients then see a new log after every restart)")
+	keyLogKey := flag.String("key-log-key", "key-log.key", "signing key of the key log file, generated if missing")
+	usersFile := flag.String("users-file", "", "keep the registered users in this file (only in memory if empty)")
+	allowGuests := flag.Bool("guests", true, "accept users that are not registered (-guests=false to only accept registered ones)")
+	invitesFile := flag.String("invites-file", "", "make the server private: only let in new users with an invite of this file (see cmd/invites)")
+	allowlist := flag.String("allowlist", "", "make the server private: registered usernames (one per line) that can log in without an invite")
+	roomOwners := flag.String("room-owners", "", "registered usernames (comma separated) that own the room")
+	adminAddr := flag.String("admin-addr", "", "serve the admin API on this address, e.g. 127.0.0.1:8081 (disabled if empty)")
+	adminTokenFile := flag.String("admin-token", "admin.token", "token of the admin API, generated if missing")
+	shutdownTimeout := flag.Duration("shutdown-timeout", SHUTDOWN_TIMEOUT, "how long to wait for the connections to close when shutting down")
+	retryAfter := flag.Duration("retry-after", SHUTDOWN_RETRY_AFTER, "how long clients are told to wait before connecting again after a shutdown")
+	fips := flag.Bool("fips", false, "only use FIPS 140-3 approved algorithms and only accept clients that do too")
+	flag.Parse()
 
-	server := NewServer(ctx)
-	server.startServer()
+	if *usernameGrace <= 0 {
+		log.Fatalln("-username-grace must be positive")
+	}
+
+	policy, err := parseSessionPolicy(*duplicateSessions)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+
+	if *fips {
+		cryptography.EnableFIPS()
+	}
+	log.Println(cryptography.FIPSStatus())
+
+	var tlsConfig *tls.Config
+	if *useTLS {
+		config, err := transport.ServerConfig(*certFile, *keyFile)
+		if err != nil {
+			log.Fatalf("Could not load TLS certificate: %s\n", err.Error())
+		}
+		tlsConfig = config
+	}
+
+	store, err := openHistory(*historyFile, *historyKey, *rotateHistoryKey)
+	if err != nil {
+		log.Fatalf("Could not open history: %s\n", err.Error())
+	}
+
+	userStore, err := openUsers(*usersFile)
+	if err != nil {
+		log.Fatalf("Could not open users: %s\n", err.Error())
+	}
+
+	keyLog, err := openKeyLog(*keyLogFile, *keyLogKey)
+	if err != nil {
+		log.Fatalf("Could not open key transparency log: %s\n", err.Error())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	server := NewServer(ctx, newServerHistory(store, *historyReplay, *historyWindow), userStore, keyLog)
+	server.usernameGrace = *usernameGrace
+	server.sessionPolicy = policy
+	server.allowGuests = *allowGuests
+	if *invitesFile != "" {
+		server.admission.invites = invites.NewStore(*invitesFile)
+	}
+	server.admission.allowlist = *allowlist
+	if *roomOwners != "" {
+		server.setRoomOwners(group.DefaultGroupID, strings.Split(*roomOwners, ","))
+	}
+	go server.releaseUsernames()
+
+	servers := []*http.Server{server.startServer(tlsConfig)}
+
+	if *adminAddr != "" {
+		token, err := admin.LoadToken(*adminTokenFile)
+		if err != nil {
+			log.Fatalf("Could not load the admin token: %s\n", err.Error())
+		}
+		servers = append(servers, server.startAdminServer(*adminAddr, token))
+	}
+
+	// A second signal kills the server right away
+	signals, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	<-signals.Done()
+	stop()
+
+	log.Println("Shutting down...")
+	server.shutdown(servers, cancel, *retryAfter, *shutdownTimeout)
+	log.Println("Server stopped")
 }
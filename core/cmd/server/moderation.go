@@ -0,0 +1,458 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Guilospanck/pqc/core/pkg/types"
+	"github.com/Guilospanck/pqc/core/pkg/ws"
+)
+
+// Every room has owners, moderators and members. Moderators can kick, ban
+// and mute members, set the topic and choose who comes in an invite-only
+// room; owners can do that to moderators too, name them and make the room
+// invite-only. The server checks who is allowed to do what, then announces
+// it to everyone in the room. Roles only count for registered users: the
+// username of a guest can be taken by anyone once it is released.
+// Owners are given to the server (-room-owners), the rest is only kept in
+// memory.
+
+type roomACL struct {
+	topic      string
+	inviteOnly bool
+	roles      map[string]types.RoomRole // owners and moderators
+	invited    map[string]bool           // let in while the room is invite-only
+	banned     map[string]string         // usernames, with the reason
+	bannedIPs  map[string]string         // addresses, with the username banned with them
+	muted      map[string]time.Time      // until when
+	mu         sync.Mutex
+}
+
+func newRoomACL(owners []string) *roomACL {
+	acl := &roomACL{
+		roles:     make(map[string]types.RoomRole),
+		invited:   make(map[string]bool),
+		banned:    make(map[string]string),
+		bannedIPs: make(map[string]string),
+		muted:     make(map[string]time.Time),
+	}
+
+	for _, owner := range owners {
+		acl.roles[owner] = types.RoomRoleOwner
+	}
+
+	return acl
+}
+
+// Owners are written like they registered. The ones that are not registered
+// yet only get their role once they are.
+func (srv *WSServer) setRoomOwners(room string, names []string) {
+	owners := make([]string, 0, len(names))
+	for _, owner := range names {
+		owner = strings.TrimSpace(owner)
+		if owner == "" {
+			continue
+		}
+
+		if user, ok := srv.users.Get(owner); ok {
+			owner = user.Username
+		} else {
+			log.Printf("Owner %s of %s is not registered yet\n", owner, room)
+		}
+		owners = append(owners, owner)
+	}
+
+	srv.rooms[room] = newRoomACL(owners)
+}
+
+// Finds `username` in `m`, whatever its case
+func lookupUsername[V any](m map[string]V, username string) (string, V, bool) {
+	for name, value := range m {
+		if strings.EqualFold(name, username) {
+			return name, value, true
+		}
+	}
+
+	var zero V
+	return "", zero, false
+}
+
+func roleRank(role types.RoomRole) int {
+	switch role {
+	case types.RoomRoleOwner:
+		return 2
+	case types.RoomRoleModerator:
+		return 1
+	}
+
+	return 0
+}
+
+// Must be called with acl.mu held
+func (srv *WSServer) roomRole(acl *roomACL, username string) types.RoomRole {
+	if _, role, ok := lookupUsername(acl.roles, username); ok && srv.registered(username) {
+		return role
+	}
+
+	return types.RoomRoleMember
+}
+
+// Whether `actor` is a moderator of `room` with a higher role than `username`
+func (srv *WSServer) outranks(room, actor, username string) bool {
+	acl, ok := srv.rooms[room]
+	if !ok {
+		return false
+	}
+
+	acl.mu.Lock()
+	defer acl.mu.Unlock()
+
+	actorRole := srv.roomRole(acl, actor)
+	return roleRank(actorRole) >= roleRank(types.RoomRoleModerator) && roleRank(actorRole) > roleRank(srv.roomRole(acl, username))
+}
+
+// How `username` is written by the user online (or registered) with it
+func (srv *WSServer) canonicalUsername(username string) string {
+	srv.mu.RLock()
+	for name := range srv.accounts {
+		if strings.EqualFold(name, username) {
+			srv.mu.RUnlock()
+			return name
+		}
+	}
+	srv.mu.RUnlock()
+
+	if user, ok := srv.users.Get(username); ok {
+		return user.Username
+	}
+
+	return username
+}
+
+// Address a connection comes from, without its port
+func remoteIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+
+	return host
+}
+
+// Whether the room lets `username`, connecting from `ip`, in. Returns why not.
+func (srv *WSServer) roomRefusal(room, username, ip string) (string, bool) {
+	acl, ok := srv.rooms[room]
+	if !ok {
+		return "", false
+	}
+
+	acl.mu.Lock()
+	defer acl.mu.Unlock()
+
+	if _, reason, ok := lookupUsername(acl.banned, username); ok {
+		return fmt.Sprintf("You are banned from %s%s.", room, reasonSuffix(reason)), true
+	}
+
+	if _, ok := acl.bannedIPs[ip]; ok {
+		return fmt.Sprintf("This address is banned from %s.", room), true
+	}
+
+	if _, invited, _ := lookupUsername(acl.invited, username); acl.inviteOnly && !invited && srv.roomRole(acl, username) == types.RoomRoleMember {
+		return fmt.Sprintf("%s is invite-only.", room), true
+	}
+
+	return "", false
+}
+
+// Until when `username` can't talk in `room`, if it is muted
+func (srv *WSServer) mutedUntil(room, username string) (time.Time, bool) {
+	acl, ok := srv.rooms[room]
+	if !ok {
+		return time.Time{}, false
+	}
+
+	acl.mu.Lock()
+	defer acl.mu.Unlock()
+
+	name, until, ok := lookupUsername(acl.muted, username)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	if time.Now().After(until) {
+		delete(acl.muted, name)
+		return time.Time{}, false
+	}
+
+	return until, true
+}
+
+// Refuses a message of a muted sender. Returns true if it was refused.
+func (srv *WSServer) refuseMuted(connection *ws.Connection, room string) bool {
+	until, muted := srv.mutedUntil(room, connection.Metadata.Username)
+	if !muted {
+		return false
+	}
+
+	srv.sendError(connection, fmt.Sprintf("You are muted in %s until %s.", room, until.Format(time.TimeOnly)))
+	return true
+}
+
+func (srv *WSServer) roomInfo(room string) (types.RoomInfo, bool) {
+	acl, ok := srv.rooms[room]
+	if !ok {
+		return types.RoomInfo{}, false
+	}
+
+	acl.mu.Lock()
+	defer acl.mu.Unlock()
+
+	info := types.RoomInfo{
+		Room:       room,
+		Topic:      acl.topic,
+		InviteOnly: acl.inviteOnly,
+		Roles:      make(map[string]types.RoomRole),
+		Muted:      make(map[string]time.Time),
+	}
+
+	// Owners given before they registered are written like they registered
+	for username := range acl.roles {
+		if user, ok := srv.users.Get(username); ok && srv.roomRole(acl, username) != types.RoomRoleMember {
+			info.Roles[user.Username] = acl.roles[username]
+		}
+	}
+
+	for username, until := range acl.muted {
+		if time.Now().Before(until) {
+			info.Muted[username] = until
+		}
+	}
+
+	return info, true
+}
+
+// Tells a device that just connected who runs the rooms
+func (srv *WSServer) informUserOfRooms(connection *ws.Connection) {
+	for room := range srv.rooms {
+		if info, ok := srv.roomInfo(room); ok {
+			srv.sendJSONMessage(connection, types.MessageTypeRoomInfo, info)
+		}
+	}
+}
+
+func reasonSuffix(reason string) string {
+	if reason == "" {
+		return ""
+	}
+
+	return " (" + reason + ")"
+}
+
+// Whether `actor` can do what `req` asks, according to the roles
+func authorizeModeration(req types.Moderation, actor string, actorRole, targetRole types.RoomRole) error {
+	switch req.Action {
+	case types.ModerationRole, types.ModerationInviteOnly:
+		if actorRole != types.RoomRoleOwner {
+			return errors.New("only owners can do that")
+		}
+		if req.Action == types.ModerationRole && strings.EqualFold(req.Target, actor) {
+			return errors.New("you can't change your own role")
+		}
+		if req.Action == types.ModerationRole && targetRole == types.RoomRoleOwner {
+			return fmt.Errorf("%s is an owner", req.Target)
+		}
+
+	case types.ModerationKick, types.ModerationBan, types.ModerationBanIP, types.ModerationMute:
+		if roleRank(actorRole) < roleRank(types.RoomRoleModerator) {
+			return errors.New("only moderators can do that")
+		}
+		if strings.EqualFold(req.Target, actor) {
+			return errors.New("you can't do that to yourself")
+		}
+		if roleRank(targetRole) >= roleRank(actorRole) {
+			return fmt.Errorf("%s has the role %s", req.Target, targetRole)
+		}
+
+	case types.ModerationUnban, types.ModerationUnmute, types.ModerationTopic, types.ModerationInvite, types.ModerationUninvite:
+		if roleRank(actorRole) < roleRank(types.RoomRoleModerator) {
+			return errors.New("only moderators can do that")
+		}
+
+	default:
+		return fmt.Errorf("unknown action %q", req.Action)
+	}
+
+	return nil
+}
+
+// Applies `req`, once authorized, and returns what is announced.
+// Must be called with acl.mu held.
+func (srv *WSServer) applyModeration(acl *roomACL, req types.Moderation, online []*ws.Connection) (types.Moderation, error) {
+	event := req
+	event.Duration = 0
+
+	switch req.Action {
+	case types.ModerationKick:
+		if len(online) == 0 {
+			return event, fmt.Errorf("%s is not connected", req.Target)
+		}
+
+	case types.ModerationBan:
+		acl.banned[req.Target] = req.Reason
+
+	case types.ModerationBanIP:
+		if len(online) == 0 {
+			return event, fmt.Errorf("%s is not connected", req.Target)
+		}
+		acl.banned[req.Target] = req.Reason
+		for _, c := range online {
+			acl.bannedIPs[remoteIP(c.Conn.RemoteAddr().String())] = req.Target
+		}
+
+	case types.ModerationUnban:
+		name, _, banned := lookupUsername(acl.banned, req.Target)
+		delete(acl.banned, name)
+		for ip, username := range acl.bannedIPs {
+			if strings.EqualFold(username, req.Target) {
+				delete(acl.bannedIPs, ip)
+				banned = true
+			}
+		}
+		if !banned {
+			return event, fmt.Errorf("%s is not banned", req.Target)
+		}
+
+	case types.ModerationMute:
+		if req.Duration <= 0 {
+			return event, errors.New("mutes need a duration")
+		}
+		event.Until = time.Now().Add(time.Duration(req.Duration) * time.Second).Truncate(time.Second)
+		if name, _, ok := lookupUsername(acl.muted, req.Target); ok {
+			delete(acl.muted, name)
+		}
+		acl.muted[req.Target] = event.Until
+
+	case types.ModerationUnmute:
+		name, until, ok := lookupUsername(acl.muted, req.Target)
+		if !ok || time.Now().After(until) {
+			return event, fmt.Errorf("%s is not muted", req.Target)
+		}
+		delete(acl.muted, name)
+
+	case types.ModerationTopic:
+		event.Value = strings.TrimSpace(req.Value)
+		acl.topic = event.Value
+
+	case types.ModerationRole:
+		if !srv.registered(req.Target) {
+			return event, errors.New("roles are only for registered users")
+		}
+		name, _, _ := lookupUsername(acl.roles, req.Target)
+		delete(acl.roles, name)
+		switch req.Value {
+		case types.RoomRoleModerator:
+			acl.roles[req.Target] = types.RoomRoleModerator
+		case types.RoomRoleMember:
+		default:
+			return event, errors.New("the role is moderator or member")
+		}
+
+	case types.ModerationInviteOnly:
+		switch req.Value {
+		case "on", "off":
+			acl.inviteOnly = req.Value == "on"
+		default:
+			return event, errors.New("invite-only is on or off")
+		}
+
+	case types.ModerationInvite:
+		acl.invited[req.Target] = true
+
+	case types.ModerationUninvite:
+		name, _, ok := lookupUsername(acl.invited, req.Target)
+		if !ok {
+			return event, fmt.Errorf("%s is not invited", req.Target)
+		}
+		delete(acl.invited, name)
+	}
+
+	return event, nil
+}
+
+// A member asked to moderate a room
+func (srv *WSServer) handleModerate(connection *ws.Connection, msg ws.WSMessage) {
+	var req types.Moderation
+	if err := json.Unmarshal(msg.Value, &req); err != nil {
+		log.Printf("Could not unmarshal moderation from %s: %s\n", connection.Metadata.Username, err.Error())
+		return
+	}
+
+	req.Actor = connection.Metadata.Username
+	if err := srv.moderate(req, true); err != nil {
+		srv.sendError(connection, "Not done: "+err.Error()+".")
+	}
+}
+
+// Checks that `req.Actor` is allowed to do it (unless `authorize` is false,
+// for the admins of the server), applies it and tells everyone. The members
+// kicked or banned are disconnected.
+func (srv *WSServer) moderate(req types.Moderation, authorize bool) error {
+	acl, ok := srv.rooms[req.Room]
+	if !ok {
+		return fmt.Errorf("unknown room %s", req.Room)
+	}
+
+	if req.Target == "" && req.Action != types.ModerationTopic && req.Action != types.ModerationInviteOnly {
+		return errors.New("a username is needed")
+	}
+	if utf8.RuneCountInString(req.Value) > MAX_ROOM_TEXT_LENGTH || utf8.RuneCountInString(req.Reason) > MAX_ROOM_TEXT_LENGTH {
+		return fmt.Errorf("topics and reasons have at most %d characters", MAX_ROOM_TEXT_LENGTH)
+	}
+
+	if req.Target != "" {
+		req.Target = srv.canonicalUsername(req.Target)
+	}
+	online := srv.userConnections(req.Target)
+
+	acl.mu.Lock()
+	var err error
+	if authorize {
+		err = authorizeModeration(req, req.Actor, srv.roomRole(acl, req.Actor), srv.roomRole(acl, req.Target))
+	}
+	var event types.Moderation
+	if err == nil {
+		event, err = srv.applyModeration(acl, req, online)
+	}
+	acl.mu.Unlock()
+
+	if err != nil {
+		log.Printf("%s could not %s %s in %s: %s\n", req.Actor, req.Action, req.Target, req.Room, err.Error())
+		return err
+	}
+
+	log.Printf("%s did %s to %q in %s (value %q, reason %q)\n", req.Actor, event.Action, event.Target, event.Room, event.Value, event.Reason)
+
+	for _, c := range srv.currentConnections() {
+		srv.sendJSONMessage(&c, types.MessageTypeModeration, event)
+	}
+
+	switch event.Action {
+	case types.ModerationKick:
+		for _, c := range online {
+			c.Close(ws.CloseKicked, fmt.Sprintf("%s kicked you from %s%s.", req.Actor, event.Room, reasonSuffix(event.Reason)))
+		}
+	case types.ModerationBan, types.ModerationBanIP:
+		for _, c := range online {
+			c.Close(ws.CloseBanned, fmt.Sprintf("%s banned you from %s%s.", req.Actor, event.Room, reasonSuffix(event.Reason)))
+		}
+	}
+
+	return nil
+}
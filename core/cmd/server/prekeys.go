@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/Guilospanck/pqc/core/pkg/devices"
+	"github.com/Guilospanck/pqc/core/pkg/pqxdh"
+	"github.com/Guilospanck/pqc/core/pkg/transparency"
+	"github.com/Guilospanck/pqc/core/pkg/types"
+	"github.com/Guilospanck/pqc/core/pkg/ws"
+)
+
+// Messages (prekey or sealed ones) waiting for their recipient device to come back online
+type mailbox[T any] struct {
+	messages map[string][]T // device address -> messages
+	mu       sync.Mutex
+}
+
+func newMailbox[T any]() *mailbox[T] {
+	return &mailbox[T]{
+		messages: make(map[string][]T),
+	}
+}
+
+// Returns false if the mailbox of `address` is full
+func (m *mailbox[T]) push(address string, msg T) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.messages[address]) >= MAX_MAILBOX_SIZE {
+		return false
+	}
+
+	m.messages[address] = append(m.messages[address], msg)
+	return true
+}
+
+func (m *mailbox[T]) take(address string) []T {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	messages := m.messages[address]
+	delete(m.messages, address)
+
+	return messages
+}
+
+// The messages for `from` are now for `to`
+func (m *mailbox[T]) move(from, to string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if messages, ok := m.messages[from]; ok {
+		m.messages[to] = append(m.messages[to], messages...)
+		delete(m.messages, from)
+	}
+}
+
+// A client published (or replenished) its prekeys. This is also the moment
+// its identity keys are published in the key log, and when the messages
+// sent while it was offline are delivered.
+func (srv *WSServer) handlePrekeyUpload(connection *ws.Connection, msg ws.WSMessage) {
+	username := connection.Metadata.Username
+	device := connection.Metadata.Device
+
+	var upload pqxdh.Upload
+	if err := json.Unmarshal(msg.Value, &upload); err != nil {
+		log.Printf("Could not unmarshal prekey upload from %s: %s\n", username, err.Error())
+		return
+	}
+
+	if err := upload.Verify(); err != nil {
+		log.Printf("Rejected prekeys of %s: %s\n", username, err.Error())
+		srv.sendError(connection, "Prekeys rejected: invalid signature.")
+		return
+	}
+
+	if !bytes.Equal(upload.IdentityKey, connection.Keys.Public) {
+		log.Printf("Rejected prekeys of %s: identity key does not match the exchanged key\n", username)
+		srv.sendError(connection, "Prekeys rejected: identity key does not match the exchanged key.")
+		return
+	}
+
+	if err := srv.prekeys.Publish(username, device, upload); err != nil {
+		log.Printf("Rejected prekeys of %s: %s\n", username, err.Error())
+		srv.sendError(connection, "Prekeys rejected: "+err.Error()+".")
+		return
+	}
+	log.Printf("Stored %d new one-time prekeys of %s (device %s)\n", len(upload.OneTimePrekeys), username, device)
+
+	srv.publishKey(transparency.Binding{
+		Username:   username,
+		Device:     device,
+		PublicKey:  upload.IdentityKey,
+		SigningKey: upload.SigningKey,
+	})
+
+	srv.requestPrekeysIfLow(username, device)
+
+	for _, initial := range srv.mailbox.take(string(connectionId(connection))) {
+		srv.deliverPrekeyMessage(connection, initial)
+	}
+	for _, envelope := range srv.sealedMailbox.take(string(connectionId(connection))) {
+		srv.sendJSONMessage(connection, types.MessageTypeSealedMessage, envelope)
+	}
+}
+
+func (srv *WSServer) handlePrekeyBundleRequest(connection *ws.Connection, msg ws.WSMessage) {
+	var request pqxdh.BundleRequest
+	if err := json.Unmarshal(msg.Value, &request); err != nil {
+		log.Printf("Could not unmarshal bundle request from %s: %s\n", connection.Metadata.Username, err.Error())
+		return
+	}
+
+	// One bundle for every device of the user.
+	// No bundles tells the client there are no prekeys for this user.
+	bundles := pqxdh.Bundles{
+		Username: request.Username,
+		Bundles:  make([]pqxdh.Bundle, 0),
+		Online:   len(srv.userConnections(request.Username)) > 0,
+	}
+	for _, device := range srv.userDevices(request.Username) {
+		bundle, _, err := srv.prekeys.Bundle(request.Username, device)
+		if err != nil {
+			continue
+		}
+
+		// The key publication goes first so the client can check the bundle against it
+		if err := srv.sendLatestKeyPublication(connection, request.Username, device); err != nil {
+			log.Printf("Could not send key publication of %s (device %s): %s\n", request.Username, device, err.Error())
+			continue
+		}
+		bundles.Bundles = append(bundles.Bundles, bundle)
+
+		srv.requestPrekeysIfLow(request.Username, device)
+	}
+
+	srv.sendJSONMessage(connection, types.MessageTypePrekeyBundle, bundles)
+}
+
+// Delivers a prekey message right away if its recipient is online,
+// otherwise keeps it until the recipient connects again.
+func (srv *WSServer) handlePrekeyMessage(connection *ws.Connection, msg ws.WSMessage) {
+	var initial pqxdh.InitialMessage
+	if err := json.Unmarshal(msg.Value, &initial); err != nil {
+		log.Printf("Could not unmarshal prekey message from %s: %s\n", connection.Metadata.Username, err.Error())
+		return
+	}
+
+	if initial.From != connection.Metadata.Username || initial.FromDevice != connection.Metadata.Device {
+		log.Printf("%s tried to send a prekey message as %s\n", connection.Metadata.Username, initial.From)
+		return
+	}
+
+	recipient := devices.Address(initial.To, initial.ToDevice)
+	if c, ok := srv.getConnection(clientId(recipient)); ok {
+		srv.deliverPrekeyMessage(c, initial)
+		srv.ackMessage(connection, msg.ID, initial.To)
+		return
+	}
+
+	if !srv.mailbox.push(recipient, initial) {
+		srv.sendError(connection, fmt.Sprintf("The mailbox of %s is full.", initial.To))
+		return
+	}
+
+	log.Printf("Stored prekey message from %s to offline device %s of %s\n", initial.From, initial.ToDevice, initial.To)
+	srv.ackMessage(connection, msg.ID, initial.To)
+}
+
+func (srv *WSServer) deliverPrekeyMessage(recipient *ws.Connection, initial pqxdh.InitialMessage) {
+	if err := srv.sendLatestKeyPublication(recipient, initial.From, initial.FromDevice); err != nil {
+		log.Printf("Could not send key publication of %s: %s\n", initial.From, err.Error())
+		return
+	}
+
+	log.Printf("Delivering prekey message from %s to %s (device %s)\n", initial.From, initial.To, initial.ToDevice)
+	srv.sendJSONMessage(recipient, types.MessageTypePrekeyMessage, initial)
+}
+
+// Asks the device that owns the prekeys to upload more one-time prekeys
+// if it is online and running low.
+func (srv *WSServer) requestPrekeysIfLow(username, device string) {
+	remaining := srv.prekeys.Remaining(username, device)
+	if remaining >= PREKEY_LOW_WATERMARK {
+		return
+	}
+
+	owner, ok := srv.getConnection(clientId(devices.Address(username, device)))
+	if !ok {
+		return
+	}
+
+	srv.sendJSONMessage(owner, types.MessageTypePrekeysLow, pqxdh.PrekeyCount{Remaining: remaining})
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Guilospanck/pqc/core/pkg/pqxdh"
+	"github.com/Guilospanck/pqc/core/pkg/types"
+	"github.com/Guilospanck/pqc/core/pkg/ws"
+)
+
+// Senders are told whether the recipient is online, so they can say the
+// message is queued
+func TestBundlesOnline(t *testing.T) {
+	srv := newTestServer(t)
+	alice, aliceClient := connectTestDevice(t, srv, "alice", "a1")
+	connectTestDevice(t, srv, "bob", "b1")
+
+	for username, online := range map[string]bool{"bob": true, "carol": false} {
+		request, err := json.Marshal(pqxdh.BundleRequest{Username: username})
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv.handlePrekeyBundleRequest(alice, ws.WSMessage{Type: types.MessageTypePrekeyBundle, Value: request})
+
+		msg := readTestMessage(t, aliceClient)
+		if msg.Type != types.MessageTypePrekeyBundle {
+			t.Fatalf("alice got a %s message instead of the bundles", msg.Type)
+		}
+		var bundles pqxdh.Bundles
+		if err := json.Unmarshal(msg.Value, &bundles); err != nil {
+			t.Fatal(err)
+		}
+		if bundles.Username != username || bundles.Online != online {
+			t.Errorf("bundles of %s online = %v, want %v", bundles.Username, bundles.Online, online)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/Guilospanck/pqc/core/pkg/chat"
+	"github.com/Guilospanck/pqc/core/pkg/types"
+	"github.com/Guilospanck/pqc/core/pkg/ws"
+)
+
+// Status of `username`, online unless it said otherwise
+func (srv *WSServer) userPresence(username string) types.Presence {
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+
+	presence := types.Presence{Username: username, Status: types.PresenceOnline}
+	if acc, ok := srv.accounts[username]; ok && acc.presence.Status != "" {
+		presence.Status = acc.presence.Status
+		presence.Text = acc.presence.Text
+	}
+
+	return presence
+}
+
+func (srv *WSServer) setPresence(username string, presence types.Presence) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if acc, ok := srv.accounts[username]; ok {
+		acc.presence = presence
+	}
+}
+
+// The user (from any of its devices) changed its status
+func (srv *WSServer) handlePresence(connection *ws.Connection, msg ws.WSMessage) {
+	var presence types.Presence
+	if err := json.Unmarshal(msg.Value, &presence); err != nil {
+		log.Printf("Could not unmarshal status from %s: %s\n", connection.Metadata.Username, err.Error())
+		return
+	}
+
+	if err := chat.ValidatePresence(presence); err != nil {
+		srv.sendError(connection, "Status not changed: "+err.Error()+".")
+		return
+	}
+
+	// We know whose status it is
+	presence.Username = connection.Metadata.Username
+	srv.setPresence(presence.Username, presence)
+
+	// Other devices of the user follow the change too
+	for _, c := range srv.currentConnections() {
+		if c.Conn == connection.Conn {
+			continue
+		}
+
+		srv.sendJSONMessage(&c, types.MessageTypePresence, presence)
+	}
+}
+
+// Typing indicators are only forwarded, the client throttles them
+func (srv *WSServer) handleTyping(connection *ws.Connection, msg ws.WSMessage) {
+	var typing types.Typing
+	if err := json.Unmarshal(msg.Value, &typing); err != nil {
+		log.Printf("Could not unmarshal typing indicator from %s: %s\n", connection.Metadata.Username, err.Error())
+		return
+	}
+
+	typing.Username = connection.Metadata.Username
+
+	for _, c := range srv.currentConnections() {
+		if c.Metadata.Username == typing.Username {
+			continue
+		}
+
+		srv.sendJSONMessage(&c, types.MessageTypeTyping, typing)
+	}
+}
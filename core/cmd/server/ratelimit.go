@@ -0,0 +1,69 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Lets each key (a username, an IP) make `limit` attempts over a sliding
+// window of `window`. Keys whose attempts are all older than the window are
+// forgotten, so the ones that stop trying don't stay in memory.
+type rateLimiter struct {
+	limit     int
+	window    time.Duration
+	attempts  map[string][]time.Time
+	lastSweep time.Time
+	mu        sync.Mutex
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{
+		limit:     limit,
+		window:    window,
+		attempts:  make(map[string][]time.Time),
+		lastSweep: time.Now(),
+	}
+}
+
+// Records an attempt of `key`, unless it already made `limit` of them in
+// the window
+func (l *rateLimiter) allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.lastSweep) > l.window {
+		for k := range l.attempts {
+			l.forget(k, now)
+		}
+		l.lastSweep = now
+	}
+
+	recent := l.forget(key, now)
+	if len(recent) >= l.limit {
+		return false
+	}
+
+	l.attempts[key] = append(recent, now)
+	return true
+}
+
+// Drops the attempts of `key` older than the window and returns the others.
+// Must be called with l.mu held.
+func (l *rateLimiter) forget(key string, now time.Time) []time.Time {
+	attempts := l.attempts[key]
+
+	i := 0
+	for i < len(attempts) && now.Sub(attempts[i]) > l.window {
+		i++
+	}
+	recent := attempts[i:]
+
+	if len(recent) == 0 {
+		delete(l.attempts, key)
+		return nil
+	}
+
+	l.attempts[key] = recent
+	return recent
+}
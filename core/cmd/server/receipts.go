@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+
+	"github.com/Guilospanck/pqc/core/pkg/chat"
+	"github.com/Guilospanck/pqc/core/pkg/types"
+	"github.com/Guilospanck/pqc/core/pkg/ws"
+)
+
+// A message we acked, and who can send receipts about it
+type ackedMessage struct {
+	sender     string
+	recipients map[string]struct{} // usernames
+}
+
+// The messages we acked lately, by id. Only the last MAX_ACKED_MESSAGES are
+// kept: receipts about older ones are dropped.
+type ackedMessages struct {
+	messages map[string]*ackedMessage
+	order    []string // ids, oldest first
+	mu       sync.Mutex
+}
+
+func newAckedMessages() *ackedMessages {
+	return &ackedMessages{messages: make(map[string]*ackedMessage)}
+}
+
+// The same message can be acked several times (once for each device it is
+// sent to), its recipients add up. An id already used by someone else is
+// not taken over: nobody gets the receipts of someone else's message.
+func (a *ackedMessages) add(id, sender string, recipients []string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	msg, ok := a.messages[id]
+	if !ok {
+		msg = &ackedMessage{sender: sender, recipients: make(map[string]struct{}, len(recipients))}
+		a.messages[id] = msg
+		a.order = append(a.order, id)
+
+		if len(a.order) > MAX_ACKED_MESSAGES {
+			delete(a.messages, a.order[0])
+			a.order = a.order[1:]
+		}
+	}
+	if msg.sender != sender {
+		return
+	}
+
+	for _, recipient := range recipients {
+		if recipient != sender {
+			msg.recipients[recipient] = struct{}{}
+		}
+	}
+}
+
+// Whether `recipient` got the message `id` from `sender`
+func (a *ackedMessages) received(id, sender, recipient string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	msg, ok := a.messages[id]
+	if !ok || msg.sender != sender {
+		return false
+	}
+
+	_, ok = msg.recipients[recipient]
+	return ok
+}
+
+// Tells the sender of a message that we accepted it (relayed it,
+// delivered it or stored it for later), and remembers who it went to for
+// the receipts. Messages acked without recipients (sealed ones: we don't
+// keep who sent them) get no receipts.
+func (srv *WSServer) ackMessage(connection *ws.Connection, id string, recipients ...string) {
+	if id == "" {
+		return
+	}
+
+	if len(recipients) > 0 {
+		srv.acked.add(id, connection.Metadata.Username, recipients)
+	}
+
+	srv.sendJSONMessage(connection, types.MessageTypeMessageAck, chat.Ack{ID: id})
+}
+
+// Receipts go to every device of the sender of the message. They aren't
+// kept for offline senders. Receipts about messages we didn't relay from
+// that sender to that recipient are dropped.
+func (srv *WSServer) handleMessageReceipt(connection *ws.Connection, msg ws.WSMessage) {
+	var receipt chat.Receipt
+	if err := json.Unmarshal(msg.Value, &receipt); err != nil {
+		log.Printf("Could not unmarshal receipt from %s: %s\n", connection.Metadata.Username, err.Error())
+		return
+	}
+
+	if err := receipt.Validate(); err != nil {
+		log.Printf("Invalid receipt from %s: %s\n", connection.Metadata.Username, err.Error())
+		return
+	}
+
+	// We know who the receipt is from
+	receipt.Recipient = connection.Metadata.Username
+
+	if !srv.acked.received(receipt.ID, receipt.Sender, receipt.Recipient) {
+		log.Printf("Dropped receipt from %s about a message %s didn't send them\n", receipt.Recipient, receipt.Sender)
+		return
+	}
+
+	for _, c := range srv.userConnections(receipt.Sender) {
+		srv.sendJSONMessage(c, types.MessageTypeMessageReceipt, receipt)
+	}
+}
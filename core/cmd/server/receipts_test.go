@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestAckedMessages(t *testing.T) {
+	acked := newAckedMessages()
+	acked.add("room", "alice", []string{"bob", "carol", "alice"})
+	acked.add("mail", "alice", []string{"bob"})
+	acked.add("mail", "alice", []string{"dave"}) // another device of the recipients
+	acked.add("mail", "mallory", []string{"mallory", "erin"})
+
+	tests := []struct {
+		name      string
+		id        string
+		sender    string
+		recipient string
+		received  bool
+	}{
+		{"room recipient", "room", "alice", "bob", true},
+		{"other room recipient", "room", "alice", "carol", true},
+		{"sender", "room", "alice", "alice", false},
+		{"not a recipient", "room", "alice", "dave", false},
+		{"wrong sender", "room", "carol", "bob", false},
+		{"unknown message", "other", "alice", "bob", false},
+		{"recipients add up", "mail", "alice", "dave", true},
+		{"id taken over", "mail", "mallory", "erin", false},
+		{"recipient of the taken over id", "mail", "alice", "erin", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if received := acked.received(tt.id, tt.sender, tt.recipient); received != tt.received {
+				t.Errorf("received(%q, %q, %q) = %v, want %v", tt.id, tt.sender, tt.recipient, received, tt.received)
+			}
+		})
+	}
+}
+
+func TestAckedMessagesForgetOldest(t *testing.T) {
+	acked := newAckedMessages()
+	for i := range MAX_ACKED_MESSAGES + 1 {
+		acked.add(fmt.Sprint(i), "alice", []string{"bob"})
+	}
+
+	if acked.received("0", "alice", "bob") {
+		t.Error("the oldest message is still remembered")
+	}
+	if !acked.received("1", "alice", "bob") || !acked.received(fmt.Sprint(MAX_ACKED_MESSAGES), "alice", "bob") {
+		t.Error("recent messages are forgotten")
+	}
+	if len(acked.messages) != MAX_ACKED_MESSAGES {
+		t.Errorf("%d messages remembered, want %d", len(acked.messages), MAX_ACKED_MESSAGES)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"log"
+	"time"
+
+	"github.com/Guilospanck/pqc/core/pkg/devices"
+	"github.com/Guilospanck/pqc/core/pkg/types"
+	"github.com/Guilospanck/pqc/core/pkg/ws"
+
+	"github.com/gorilla/websocket"
+)
+
+// Anyone can send a username and a device id (both are public), so a device
+// that connects again must also show the token it got on its last connection.
+// We only keep a hash of the tokens.
+type reconnectToken struct {
+	hash    [sha256.Size]byte
+	expires time.Time // zero while the device is online
+}
+
+// Size of the reconnect tokens, in bytes
+const reconnectTokenSize = 32
+
+// Gives the device of `connection` a new token, replacing its previous one.
+// It is valid while the device is online, and for as long as the username
+// is kept once it leaves.
+func (srv *WSServer) sendReconnectToken(connection *ws.Connection) {
+	token, err := srv.newReconnectToken(connectionId(connection))
+	if err != nil {
+		log.Printf("Could not generate reconnect token for %s: %s\n", connection.Metadata.Username, err.Error())
+		return
+	}
+
+	msg := ws.WSMessage{
+		Type:     types.MessageTypeReconnectToken,
+		Value:    []byte(token),
+		Nonce:    nil,
+		Metadata: ws.WSMetadata{Username: connection.Metadata.Username, Color: connection.Metadata.Color},
+	}
+	jsonMsg := msg.Marshal()
+
+	if err := connection.WriteMessage(string(jsonMsg), websocket.TextMessage); err != nil {
+		log.Printf("Error trying to send reconnect token to %s: %s\n", connection.Metadata.Username, err.Error())
+	}
+}
+
+// Generates the token of the device `id`, replacing its previous one
+func (srv *WSServer) newReconnectToken(id clientId) (string, error) {
+	token := make([]byte, reconnectTokenSize)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	encoded := hex.EncodeToString(token)
+
+	srv.mu.Lock()
+	srv.reconnectTokens[id] = &reconnectToken{hash: sha256.Sum256([]byte(encoded))}
+	srv.mu.Unlock()
+
+	return encoded, nil
+}
+
+// Must be called with srv.mu held
+func (srv *WSServer) validReconnectToken(username, device, token string) bool {
+	stored, ok := srv.reconnectTokens[clientId(devices.Address(username, device))]
+	if !ok || token == "" || stored.expired() {
+		return false
+	}
+
+	hash := sha256.Sum256([]byte(token))
+	return subtle.ConstantTimeCompare(hash[:], stored.hash[:]) == 1
+}
+
+func (token *reconnectToken) expired() bool {
+	return !token.expires.IsZero() && time.Now().After(token.expires)
+}
+
+// The token of a device that left expires once its username would be released
+func (srv *WSServer) refreshReconnectToken(id clientId) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if token, ok := srv.reconnectTokens[id]; ok {
+		token.expires = time.Now().Add(srv.usernameGrace)
+	}
+}
+
+// Whether the device is coming back with a valid token, so it was let in before
+func (srv *WSServer) reclaimsIdentity(username, device, token string) bool {
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+
+	return srv.validReconnectToken(username, device, token)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Guilospanck/pqc/core/pkg/devices"
+)
+
+func TestReconnectTokenExpiry(t *testing.T) {
+	srv := newTestServer(t)
+	srv.usernameGrace = 10 * time.Millisecond
+
+	id := clientId(devices.Address("alice", "d1"))
+	token, err := srv.newReconnectToken(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := func() bool {
+		srv.mu.RLock()
+		defer srv.mu.RUnlock()
+		return srv.validReconnectToken("alice", "d1", token)
+	}
+
+	// Online for longer than the grace period
+	time.Sleep(2 * srv.usernameGrace)
+	srv.releaseIdleUsernames()
+	if !valid() {
+		t.Fatal("token of an online device expired")
+	}
+
+	// Left: valid until the grace period ends
+	srv.refreshReconnectToken(id)
+	if !valid() {
+		t.Fatal("token expired as soon as the device left")
+	}
+
+	time.Sleep(2 * srv.usernameGrace)
+	if valid() {
+		t.Fatal("token still valid after the grace period")
+	}
+
+	srv.releaseIdleUsernames()
+	srv.mu.RLock()
+	_, kept := srv.reconnectTokens[id]
+	srv.mu.RUnlock()
+	if kept {
+		t.Fatal("expired token was not released")
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/Guilospanck/pqc/core/pkg/devices"
+	"github.com/Guilospanck/pqc/core/pkg/sas"
+	"github.com/Guilospanck/pqc/core/pkg/types"
+	"github.com/Guilospanck/pqc/core/pkg/ws"
+
+	"github.com/gorilla/websocket"
+)
+
+// Verification messages are relayed as they are: the server only
+// looks at the envelope to know where they go.
+func (srv *WSServer) handleSASMessage(connection *ws.Connection, msg ws.WSMessage) {
+	var envelope sas.Envelope
+	if err := json.Unmarshal(msg.Value, &envelope); err != nil {
+		log.Printf("Could not unmarshal %s from %s: %s\n", msg.Type, connection.Metadata.Username, err.Error())
+		return
+	}
+
+	if envelope.From != connection.Metadata.Username || envelope.FromDevice != connection.Metadata.Device {
+		log.Printf("%s tried to send a %s message as %s\n", connection.Metadata.Username, msg.Type, envelope.From)
+		return
+	}
+
+	recipients := srv.userConnections(envelope.To)
+	if envelope.ToDevice != "" {
+		recipients = make([]*ws.Connection, 0, 1)
+		if c, ok := srv.getConnection(clientId(devices.Address(envelope.To, envelope.ToDevice))); ok {
+			recipients = append(recipients, c)
+		}
+	}
+
+	if len(recipients) == 0 {
+		srv.sendError(connection, fmt.Sprintf("%s is not online.", envelope.To))
+		return
+	}
+
+	relayed := ws.WSMessage{
+		Type:     msg.Type,
+		Value:    msg.Value,
+		Nonce:    nil,
+		Metadata: connection.Metadata,
+	}
+	jsonMsg := relayed.Marshal()
+
+	for _, c := range recipients {
+		// The recipient compares the keys published in the key log,
+		// so it needs the ones of the sender
+		if msg.Type == types.MessageTypeSASRequest || msg.Type == types.MessageTypeSASAccept {
+			if err := srv.sendLatestKeyPublication(c, envelope.From, envelope.FromDevice); err != nil {
+				log.Printf("Could not send key publication of %s: %s\n", envelope.From, err.Error())
+			}
+		}
+
+		if err := c.WriteMessage(string(jsonMsg), websocket.TextMessage); err != nil {
+			log.Printf("Error relaying %s message to %s: %s\n", msg.Type, c.Metadata.Username, err.Error())
+		}
+	}
+}
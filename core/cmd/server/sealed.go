@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/Guilospanck/pqc/core/pkg/sealed"
+	"github.com/Guilospanck/pqc/core/pkg/types"
+	"github.com/Guilospanck/pqc/core/pkg/ws"
+)
+
+// Sealed messages only tell who they are for, through the delivery token
+// the recipient device got in its prekey bundle. Clients send them without
+// their metadata, we don't keep (nor log) which connection sent them, and
+// recipients don't get the sender metadata.
+//
+// They still arrive on the authenticated connection of their sender. Sealed
+// sender protects from what the server keeps (logs, mailboxes) and from
+// a server that gets compromised later, not from a server that watches its
+// connections while it runs: it could match a sealed message to the
+// connection (and the IP) it came from.
+func (srv *WSServer) handleSealedMessage(connection *ws.Connection, msg ws.WSMessage) {
+	var envelope sealed.Envelope
+	if err := json.Unmarshal(msg.Value, &envelope); err != nil {
+		log.Printf("Could not unmarshal sealed message: %s\n", err.Error())
+		return
+	}
+
+	recipient, ok := srv.prekeys.Recipient(envelope.DeliveryToken)
+	if !ok {
+		srv.sendError(connection, "Sealed message rejected: unknown delivery token.")
+		return
+	}
+
+	if c, ok := srv.getConnection(clientId(recipient)); ok {
+		log.Printf("Delivering sealed message to %s\n", recipient)
+		srv.sendJSONMessage(c, types.MessageTypeSealedMessage, envelope)
+		srv.ackMessage(connection, msg.ID)
+		return
+	}
+
+	if !srv.sealedMailbox.push(recipient, envelope) {
+		srv.sendError(connection, "The mailbox of the recipient is full.")
+		return
+	}
+
+	log.Printf("Stored sealed message to offline device %s\n", recipient)
+	srv.ackMessage(connection, msg.ID)
+}
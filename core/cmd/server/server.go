@@ -2,34 +2,91 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"log"
 	"net/http"
 	"slices"
 	"sync"
-
+	"sync/atomic"
+	"time"
+
+	"github.com/Guilospanck/pqc/core/pkg/chat"
+	"github.com/Guilospanck/pqc/core/pkg/cryptography"
+	"github.com/Guilospanck/pqc/core/pkg/devices"
+	"github.com/Guilospanck/pqc/core/pkg/group"
+	"github.com/Guilospanck/pqc/core/pkg/pqxdh"
+	"github.com/Guilospanck/pqc/core/pkg/sealed"
+	"github.com/Guilospanck/pqc/core/pkg/transparency"
 	"github.com/Guilospanck/pqc/core/pkg/types"
+	"github.com/Guilospanck/pqc/core/pkg/users"
 	"github.com/Guilospanck/pqc/core/pkg/ws"
 
 	"github.com/gorilla/websocket"
 )
 
+// Address of a device: a user can be connected from several of them
 type clientId string
 
 type WSServer struct {
 	// TODO: create concept of rooms
-	connections   map[clientId]*ws.Connection
-	usedUsernames []string
-	mu            sync.RWMutex
-	ctx           context.Context
+	connections     map[clientId]*ws.Connection
+	accounts        map[string]*account
+	renamed         map[string]renamedUser // usernames recently given up
+	profileChanges  map[clientId]string    // devices disconnected to come back under a new username
+	reconnectTokens map[clientId]*reconnectToken
+	usernameGrace   time.Duration
+	sessionPolicy   sessionPolicy              // when a device connects while it is still connected
+	users           *users.Store               // registered users
+	allowGuests     bool                       // users that are not registered can chat
+	admission       admission                  // who can come in a private server
+	pendingDevices  map[clientId]pendingDevice // devices waiting for approval
+	approvalsByUser *rateLimiter               // approval requests for each username
+	approvalsByIP   *rateLimiter               // and from each IP
+	accountsByIP    *rateLimiter               // logins and registrations from each IP
+	keyLog          *transparency.Log
+	prekeys         *pqxdh.Directory
+	mailbox         *mailbox[pqxdh.InitialMessage]
+	sealedMailbox   *mailbox[sealed.Envelope]
+	groups          map[string]*roomGroup
+	rooms           map[string]*roomACL // who runs each room, and who is kept out
+	history         *serverHistory
+	acked           *ackedMessages // who each message went to, for the receipts
+	started         time.Time
+	opened          atomic.Int64 // connections opened since the server started
+	relayed         atomic.Int64 // messages sent by the clients since the server started
+	draining        atomic.Bool  // new connections are refused
+	stopping        atomic.Bool  // the server is shutting down
+	mu              sync.RWMutex
+	ctx             context.Context
 }
 
-func NewServer(ctx context.Context) *WSServer {
+func NewServer(ctx context.Context, history *serverHistory, userStore *users.Store, keyLog *transparency.Log) *WSServer {
 	return &WSServer{
-		connections:   make(map[clientId]*ws.Connection),
-		ctx:           ctx,
-		usedUsernames: make([]string, 0),
+		connections:     make(map[clientId]*ws.Connection),
+		ctx:             ctx,
+		accounts:        make(map[string]*account),
+		renamed:         make(map[string]renamedUser),
+		profileChanges:  make(map[clientId]string),
+		reconnectTokens: make(map[clientId]*reconnectToken),
+		usernameGrace:   USERNAME_GRACE_PERIOD,
+		sessionPolicy:   DUPLICATE_SESSION_POLICY,
+		users:           userStore,
+		allowGuests:     true,
+		pendingDevices:  make(map[clientId]pendingDevice),
+		approvalsByUser: newRateLimiter(DEVICE_APPROVAL_RATE, DEVICE_APPROVAL_RATE_WINDOW),
+		approvalsByIP:   newRateLimiter(DEVICE_APPROVAL_RATE, DEVICE_APPROVAL_RATE_WINDOW),
+		accountsByIP:    newRateLimiter(ACCOUNT_ATTEMPT_RATE, ACCOUNT_ATTEMPT_RATE_WINDOW),
+		keyLog:          keyLog,
+		prekeys:         pqxdh.NewDirectory(),
+		mailbox:         newMailbox[pqxdh.InitialMessage](),
+		sealedMailbox:   newMailbox[sealed.Envelope](),
+		groups:          map[string]*roomGroup{group.DefaultGroupID: newRoomGroup(group.DefaultGroupID)},
+		rooms:           map[string]*roomACL{group.DefaultGroupID: newRoomACL(nil)},
+		history:         history,
+		acked:           newAckedMessages(),
+		started:         time.Now(),
 	}
 }
 
@@ -37,7 +94,7 @@ func (srv *WSServer) addConnection(connection *ws.Connection) {
 	srv.mu.Lock()
 	defer srv.mu.Unlock()
 
-	srv.connections[clientId(connection.Metadata.Username)] = connection
+	srv.connections[connectionId(connection)] = connection
 }
 
 func (srv *WSServer) removeConnection(id clientId) {
@@ -47,6 +104,14 @@ func (srv *WSServer) removeConnection(id clientId) {
 	delete(srv.connections, id)
 }
 
+func (srv *WSServer) getConnection(id clientId) (*ws.Connection, bool) {
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+
+	connection, ok := srv.connections[id]
+	return connection, ok
+}
+
 func (srv *WSServer) currentConnections() []ws.Connection {
 	srv.mu.RLock()
 	defer srv.mu.RUnlock()
@@ -60,25 +125,30 @@ func (srv *WSServer) currentConnections() []ws.Connection {
 	return connections
 }
 
-func (srv *WSServer) getRandomUsername() string {
-	generatedUsername := ""
-
-	for {
-		generatedUsername = GetRandomName()
-		if !slices.Contains(srv.usedUsernames, generatedUsername) {
-			break
+// Serves wss:// if `tlsConfig` is set, ws:// otherwise, until the returned
+// server is shut down
+func (srv *WSServer) startServer(tlsConfig *tls.Config) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", srv.wsHandler)
+
+	server := &http.Server{Addr: ":8080", Handler: mux, TLSConfig: tlsConfig}
+
+	go func() {
+		var err error
+		if tlsConfig == nil {
+			log.Print("WS server started at ws://localhost:8080/ws")
+			err = server.ListenAndServe()
+		} else {
+			log.Print("WS server started at wss://localhost:8080/ws")
+			err = server.ListenAndServeTLS("", "")
 		}
-	}
 
-	srv.usedUsernames = append(srv.usedUsernames, generatedUsername)
-	return generatedUsername
-}
-
-func (srv *WSServer) startServer() {
-	http.HandleFunc("/ws", srv.wsHandler)
+		if !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
 
-	log.Print("WS server started at localhost:8080/ws")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	return server
 }
 
 var upgrader = websocket.Upgrader{
@@ -94,22 +164,107 @@ func (srv *WSServer) wsHandler(w http.ResponseWriter, r *http.Request) {
 	// If a client is reconnecting,
 	// then it will send what was its last known name and color.
 	// Also their keys, for that matter.
+	// Every client also tells which of the user's devices it is.
 	headers := r.Header
-	username := headers.Get("username")
-	color := headers.Get("color")
-	if username == "" || color == "" {
-		username = srv.getRandomUsername()
-		color = GetRandomColor()
+
+	if srv.draining.Load() {
+		http.Error(w, "This server is not accepting new connections, try again later.", http.StatusServiceUnavailable)
+		return
+	}
+
+	// Both sides must use the same algorithms
+	if (headers.Get("fips") == "on") != cryptography.FIPS() {
+		reason := "This server is not in FIPS mode."
+		if cryptography.FIPS() {
+			reason = "This server only accepts clients in FIPS mode."
+		}
+		http.Error(w, reason, http.StatusForbidden)
+		return
+	}
+
+	username, color, device, needsApproval := srv.resolveAccount(headers.Get("username"), headers.Get("color"), headers.Get("device"), headers.Get("reconnect-token"))
+
+	// Banned users, and the ones not invited to an invite-only room, stay out
+	if reason, refused := srv.roomRefusal(group.DefaultGroupID, username, remoteIP(r.RemoteAddr)); refused {
+		log.Printf("%s (device %s) refused: %s\n", username, device, reason)
+		http.Error(w, reason, http.StatusForbidden)
+		return
+	}
+
+	if srv.hasSession(username, device) {
+		switch srv.sessionPolicy {
+		case sessionReject:
+			log.Printf("Device %s of %s is already connected, refusing the new session\n", device, username)
+			http.Error(w, "This device is already connected from somewhere else.", http.StatusConflict)
+			return
+		case sessionDevice:
+			// It showed the reconnect token of the device, no need to approve it
+			previous := device
+			device = srv.addSessionDevice(username)
+			log.Printf("Device %s of %s is already connected, adding the new session as device %s\n", previous, username, device)
+		}
 	}
 
 	connection.Metadata.Username = username
 	connection.Metadata.Color = color
+	connection.Metadata.Device = device
+
+	// A device the user never used before must be approved by another one.
+	// Registered users log in from it instead: their password says who they are.
+	loginInstead := needsApproval && srv.registered(username)
+	needsApproval = needsApproval && !loginInstead
+
+	// Private servers check who comes in before anything else, devices
+	// waiting for approval included
+	admitted := true
+	if srv.private() && !srv.reclaimsIdentity(username, device, headers.Get("reconnect-token")) {
+		invited, err := srv.admitInvite(headers.Get("invite"))
+		if err != nil {
+			log.Printf("Invite of %s (device %s) refused: %s\n", username, device, err.Error())
+			http.Error(w, "Invalid, used up or expired invite.", http.StatusForbidden)
+			return
+		}
+		if invited {
+			log.Printf("%s (device %s) came in with an invite\n", username, device)
+		}
+		admitted = invited
+	}
 
-	// Send the generated username and color to the WSClient
+	if needsApproval {
+		if !admitted {
+			log.Printf("Device %s of %s refused: not admitted\n", device, username)
+			http.Error(w, "This server is private, a new device needs an invite to be approved.", http.StatusForbidden)
+			return
+		}
+
+		if status, reason := srv.waitForDeviceApproval(username, device, remoteIP(r.RemoteAddr)); status != http.StatusOK {
+			log.Printf("Device %s of %s refused: %s\n", device, username, reason)
+			http.Error(w, reason, status)
+			return
+		}
+	}
+
+	loginRequired := loginInstead || !admitted || (!srv.allowGuests && !srv.registered(username))
+
+	// Send the generated username, color and device to the WSClient
 	// INFO: it needs to be *before* the upgrade
 	responseHeader := http.Header{}
 	responseHeader.Set("username", username)
 	responseHeader.Set("color", color)
+	responseHeader.Set("device", device)
+	if cryptography.FIPS() {
+		responseHeader.Set("fips", "on")
+	}
+	if loginRequired {
+		responseHeader.Set("login-required", "on")
+	}
+	// Clients then send us a readable copy of their group messages
+	if srv.history.keeps() {
+		responseHeader.Set("history", "on")
+	}
+	if !admitted {
+		responseHeader.Set("private", "on")
+	}
 
 	conn, err := upgrader.Upgrade(w, r, responseHeader)
 
@@ -120,20 +275,50 @@ func (srv *WSServer) wsHandler(w http.ResponseWriter, r *http.Request) {
 	defer conn.Close()
 
 	connection.Conn = conn
+	connection.Since = time.Now()
+
+	if loginRequired {
+		go connection.WriteLoop(srv.ctx)
+		<-connection.WriteLoopReady
+
+		srv.readLoginMessages(&connection, admitted)
+		return
+	}
+
+	firstDevice := len(srv.userConnections(username)) == 0
+	if old, ok := srv.getConnection(connectionId(&connection)); ok {
+		srv.replaceSession(old)
+	}
+	if firstDevice {
+		srv.setPresence(username, types.Presence{Username: username, Status: types.PresenceOnline})
+	}
 	srv.addConnection(&connection)
+	srv.opened.Add(1)
 
-	log.Printf("New connection: %s - %s\n", username, color)
+	log.Printf("New connection: %s (device %s) - %s\n", username, device, color)
 
 	// Start write loop
 	go connection.WriteLoop(srv.ctx)
 
 	<-connection.WriteLoopReady
 
+	// For the device to prove who it is when it connects again
+	srv.sendReconnectToken(&connection)
+
 	// Update this newly connected user with info regarding all connected users
 	srv.informUserOfAllCurrentUsers(&connection)
 
-	// Send to other clients the event of a newly connected client
-	srv.fanOutUserEnteredChat(username, color)
+	// Topic, roles and mutes of the rooms
+	srv.informUserOfRooms(&connection)
+
+	// Send the key log tree head and the keys of everyone already connected
+	srv.informUserOfKeyLog(&connection)
+
+	// Send to other clients the event of a newly connected client.
+	// Other devices of a user that is already online don't count.
+	if firstDevice {
+		srv.fanOutUserEnteredChat(username, color)
+	}
 
 	// Start read loop
 	srv.readAndHandleClientMessages(&connection)
@@ -154,42 +339,140 @@ func (srv *WSServer) readAndHandleClientMessages(connection *ws.Connection) {
 			continue
 		}
 
-		decryptedMessageSent := connection.HandleClientMessage(msgJson)
+		srv.handleClientMessage(connection, msgJson)
+	}
+}
+
+func (srv *WSServer) handleClientMessage(connection *ws.Connection, msg ws.WSMessage) {
+	switch msg.Type {
+	case types.MessageTypeKeyLogConsistency:
+		srv.handleConsistencyRequest(connection, msg)
 
-		if msgJson.Type != types.MessageTypeEncryptedMessage || decryptedMessageSent == nil {
-			continue
+	case types.MessageTypePrekeyUpload:
+		srv.handlePrekeyUpload(connection, msg)
+
+	case types.MessageTypePrekeyBundle:
+		srv.handlePrekeyBundleRequest(connection, msg)
+
+	case types.MessageTypePrekeyMessage:
+		srv.handlePrekeyMessage(connection, msg)
+
+	case types.MessageTypeSealedMessage:
+		srv.handleSealedMessage(connection, msg)
+
+	case types.MessageTypeGroupKeyPackage:
+		srv.handleGroupKeyPackage(connection, msg)
+
+	case types.MessageTypeGroupCommit:
+		srv.handleGroupCommit(connection, msg)
+
+	case types.MessageTypeGroupMessage:
+		srv.handleGroupMessage(connection, msg)
+
+	case types.MessageTypeHistoryCopy:
+		srv.handleHistoryCopy(connection, msg)
+
+	case types.MessageTypeMessageReceipt:
+		srv.handleMessageReceipt(connection, msg)
+
+	case types.MessageTypeAccountRegister, types.MessageTypeAccountLogin:
+		srv.handleAccountMessage(connection, msg, true)
+
+	case types.MessageTypeModerate:
+		srv.handleModerate(connection, msg)
+
+	case types.MessageTypeProfileChange:
+		srv.handleProfileChange(connection, msg)
+
+	case types.MessageTypePresence:
+		srv.handlePresence(connection, msg)
+
+	case types.MessageTypeTyping:
+		srv.handleTyping(connection, msg)
+
+	case types.MessageTypeDeviceApproval:
+		srv.handleDeviceApproval(connection, msg)
+
+	case types.MessageTypeDeviceList:
+		srv.handleDeviceList(connection)
+
+	case types.MessageTypeDeviceRemove:
+		srv.handleDeviceRemove(connection, msg)
+
+	case types.MessageTypeSASRequest,
+		types.MessageTypeSASAccept,
+		types.MessageTypeSASReveal,
+		types.MessageTypeSASConfirm,
+		types.MessageTypeSASCancel:
+		srv.handleSASMessage(connection, msg)
+
+	case types.MessageTypeExchangeKeys:
+		connection.HandleClientMessage(msg)
+
+		// Now we can relay the messages it missed
+		srv.replayHistory(connection)
+
+	case types.MessageTypeEncryptedMessage:
+		decryptedMessageSent := connection.HandleClientMessage(msg)
+		if decryptedMessageSent == nil {
+			return
 		}
 
 		srv.fanOutUserMessage(connection, decryptedMessageSent)
+
+	default:
+		connection.HandleClientMessage(msg)
 	}
 }
 
-// Remove client from connections and broadcast user left event
+// Remove client from connections and, once the last device
+// of the user is gone, broadcast user left event
 func (srv *WSServer) userDisconnected(connection *ws.Connection) {
-	connections := srv.currentConnections()
+	// The device might have reconnected already
+	id := connectionId(connection)
+	if current, ok := srv.getConnection(id); !ok || current != connection {
+		return
+	}
 
-	for _, v := range connections {
-		if v.Metadata.Username != connection.Metadata.Username {
-			continue
-		}
+	srv.removeConnection(id)
 
-		srv.removeConnection(clientId(v.Metadata.Username))
+	// Everyone is leaving, nobody needs to be told
+	if srv.stopping.Load() {
+		return
+	}
 
-		// Broadcast user left event to other clients
-		leftMsg := ws.WSMessage{
-			Type:     types.MessageTypeUserLeftChat,
-			Value:    nil,
-			Nonce:    nil,
-			Metadata: ws.WSMetadata{Username: connection.Metadata.Username, Color: connection.Metadata.Color},
-		}
-		leftJsonMsg := leftMsg.Marshal()
-		for _, c := range srv.currentConnections() {
-			if err := c.WriteMessage(string(leftJsonMsg), websocket.TextMessage); err != nil {
-				log.Printf("Error trying to inform clients that user left: %s\n", err.Error())
-			}
-		}
+	srv.groupMemberLeft(string(id))
+
+	// After a change of profile the user comes back (maybe under a new
+	// username), the others know already
+	username, changed := srv.takeProfileChange(id)
+	if !changed {
+		username = connection.Metadata.Username
+	}
+	srv.historyDeviceLeft(clientId(devices.Address(username, connection.Metadata.Device)))
+	srv.refreshReconnectToken(clientId(devices.Address(username, connection.Metadata.Device)))
 
-		break
+	if len(srv.userConnections(connection.Metadata.Username)) > 0 {
+		return
+	}
+
+	srv.userLastSeen(username)
+	if changed {
+		return
+	}
+
+	// Broadcast user left event to other clients
+	leftMsg := ws.WSMessage{
+		Type:     types.MessageTypeUserLeftChat,
+		Value:    nil,
+		Nonce:    nil,
+		Metadata: ws.WSMetadata{Username: connection.Metadata.Username, Color: connection.Metadata.Color},
+	}
+	leftJsonMsg := leftMsg.Marshal()
+	for _, c := range srv.currentConnections() {
+		if err := c.WriteMessage(string(leftJsonMsg), websocket.TextMessage); err != nil {
+			log.Printf("Error trying to inform clients that user left: %s\n", err.Error())
+		}
 	}
 }
 
@@ -198,7 +481,12 @@ func (srv *WSServer) informUserOfAllCurrentUsers(newUser *ws.Connection) {
 	users := make([]ws.WSMetadata, 0, len(connections))
 
 	for _, c := range connections {
-		users = append(users, ws.WSMetadata{Username: c.Metadata.Username, Color: c.Metadata.Color})
+		if slices.ContainsFunc(users, func(user ws.WSMetadata) bool { return user.Username == c.Metadata.Username }) {
+			continue
+		}
+
+		presence := srv.userPresence(c.Metadata.Username)
+		users = append(users, ws.WSMetadata{Username: c.Metadata.Username, Color: c.Metadata.Color, Presence: &presence})
 	}
 
 	marshalledUsers, err := json.Marshal(users)
@@ -238,16 +526,96 @@ func (srv *WSServer) fanOutUserEnteredChat(username, color string) {
 }
 
 func (srv *WSServer) fanOutUserMessage(client *ws.Connection, decryptedMessage []byte) {
+	payload, err := chat.Parse(decryptedMessage)
+	if err != nil {
+		log.Printf("Could not read message from %s: %s\n", client.Metadata.Username, err.Error())
+		srv.sendError(client, "Message not sent: "+err.Error())
+		return
+	}
+
+	// We know who sent it, whatever the payload says
+	payload.Sender = client.Metadata.Username
+	srv.relayed.Add(1)
+
+	marshalled, err := chat.Marshal(payload)
+	if err != nil {
+		log.Printf("Could not marshal message from %s: %s\n", client.Metadata.Username, err.Error())
+		return
+	}
+
+	// Direct messages go end-to-end, as prekey messages: we never read them
+	if payload.Recipient != "" {
+		log.Printf("Refused direct message from %s that was not sent end-to-end\n", client.Metadata.Username)
+		srv.sendError(client, "Direct messages must be sent end-to-end, update your client.")
+		return
+	}
+
+	// Older clients don't say which room, there is only one
+	if payload.Room == "" {
+		payload.Room = group.DefaultGroupID
+	}
+
+	// Reactions are not talking
+	if (payload.Action == "" || payload.Action == types.ChatActionEdit) && srv.refuseMuted(client, payload.Room) {
+		return
+	}
+
+	if payload.Action != "" {
+		if err := srv.applyChatAction(client, payload); err != nil {
+			srv.sendError(client, "Not changed: "+err.Error()+".")
+			return
+		}
+	} else {
+		srv.recordMessage(client, payload)
+	}
+
 	connections := srv.currentConnections()
+	recipients := make([]string, 0, len(connections))
 
 	for _, c := range connections {
 		if string(c.Keys.Public) == string(client.Keys.Public) {
 			continue
 		}
 
-		msgWithPublicKey := fmt.Sprintf("%s: %s", client.Metadata.Username, string(decryptedMessage))
+		c.RelayMessage(string(marshalled), client.Metadata.Username, client.Metadata.Color)
+		recipients = append(recipients, c.Metadata.Username)
+	}
+
+	srv.ackMessage(client, payload.ID, recipients...)
+}
+
+// Sends `value`, marshalled as JSON, in a message of type `msgType`
+func (srv *WSServer) sendJSONMessage(connection *ws.Connection, msgType types.MessageType, value any) {
+	marshalled, err := json.Marshal(value)
+	if err != nil {
+		log.Printf("Could not marshal %s message: %s\n", msgType, err.Error())
+		return
+	}
+
+	msg := ws.WSMessage{
+		Type:     msgType,
+		Value:    marshalled,
+		Nonce:    nil,
+		Metadata: ws.WSMetadata{Username: connection.Metadata.Username, Color: connection.Metadata.Color},
+	}
+	jsonMsg := msg.Marshal()
+
+	if err := connection.WriteMessage(string(jsonMsg), websocket.TextMessage); err != nil {
+		log.Printf("Error trying to send %s message to %s: %s\n", msgType, connection.Metadata.Username, err.Error())
+	}
+}
+
+// Sends an error the client will show to the user
+func (srv *WSServer) sendError(connection *ws.Connection, text string) {
+	msg := ws.WSMessage{
+		Type:     types.MessageTypeError,
+		Value:    []byte(text),
+		Nonce:    nil,
+		Metadata: ws.WSMetadata{Username: connection.Metadata.Username, Color: connection.Metadata.Color},
+	}
+	jsonMsg := msg.Marshal()
 
-		log.Printf("Relaying message: \"%s\" from \"%s\" to client \"%s\"\n", msgWithPublicKey, client.Metadata.Username, c.Metadata.Username)
-		c.RelayMessage(msgWithPublicKey, client.Metadata.Username, client.Metadata.Color)
+	if err := connection.WriteMessage(string(jsonMsg), websocket.TextMessage); err != nil {
+		log.Printf("Error trying to send error message to %s: %s\n", connection.Metadata.Username, err.Error())
 	}
 }
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Guilospanck/pqc/core/pkg/devices"
+	"github.com/Guilospanck/pqc/core/pkg/ws"
+)
+
+// What happens when a device connects while the server still has a session
+// for it, e.g. because its network went away without the server noticing
+type sessionPolicy string
+
+const (
+	sessionKick   sessionPolicy = "kick"   // the new session replaces the old one
+	sessionReject sessionPolicy = "reject" // the old session stays and the new one is refused
+	sessionDevice sessionPolicy = "device" // both stay, the new one as another device of the user
+)
+
+func parseSessionPolicy(policy string) (sessionPolicy, error) {
+	switch sessionPolicy(policy) {
+	case sessionKick, sessionReject, sessionDevice:
+		return sessionPolicy(policy), nil
+	}
+
+	return "", fmt.Errorf("unknown duplicate session policy %q (kick, reject or device)", policy)
+}
+
+// Whether `device` of `username` has a session already
+func (srv *WSServer) hasSession(username, device string) bool {
+	_, ok := srv.getConnection(clientId(devices.Address(username, device)))
+	return ok
+}
+
+// Approves a new device for `username`, for a session that can't use the
+// device id it asked for
+func (srv *WSServer) addSessionDevice(username string) string {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	device := devices.NewID()
+	if acc, ok := srv.accounts[username]; ok {
+		acc.devices = append(acc.devices, device)
+		acc.lastSeen = time.Now()
+	}
+
+	return device
+}
+
+// Ends the session of `connection` because its device connected again.
+// The user stays online, so the others are not told it left.
+func (srv *WSServer) replaceSession(connection *ws.Connection) {
+	id := connectionId(connection)
+
+	srv.removeConnection(id)
+	srv.groupMemberLeft(string(id))
+	srv.historyDeviceLeft(id)
+
+	log.Printf("Session of %s (device %s) replaced by a new one\n", connection.Metadata.Username, connection.Metadata.Device)
+	connection.Close(ws.CloseSessionReplaced, "This device connected again from somewhere else.")
+}
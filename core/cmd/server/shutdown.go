@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Guilospanck/pqc/core/pkg/types"
+	"github.com/Guilospanck/pqc/core/pkg/ws"
+
+	"github.com/gorilla/websocket"
+)
+
+// On SIGINT or SIGTERM the server stops accepting connections, tells every
+// client it is going away (and when to come back, so they don't all connect
+// again at once) and closes their connections properly, then exits once
+// they are closed or after the shutdown timeout.
+
+// Tells `connections` the server is going away and sends them a close message.
+// Clients wait `retryAfter` (and a bit more) before connecting again.
+func (srv *WSServer) goAway(connections []ws.Connection, reason string, retryAfter time.Duration) {
+	goingAway := types.GoingAway{Reason: reason, RetryAfter: int64(retryAfter / time.Second)}
+
+	for _, c := range connections {
+		srv.sendJSONMessage(&c, types.MessageTypeGoingAway, goingAway)
+		c.SendClose(websocket.CloseGoingAway, reason)
+	}
+}
+
+// Stops the server, stopping `servers` from accepting connections. `stop`
+// cancels the context of the server, which ends the write loops.
+func (srv *WSServer) shutdown(servers []*http.Server, stop context.CancelFunc, retryAfter, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	srv.draining.Store(true)
+	srv.stopping.Store(true)
+
+	// Requests still being handled (e.g. devices waiting for approval) are
+	// refused once they are done
+	var listeners sync.WaitGroup
+	for _, server := range servers {
+		listeners.Go(func() {
+			if err := server.Shutdown(ctx); err != nil {
+				log.Printf("Could not stop listening on %s: %s\n", server.Addr, err.Error())
+			}
+		})
+	}
+
+	connections := srv.currentConnections()
+	log.Printf("Closing %d connections\n", len(connections))
+	srv.goAway(connections, "The server is shutting down.", retryAfter)
+
+	// Clients answer with their own close message, which ends their session
+	ticker := time.NewTicker(SHUTDOWN_POLL_PERIOD)
+	defer ticker.Stop()
+
+wait:
+	for len(srv.currentConnections()) > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			log.Printf("%d connections did not close in time\n", len(srv.currentConnections()))
+			break wait
+		}
+	}
+
+	stop()
+	for _, c := range connections {
+		select {
+		case <-c.WriteLoopClosed:
+		case <-ctx.Done():
+		}
+		c.Conn.Close()
+	}
+
+	listeners.Wait()
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/Guilospanck/pqc/core/pkg/transparency"
+	"github.com/Guilospanck/pqc/core/pkg/types"
+	"github.com/Guilospanck/pqc/core/pkg/ws"
+)
+
+// Opens the key log kept in `file` (in memory if empty), signed with the key
+// saved in `keyFile`. A log in memory gets a new signing key every time.
+func openKeyLog(file, keyFile string) (*transparency.Log, error) {
+	var backend transparency.Backend = transparency.NewMemoryBackend()
+	if file == "" {
+		keyFile = ""
+	} else {
+		backend = transparency.NewFileBackend(file)
+	}
+
+	signingKey, err := transparency.LoadSigningKey(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return transparency.NewLog(signingKey, backend)
+}
+
+// Appends the binding of a connection that just proved it owns its identity
+// keys and broadcasts it, with its inclusion proof, to everyone.
+func (srv *WSServer) publishKey(binding transparency.Binding) {
+	// Reconnecting clients keep their keys, so there is no need to log them again
+	index, alreadyPublished := srv.keyLogIndex(binding)
+	if !alreadyPublished {
+		var err error
+		index, err = srv.keyLog.Append(binding)
+		if err != nil {
+			log.Printf("Could not publish key of %s (device %s): %s\n", binding.Username, binding.Device, err.Error())
+			return
+		}
+		log.Printf("Published key of %s (device %s) at index %d of the key log\n", binding.Username, binding.Device, index)
+	}
+
+	for _, c := range srv.currentConnections() {
+		srv.sendKeyPublication(&c, binding, index)
+	}
+}
+
+func (srv *WSServer) keyLogIndex(binding transparency.Binding) (uint64, bool) {
+	published, index, ok := srv.keyLog.Lookup(binding.Username, binding.Device)
+	if !ok || !published.Equal(binding) {
+		return 0, false
+	}
+
+	return index, true
+}
+
+// Sends to a newly connected device the current tree head and the bindings
+// of every other device already connected, so it can learn their keys.
+func (srv *WSServer) informUserOfKeyLog(newUser *ws.Connection) {
+	announcement := transparency.TreeHeadAnnouncement{
+		TreeHead: srv.keyLog.SignedTreeHead(),
+		LogKey:   srv.keyLog.PublicKey(),
+	}
+	srv.sendJSONMessage(newUser, types.MessageTypeKeyLogTreeHead, announcement)
+
+	for _, c := range srv.currentConnections() {
+		if connectionId(&c) == connectionId(newUser) {
+			continue
+		}
+
+		binding, index, ok := srv.keyLog.Lookup(c.Metadata.Username, c.Metadata.Device)
+		if !ok {
+			continue
+		}
+		srv.sendKeyPublication(newUser, binding, index)
+	}
+}
+
+func (srv *WSServer) sendKeyPublication(connection *ws.Connection, binding transparency.Binding, index uint64) {
+	publication, err := srv.keyPublication(binding, index)
+	if err != nil {
+		log.Printf("Could not create inclusion proof for %s: %s\n", binding.Username, err.Error())
+		return
+	}
+
+	srv.sendJSONMessage(connection, types.MessageTypeKeyPublished, publication)
+}
+
+func (srv *WSServer) keyPublication(binding transparency.Binding, index uint64) (transparency.KeyPublication, error) {
+	proof, treeHead, err := srv.keyLog.InclusionProof(index)
+	if err != nil {
+		return transparency.KeyPublication{}, err
+	}
+
+	return transparency.KeyPublication{
+		Binding:  binding,
+		Index:    index,
+		Proof:    proof,
+		TreeHead: treeHead,
+		LogKey:   srv.keyLog.PublicKey(),
+	}, nil
+}
+
+// Sends the latest publication of the `device` of `username`, so that
+// clients can verify keys of devices they never saw online.
+func (srv *WSServer) sendLatestKeyPublication(connection *ws.Connection, username, device string) error {
+	binding, index, ok := srv.keyLog.Lookup(username, device)
+	if !ok {
+		return fmt.Errorf("no key published for %s (device %s)", username, device)
+	}
+
+	publication, err := srv.keyPublication(binding, index)
+	if err != nil {
+		return err
+	}
+
+	srv.sendJSONMessage(connection, types.MessageTypeKeyPublished, publication)
+	return nil
+}
+
+func (srv *WSServer) handleConsistencyRequest(connection *ws.Connection, msg ws.WSMessage) {
+	var check transparency.ConsistencyCheck
+	if err := json.Unmarshal(msg.Value, &check); err != nil {
+		log.Printf("Could not unmarshal consistency request from %s: %s\n", connection.Metadata.Username, err.Error())
+		return
+	}
+
+	proof, err := srv.keyLog.ConsistencyProof(check.First, check.Second)
+	if err != nil {
+		log.Printf("Could not create consistency proof for %s: %s\n", connection.Metadata.Username, err.Error())
+		return
+	}
+	check.Proof = proof
+
+	srv.sendJSONMessage(connection, types.MessageTypeKeyLogConsistency, check)
+}
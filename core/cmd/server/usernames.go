@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Guilospanck/pqc/core/pkg/devices"
+	"github.com/Guilospanck/pqc/core/pkg/types"
+	"github.com/Guilospanck/pqc/core/pkg/ws"
+)
+
+// Usernames belong to an account while one of its devices is online, and
+// for `usernameGrace` after the last one left (so it can reconnect). A
+// username given up with /nick is kept for as long, so nobody can pretend
+// to be its former owner right away.
+
+// A username given up for `to`
+type renamedUser struct {
+	to string
+	at time.Time
+}
+
+var colorPattern = regexp.MustCompile(`^#[0-9A-Fa-f]{6}$`)
+
+func validUsername(username string) error {
+	length := utf8.RuneCountInString(username)
+	if length < MIN_USERNAME_LENGTH || length > MAX_USERNAME_LENGTH || username != strings.TrimSpace(username) {
+		return fmt.Errorf("usernames have %d to %d characters, without spaces around them", MIN_USERNAME_LENGTH, MAX_USERNAME_LENGTH)
+	}
+
+	// `#` separates usernames from devices and `"` quotes usernames in commands
+	if strings.ContainsAny(username, "#\"") || strings.HasPrefix(username, "/") {
+		return errors.New("usernames can't contain # or \", nor start with /")
+	}
+
+	for _, r := range username {
+		if !unicode.IsPrint(r) {
+			return errors.New("usernames can't contain control characters")
+		}
+	}
+
+	return nil
+}
+
+// Whether `username` (whatever its case) belongs to an account other than
+// `except`, is registered or was given up recently. Must be called with
+// srv.mu held.
+func (srv *WSServer) usernameTaken(username, except string) bool {
+	if !strings.EqualFold(username, except) && srv.registered(username) {
+		return true
+	}
+
+	for name := range srv.accounts {
+		if name != except && strings.EqualFold(name, username) {
+			return true
+		}
+	}
+
+	for name, renamed := range srv.renamed {
+		if renamed.to != except && strings.EqualFold(name, username) && time.Since(renamed.at) < srv.usernameGrace {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Whether the device `id` was disconnected by a change of profile, and
+// the username it comes back with
+func (srv *WSServer) takeProfileChange(id clientId) (string, bool) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	username, ok := srv.profileChanges[id]
+	delete(srv.profileChanges, id)
+	return username, ok
+}
+
+// Random usernames combine the words of two names ("Amazing" + "Falcon").
+// If we keep drawing taken ones a number is added, so we never run out.
+// Must be called with srv.mu held.
+func (srv *WSServer) getRandomUsername() string {
+	for range RANDOM_USERNAME_ATTEMPTS {
+		if username := GetRandomName(); !srv.usernameTaken(username, "") {
+			return username
+		}
+	}
+
+	base := GetRandomName()
+	for i := 2; ; i++ {
+		if username := fmt.Sprintf("%s %d", base, i); !srv.usernameTaken(username, "") {
+			return username
+		}
+	}
+}
+
+// A user asked for a new username (/nick) or color (/color). Everyone is
+// told, then its devices are disconnected to connect again as the new user.
+func (srv *WSServer) handleProfileChange(connection *ws.Connection, msg ws.WSMessage) {
+	username := connection.Metadata.Username
+
+	var change types.ProfileChange
+	if err := json.Unmarshal(msg.Value, &change); err != nil {
+		log.Printf("Could not unmarshal profile change from %s: %s\n", username, err.Error())
+		return
+	}
+
+	renamed := types.UserRenamed{Username: username, NewUsername: username}
+	if change.Username != "" && change.Username != username && srv.registered(username) {
+		srv.sendError(connection, "Username not changed: registered usernames can't be changed.")
+		return
+	}
+	if change.Username != "" {
+		if err := validUsername(change.Username); err != nil {
+			srv.sendError(connection, "Username not changed: "+err.Error()+".")
+			return
+		}
+		renamed.NewUsername = change.Username
+	}
+
+	if change.Color != "" && !colorPattern.MatchString(change.Color) {
+		srv.sendError(connection, "Color not changed: colors are written #rrggbb.")
+		return
+	}
+
+	srv.mu.Lock()
+	acc, ok := srv.accounts[username]
+	if !ok {
+		srv.mu.Unlock()
+		return
+	}
+
+	if renamed.NewUsername != username {
+		if srv.usernameTaken(renamed.NewUsername, username) {
+			srv.mu.Unlock()
+			srv.sendError(connection, fmt.Sprintf("%s is already taken.", renamed.NewUsername))
+			return
+		}
+
+		delete(srv.accounts, username)
+		srv.accounts[renamed.NewUsername] = acc
+		for _, device := range acc.devices {
+			from, to := clientId(devices.Address(username, device)), clientId(devices.Address(renamed.NewUsername, device))
+			if token, ok := srv.reconnectTokens[from]; ok {
+				srv.reconnectTokens[to] = token
+				delete(srv.reconnectTokens, from)
+			}
+		}
+		srv.renamed[username] = renamedUser{to: renamed.NewUsername, at: time.Now()}
+		// It may be taking back a username it gave up
+		delete(srv.renamed, renamed.NewUsername)
+	}
+
+	if change.Color != "" {
+		acc.color = change.Color
+	}
+	acc.lastSeen = time.Now()
+	renamed.Color = acc.color
+	userDevices := slices.Clone(acc.devices)
+	srv.mu.Unlock()
+
+	if change.Color != "" && srv.registered(username) {
+		if err := srv.users.SetColor(username, change.Color); err != nil {
+			log.Printf("Could not save the color of %s: %s\n", username, err.Error())
+		}
+	}
+
+	// Messages waiting for its offline devices follow it
+	for _, device := range userDevices {
+		srv.mailbox.move(devices.Address(username, device), devices.Address(renamed.NewUsername, device))
+		srv.sealedMailbox.move(devices.Address(username, device), devices.Address(renamed.NewUsername, device))
+	}
+
+	log.Printf("%s is now %s (%s)\n", username, renamed.NewUsername, renamed.Color)
+
+	for _, c := range srv.currentConnections() {
+		srv.sendJSONMessage(&c, types.MessageTypeUserRenamed, renamed)
+	}
+
+	connections := srv.userConnections(username)
+
+	srv.mu.Lock()
+	for _, c := range connections {
+		srv.profileChanges[connectionId(c)] = renamed.NewUsername
+	}
+	srv.mu.Unlock()
+
+	for _, c := range connections {
+		c.Close(ws.CloseRenamed, fmt.Sprintf("You are now %s.", renamed.NewUsername))
+	}
+}
+
+// The last device of `username` left
+func (srv *WSServer) userLastSeen(username string) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if acc, ok := srv.accounts[username]; ok {
+		acc.lastSeen = time.Now()
+	}
+}
+
+// Frees the usernames of the accounts offline for longer than the grace period
+func (srv *WSServer) releaseUsernames() {
+	ticker := time.NewTicker(min(USERNAME_RELEASE_PERIOD, srv.usernameGrace))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			srv.releaseIdleUsernames()
+		case <-srv.ctx.Done():
+			return
+		}
+	}
+}
+
+func (srv *WSServer) releaseIdleUsernames() {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	online := make(map[string]bool)
+	for _, c := range srv.connections {
+		online[c.Metadata.Username] = true
+	}
+
+	for username, acc := range srv.accounts {
+		if !online[username] && time.Since(acc.lastSeen) >= srv.usernameGrace {
+			log.Printf("Releasing username %s\n", username)
+			delete(srv.accounts, username)
+		}
+	}
+
+	for username, renamed := range srv.renamed {
+		if time.Since(renamed.at) >= srv.usernameGrace {
+			delete(srv.renamed, username)
+		}
+	}
+
+	// The tokens of online devices only start expiring when they leave
+	for id, token := range srv.reconnectTokens {
+		if token.expired() {
+			delete(srv.reconnectTokens, id)
+		}
+	}
+}
@@ -2,11 +2,14 @@ package main
 
 import (
 	"math/rand"
+	"strings"
 )
 
+// The first word of a name and the last word of another one
 func GetRandomName() string {
-	idx := rand.Intn(len(RANDOM_NAMES))
-	return RANDOM_NAMES[idx]
+	first, _, _ := strings.Cut(RANDOM_NAMES[rand.Intn(len(RANDOM_NAMES))], " ")
+	_, last, _ := strings.Cut(RANDOM_NAMES[rand.Intn(len(RANDOM_NAMES))], " ")
+	return first + " " + last
 }
 
 func GetRandomColor() string {
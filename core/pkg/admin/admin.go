@@ -0,0 +1,134 @@
+package admin
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// The admin API lets the operators of a server see who is connected and
+// step in: kick or ban users, make announcements and drain the server. It
+// listens apart from the chat (only on localhost by default) and every
+// request must carry the token of the server:
+//
+//	Authorization: Bearer <token>
+//
+// The token is kept in a file, generated the first time the server needs it.
+
+var ErrUnauthorized = errors.New("missing or wrong admin token")
+
+const (
+	tokenSize       = 32
+	fingerprintSize = 16
+)
+
+// A device connected to the server
+type Connection struct {
+	Username    string    `json:"username"`
+	Color       string    `json:"color"`
+	Device      string    `json:"device"`
+	RemoteAddr  string    `json:"remote_addr"`
+	Since       time.Time `json:"since"`
+	Presence    string    `json:"presence"`
+	Registered  bool      `json:"registered"`
+	IdentityKey string    `json:"identity_key,omitempty"` // fingerprint of its keys in the key log, if published
+	ExchangeKey string    `json:"exchange_key"`           // fingerprint of the key it exchanged the session key with
+}
+
+type Room struct {
+	Name       string `json:"name"`
+	Topic      string `json:"topic,omitempty"`
+	InviteOnly bool   `json:"invite_only"`
+	Epoch      uint64 `json:"epoch"`   // of its group
+	Members    int    `json:"members"` // devices in its group
+	Banned     int    `json:"banned"`
+	Muted      int    `json:"muted"`
+}
+
+type Stats struct {
+	Started           time.Time `json:"started"`
+	Uptime            string    `json:"uptime"`
+	Connections       int       `json:"connections"`
+	UsersOnline       int       `json:"users_online"`
+	Accounts          int       `json:"accounts"`   // usernames held, online or not
+	Registered        int       `json:"registered"` // registered users
+	ConnectionsOpened int64     `json:"connections_opened"`
+	MessagesRelayed   int64     `json:"messages_relayed"`
+	KeyLogSize        uint64    `json:"key_log_size"`
+	Rooms             []Room    `json:"rooms"`
+	Draining          bool      `json:"draining"`
+	FIPS              bool      `json:"fips"`
+}
+
+// Asks to kick or ban `Username` (and, with `IP`, the addresses it is
+// connected from), or to lift its ban
+type UserAction struct {
+	Username string `json:"username"`
+	Reason   string `json:"reason,omitempty"`
+	IP       bool   `json:"ip,omitempty"`
+}
+
+type Announcement struct {
+	Text string `json:"text"`
+}
+
+// Draining refuses new connections. The ones already there stay, unless
+// asked to `Disconnect` (they then try to connect again, elsewhere if the
+// server is behind a load balancer).
+type Drain struct {
+	Disconnect bool `json:"disconnect,omitempty"`
+}
+
+// Short hash of `keys`, for people to compare them
+func Fingerprint(keys ...[]byte) string {
+	hash := sha256.New()
+	for _, key := range keys {
+		hash.Write(key)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)[:fingerprintSize])
+}
+
+// Reads the token in `path`, generating it if the file doesn't exist
+func LoadToken(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return newToken(path)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("empty admin token in %s", path)
+	}
+
+	return token, nil
+}
+
+func newToken(path string) (string, error) {
+	token := make([]byte, tokenSize)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	encoded := hex.EncodeToString(token)
+
+	if err := os.WriteFile(path, []byte(encoded+"\n"), 0600); err != nil {
+		return "", err
+	}
+
+	return encoded, nil
+}
+
+// Whether the Authorization header `header` carries `token`
+func Authorized(header, token string) bool {
+	given, ok := strings.CutPrefix(header, "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
@@ -0,0 +1,148 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadToken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "admin.token")
+
+	token, err := LoadToken(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(token) != 2*tokenSize {
+		t.Errorf("token of %d characters", len(token))
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("token saved with mode %s", info.Mode().Perm())
+	}
+
+	again, err := LoadToken(path)
+	if err != nil || again != token {
+		t.Errorf("LoadToken() = %q, %v, want the saved token", again, err)
+	}
+
+	if err := os.WriteFile(path, []byte("\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadToken(path); err == nil {
+		t.Error("LoadToken() accepted an empty token")
+	}
+}
+
+func TestAuthorized(t *testing.T) {
+	tests := []struct {
+		header string
+		ok     bool
+	}{
+		{"Bearer secret", true},
+		{"Bearer wrong", false},
+		{"Bearer secret2", false},
+		{"bearer secret", false},
+		{"secret", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := Authorized(tt.header, "secret"); got != tt.ok {
+			t.Errorf("Authorized(%q) = %v, want %v", tt.header, got, tt.ok)
+		}
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	a := Fingerprint([]byte("identity"), []byte("signing"))
+	if len(a) != 2*fingerprintSize {
+		t.Errorf("fingerprint of %d characters", len(a))
+	}
+	if a != Fingerprint([]byte("identity"), []byte("signing")) {
+		t.Error("fingerprints of the same keys differ")
+	}
+	if a == Fingerprint([]byte("identity"), []byte("other")) {
+		t.Error("fingerprints of different keys match")
+	}
+}
+
+func TestClient(t *testing.T) {
+	var got struct {
+		method, path, contentType string
+		body                      UserAction
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !Authorized(r.Header.Get("Authorization"), "secret") {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		got.method, got.path, got.contentType = r.Method, r.URL.Path, r.Header.Get("Content-Type")
+		got.body = UserAction{}
+		json.NewDecoder(r.Body).Decode(&got.body)
+
+		switch r.URL.Path {
+		case "/stats":
+			json.NewEncoder(w).Encode(Stats{Connections: 3, Rooms: []Room{{Name: "general"}}})
+		case "/connections":
+			json.NewEncoder(w).Encode([]Connection{{Username: "alice", Device: "d1"}})
+		case "/unban":
+			http.Error(w, "alice is not banned", http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	client := NewClient(server.URL+"/", "secret")
+
+	stats, err := client.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Connections != 3 || len(stats.Rooms) != 1 || got.method != http.MethodGet {
+		t.Errorf("Stats() = %+v with %s", stats, got.method)
+	}
+
+	connections, err := client.Connections()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(connections) != 1 || connections[0].Username != "alice" {
+		t.Errorf("Connections() = %+v", connections)
+	}
+
+	action := UserAction{Username: "mallory", Reason: "spam", IP: true}
+	if err := client.Ban(action); err != nil {
+		t.Fatal(err)
+	}
+	if got.method != http.MethodPost || got.path != "/ban" || got.contentType != "application/json" || got.body != action {
+		t.Errorf("Ban() sent %+v", got)
+	}
+
+	if err := client.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	if got.method != http.MethodDelete || got.path != "/drain" {
+		t.Errorf("Resume() sent %s %s", got.method, got.path)
+	}
+
+	// Errors come back with their text
+	err = client.Unban(UserAction{Username: "alice"})
+	if err == nil || !strings.Contains(err.Error(), "alice is not banned") || !strings.Contains(err.Error(), "404") {
+		t.Errorf("Unban() = %v", err)
+	}
+
+	if _, err := NewClient(server.URL, "wrong").Stats(); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("Stats() with a wrong token = %v, want %v", err, ErrUnauthorized)
+	}
+}
@@ -0,0 +1,103 @@
+package admin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Talks to the admin API of a server
+type Client struct {
+	baseURL string
+	token   string
+	http    *http.Client
+}
+
+func NewClient(baseURL, token string) *Client {
+	return &Client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		token:   token,
+		http:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (c *Client) Connections() ([]Connection, error) {
+	var connections []Connection
+	return connections, c.do(http.MethodGet, "/connections", nil, &connections)
+}
+
+func (c *Client) Stats() (Stats, error) {
+	var stats Stats
+	return stats, c.do(http.MethodGet, "/stats", nil, &stats)
+}
+
+func (c *Client) Kick(action UserAction) error {
+	return c.do(http.MethodPost, "/kick", action, nil)
+}
+
+func (c *Client) Ban(action UserAction) error {
+	return c.do(http.MethodPost, "/ban", action, nil)
+}
+
+func (c *Client) Unban(action UserAction) error {
+	return c.do(http.MethodPost, "/unban", action, nil)
+}
+
+func (c *Client) Announce(text string) error {
+	return c.do(http.MethodPost, "/announce", Announcement{Text: text}, nil)
+}
+
+func (c *Client) Drain(drain Drain) error {
+	return c.do(http.MethodPost, "/drain", drain, nil)
+}
+
+// Accepts new connections again
+func (c *Client) Resume() error {
+	return c.do(http.MethodDelete, "/drain", nil, nil)
+}
+
+// Sends `body` (as JSON, if any) and reads the answer into `result` (if any).
+// The API answers errors with their text.
+func (c *Client) do(method, path string, body, result any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusUnauthorized {
+		return ErrUnauthorized
+	}
+	if res.StatusCode >= 300 {
+		text, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("%s (%s)", strings.TrimSpace(string(text)), res.Status)
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	return json.NewDecoder(res.Body).Decode(result)
+}
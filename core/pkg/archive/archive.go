@@ -0,0 +1,263 @@
+package archive
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Guilospanck/pqc/core/pkg/cryptography"
+)
+
+// Local history of a client: the messages it received (and sent), encrypted
+// with a key derived from a passphrase of the user. The file starts with a
+// header line (KDF, salt, and something encrypted to check the passphrase),
+// followed by one encrypted entry per line. Entries are decrypted once, when
+// the archive is opened, and searched in memory.
+
+var (
+	ErrWrongPassphrase = errors.New("wrong passphrase")
+	ErrModeMismatch    = errors.New("archive created with another FIPS mode")
+	ErrNotFound        = errors.New("message not in the archive")
+)
+
+const saltSize = 16
+
+// Encrypted to check the passphrase before reading anything else
+var checkValue = []byte("pqc-archive")
+
+type Entry struct {
+	ID   string    `json:"id,omitempty"` // of the chat message
+	Kind string    `json:"kind"`         // message, own_message, mail or sent
+	From string    `json:"from"`
+	Text string    `json:"text"`
+	Time time.Time `json:"time"`
+}
+
+type header struct {
+	KDF        string `json:"kdf"`
+	Salt       []byte `json:"salt"`
+	FIPS       bool   `json:"fips"`
+	CheckNonce []byte `json:"check_nonce"`
+	Check      []byte `json:"check"`
+}
+
+type record struct {
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+type Archive struct {
+	path    string
+	header  header
+	key     []byte
+	entries []Entry // oldest first
+	mu      sync.Mutex
+}
+
+// Opens the archive at `path`, creating it (with `passphrase`) if it doesn't exist
+func Open(path, passphrase string) (*Archive, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return create(path, passphrase)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	if !scanner.Scan() {
+		return nil, fmt.Errorf("empty archive: %s", path)
+	}
+
+	var h header
+	if err := json.Unmarshal(scanner.Bytes(), &h); err != nil {
+		return nil, err
+	}
+
+	if h.FIPS != cryptography.FIPS() {
+		return nil, ErrModeMismatch
+	}
+
+	key, err := cryptography.DeriveKeyFromPassphrase(h.KDF, passphrase, h.Salt)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := cryptography.DecryptMessage(key, h.CheckNonce, h.Check); err != nil {
+		return nil, ErrWrongPassphrase
+	}
+
+	a := &Archive{path: path, header: h, key: key, entries: make([]Entry, 0)}
+	for scanner.Scan() {
+		var r record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return nil, err
+		}
+
+		plaintext, err := cryptography.DecryptMessage(key, r.Nonce, r.Ciphertext)
+		if err != nil {
+			return nil, fmt.Errorf("corrupted archive entry: %w", err)
+		}
+
+		var entry Entry
+		if err := json.Unmarshal(plaintext, &entry); err != nil {
+			return nil, err
+		}
+		a.entries = append(a.entries, entry)
+	}
+
+	return a, scanner.Err()
+}
+
+func create(path, passphrase string) (*Archive, error) {
+	h := header{
+		KDF:  cryptography.PassphraseKDF(),
+		Salt: make([]byte, saltSize),
+		FIPS: cryptography.FIPS(),
+	}
+	rand.Read(h.Salt)
+
+	key, err := cryptography.DeriveKeyFromPassphrase(h.KDF, passphrase, h.Salt)
+	if err != nil {
+		return nil, err
+	}
+
+	h.CheckNonce, h.Check, err = cryptography.EncryptMessage(key, checkValue)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := appendLine(path, h); err != nil {
+		return nil, err
+	}
+
+	return &Archive{path: path, header: h, key: key, entries: make([]Entry, 0)}, nil
+}
+
+func (a *Archive) Add(entry Entry) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	r, err := a.seal(entry)
+	if err != nil {
+		return err
+	}
+
+	if err := appendLine(a.path, r); err != nil {
+		return err
+	}
+
+	a.entries = append(a.entries, entry)
+	return nil
+}
+
+// Replaces the text of the message `id`
+func (a *Archive) Edit(id, text string) error {
+	return a.rewrite(id, func(entry *Entry) bool {
+		entry.Text = text
+		return true
+	})
+}
+
+func (a *Archive) Delete(id string) error {
+	return a.rewrite(id, func(*Entry) bool { return false })
+}
+
+// Writes the whole archive again (to a temporary file first) with `change`
+// applied to the message `id`, which is removed if `change` returns false
+func (a *Archive) rewrite(id string, change func(entry *Entry) bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	found := false
+	entries := make([]Entry, 0, len(a.entries))
+	for _, entry := range a.entries {
+		if id != "" && entry.ID == id {
+			found = true
+			if !change(&entry) {
+				continue
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	if !found {
+		return ErrNotFound
+	}
+
+	lines := make([]any, 0, len(entries)+1)
+	lines = append(lines, a.header)
+	for _, entry := range entries {
+		r, err := a.seal(entry)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, r)
+	}
+
+	var data []byte
+	for _, line := range lines {
+		marshalled, err := json.Marshal(line)
+		if err != nil {
+			return err
+		}
+		data = append(append(data, marshalled...), '\n')
+	}
+
+	tmp := a.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, a.path); err != nil {
+		return err
+	}
+
+	a.entries = entries
+	return nil
+}
+
+func (a *Archive) seal(entry Entry) (record, error) {
+	plaintext, err := json.Marshal(entry)
+	if err != nil {
+		return record{}, err
+	}
+
+	nonce, ciphertext, err := cryptography.EncryptMessage(a.key, plaintext)
+	if err != nil {
+		return record{}, err
+	}
+
+	return record{Nonce: nonce, Ciphertext: ciphertext}, nil
+}
+
+func (a *Archive) Len() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return len(a.entries)
+}
+
+func appendLine(path string, value any) error {
+	line, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
@@ -0,0 +1,179 @@
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestArchive(t *testing.T) (*Archive, string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "history")
+	a, err := Open(path, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a, path
+}
+
+func TestOpen(t *testing.T) {
+	a, path := newTestArchive(t)
+
+	entries := []Entry{
+		{ID: "1", Kind: "message", From: "alice", Text: "hello there", Time: time.Unix(100, 0).UTC()},
+		{ID: "2", Kind: "sent", From: "bob", Text: "general kenobi", Time: time.Unix(200, 0).UTC()},
+	}
+	for _, entry := range entries {
+		if err := a.Add(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "kenobi") {
+		t.Error("the archive is not encrypted")
+	}
+
+	reopened, err := Open(path, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.Len() != len(entries) {
+		t.Fatalf("%d entries after reopening, want %d", reopened.Len(), len(entries))
+	}
+	for i, entry := range reopened.entries {
+		if entry != entries[i] {
+			t.Errorf("entry %d = %+v, want %+v", i, entry, entries[i])
+		}
+	}
+
+	if _, err := Open(path, "wrong horse"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("Open() with a wrong passphrase = %v, want %v", err, ErrWrongPassphrase)
+	}
+}
+
+func TestOpenCorrupted(t *testing.T) {
+	a, path := newTestArchive(t)
+	if err := a.Add(Entry{ID: "1", Text: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// An entry encrypted with the key of another archive
+	other, _ := newTestArchive(t)
+	r, err := other.seal(Entry{ID: "2", Text: "injected"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := appendLine(path, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Open(path, "correct horse"); err == nil {
+		t.Error("Open() accepted an entry encrypted with another key")
+	}
+}
+
+func TestEditAndDelete(t *testing.T) {
+	a, path := newTestArchive(t)
+	for i := range 3 {
+		if err := a.Add(Entry{ID: fmt.Sprint(i), Text: fmt.Sprintf("message %d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := a.Edit("1", "edited"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Delete("0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Delete("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Delete() of a missing message = %v, want %v", err, ErrNotFound)
+	}
+	// Entries without an id can't be changed
+	if err := a.Edit("", "edited"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Edit() without an id = %v, want %v", err, ErrNotFound)
+	}
+
+	reopened, err := Open(path, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"edited", "message 2"}
+	if reopened.Len() != len(want) {
+		t.Fatalf("%d entries after reopening, want %d", reopened.Len(), len(want))
+	}
+	for i, entry := range reopened.entries {
+		if entry.Text != want[i] {
+			t.Errorf("entry %d = %q, want %q", i, entry.Text, want[i])
+		}
+	}
+
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Error("the temporary file was left behind")
+	}
+}
+
+func TestSearch(t *testing.T) {
+	a, _ := newTestArchive(t)
+
+	start := time.Unix(1000, 0)
+	for i := range 25 {
+		from := "alice"
+		if i%5 == 0 {
+			from = "Bob"
+		}
+		entry := Entry{ID: fmt.Sprint(i), From: from, Text: fmt.Sprintf("Message number %d", i), Time: start.Add(time.Duration(i) * time.Minute)}
+		if err := a.Add(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		query Query
+		total int
+		pages int
+		ids   []string // of the page, newest first
+	}{
+		{"everything", Query{}, 25, 2, nil},
+		{"second page", Query{Page: 2}, 25, 2, []string{"4", "3", "2", "1", "0"}},
+		{"page past the end", Query{Page: 3}, 25, 2, []string{}},
+		{"sender whatever the case", Query{From: "bob"}, 5, 1, []string{"20", "15", "10", "5", "0"}},
+		{"all the words", Query{Text: "NUMBER 2"}, 7, 1, []string{"24", "23", "22", "21", "20", "12", "2"}},
+		{"missing word", Query{Text: "message nope"}, 0, 0, []string{}},
+		{"time range", Query{Since: start.Add(3 * time.Minute), Until: start.Add(6 * time.Minute)}, 3, 1, []string{"5", "4", "3"}},
+		{"page size", Query{From: "Bob", PageSize: 2, Page: 3}, 5, 3, []string{"0"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page := a.Search(tt.query)
+			if page.Total != tt.total || page.Pages != tt.pages {
+				t.Errorf("Search() found %d in %d pages, want %d in %d", page.Total, page.Pages, tt.total, tt.pages)
+			}
+			if tt.ids == nil {
+				if len(page.Entries) != DefaultPageSize {
+					t.Errorf("%d entries, want a full page of %d", len(page.Entries), DefaultPageSize)
+				}
+				return
+			}
+
+			ids := make([]string, 0, len(page.Entries))
+			for _, entry := range page.Entries {
+				ids = append(ids, entry.ID)
+			}
+			if strings.Join(ids, ",") != strings.Join(tt.ids, ",") {
+				t.Errorf("Search() = %v, want %v", ids, tt.ids)
+			}
+		})
+	}
+}
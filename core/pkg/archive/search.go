@@ -0,0 +1,85 @@
+package archive
+
+import (
+	"strings"
+	"time"
+)
+
+// Entries per page when the query doesn't say
+const DefaultPageSize = 20
+
+// Every non-empty field must match. `Text` matches entries containing
+// all of its words (case insensitive), `From` the exact sender.
+type Query struct {
+	Text     string    `json:"text,omitempty"`
+	From     string    `json:"from,omitempty"`
+	Since    time.Time `json:"since,omitzero"`
+	Until    time.Time `json:"until,omitzero"`
+	Page     int       `json:"page,omitempty"` // starting at 1
+	PageSize int       `json:"page_size,omitempty"`
+}
+
+// One page of results, newest first
+type Page struct {
+	Query   Query   `json:"query"`
+	Page    int     `json:"page"`
+	Pages   int     `json:"pages"`
+	Total   int     `json:"total"`
+	Entries []Entry `json:"entries"`
+}
+
+func (q Query) matches(entry Entry, words []string) bool {
+	if q.From != "" && !strings.EqualFold(entry.From, q.From) {
+		return false
+	}
+	if !q.Since.IsZero() && entry.Time.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !entry.Time.Before(q.Until) {
+		return false
+	}
+
+	text := strings.ToLower(entry.Text)
+	for _, word := range words {
+		if !strings.Contains(text, word) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (a *Archive) Search(q Query) Page {
+	if q.PageSize <= 0 {
+		q.PageSize = DefaultPageSize
+	}
+	if q.Page <= 0 {
+		q.Page = 1
+	}
+
+	words := strings.Fields(strings.ToLower(q.Text))
+
+	a.mu.Lock()
+	matches := make([]Entry, 0)
+	for i := len(a.entries) - 1; i >= 0; i-- {
+		if q.matches(a.entries[i], words) {
+			matches = append(matches, a.entries[i])
+		}
+	}
+	a.mu.Unlock()
+
+	page := Page{
+		Query:   q,
+		Page:    q.Page,
+		Pages:   (len(matches) + q.PageSize - 1) / q.PageSize,
+		Total:   len(matches),
+		Entries: make([]Entry, 0),
+	}
+
+	start := (q.Page - 1) * q.PageSize
+	if start < len(matches) {
+		page.Entries = matches[start:min(start+q.PageSize, len(matches))]
+	}
+
+	return page
+}
@@ -0,0 +1,122 @@
+package chat
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Guilospanck/pqc/core/pkg/types"
+)
+
+// Version of the chat message payload. Messages with a newer version
+// can't be read, so clients have to be updated first.
+//
+//	1: text messages
+//	2: edits and deletions (`Action` and `Target`)
+//	3: reactions
+//	4: direct messages (`Recipient`)
+const Version = 4
+
+var (
+	ErrUnsupportedVersion = errors.New("unsupported chat message version")
+	ErrInvalidMessage     = errors.New("invalid chat message")
+)
+
+// Longest reaction we accept, in bytes (some emojis take a lot of them)
+const maxReactionSize = 32
+
+// Size of the random message IDs, in bytes
+const idSize = 16
+
+// A new text message from `sender` to `room`
+func New(sender, room, body, replyTo string) (types.ChatMessage, error) {
+	id := make([]byte, idSize)
+	if _, err := rand.Read(id); err != nil {
+		return types.ChatMessage{}, err
+	}
+
+	return types.ChatMessage{
+		Version:     Version,
+		ID:          hex.EncodeToString(id),
+		Sender:      sender,
+		Room:        room,
+		Timestamp:   time.Now().UTC(),
+		Body:        body,
+		ReplyTo:     replyTo,
+		ContentType: types.ContentTypeText,
+	}, nil
+}
+
+// A direct message, only routed to the devices of `recipient`
+func NewDirect(sender, recipient, body string) (types.ChatMessage, error) {
+	msg, err := New(sender, "", body, "")
+	if err != nil {
+		return msg, err
+	}
+
+	msg.Recipient = recipient
+	return msg, nil
+}
+
+// A message editing (`body` is then the new body), deleting or reacting
+// to (`body` is then the emoji) the message `target`
+func NewAction(sender, room string, action types.ChatAction, target, body string) (types.ChatMessage, error) {
+	msg, err := New(sender, room, body, "")
+	if err != nil {
+		return msg, err
+	}
+
+	msg.Action = action
+	msg.Target = target
+	return msg, nil
+}
+
+func Marshal(msg types.ChatMessage) ([]byte, error) {
+	return json.Marshal(msg)
+}
+
+// Reads a payload from a decrypted message
+func Parse(data []byte) (types.ChatMessage, error) {
+	var msg types.ChatMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return msg, fmt.Errorf("%w: %s", ErrInvalidMessage, err.Error())
+	}
+
+	if msg.Version < 1 || msg.Version > Version {
+		return msg, fmt.Errorf("%w: %d", ErrUnsupportedVersion, msg.Version)
+	}
+
+	if msg.ID == "" || msg.Sender == "" {
+		return msg, fmt.Errorf("%w: missing id or sender", ErrInvalidMessage)
+	}
+
+	// Direct messages can't be changed: they aren't kept by the server
+	if msg.Recipient != "" && (msg.Room != "" || msg.Action != "") {
+		return msg, fmt.Errorf("%w: direct message with a room or an action", ErrInvalidMessage)
+	}
+
+	switch msg.Action {
+	case "":
+	case types.ChatActionEdit, types.ChatActionDelete:
+		if msg.Target == "" {
+			return msg, fmt.Errorf("%w: %s without a target", ErrInvalidMessage, msg.Action)
+		}
+	case types.ChatActionReact, types.ChatActionUnreact:
+		if msg.Target == "" || !ValidReaction(msg.Body) {
+			return msg, fmt.Errorf("%w: invalid reaction", ErrInvalidMessage)
+		}
+	default:
+		return msg, fmt.Errorf("%w: unknown action %q", ErrInvalidMessage, msg.Action)
+	}
+
+	return msg, nil
+}
+
+// Reactions are short and have no spaces (an emoji, or a few of them)
+func ValidReaction(emoji string) bool {
+	return emoji != "" && len(emoji) <= maxReactionSize && !strings.ContainsAny(emoji, " \t\n")
+}
@@ -0,0 +1,116 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Guilospanck/pqc/core/pkg/types"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		err  error
+	}{
+		{"text", `{"version":1,"id":"1","sender":"alice","room":"general","body":"hi"}`, nil},
+		{"current version", `{"version":4,"id":"1","sender":"alice","body":"hi"}`, nil},
+		{"edit", `{"version":2,"id":"1","sender":"alice","action":"edit","target":"0","body":"hello"}`, nil},
+		{"delete", `{"version":2,"id":"1","sender":"alice","action":"delete","target":"0"}`, nil},
+		{"react", `{"version":3,"id":"1","sender":"alice","action":"react","target":"0","body":"👍"}`, nil},
+		{"unreact", `{"version":3,"id":"1","sender":"alice","action":"unreact","target":"0","body":"👍"}`, nil},
+		{"direct", `{"version":4,"id":"1","sender":"alice","recipient":"bob","body":"hi"}`, nil},
+
+		{"not json", `hello`, ErrInvalidMessage},
+		{"wrong type", `{"version":"4","id":"1","sender":"alice"}`, ErrInvalidMessage},
+		{"no version", `{"id":"1","sender":"alice","body":"hi"}`, ErrUnsupportedVersion},
+		{"newer version", `{"version":5,"id":"1","sender":"alice","body":"hi"}`, ErrUnsupportedVersion},
+		{"no id", `{"version":4,"sender":"alice","body":"hi"}`, ErrInvalidMessage},
+		{"no sender", `{"version":4,"id":"1","body":"hi"}`, ErrInvalidMessage},
+		{"direct in a room", `{"version":4,"id":"1","sender":"alice","recipient":"bob","room":"general"}`, ErrInvalidMessage},
+		{"direct edit", `{"version":4,"id":"1","sender":"alice","recipient":"bob","action":"edit","target":"0"}`, ErrInvalidMessage},
+		{"edit without target", `{"version":4,"id":"1","sender":"alice","action":"edit","body":"hello"}`, ErrInvalidMessage},
+		{"delete without target", `{"version":4,"id":"1","sender":"alice","action":"delete"}`, ErrInvalidMessage},
+		{"react without target", `{"version":4,"id":"1","sender":"alice","action":"react","body":"👍"}`, ErrInvalidMessage},
+		{"empty reaction", `{"version":4,"id":"1","sender":"alice","action":"react","target":"0"}`, ErrInvalidMessage},
+		{"reaction with spaces", `{"version":4,"id":"1","sender":"alice","action":"react","target":"0","body":"a b"}`, ErrInvalidMessage},
+		{"long reaction", `{"version":4,"id":"1","sender":"alice","action":"unreact","target":"0","body":"` + strings.Repeat("x", maxReactionSize+1) + `"}`, ErrInvalidMessage},
+		{"unknown action", `{"version":4,"id":"1","sender":"alice","action":"pin","target":"0"}`, ErrInvalidMessage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse([]byte(tt.json)); !errors.Is(err, tt.err) {
+				t.Errorf("Parse() = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	text, err := New("alice", "general", "hello", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err := New("bob", "general", "hi", text.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	direct, err := NewDirect("alice", "bob", "psst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	edit, err := NewAction("alice", "general", types.ChatActionEdit, text.ID, "hello!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	react, err := NewAction("bob", "general", types.ChatActionReact, text.ID, "🎉")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if text.ID == reply.ID || len(text.ID) != 2*idSize {
+		t.Errorf("message ids %q and %q", text.ID, reply.ID)
+	}
+
+	for _, msg := range []types.ChatMessage{text, reply, direct, edit, react} {
+		data, err := Marshal(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		parsed, err := Parse(data)
+		if err != nil {
+			t.Fatalf("Parse() of %s = %v", data, err)
+		}
+
+		want, _ := json.Marshal(msg)
+		got, _ := json.Marshal(parsed)
+		if string(got) != string(want) {
+			t.Errorf("Parse() = %s, want %s", got, want)
+		}
+	}
+}
+
+func TestValidReaction(t *testing.T) {
+	tests := []struct {
+		emoji string
+		valid bool
+	}{
+		{"👍", true},
+		{"👨‍👩‍👧‍👦", true},
+		{"+1", true},
+		{"", false},
+		{"👍 👍", false},
+		{"👍\n", false},
+		{strings.Repeat("👍", maxReactionSize/4+1), false},
+	}
+
+	for _, tt := range tests {
+		if valid := ValidReaction(tt.emoji); valid != tt.valid {
+			t.Errorf("ValidReaction(%q) = %v, want %v", tt.emoji, valid, tt.valid)
+		}
+	}
+}
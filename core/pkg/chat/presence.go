@@ -0,0 +1,26 @@
+package chat
+
+import (
+	"errors"
+
+	"github.com/Guilospanck/pqc/core/pkg/types"
+)
+
+var ErrInvalidPresence = errors.New("invalid status")
+
+// Longest custom status we accept, in bytes
+const maxStatusTextSize = 64
+
+func ValidatePresence(p types.Presence) error {
+	switch p.Status {
+	case types.PresenceOnline, types.PresenceAway, types.PresenceBusy:
+	default:
+		return ErrInvalidPresence
+	}
+
+	if len(p.Text) > maxStatusTextSize {
+		return ErrInvalidPresence
+	}
+
+	return nil
+}
@@ -0,0 +1,35 @@
+package chat
+
+import (
+	"errors"
+
+	"github.com/Guilospanck/pqc/core/pkg/types"
+)
+
+var ErrInvalidReceipt = errors.New("invalid receipt")
+
+// Sent by the server to the sender of a message it accepted
+type Ack struct {
+	ID string `json:"id"`
+}
+
+// Sent by a recipient of a message to its sender, through the server.
+// Receipts only carry message ids, never their content.
+type Receipt struct {
+	ID        string              `json:"id"`
+	Status    types.MessageStatus `json:"status"`
+	Sender    string              `json:"sender"`              // who sent the message, the receipt goes to it
+	Recipient string              `json:"recipient,omitempty"` // set by the server
+}
+
+func (r Receipt) Validate() error {
+	if r.ID == "" || r.Sender == "" {
+		return ErrInvalidReceipt
+	}
+
+	if r.Status != types.MessageStatusDelivered && r.Status != types.MessageStatusRead {
+		return ErrInvalidReceipt
+	}
+
+	return nil
+}
@@ -1,6 +1,8 @@
 package cryptography
 
 import (
+	"crypto/ed25519"
+	"crypto/hkdf"
 	"crypto/mlkem"
 	"crypto/rand"
 	"log"
@@ -8,18 +10,31 @@ import (
 	"crypto/sha256"
 
 	"golang.org/x/crypto/chacha20poly1305"
-	"golang.org/x/crypto/hkdf"
 )
 
 type Keys struct {
 	Private      *mlkem.DecapsulationKey768
 	Public       []byte
 	SharedSecret []byte
+	Signing      ed25519.PrivateKey // identity signing key, only known by clients
+	Seed         []byte             // identity keys are derived from it, only known by clients
 }
 
+// Size of the identity seed (256-bit)
+const SeedSize = 32
+
 func GenerateKeys() (Keys, error) {
+	seed := make([]byte, SeedSize)
+	rand.Read(seed)
+
+	return KeysFromSeed(seed)
+}
+
+// Deterministically derives the identity keys from `seed`,
+// so they can be recovered from a backup of the seed.
+func KeysFromSeed(seed []byte) (Keys, error) {
 	// private key
-	decapsulationKey, err := mlkem.GenerateKey768()
+	decapsulationKey, err := mlkem.NewDecapsulationKey768(DeriveBytes(seed, []byte("pqc-identity-kem"), mlkem.SeedSize))
 	if err != nil {
 		log.Printf("Error trying to generate private key: %s", err.Error())
 		return Keys{}, err
@@ -27,9 +42,14 @@ func GenerateKeys() (Keys, error) {
 	// public key
 	encapsulationKey := decapsulationKey.EncapsulationKey().Bytes()
 
+	// signing key
+	signingKey := ed25519.NewKeyFromSeed(DeriveBytes(seed, []byte("pqc-identity-signing"), ed25519.SeedSize))
+
 	keys := Keys{
 		Private: decapsulationKey,
 		Public:  encapsulationKey,
+		Signing: signingKey,
+		Seed:    seed,
 	}
 
 	return keys, nil
@@ -49,14 +69,30 @@ func KeyExchange(publicKey []byte) (sharedSecret, ciphertext []byte) {
 // Uses HKDF to make the shared secret even more hard to be discovered and
 // also more uniform and able to be used into the symmetric algorithms
 func DeriveKey(sharedSecret []byte) []byte {
-	hkdf := hkdf.New(sha256.New, sharedSecret, nil, nil)
-	key := make([]byte, 32) // 256-bit
-	hkdf.Read(key)
+	return DeriveKeyWithInfo(sharedSecret, nil)
+}
+
+// Same as `DeriveKey`, but binds the derived key to some context (`info`),
+// so keys derived from the same secret for different purposes are different.
+func DeriveKeyWithInfo(secret, info []byte) []byte {
+	return DeriveBytes(secret, info, 32) // 256-bit
+}
+
+// Derives `length` bytes from `secret`, e.g. to seed other keys
+func DeriveBytes(secret, info []byte, length int) []byte {
+	key, err := hkdf.Key(sha256.New, secret, nil, string(info), length)
+	if err != nil {
+		log.Printf("Could not derive key: %s", err.Error())
+	}
 	return key
 }
 
-// Symmetrically encrypts a message using CHACHA20-POLY1305
+// Symmetrically encrypts a message using CHACHA20-POLY1305 (AES-256-GCM in FIPS mode)
 func EncryptMessage(key, plaintext []byte) ([]byte, []byte, error) {
+	if FIPS() {
+		return encryptAESGCM(key, plaintext)
+	}
+
 	aead, err := chacha20poly1305.New(key)
 	if err != nil {
 		return nil, nil, err
@@ -71,8 +107,12 @@ func EncryptMessage(key, plaintext []byte) ([]byte, []byte, error) {
 	return nonce, ciphertext, nil
 }
 
-// Symmetrically decripts a message using CHACHA20-POLY1305
+// Symmetrically decripts a message using CHACHA20-POLY1305 (AES-256-GCM in FIPS mode)
 func DecryptMessage(key, nonce, ciphertext []byte) ([]byte, error) {
+	if FIPS() {
+		return decryptAESGCM(key, nonce, ciphertext)
+	}
+
 	aead, err := chacha20poly1305.New(key)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,76 @@
+package cryptography
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/fips140"
+	"errors"
+)
+
+// In FIPS mode only algorithms approved by FIPS 140-3 (and implemented by the
+// Go Cryptographic Module) are used: ML-KEM, Ed25519, HKDF-SHA256 and, instead
+// of ChaCha20-Poly1305, AES-256-GCM. Both sides of every exchange must be in
+// the same mode, the server refuses clients that are not.
+
+var ErrInvalidNonce = errors.New("invalid nonce")
+
+var fipsMode bool
+
+// Must be called before any key is used
+func EnableFIPS() {
+	fipsMode = true
+}
+
+// Either enabled by us or by running with GODEBUG=fips140=on
+func FIPS() bool {
+	return fipsMode || fips140.Enabled()
+}
+
+// Describes the FIPS mode, for the logs and the UI
+func FIPSStatus() string {
+	switch {
+	case fips140.Enabled():
+		return "FIPS 140-3 mode (Go Cryptographic Module)"
+	case fipsMode:
+		return "FIPS 140-3 mode (approved algorithms only)"
+	default:
+		return "FIPS 140-3 mode disabled"
+	}
+}
+
+// GCM nonces are generated by the module itself (and prepended to the ciphertext),
+// which is what makes the encryption approved.
+func encryptAESGCM(key, plaintext []byte) ([]byte, []byte, error) {
+	aead, err := newAESGCM(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sealed := aead.Seal(nil, nil, plaintext, nil)
+
+	return sealed[:gcmNonceSize], sealed[gcmNonceSize:], nil
+}
+
+func decryptAESGCM(key, nonce, ciphertext []byte) ([]byte, error) {
+	if len(nonce) != gcmNonceSize {
+		return nil, ErrInvalidNonce
+	}
+
+	aead, err := newAESGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return aead.Open(nil, nil, append(append([]byte{}, nonce...), ciphertext...), nil)
+}
+
+const gcmNonceSize = 12
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCMWithRandomNonce(block)
+}
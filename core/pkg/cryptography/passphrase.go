@@ -0,0 +1,53 @@
+package cryptography
+
+import (
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Keys derived from something a user can remember need a slow KDF:
+// Argon2id, or PBKDF2-HMAC-SHA256 in FIPS mode (Argon2 is not approved).
+
+const (
+	KDFArgon2id = "argon2id"
+	KDFPBKDF2   = "pbkdf2-sha256"
+)
+
+const (
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024 // KiB
+	argon2Threads = 4
+
+	pbkdf2Iterations = 600_000 // OWASP recommendation for PBKDF2-HMAC-SHA256
+)
+
+var ErrKDFNotApproved = errors.New("key derivation function not approved in FIPS mode")
+
+// KDF used for new passphrase-derived keys
+func PassphraseKDF() string {
+	if FIPS() {
+		return KDFPBKDF2
+	}
+	return KDFArgon2id
+}
+
+// Derives a 256-bit key from `passphrase` with `kdf` (see `PassphraseKDF`)
+func DeriveKeyFromPassphrase(kdf, passphrase string, salt []byte) ([]byte, error) {
+	switch kdf {
+	case KDFArgon2id:
+		if FIPS() {
+			return nil, ErrKDFNotApproved
+		}
+		return argon2.IDKey([]byte(passphrase), salt, argon2Time, argon2Memory, argon2Threads, 32), nil
+
+	case KDFPBKDF2:
+		return pbkdf2.Key(sha256.New, passphrase, salt, pbkdf2Iterations, 32)
+
+	default:
+		return nil, fmt.Errorf("unknown key derivation function: %s", kdf)
+	}
+}
@@ -0,0 +1,38 @@
+package devices
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// A user can be connected from several devices at once, each one with
+// its own keys. Devices are told apart by a random id.
+
+// Returns a new random device id
+func NewID() string {
+	id := make([]byte, 4)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// Identifies one device of a user, e.g. in the key log or in a group
+func Address(username, device string) string {
+	return username + "#" + device
+}
+
+// One of the devices allowed to connect as a user
+type Device struct {
+	ID     string `json:"id"`
+	Online bool   `json:"online"`
+}
+
+// Sent by a device of the user to accept (or refuse) a new device
+type Approval struct {
+	Device   string `json:"device"`
+	Approved bool   `json:"approved"`
+}
+
+// Sent by a device of the user to remove another one of its devices
+type Removal struct {
+	Device string `json:"device"`
+}
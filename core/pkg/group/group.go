@@ -0,0 +1,583 @@
+package group
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/mlkem"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/Guilospanck/pqc/core/pkg/cryptography"
+)
+
+var (
+	ErrWrongGroup       = errors.New("message for another group")
+	ErrWrongEpoch       = errors.New("message for another epoch")
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrInvalidCommit    = errors.New("invalid commit")
+	ErrCannotDecrypt    = errors.New("no key to decrypt the path secret")
+	ErrRemoved          = errors.New("we were removed from the group")
+	ErrPendingCommit    = errors.New("a commit of ours is already pending")
+)
+
+// Everything that changes from one epoch to the next
+type state struct {
+	epoch       uint64
+	tree        Tree
+	ownLeaf     uint32
+	privateKeys map[uint32]*mlkem.DecapsulationKey768 // by node index
+	initSecret  []byte
+	epochSecret []byte
+}
+
+func (s *state) clone() *state {
+	return &state{
+		epoch:       s.epoch,
+		tree:        s.tree.clone(),
+		ownLeaf:     s.ownLeaf,
+		privateKeys: maps.Clone(s.privateKeys),
+		initSecret:  s.initSecret,
+		epochSecret: s.epochSecret,
+	}
+}
+
+// Key schedule: the new epoch secret mixes the previous one (through the
+// init secret) with the fresh commit secret, and is bound to the group state.
+func (s *state) advance(groupID string, commitSecret []byte) {
+	info := []byte("group-epoch" + groupID)
+	info = binary.BigEndian.AppendUint64(info, s.epoch)
+	info = append(info, s.tree.hash()...)
+
+	secret := append(bytes.Clone(s.initSecret), commitSecret...)
+	s.epochSecret = cryptography.DeriveKeyWithInfo(secret, info)
+	s.initSecret = cryptography.DeriveKeyWithInfo(s.epochSecret, []byte("group-init"))
+}
+
+func (s *state) applicationKey() []byte {
+	return cryptography.DeriveKeyWithInfo(s.epochSecret, []byte("group-application"))
+}
+
+// TreeKEM group, with ML-KEM as the KEM. Members share an epoch secret
+// that changes with every commit (adds, removes and key updates).
+type Group struct {
+	ID         string
+	signingKey ed25519.PrivateKey
+	current    *state
+	previous   *state // kept to decrypt messages sent right before a commit
+	pending    *state // state after our own commit, until the server accepts it
+	pendingSig []byte
+	mu         sync.Mutex
+}
+
+// Creates a group with only us in it
+func Create(groupID string, kp KeyPackage, leafKey *mlkem.DecapsulationKey768, signingKey ed25519.PrivateKey) *Group {
+	s := &state{
+		epoch:       0,
+		tree:        Tree{{Leaf: &kp.Leaf}},
+		ownLeaf:     0,
+		privateKeys: map[uint32]*mlkem.DecapsulationKey768{0: leafKey},
+		initSecret:  randomSecret(),
+	}
+	s.advance(groupID, randomSecret())
+
+	return &Group{
+		ID:         groupID,
+		signingKey: signingKey,
+		current:    s,
+	}
+}
+
+// Joins a group we were added to
+func Join(welcome Welcome, kp KeyPackage, leafKey *mlkem.DecapsulationKey768, signingKey ed25519.PrivateKey) (*Group, error) {
+	plaintext, err := open(leafKey, welcome.KEMCiphertext, welcome.Nonce, welcome.Ciphertext, welcomeContext(welcome.GroupID, welcome.Epoch))
+	if err != nil {
+		return nil, fmt.Errorf("could not open welcome: %w", err)
+	}
+
+	var secrets groupSecrets
+	if err := json.Unmarshal(plaintext, &secrets); err != nil {
+		return nil, err
+	}
+
+	ownLeaf, ok := welcome.Tree.findLeafByKey(kp.Leaf.EncryptionKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: welcome tree does not contain us", ErrInvalidCommit)
+	}
+
+	s := &state{
+		epoch:       welcome.Epoch,
+		tree:        welcome.Tree,
+		ownLeaf:     ownLeaf,
+		privateKeys: map[uint32]*mlkem.DecapsulationKey768{2 * ownLeaf: leafKey},
+		epochSecret: secrets.EpochSecret,
+	}
+	s.initSecret = cryptography.DeriveKeyWithInfo(s.epochSecret, []byte("group-init"))
+
+	path := s.tree.directPath(2 * ownLeaf)
+	start := slices.Index(path, secrets.PathNode)
+	if start < 0 {
+		return nil, fmt.Errorf("%w: path secret for a node that is not our parent", ErrInvalidCommit)
+	}
+	if _, err := s.derivePath(path[start:], secrets.PathSecret); err != nil {
+		return nil, err
+	}
+
+	return &Group{
+		ID:         welcome.GroupID,
+		signingKey: signingKey,
+		current:    s,
+	}, nil
+}
+
+func (g *Group) Epoch() uint64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.current.epoch
+}
+
+func (g *Group) Members() []LeafNode {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.current.tree.Members()
+}
+
+func (g *Group) HasPendingCommit() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.pending != nil
+}
+
+// Drops our pending commit (e.g. the server rejected it)
+func (g *Group) DiscardPendingCommit() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.pending = nil
+	g.pendingSig = nil
+}
+
+// Creates a commit adding and removing members, and updating our keys.
+// It only takes effect once the server accepts it and sends it back to us.
+func (g *Group) Commit(adds []KeyPackage, removes []string) (CommitBundle, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.pending != nil {
+		return CommitBundle{}, ErrPendingCommit
+	}
+
+	next := g.current.clone()
+	own, _ := next.tree.leaf(next.ownLeaf)
+
+	commit := Commit{
+		GroupID: g.ID,
+		Epoch:   g.current.epoch,
+		Sender:  next.ownLeaf,
+		Adds:    make([]KeyPackage, 0, len(adds)),
+		Removes: make([]string, 0, len(removes)),
+	}
+
+	for _, address := range removes {
+		index, ok := next.tree.findLeaf(address)
+		if !ok || index == next.ownLeaf {
+			continue
+		}
+
+		next.removeLeaf(index)
+		commit.Removes = append(commit.Removes, address)
+	}
+
+	added := make(map[uint32]KeyPackage)
+	for _, kp := range adds {
+		if kp.GroupID != g.ID || !kp.Verify() {
+			continue
+		}
+		if _, exists := next.tree.findLeaf(kp.Leaf.Address()); exists {
+			continue
+		}
+
+		added[next.tree.addLeaf(kp.Leaf)] = kp
+		commit.Adds = append(commit.Adds, kp)
+	}
+
+	// New keys for our leaf and all of our direct path
+	leafSecret := randomSecret()
+	leafKey, err := deriveNodeKey(leafSecret)
+	if err != nil {
+		return CommitBundle{}, err
+	}
+
+	leaf := LeafNode{
+		Username:      own.Username,
+		Device:        own.Device,
+		EncryptionKey: leafKey.EncapsulationKey().Bytes(),
+		SigningKey:    g.signingKey.Public().(ed25519.PublicKey),
+	}
+	ownNode := 2 * next.ownLeaf
+	next.tree[ownNode] = Node{Leaf: &leaf}
+	next.privateKeys = map[uint32]*mlkem.DecapsulationKey768{ownNode: leafKey}
+
+	path := next.tree.directPath(ownNode)
+	copath := next.tree.copath(ownNode)
+	pathSecrets := make(map[uint32][]byte, len(path))
+	context := pathContext(g.ID, g.current.epoch)
+
+	commit.Path = UpdatePath{Leaf: leaf, Nodes: make([]UpdatePathNode, 0, len(path))}
+	pathSecret := leafSecret
+
+	for i, p := range path {
+		pathSecret = deriveSecret(pathSecret, "path")
+		nodeKey, err := deriveNodeKey(pathSecret)
+		if err != nil {
+			return CommitBundle{}, err
+		}
+
+		next.tree[p] = Node{PublicKey: nodeKey.EncapsulationKey().Bytes()}
+		next.privateKeys[p] = nodeKey
+		pathSecrets[p] = pathSecret
+
+		updateNode := UpdatePathNode{
+			PublicKey:        next.tree[p].PublicKey,
+			EncryptedSecrets: make([]EncryptedSecret, 0),
+		}
+
+		// New members get their path secret in the welcome instead
+		for _, r := range next.tree.resolution(copath[i]) {
+			if _, isNew := added[r/2]; isNew && level(r) == 0 {
+				continue
+			}
+
+			encrypted, err := seal(next.tree[r].encryptionKey(), pathSecret, context)
+			if err != nil {
+				return CommitBundle{}, err
+			}
+			encrypted.Node = r
+			updateNode.EncryptedSecrets = append(updateNode.EncryptedSecrets, encrypted)
+		}
+
+		commit.Path.Nodes = append(commit.Path.Nodes, updateNode)
+	}
+
+	next.epoch++
+	next.advance(g.ID, deriveSecret(pathSecret, "commit"))
+
+	commit.Signature = ed25519.Sign(g.signingKey, commit.signedData())
+
+	welcomes := make([]Welcome, 0, len(added))
+	for index, kp := range added {
+		welcome, err := g.welcome(next, index, kp, path, pathSecrets)
+		if err != nil {
+			return CommitBundle{}, err
+		}
+		welcomes = append(welcomes, welcome)
+	}
+
+	g.pending = next
+	g.pendingSig = commit.Signature
+
+	return CommitBundle{Commit: commit, Welcomes: welcomes}, nil
+}
+
+func (g *Group) welcome(next *state, index uint32, kp KeyPackage, path []uint32, pathSecrets map[uint32][]byte) (Welcome, error) {
+	// Lowest common ancestor of the new member and us
+	var ancestor uint32
+	for _, p := range path {
+		if inSubtree(2*index, p) {
+			ancestor = p
+			break
+		}
+	}
+
+	secrets, err := json.Marshal(groupSecrets{
+		EpochSecret: next.epochSecret,
+		PathSecret:  pathSecrets[ancestor],
+		PathNode:    ancestor,
+	})
+	if err != nil {
+		return Welcome{}, err
+	}
+
+	encrypted, err := seal(kp.Leaf.EncryptionKey, secrets, welcomeContext(g.ID, next.epoch))
+	if err != nil {
+		return Welcome{}, err
+	}
+
+	return Welcome{
+		GroupID:       g.ID,
+		Epoch:         next.epoch,
+		To:            kp.Leaf.Address(),
+		Tree:          next.tree,
+		KEMCiphertext: encrypted.KEMCiphertext,
+		Nonce:         encrypted.Nonce,
+		Ciphertext:    encrypted.Ciphertext,
+	}, nil
+}
+
+// Applies a commit accepted by the server: ours (then the pending state
+// becomes the current one) or from another member.
+func (g *Group) ProcessCommit(commit Commit) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if commit.GroupID != g.ID {
+		return ErrWrongGroup
+	}
+	if commit.Epoch != g.current.epoch {
+		return fmt.Errorf("%w: commit for epoch %d, we are at %d", ErrWrongEpoch, commit.Epoch, g.current.epoch)
+	}
+
+	if g.pending != nil && bytes.Equal(commit.Signature, g.pendingSig) {
+		g.previous = g.current
+		g.current = g.pending
+		g.pending = nil
+		g.pendingSig = nil
+		return nil
+	}
+
+	// Someone else's commit made it first, so ours is void
+	g.pending = nil
+	g.pendingSig = nil
+
+	sender, ok := g.current.tree.leaf(commit.Sender)
+	if !ok || !verify(sender.SigningKey, commit.signedData(), commit.Signature) {
+		return fmt.Errorf("%w: commit from leaf %d", ErrInvalidSignature, commit.Sender)
+	}
+	if commit.Sender == g.current.ownLeaf {
+		return fmt.Errorf("%w: commit from us that we did not create", ErrInvalidCommit)
+	}
+	if commit.Path.Leaf.Address() != sender.Address() || !bytes.Equal(commit.Path.Leaf.SigningKey, sender.SigningKey) {
+		return fmt.Errorf("%w: committer changed its identity", ErrInvalidCommit)
+	}
+
+	next := g.current.clone()
+	own, _ := next.tree.leaf(next.ownLeaf)
+
+	for _, address := range commit.Removes {
+		if address == own.Address() {
+			return ErrRemoved
+		}
+
+		if index, ok := next.tree.findLeaf(address); ok {
+			next.removeLeaf(index)
+		}
+	}
+
+	added := make(map[uint32]bool)
+	for _, kp := range commit.Adds {
+		if kp.GroupID != g.ID || !kp.Verify() {
+			return fmt.Errorf("%w: invalid key package for %s", ErrInvalidCommit, kp.Leaf.Username)
+		}
+		added[next.tree.addLeaf(kp.Leaf)] = true
+	}
+
+	senderNode := 2 * commit.Sender
+	path := next.tree.directPath(senderNode)
+	copath := next.tree.copath(senderNode)
+	if len(commit.Path.Nodes) != len(path) {
+		return fmt.Errorf("%w: update path has %d nodes, expected %d", ErrInvalidCommit, len(commit.Path.Nodes), len(path))
+	}
+
+	// Resolutions are computed before the committer's path is applied,
+	// exactly like the committer did.
+	resolutions := make([][]uint32, len(copath))
+	for i, c := range copath {
+		resolutions[i] = next.tree.resolution(c)
+	}
+
+	leaf := commit.Path.Leaf
+	next.tree[senderNode] = Node{Leaf: &leaf}
+	for i, p := range path {
+		next.tree[p] = Node{PublicKey: commit.Path.Nodes[i].PublicKey}
+	}
+
+	ownNode := 2 * next.ownLeaf
+	context := pathContext(g.ID, g.current.epoch)
+
+	for i := range path {
+		if !inSubtree(ownNode, copath[i]) {
+			continue
+		}
+
+		pathSecret, err := next.openPathSecret(commit.Path.Nodes[i], resolutions[i], added, context)
+		if err != nil {
+			return err
+		}
+
+		last, err := next.derivePath(path[i:], pathSecret)
+		if err != nil {
+			return err
+		}
+
+		next.epoch++
+		next.advance(g.ID, deriveSecret(last, "commit"))
+		g.previous = g.current
+		g.current = next
+
+		return nil
+	}
+
+	return ErrCannotDecrypt
+}
+
+func (s *state) openPathSecret(node UpdatePathNode, resolution []uint32, added map[uint32]bool, context []byte) ([]byte, error) {
+	for _, encrypted := range node.EncryptedSecrets {
+		if !slices.Contains(resolution, encrypted.Node) {
+			continue
+		}
+		if level(encrypted.Node) == 0 && added[encrypted.Node/2] {
+			continue
+		}
+
+		key, ok := s.privateKeys[encrypted.Node]
+		if !ok {
+			continue
+		}
+
+		return open(key, encrypted.KEMCiphertext, encrypted.Nonce, encrypted.Ciphertext, context)
+	}
+
+	return nil, ErrCannotDecrypt
+}
+
+// Derives the keys of `path` from the path secret of its first node,
+// making sure they match the public keys in the tree. Returns the last path secret.
+func (s *state) derivePath(path []uint32, pathSecret []byte) ([]byte, error) {
+	for i, p := range path {
+		if i > 0 {
+			pathSecret = deriveSecret(pathSecret, "path")
+		}
+
+		nodeKey, err := deriveNodeKey(pathSecret)
+		if err != nil {
+			return nil, err
+		}
+
+		if !bytes.Equal(nodeKey.EncapsulationKey().Bytes(), s.tree[p].PublicKey) {
+			return nil, fmt.Errorf("%w: public key of node %d does not match its path secret", ErrInvalidCommit, p)
+		}
+
+		s.privateKeys[p] = nodeKey
+	}
+
+	return pathSecret, nil
+}
+
+// Encrypts `plaintext` once for every member of the current epoch
+func (g *Group) Encrypt(plaintext []byte) (ApplicationMessage, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	nonce, ciphertext, err := cryptography.EncryptMessage(g.current.applicationKey(), plaintext)
+	if err != nil {
+		return ApplicationMessage{}, err
+	}
+
+	msg := ApplicationMessage{
+		GroupID:    g.ID,
+		Epoch:      g.current.epoch,
+		Sender:     g.current.ownLeaf,
+		Nonce:      nonce,
+		Ciphertext: ciphertext,
+	}
+	msg.Signature = ed25519.Sign(g.signingKey, msg.signedData())
+
+	return msg, nil
+}
+
+// Decrypts a message of the current (or previous) epoch and returns who sent it
+func (g *Group) Decrypt(msg ApplicationMessage) (LeafNode, []byte, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if msg.GroupID != g.ID {
+		return LeafNode{}, nil, ErrWrongGroup
+	}
+
+	s := g.current
+	if msg.Epoch != s.epoch {
+		if g.previous == nil || msg.Epoch != g.previous.epoch {
+			return LeafNode{}, nil, fmt.Errorf("%w: message for epoch %d, we are at %d", ErrWrongEpoch, msg.Epoch, g.current.epoch)
+		}
+		s = g.previous
+	}
+
+	sender, ok := s.tree.leaf(msg.Sender)
+	if !ok || !verify(sender.SigningKey, msg.signedData(), msg.Signature) {
+		return LeafNode{}, nil, fmt.Errorf("%w: message from leaf %d", ErrInvalidSignature, msg.Sender)
+	}
+
+	plaintext, err := cryptography.DecryptMessage(s.applicationKey(), msg.Nonce, msg.Ciphertext)
+	if err != nil {
+		return LeafNode{}, nil, err
+	}
+
+	return sender, plaintext, nil
+}
+
+func (s *state) removeLeaf(index uint32) {
+	s.tree.removeLeaf(index)
+	delete(s.privateKeys, 2*index)
+	for _, p := range s.tree.directPath(2 * index) {
+		delete(s.privateKeys, p)
+	}
+}
+
+func pathContext(groupID string, epoch uint64) []byte {
+	return binary.BigEndian.AppendUint64([]byte("group-path-secret"+groupID), epoch)
+}
+
+func welcomeContext(groupID string, epoch uint64) []byte {
+	return binary.BigEndian.AppendUint64([]byte("group-welcome"+groupID), epoch)
+}
+
+func deriveSecret(secret []byte, label string) []byte {
+	return cryptography.DeriveKeyWithInfo(secret, []byte("group-"+label))
+}
+
+// Node key pairs are derived deterministically from their path secret
+func deriveNodeKey(pathSecret []byte) (*mlkem.DecapsulationKey768, error) {
+	seed := cryptography.DeriveBytes(pathSecret, []byte("group-node-key"), mlkem.SeedSize)
+	return mlkem.NewDecapsulationKey768(seed)
+}
+
+// Encrypts `plaintext` to an ML-KEM public key (KEM + AEAD, HPKE style)
+func seal(publicKey, plaintext, context []byte) (EncryptedSecret, error) {
+	ek, err := mlkem.NewEncapsulationKey768(publicKey)
+	if err != nil {
+		return EncryptedSecret{}, err
+	}
+
+	sharedSecret, kemCiphertext := ek.Encapsulate()
+	key := cryptography.DeriveKeyWithInfo(sharedSecret, context)
+
+	nonce, ciphertext, err := cryptography.EncryptMessage(key, plaintext)
+	if err != nil {
+		return EncryptedSecret{}, err
+	}
+
+	return EncryptedSecret{KEMCiphertext: kemCiphertext, Nonce: nonce, Ciphertext: ciphertext}, nil
+}
+
+func open(key *mlkem.DecapsulationKey768, kemCiphertext, nonce, ciphertext, context []byte) ([]byte, error) {
+	sharedSecret, err := key.Decapsulate(kemCiphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	return cryptography.DecryptMessage(cryptography.DeriveKeyWithInfo(sharedSecret, context), nonce, ciphertext)
+}
+
+func randomSecret() []byte {
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	return secret
+}
@@ -0,0 +1,496 @@
+package group
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/mlkem"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+type member struct {
+	name       string
+	signingKey ed25519.PrivateKey
+	kp         KeyPackage
+	leafKey    *mlkem.DecapsulationKey768
+	group      *Group
+}
+
+func newMember(t *testing.T, name string) *member {
+	t.Helper()
+
+	_, signingKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kp, leafKey, err := NewKeyPackage(DefaultGroupID, name, name+"-device", signingKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &member{name: name, signingKey: signingKey, kp: kp, leafKey: leafKey}
+}
+
+func (m *member) address() string {
+	return m.kp.Leaf.Address()
+}
+
+// Goes through JSON like everything the server relays
+func relay[T any](t *testing.T, v T) T {
+	t.Helper()
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var relayed T
+	if err := json.Unmarshal(data, &relayed); err != nil {
+		t.Fatal(err)
+	}
+	return relayed
+}
+
+// `committer` adds and removes members, everyone else in `others` processes
+// the commit and the new members join with their welcome
+func commit(t *testing.T, committer *member, others []*member, adds []*member, removes []*member) CommitBundle {
+	t.Helper()
+
+	kps := make([]KeyPackage, 0, len(adds))
+	for _, m := range adds {
+		kps = append(kps, m.kp)
+	}
+	addresses := make([]string, 0, len(removes))
+	for _, m := range removes {
+		addresses = append(addresses, m.address())
+	}
+
+	bundle, err := committer.group.Commit(kps, addresses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle = relay(t, bundle)
+
+	if err := committer.group.ProcessCommit(bundle.Commit); err != nil {
+		t.Fatalf("%s processing its own commit: %v", committer.name, err)
+	}
+	for _, m := range others {
+		if err := m.group.ProcessCommit(bundle.Commit); err != nil {
+			t.Fatalf("%s processing the commit of %s: %v", m.name, committer.name, err)
+		}
+	}
+
+	for _, m := range adds {
+		welcome, ok := welcomeFor(bundle, m)
+		if !ok {
+			t.Fatalf("no welcome for %s", m.name)
+		}
+
+		g, err := Join(welcome, m.kp, m.leafKey, m.signingKey)
+		if err != nil {
+			t.Fatalf("%s joining: %v", m.name, err)
+		}
+		m.group = g
+	}
+
+	return bundle
+}
+
+func welcomeFor(bundle CommitBundle, m *member) (Welcome, bool) {
+	for _, welcome := range bundle.Welcomes {
+		if welcome.To == m.address() {
+			return welcome, true
+		}
+	}
+	return Welcome{}, false
+}
+
+// A group of `n` members, created by the first one, who added the others
+// one at a time (so the tree has blank and unmerged nodes along the way)
+func newTestGroup(t *testing.T, n int) []*member {
+	t.Helper()
+
+	members := make([]*member, 0, n)
+	for i := range n {
+		members = append(members, newMember(t, fmt.Sprintf("user%d", i)))
+	}
+
+	creator := members[0]
+	creator.group = Create(DefaultGroupID, creator.kp, creator.leafKey, creator.signingKey)
+
+	for i := 1; i < n; i++ {
+		commit(t, creator, members[1:i], []*member{members[i]}, nil)
+	}
+
+	return members
+}
+
+// Every member can read what every other member sends
+func checkEveryoneTalks(t *testing.T, members []*member) {
+	t.Helper()
+
+	for _, sender := range members {
+		plaintext := []byte("hello from " + sender.name)
+		msg, err := sender.group.Encrypt(plaintext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg = relay(t, msg)
+
+		for _, recipient := range members {
+			from, decrypted, err := recipient.group.Decrypt(msg)
+			if err != nil {
+				t.Fatalf("%s decrypting a message of %s: %v", recipient.name, sender.name, err)
+			}
+			if !bytes.Equal(decrypted, plaintext) || from.Address() != sender.address() {
+				t.Errorf("%s decrypted %q from %s, want %q from %s", recipient.name, decrypted, from.Address(), plaintext, sender.address())
+			}
+		}
+	}
+}
+
+func TestGroupMembership(t *testing.T) {
+	for _, n := range []int{1, 2, 3, 5, 8} {
+		t.Run(fmt.Sprintf("%d members", n), func(t *testing.T) {
+			members := newTestGroup(t, n)
+
+			for _, m := range members {
+				if epoch := m.group.Epoch(); epoch != uint64(n-1) {
+					t.Errorf("%s is at epoch %d, want %d", m.name, epoch, n-1)
+				}
+				if count := len(m.group.Members()); count != n {
+					t.Errorf("%s sees %d members, want %d", m.name, count, n)
+				}
+			}
+
+			checkEveryoneTalks(t, members)
+		})
+	}
+}
+
+// Members other than the creator commit too (key updates, adds and removes)
+func TestGroupCommitsFromEveryone(t *testing.T) {
+	members := newTestGroup(t, 4)
+
+	for i, committer := range members {
+		others := append(append([]*member{}, members[:i]...), members[i+1:]...)
+		commit(t, committer, others, nil, nil)
+		checkEveryoneTalks(t, members)
+	}
+
+	newcomer := newMember(t, "newcomer")
+	commit(t, members[2], append([]*member{members[0], members[1]}, members[3]), []*member{newcomer}, nil)
+	members = append(members, newcomer)
+	checkEveryoneTalks(t, members)
+}
+
+func TestGroupRemove(t *testing.T) {
+	members := newTestGroup(t, 4)
+	removed := members[1]
+	remaining := []*member{members[0], members[2], members[3]}
+
+	bundle, err := members[0].group.Commit(nil, []string{removed.address()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := members[0].group.ProcessCommit(bundle.Commit); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range remaining[1:] {
+		if err := m.group.ProcessCommit(bundle.Commit); err != nil {
+			t.Fatalf("%s processing the removal: %v", m.name, err)
+		}
+	}
+
+	if err := removed.group.ProcessCommit(bundle.Commit); !errors.Is(err, ErrRemoved) {
+		t.Errorf("removed member processing its removal: %v, want %v", err, ErrRemoved)
+	}
+
+	checkEveryoneTalks(t, remaining)
+
+	// The removed member can't read what is sent after it left
+	msg, err := members[0].group.Encrypt([]byte("after the removal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := removed.group.Decrypt(msg); err == nil {
+		t.Error("removed member decrypted a message of the next epoch")
+	}
+
+	// Nor decrypt the path secrets of the next commits
+	next, err := members[2].group.Commit(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next.Commit.Epoch = removed.group.Epoch()
+	if err := removed.group.ProcessCommit(next.Commit); err == nil {
+		t.Error("removed member processed a commit made after its removal")
+	}
+}
+
+func TestGroupDecryptEpochs(t *testing.T) {
+	members := newTestGroup(t, 3)
+	alice, bob := members[0], members[1]
+
+	old, err := alice.group.Encrypt([]byte("epoch 2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commit(t, bob, []*member{alice, members[2]}, nil, nil)
+
+	// Sent right before the commit: still readable
+	if _, plaintext, err := members[2].group.Decrypt(old); err != nil || string(plaintext) != "epoch 2" {
+		t.Errorf("Decrypt() of the previous epoch = %q, %v", plaintext, err)
+	}
+
+	commit(t, bob, []*member{alice, members[2]}, nil, nil)
+
+	// Two epochs ago: the keys are gone
+	if _, _, err := members[2].group.Decrypt(old); !errors.Is(err, ErrWrongEpoch) {
+		t.Errorf("Decrypt() of two epochs ago = %v, want %v", err, ErrWrongEpoch)
+	}
+}
+
+func TestGroupTamperedMessages(t *testing.T) {
+	members := newTestGroup(t, 3)
+	alice, bob := members[0], members[1]
+
+	msg, err := alice.group.Encrypt([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		change func(m *ApplicationMessage)
+		err    error
+	}{
+		{"other group", func(m *ApplicationMessage) { m.GroupID = "other" }, ErrWrongGroup},
+		{"future epoch", func(m *ApplicationMessage) { m.Epoch++ }, ErrWrongEpoch},
+		{"other sender", func(m *ApplicationMessage) { m.Sender = 2 }, ErrInvalidSignature},
+		{"unknown sender", func(m *ApplicationMessage) { m.Sender = 42 }, ErrInvalidSignature},
+		{"ciphertext", func(m *ApplicationMessage) { m.Ciphertext[0] ^= 1 }, ErrInvalidSignature},
+		{"nonce", func(m *ApplicationMessage) { m.Nonce[0] ^= 1 }, ErrInvalidSignature},
+		{"signature", func(m *ApplicationMessage) { m.Signature[0] ^= 1 }, ErrInvalidSignature},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tampered := relay(t, msg)
+			tt.change(&tampered)
+
+			if _, _, err := bob.group.Decrypt(tampered); !errors.Is(err, tt.err) {
+				t.Errorf("Decrypt() = %v, want %v", err, tt.err)
+			}
+		})
+	}
+
+	// A member re-signing a message with the key of the epoch can't pass it off as someone else's
+	forged, err := bob.group.Encrypt([]byte("I am alice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged.Sender = 0
+	if _, _, err := members[2].group.Decrypt(forged); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Decrypt() of a forged sender = %v, want %v", err, ErrInvalidSignature)
+	}
+}
+
+func TestGroupTamperedCommits(t *testing.T) {
+	members := newTestGroup(t, 3)
+	alice, bob, carol := members[0], members[1], members[2]
+
+	bundle, err := alice.group.Commit(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice.group.DiscardPendingCommit()
+
+	mallory := newMember(t, "mallory")
+
+	tests := []struct {
+		name   string
+		change func(c *Commit)
+		err    error
+	}{
+		{"other group", func(c *Commit) { c.GroupID = "other" }, ErrWrongGroup},
+		{"old epoch", func(c *Commit) { c.Epoch-- }, ErrWrongEpoch},
+		{"future epoch", func(c *Commit) { c.Epoch++ }, ErrWrongEpoch},
+		{"signature", func(c *Commit) { c.Signature[0] ^= 1 }, ErrInvalidSignature},
+		{"other sender", func(c *Commit) { c.Sender = 1 }, ErrInvalidSignature},
+		{"added member", func(c *Commit) { c.Adds = append(c.Adds, mallory.kp) }, ErrInvalidSignature},
+		{"removed member", func(c *Commit) { c.Removes = append(c.Removes, carol.address()) }, ErrInvalidSignature},
+		{"path key", func(c *Commit) { c.Path.Nodes[0].PublicKey[0] ^= 1 }, ErrInvalidSignature},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tampered := relay(t, bundle.Commit)
+			tt.change(&tampered)
+
+			if err := bob.group.ProcessCommit(tampered); !errors.Is(err, tt.err) {
+				t.Errorf("ProcessCommit() = %v, want %v", err, tt.err)
+			}
+			if epoch := bob.group.Epoch(); epoch != 2 {
+				t.Errorf("a rejected commit moved the group to epoch %d", epoch)
+			}
+		})
+	}
+
+	// The untouched commit is still fine
+	if err := bob.group.ProcessCommit(bundle.Commit); err != nil {
+		t.Errorf("ProcessCommit() = %v", err)
+	}
+}
+
+// A commit whose path keys don't match the path secrets it carries
+func TestGroupCommitWithWrongPathSecret(t *testing.T) {
+	members := newTestGroup(t, 2)
+	alice, bob := members[0], members[1]
+
+	bundle, err := alice.group.Commit(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Alice signs a path whose public key is not the one of its secret
+	tampered := relay(t, bundle.Commit)
+	tampered.Path.Nodes[0].PublicKey = bytes.Clone(bob.kp.Leaf.EncryptionKey)
+	tampered.Signature = ed25519.Sign(alice.signingKey, tampered.signedData())
+
+	if err := bob.group.ProcessCommit(tampered); !errors.Is(err, ErrInvalidCommit) {
+		t.Errorf("ProcessCommit() = %v, want %v", err, ErrInvalidCommit)
+	}
+}
+
+func TestGroupPendingCommit(t *testing.T) {
+	members := newTestGroup(t, 3)
+	alice, bob, carol := members[0], members[1], members[2]
+
+	if _, err := alice.group.Commit(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !alice.group.HasPendingCommit() {
+		t.Fatal("no pending commit")
+	}
+	if _, err := alice.group.Commit(nil, nil); !errors.Is(err, ErrPendingCommit) {
+		t.Errorf("second Commit() = %v, want %v", err, ErrPendingCommit)
+	}
+
+	// Bob's commit reaches the server first: Alice's is void
+	commit(t, bob, []*member{alice, carol}, nil, nil)
+	if alice.group.HasPendingCommit() {
+		t.Error("the pending commit survived a commit of someone else")
+	}
+	checkEveryoneTalks(t, members)
+}
+
+func TestGroupJoin(t *testing.T) {
+	members := newTestGroup(t, 2)
+	alice, bob := members[0], members[1]
+	carol, dave := newMember(t, "carol"), newMember(t, "dave")
+
+	bundle, err := alice.group.Commit([]KeyPackage{carol.kp}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := alice.group.ProcessCommit(bundle.Commit); err != nil {
+		t.Fatal(err)
+	}
+	if err := bob.group.ProcessCommit(bundle.Commit); err != nil {
+		t.Fatal(err)
+	}
+	welcome, ok := welcomeFor(bundle, carol)
+	if !ok {
+		t.Fatal("no welcome for carol")
+	}
+
+	t.Run("someone else's welcome", func(t *testing.T) {
+		if _, err := Join(relay(t, welcome), dave.kp, dave.leafKey, dave.signingKey); err == nil {
+			t.Error("Join() with the welcome of someone else succeeded")
+		}
+	})
+
+	t.Run("tampered welcome", func(t *testing.T) {
+		tampered := relay(t, welcome)
+		tampered.Epoch++
+		if _, err := Join(tampered, carol.kp, carol.leafKey, carol.signingKey); err == nil {
+			t.Error("Join() with a welcome of another epoch succeeded")
+		}
+	})
+
+	t.Run("welcome", func(t *testing.T) {
+		g, err := Join(relay(t, welcome), carol.kp, carol.leafKey, carol.signingKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		carol.group = g
+		checkEveryoneTalks(t, []*member{alice, bob, carol})
+	})
+}
+
+func TestKeyPackageVerify(t *testing.T) {
+	m := newMember(t, "alice")
+	if !m.kp.Verify() {
+		t.Fatal("valid key package does not verify")
+	}
+
+	tests := []struct {
+		name   string
+		change func(kp *KeyPackage)
+	}{
+		{"group", func(kp *KeyPackage) { kp.GroupID = "other" }},
+		{"username", func(kp *KeyPackage) { kp.Leaf.Username = "mallory" }},
+		{"encryption key", func(kp *KeyPackage) { kp.Leaf.EncryptionKey[0] ^= 1 }},
+		{"signature", func(kp *KeyPackage) { kp.Signature[0] ^= 1 }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kp := relay(t, m.kp)
+			tt.change(&kp)
+			if kp.Verify() {
+				t.Error("tampered key package verified")
+			}
+		})
+	}
+}
+
+func TestTreeMath(t *testing.T) {
+	// RFC 9420, appendix C: a tree of 8 leaves
+	tests := []struct {
+		x      uint32
+		level  uint32
+		parent uint32
+		sib    uint32
+	}{
+		{0, 0, 1, 2},
+		{2, 0, 1, 0},
+		{1, 1, 3, 5},
+		{5, 1, 3, 1},
+		{3, 2, 7, 11},
+		{11, 2, 7, 3},
+		{14, 0, 13, 12},
+	}
+
+	tree := make(Tree, nodeWidth(8))
+	if root(8) != 7 || len(tree) != 15 {
+		t.Fatalf("tree of 8 leaves: root %d, width %d", root(8), len(tree))
+	}
+
+	for _, tt := range tests {
+		if got := level(tt.x); got != tt.level {
+			t.Errorf("level(%d) = %d, want %d", tt.x, got, tt.level)
+		}
+		if got := parent(tt.x); got != tt.parent {
+			t.Errorf("parent(%d) = %d, want %d", tt.x, got, tt.parent)
+		}
+		if got := sibling(tt.x); got != tt.sib {
+			t.Errorf("sibling(%d) = %d, want %d", tt.x, got, tt.sib)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package group
+
+import (
+	"crypto/ed25519"
+	"crypto/mlkem"
+	"encoding/binary"
+	"encoding/json"
+)
+
+// Id of the group shared by everyone connected to the server
+const DefaultGroupID = "general"
+
+// Published by a client that wants to join a group
+type KeyPackage struct {
+	GroupID   string   `json:"group_id"`
+	Leaf      LeafNode `json:"leaf"`
+	Signature []byte   `json:"signature"`
+}
+
+func (kp KeyPackage) signedData() []byte {
+	marshalled, _ := json.Marshal(kp.Leaf)
+	return append([]byte("group-key-package"+kp.GroupID), marshalled...)
+}
+
+func (kp KeyPackage) Verify() bool {
+	return verify(kp.Leaf.SigningKey, kp.signedData(), kp.Signature)
+}
+
+// Path secret encrypted to the ML-KEM key of a node
+type EncryptedSecret struct {
+	Node          uint32 `json:"node"`
+	KEMCiphertext []byte `json:"kem_ciphertext"`
+	Nonce         []byte `json:"nonce"`
+	Ciphertext    []byte `json:"ciphertext"`
+}
+
+type UpdatePathNode struct {
+	PublicKey        []byte            `json:"public_key"`
+	EncryptedSecrets []EncryptedSecret `json:"encrypted_secrets"`
+}
+
+// New keys for the committer leaf and every node of its direct path
+type UpdatePath struct {
+	Leaf  LeafNode         `json:"leaf"`
+	Nodes []UpdatePathNode `json:"nodes"`
+}
+
+// Moves the group from `Epoch` to the next one
+type Commit struct {
+	GroupID   string       `json:"group_id"`
+	Epoch     uint64       `json:"epoch"`
+	Sender    uint32       `json:"sender"`
+	Adds      []KeyPackage `json:"adds"`
+	Removes   []string     `json:"removes"` // device addresses
+	Path      UpdatePath   `json:"path"`
+	Signature []byte       `json:"signature"`
+}
+
+func (c Commit) signedData() []byte {
+	unsigned := c
+	unsigned.Signature = nil
+	marshalled, _ := json.Marshal(unsigned)
+	return append([]byte("group-commit"), marshalled...)
+}
+
+// Secrets a new member needs to join the group
+type groupSecrets struct {
+	EpochSecret []byte `json:"epoch_secret"`
+	PathSecret  []byte `json:"path_secret"`
+	PathNode    uint32 `json:"path_node"`
+}
+
+// Sent to every member added by a commit
+type Welcome struct {
+	GroupID       string `json:"group_id"`
+	Epoch         uint64 `json:"epoch"`
+	To            string `json:"to"` // device address
+	Tree          Tree   `json:"tree"`
+	KEMCiphertext []byte `json:"kem_ciphertext"`
+	Nonce         []byte `json:"nonce"`
+	Ciphertext    []byte `json:"ciphertext"`
+}
+
+// A commit along with the welcomes of the members it adds
+type CommitBundle struct {
+	Commit   Commit    `json:"commit"`
+	Welcomes []Welcome `json:"welcomes"`
+}
+
+// Changes the server wants a member to commit
+type Proposals struct {
+	GroupID string       `json:"group_id"`
+	Epoch   uint64       `json:"epoch"`
+	Adds    []KeyPackage `json:"adds"`
+	Removes []string     `json:"removes"` // device addresses
+}
+
+// Message encrypted once for the whole group
+type ApplicationMessage struct {
+	GroupID    string `json:"group_id"`
+	Epoch      uint64 `json:"epoch"`
+	Sender     uint32 `json:"sender"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+	Signature  []byte `json:"signature"`
+}
+
+func (m ApplicationMessage) signedData() []byte {
+	data := []byte("group-application-message" + m.GroupID)
+	data = binary.BigEndian.AppendUint64(data, m.Epoch)
+	data = binary.BigEndian.AppendUint32(data, m.Sender)
+	data = append(data, m.Nonce...)
+	data = append(data, m.Ciphertext...)
+	return data
+}
+
+func NewKeyPackage(groupID, username, device string, signingKey ed25519.PrivateKey) (KeyPackage, *mlkem.DecapsulationKey768, error) {
+	leafKey, err := mlkem.GenerateKey768()
+	if err != nil {
+		return KeyPackage{}, nil, err
+	}
+
+	kp := KeyPackage{
+		GroupID: groupID,
+		Leaf: LeafNode{
+			Username:      username,
+			Device:        device,
+			EncryptionKey: leafKey.EncapsulationKey().Bytes(),
+			SigningKey:    signingKey.Public().(ed25519.PublicKey),
+		},
+	}
+	kp.Signature = ed25519.Sign(signingKey, kp.signedData())
+
+	return kp, leafKey, nil
+}
+
+func verify(signingKey, data, signature []byte) bool {
+	if len(signingKey) != ed25519.PublicKeySize {
+		return false
+	}
+	return ed25519.Verify(ed25519.PublicKey(signingKey), data, signature)
+}
@@ -0,0 +1,245 @@
+package group
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+
+	"github.com/Guilospanck/pqc/core/pkg/devices"
+)
+
+// The ratchet tree uses the array representation of RFC 9420 (appendix C):
+// leaves are the even nodes, parents the odd ones, and the number of leaves
+// is always a power of two (missing members are blank leaves).
+
+// Leaf of the ratchet tree: a member of the group (one device of a user)
+type LeafNode struct {
+	Username      string `json:"username"`
+	Device        string `json:"device"`
+	EncryptionKey []byte `json:"encryption_key"` // ML-KEM
+	SigningKey    []byte `json:"signing_key"`    // Ed25519
+}
+
+// Members are identified by their device address
+func (l LeafNode) Address() string {
+	return devices.Address(l.Username, l.Device)
+}
+
+type Node struct {
+	Leaf      *LeafNode `json:"leaf,omitempty"`       // only for leaves
+	PublicKey []byte    `json:"public_key,omitempty"` // only for parents (ML-KEM)
+	Unmerged  []uint32  `json:"unmerged,omitempty"`   // leaves added below this parent since its key was set
+}
+
+func (n Node) blank() bool {
+	return n.Leaf == nil && n.PublicKey == nil
+}
+
+func (n Node) encryptionKey() []byte {
+	if n.Leaf != nil {
+		return n.Leaf.EncryptionKey
+	}
+	return n.PublicKey
+}
+
+type Tree []Node
+
+func log2(x uint32) uint32 {
+	if x == 0 {
+		return 0
+	}
+
+	k := uint32(0)
+	for (x >> k) > 0 {
+		k++
+	}
+	return k - 1
+}
+
+func level(x uint32) uint32 {
+	k := uint32(0)
+	for (x>>k)&1 == 1 {
+		k++
+	}
+	return k
+}
+
+func nodeWidth(leaves uint32) uint32 {
+	if leaves == 0 {
+		return 0
+	}
+	return 2*(leaves-1) + 1
+}
+
+func root(leaves uint32) uint32 {
+	return (1 << log2(nodeWidth(leaves))) - 1
+}
+
+func left(x uint32) uint32 {
+	return x ^ (1 << (level(x) - 1))
+}
+
+func right(x uint32) uint32 {
+	return x ^ (3 << (level(x) - 1))
+}
+
+func parent(x uint32) uint32 {
+	k := level(x)
+	b := (x >> (k + 1)) & 1
+	return (x | (1 << k)) ^ (b << (k + 1))
+}
+
+func sibling(x uint32) uint32 {
+	p := parent(x)
+	if x < p {
+		return right(p)
+	}
+	return left(p)
+}
+
+// Whether `x` is `ancestor` or one of its descendants
+func inSubtree(x, ancestor uint32) bool {
+	span := uint32(1)<<level(ancestor) - 1
+	return x >= ancestor-span && x <= ancestor+span
+}
+
+func (t Tree) leafCount() uint32 {
+	return (uint32(len(t)) + 1) / 2
+}
+
+// Parents of `x`, from the closest one up to the root
+func (t Tree) directPath(x uint32) []uint32 {
+	r := root(t.leafCount())
+	path := make([]uint32, 0)
+
+	for x != r {
+		x = parent(x)
+		path = append(path, x)
+	}
+
+	return path
+}
+
+// Siblings of `x` and of each node of its direct path (except the root)
+func (t Tree) copath(x uint32) []uint32 {
+	path := t.directPath(x)
+	if len(path) == 0 {
+		return []uint32{}
+	}
+
+	nodes := append([]uint32{x}, path[:len(path)-1]...)
+	copath := make([]uint32, 0, len(nodes))
+	for _, n := range nodes {
+		copath = append(copath, sibling(n))
+	}
+
+	return copath
+}
+
+// Smallest set of non-blank nodes covering every member below `x`
+func (t Tree) resolution(x uint32) []uint32 {
+	node := t[x]
+
+	if !node.blank() {
+		nodes := []uint32{x}
+		for _, leaf := range node.Unmerged {
+			nodes = append(nodes, 2*leaf)
+		}
+		return nodes
+	}
+
+	if level(x) == 0 {
+		return []uint32{}
+	}
+
+	return append(t.resolution(left(x)), t.resolution(right(x))...)
+}
+
+// Puts `leaf` in the first blank leaf, doubling the tree if it is full
+func (t *Tree) addLeaf(leaf LeafNode) uint32 {
+	index := uint32(0)
+	for ; index < t.leafCount(); index++ {
+		if (*t)[2*index].blank() {
+			break
+		}
+	}
+
+	if index == t.leafCount() {
+		leaves := max(1, 2*t.leafCount())
+		grown := make(Tree, nodeWidth(leaves))
+		copy(grown, *t)
+		*t = grown
+	}
+
+	(*t)[2*index] = Node{Leaf: &leaf}
+
+	// Parents above the new leaf don't know about it yet
+	for _, p := range t.directPath(2 * index) {
+		if !(*t)[p].blank() {
+			(*t)[p].Unmerged = append((*t)[p].Unmerged, index)
+		}
+	}
+
+	return index
+}
+
+// Removes a member: its leaf and every parent it knows the key of are blanked
+func (t Tree) removeLeaf(index uint32) {
+	t[2*index] = Node{}
+	for _, p := range t.directPath(2 * index) {
+		t[p] = Node{}
+	}
+}
+
+func (t Tree) findLeaf(address string) (uint32, bool) {
+	for i := uint32(0); i < t.leafCount(); i++ {
+		if leaf := t[2*i].Leaf; leaf != nil && leaf.Address() == address {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func (t Tree) findLeafByKey(encryptionKey []byte) (uint32, bool) {
+	for i := uint32(0); i < t.leafCount(); i++ {
+		if leaf := t[2*i].Leaf; leaf != nil && bytes.Equal(leaf.EncryptionKey, encryptionKey) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func (t Tree) leaf(index uint32) (LeafNode, bool) {
+	if index >= t.leafCount() || t[2*index].Leaf == nil {
+		return LeafNode{}, false
+	}
+	return *t[2*index].Leaf, true
+}
+
+func (t Tree) Members() []LeafNode {
+	members := make([]LeafNode, 0, t.leafCount())
+	for i := uint32(0); i < t.leafCount(); i++ {
+		if leaf := t[2*i].Leaf; leaf != nil {
+			members = append(members, *leaf)
+		}
+	}
+	return members
+}
+
+func (t Tree) hash() []byte {
+	marshalled, _ := json.Marshal(t)
+	sum := sha256.Sum256(marshalled)
+	return sum[:]
+}
+
+func (t Tree) clone() Tree {
+	cloned := make(Tree, len(t))
+	for i, node := range t {
+		cloned[i] = Node{
+			Leaf:      node.Leaf,
+			PublicKey: node.PublicKey,
+			Unmerged:  append([]uint32(nil), node.Unmerged...),
+		}
+	}
+	return cloned
+}
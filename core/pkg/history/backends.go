@@ -0,0 +1,63 @@
+package history
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/Guilospanck/pqc/core/pkg/jsonl"
+)
+
+// Keeps the records until the server stops
+type MemoryBackend struct {
+	records []Record
+	mu      sync.Mutex
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{records: make([]Record, 0)}
+}
+
+func (b *MemoryBackend) Append(record Record) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.records = append(b.records, record)
+	return nil
+}
+
+func (b *MemoryBackend) Records() ([]Record, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return slices.Clone(b.records), nil
+}
+
+func (b *MemoryBackend) Replace(records []Record) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.records = slices.Clone(records)
+	return nil
+}
+
+// Keeps the records in a file, one JSON record per line
+type FileBackend struct {
+	file *jsonl.File[Record]
+}
+
+func NewFileBackend(path string) *FileBackend {
+	return &FileBackend{file: jsonl.New[Record](path, false)}
+}
+
+func (b *FileBackend) Append(record Record) error {
+	return b.file.Append(record)
+}
+
+func (b *FileBackend) Records() ([]Record, error) {
+	return b.file.All()
+}
+
+// The history is never left half rewritten
+func (b *FileBackend) Replace(records []Record) error {
+	return b.file.Replace(records)
+}
@@ -0,0 +1,266 @@
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Guilospanck/pqc/core/pkg/cryptography"
+	"github.com/Guilospanck/pqc/core/pkg/types"
+)
+
+// Messages relayed by the server, kept encrypted at rest under the server
+// storage keys. Every record says which key encrypted it, so the current
+// key can be rotated (re-encrypting everything) without losing anything.
+
+var (
+	ErrUnknownKey = errors.New("record encrypted with an unknown storage key")
+	ErrNotFound   = errors.New("message not in the history")
+)
+
+type Message struct {
+	Username string            `json:"username"`
+	Color    string            `json:"color"`
+	Payload  types.ChatMessage `json:"payload"`
+	Time     time.Time         `json:"time"`
+
+	// Who reacted with each emoji
+	Reactions map[string][]string `json:"reactions,omitempty"`
+}
+
+// A message encrypted under one of the storage keys
+type Record struct {
+	KeyID      string `json:"key_id"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// Where the records are kept, in the order they were appended
+type Backend interface {
+	Append(record Record) error
+	Records() ([]Record, error)
+	Replace(records []Record) error
+}
+
+type Store struct {
+	backend Backend
+	keys    *Keys
+	mu      sync.Mutex
+}
+
+func NewStore(backend Backend, keys *Keys) *Store {
+	return &Store{backend: backend, keys: keys}
+}
+
+func (s *Store) Add(msg Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, err := s.seal(msg)
+	if err != nil {
+		return err
+	}
+
+	return s.backend.Append(record)
+}
+
+// The last `limit` messages sent after `since`, oldest first
+func (s *Store) Recent(limit int, since time.Time) ([]Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records, err := s.backend.Records()
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]Message, 0, limit)
+	for i := len(records) - 1; i >= 0 && len(messages) < limit; i-- {
+		msg, err := s.open(records[i])
+		if err != nil {
+			return nil, err
+		}
+
+		if !msg.Time.After(since) {
+			break
+		}
+		messages = append(messages, msg)
+	}
+
+	// We went backwards
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+
+	return messages, nil
+}
+
+// The message whose chat message id is `id`
+func (s *Store) Get(id string) (Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records, err := s.backend.Records()
+	if err != nil {
+		return Message{}, err
+	}
+
+	for i := len(records) - 1; i >= 0; i-- {
+		msg, err := s.open(records[i])
+		if err != nil {
+			return Message{}, err
+		}
+
+		if msg.Payload.ID == id {
+			return msg, nil
+		}
+	}
+
+	return Message{}, ErrNotFound
+}
+
+// Replaces the body of the message `id`
+func (s *Store) Edit(id, body string) error {
+	return s.rewrite(id, func(msg *Message) bool {
+		msg.Payload.Body = body
+		return true
+	})
+}
+
+func (s *Store) Delete(id string) error {
+	return s.rewrite(id, func(*Message) bool { return false })
+}
+
+// Adds (or removes) the reaction of `username` to the message `id`
+func (s *Store) React(id, emoji, username string, add bool) error {
+	return s.rewrite(id, func(msg *Message) bool {
+		users := slices.DeleteFunc(msg.Reactions[emoji], func(user string) bool { return user == username })
+		if add {
+			users = append(users, username)
+		}
+
+		if msg.Reactions == nil {
+			msg.Reactions = make(map[string][]string)
+		}
+		msg.Reactions[emoji] = users
+		if len(users) == 0 {
+			delete(msg.Reactions, emoji)
+		}
+
+		return true
+	})
+}
+
+// Saves the history again with `change` applied to the message `id`,
+// which is removed if `change` returns false
+func (s *Store) rewrite(id string, change func(msg *Message) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records, err := s.backend.Records()
+	if err != nil {
+		return err
+	}
+
+	found := false
+	rewritten := make([]Record, 0, len(records))
+	for _, record := range records {
+		msg, err := s.open(record)
+		if err != nil {
+			return err
+		}
+
+		if msg.Payload.ID == id {
+			found = true
+			if !change(&msg) {
+				continue
+			}
+
+			record, err = s.seal(msg)
+			if err != nil {
+				return err
+			}
+		}
+
+		rewritten = append(rewritten, record)
+	}
+
+	if !found {
+		return ErrNotFound
+	}
+
+	return s.backend.Replace(rewritten)
+}
+
+// Encrypts every record again under a new storage key.
+// The old keys are only forgotten once all records are re-encrypted.
+func (s *Store) RotateKey() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records, err := s.backend.Records()
+	if err != nil {
+		return err
+	}
+
+	if err := s.keys.rotate(); err != nil {
+		return err
+	}
+
+	rotated := make([]Record, 0, len(records))
+	for _, record := range records {
+		msg, err := s.open(record)
+		if err != nil {
+			return err
+		}
+
+		record, err := s.seal(msg)
+		if err != nil {
+			return err
+		}
+		rotated = append(rotated, record)
+	}
+
+	if err := s.backend.Replace(rotated); err != nil {
+		return err
+	}
+
+	return s.keys.prune()
+}
+
+func (s *Store) seal(msg Message) (Record, error) {
+	plaintext, err := json.Marshal(msg)
+	if err != nil {
+		return Record{}, err
+	}
+
+	id, key := s.keys.current()
+	nonce, ciphertext, err := cryptography.EncryptMessage(key, plaintext)
+	if err != nil {
+		return Record{}, err
+	}
+
+	return Record{KeyID: id, Nonce: nonce, Ciphertext: ciphertext}, nil
+}
+
+func (s *Store) open(record Record) (Message, error) {
+	key, ok := s.keys.get(record.KeyID)
+	if !ok {
+		return Message{}, fmt.Errorf("%w: %s", ErrUnknownKey, record.KeyID)
+	}
+
+	plaintext, err := cryptography.DecryptMessage(key, record.Nonce, record.Ciphertext)
+	if err != nil {
+		return Message{}, err
+	}
+
+	var msg Message
+	if err := json.Unmarshal(plaintext, &msg); err != nil {
+		return Message{}, err
+	}
+
+	return msg, nil
+}
@@ -0,0 +1,101 @@
+package history
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const keySize = 32
+
+// Storage keys, saved one per line (hex encoded). The last one is the
+// current key, the others are only kept while a rotation is going on.
+// Without a path, the keys only live in memory.
+type Keys struct {
+	path string
+	ids  []string
+	keys map[string][]byte
+}
+
+// Loads the storage keys saved at `path`, generating the first one if needed
+func LoadKeys(path string) (*Keys, error) {
+	k := &Keys{path: path, ids: make([]string, 0), keys: make(map[string][]byte)}
+
+	data, err := os.ReadFile(path)
+	if path == "" || os.IsNotExist(err) {
+		return k, k.rotate()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for line := range strings.FieldsSeq(string(data)) {
+		key, err := hex.DecodeString(line)
+		if err != nil || len(key) != keySize {
+			return nil, fmt.Errorf("invalid storage key in %s", path)
+		}
+		k.add(key)
+	}
+
+	if len(k.ids) == 0 {
+		return k, k.rotate()
+	}
+
+	return k, nil
+}
+
+// Short fingerprint of a key, stored with the records it encrypts
+func keyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4])
+}
+
+func (k *Keys) add(key []byte) {
+	id := keyID(key)
+	k.ids = append(k.ids, id)
+	k.keys[id] = key
+}
+
+func (k *Keys) current() (string, []byte) {
+	id := k.ids[len(k.ids)-1]
+	return id, k.keys[id]
+}
+
+func (k *Keys) get(id string) ([]byte, bool) {
+	key, ok := k.keys[id]
+	return key, ok
+}
+
+// Adds a new current key (and saves it before anything is encrypted with it)
+func (k *Keys) rotate() error {
+	key := make([]byte, keySize)
+	rand.Read(key)
+	k.add(key)
+
+	return k.save()
+}
+
+// Forgets every key but the current one
+func (k *Keys) prune() error {
+	id, key := k.current()
+	k.ids = []string{id}
+	k.keys = map[string][]byte{id: key}
+
+	return k.save()
+}
+
+func (k *Keys) save() error {
+	if k.path == "" {
+		return nil
+	}
+
+	lines := make([]string, 0, len(k.ids))
+	for _, id := range k.ids {
+		lines = append(lines, hex.EncodeToString(k.keys[id]))
+	}
+
+	return os.WriteFile(k.path, []byte(strings.Join(lines, "\n")+"\n"), 0600)
+}
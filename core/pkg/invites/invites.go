@@ -0,0 +1,216 @@
+package invites
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Invite codes let people join a private server. The codes are only shown
+// when minted, the file keeps their SHA-256 hash. The server and the CLI
+// both change the file, so every change happens under a lock file.
+
+var (
+	ErrInvalidInvite = errors.New("invalid, used up or expired invite")
+	ErrNotFound      = errors.New("no such invite")
+	ErrLocked        = errors.New("invites file locked by another process (remove its .lock file if there is none)")
+)
+
+const (
+	codeSize = 16
+
+	// Invites are named by the beginning of their hash
+	idLength = 8
+
+	lockAttempts = 50
+	lockWait     = 20 * time.Millisecond
+)
+
+type Invite struct {
+	ID      string    `json:"id"`
+	Hash    []byte    `json:"hash"`
+	MaxUses int       `json:"max_uses"` // 0 for no limit
+	Used    int       `json:"used"`
+	Expires time.Time `json:"expires,omitzero"` // zero for never
+	Created time.Time `json:"created"`
+	Note    string    `json:"note,omitempty"` // who it is for, for the admins
+}
+
+func (i Invite) Expired() bool {
+	return !i.Expires.IsZero() && time.Now().After(i.Expires)
+}
+
+func (i Invite) UsedUp() bool {
+	return i.MaxUses > 0 && i.Used >= i.MaxUses
+}
+
+// Invites kept in a JSON file
+type Store struct {
+	path string
+}
+
+func NewStore(path string) *Store {
+	return &Store{path: path}
+}
+
+// Creates an invite usable `maxUses` times (0 for no limit) for `ttl`
+// (0 for ever), and returns its code
+func (s *Store) Mint(maxUses int, ttl time.Duration, note string) (string, Invite, error) {
+	if maxUses < 0 || ttl < 0 {
+		return "", Invite{}, errors.New("uses and expiry can't be negative")
+	}
+
+	raw := make([]byte, codeSize)
+	if _, err := rand.Read(raw); err != nil {
+		return "", Invite{}, err
+	}
+	code := hex.EncodeToString(raw)
+
+	hash := sha256.Sum256([]byte(code))
+	invite := Invite{
+		ID:      hex.EncodeToString(hash[:])[:idLength],
+		Hash:    hash[:],
+		MaxUses: maxUses,
+		Created: time.Now(),
+		Note:    note,
+	}
+	if ttl > 0 {
+		invite.Expires = invite.Created.Add(ttl)
+	}
+
+	err := s.update(func(invites []Invite) ([]Invite, error) {
+		return append(invites, invite), nil
+	})
+	if err != nil {
+		return "", Invite{}, err
+	}
+
+	return code, invite, nil
+}
+
+// Spends one use of the invite `code`
+func (s *Store) Use(code string) error {
+	hash := sha256.Sum256([]byte(strings.TrimSpace(code)))
+
+	return s.update(func(invites []Invite) ([]Invite, error) {
+		for i, invite := range invites {
+			if subtle.ConstantTimeCompare(invite.Hash, hash[:]) != 1 {
+				continue
+			}
+			if invite.Expired() || invite.UsedUp() {
+				return nil, ErrInvalidInvite
+			}
+
+			invites[i].Used++
+			return invites, nil
+		}
+
+		return nil, ErrInvalidInvite
+	})
+}
+
+// Revokes the invite with id (or code) `idOrCode`
+func (s *Store) Revoke(idOrCode string) error {
+	hash := sha256.Sum256([]byte(idOrCode))
+
+	return s.update(func(invites []Invite) ([]Invite, error) {
+		i := slices.IndexFunc(invites, func(invite Invite) bool {
+			return invite.ID == idOrCode || subtle.ConstantTimeCompare(invite.Hash, hash[:]) == 1
+		})
+		if i < 0 {
+			return nil, ErrNotFound
+		}
+
+		return slices.Delete(invites, i, i+1), nil
+	})
+}
+
+func (s *Store) List() ([]Invite, error) {
+	return s.load()
+}
+
+// Changes the invites under the lock. Expired and used up invites are
+// dropped on the way.
+func (s *Store) update(change func(invites []Invite) ([]Invite, error)) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	invites, err := s.load()
+	if err != nil {
+		return err
+	}
+
+	invites, err = change(invites)
+	if err != nil {
+		return err
+	}
+
+	invites = slices.DeleteFunc(invites, func(invite Invite) bool {
+		return invite.Expired() || invite.UsedUp()
+	})
+
+	return s.save(invites)
+}
+
+func (s *Store) load() ([]Invite, error) {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return []Invite{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var invites []Invite
+	if err := json.Unmarshal(data, &invites); err != nil {
+		return nil, fmt.Errorf("could not read invites: %w", err)
+	}
+
+	return invites, nil
+}
+
+// Writes the invites next to the old file, then swaps the files,
+// so it is never left half written
+func (s *Store) save(invites []Invite) error {
+	data, err := json.MarshalIndent(invites, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.path)
+}
+
+// Only one process changes the file at a time
+func (s *Store) lock() (func(), error) {
+	path := s.path + ".lock"
+
+	for range lockAttempts {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		time.Sleep(lockWait)
+	}
+
+	return nil, ErrLocked
+}
@@ -0,0 +1,188 @@
+package invites
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	return NewStore(filepath.Join(t.TempDir(), "invites.json"))
+}
+
+func TestMint(t *testing.T) {
+	store := newTestStore(t)
+
+	code, invite, err := store.Mint(2, time.Hour, "for alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(code) != 2*codeSize || len(invite.ID) != idLength || invite.MaxUses != 2 || invite.Note != "for alice" {
+		t.Errorf("Mint() = %q, %+v", code, invite)
+	}
+	if invite.Expires.Sub(invite.Created) != time.Hour {
+		t.Errorf("expires %s after its creation", invite.Expires.Sub(invite.Created))
+	}
+
+	data, err := os.ReadFile(store.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), code) {
+		t.Error("the code is stored as is")
+	}
+
+	if _, invite, err := store.Mint(0, 0, ""); err != nil || !invite.Expires.IsZero() {
+		t.Errorf("Mint() without expiry = %+v, %v", invite, err)
+	}
+	if _, _, err := store.Mint(-1, 0, ""); err == nil {
+		t.Error("Mint() accepted negative uses")
+	}
+
+	invites, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(invites) != 2 {
+		t.Errorf("%d invites listed, want 2", len(invites))
+	}
+}
+
+func TestUse(t *testing.T) {
+	store := newTestStore(t)
+
+	code, _, err := store.Mint(2, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Pasted codes often come with spaces around them
+	if err := store.Use(" " + code + "\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Use(code); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Use(code); !errors.Is(err, ErrInvalidInvite) {
+		t.Errorf("Use() of a used up invite = %v, want %v", err, ErrInvalidInvite)
+	}
+	if err := store.Use("not a code"); !errors.Is(err, ErrInvalidInvite) {
+		t.Errorf("Use() of an unknown code = %v, want %v", err, ErrInvalidInvite)
+	}
+
+	invites, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(invites) != 0 {
+		t.Errorf("used up invite still listed: %+v", invites)
+	}
+}
+
+func TestExpiredInvites(t *testing.T) {
+	store := newTestStore(t)
+
+	code, _, err := store.Mint(0, time.Millisecond, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	if err := store.Use(code); !errors.Is(err, ErrInvalidInvite) {
+		t.Errorf("Use() of an expired invite = %v, want %v", err, ErrInvalidInvite)
+	}
+
+	// Dropped on the next change
+	if _, _, err := store.Mint(0, 0, ""); err != nil {
+		t.Fatal(err)
+	}
+	invites, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(invites) != 1 || invites[0].Expired() {
+		t.Errorf("invites after a change = %+v", invites)
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	store := newTestStore(t)
+
+	code, _, err := store.Mint(0, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, other, err := store.Mint(0, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Revoke(code); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Use(code); !errors.Is(err, ErrInvalidInvite) {
+		t.Errorf("Use() of a revoked invite = %v, want %v", err, ErrInvalidInvite)
+	}
+
+	if err := store.Revoke(other.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Revoke(other.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Revoke() twice = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestConcurrentUses(t *testing.T) {
+	store := newTestStore(t)
+
+	const uses = 5
+	code, _, err := store.Mint(uses, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Two processes (the server and the CLI) can change the file at once
+	var wg sync.WaitGroup
+	results := make(chan error, 2*uses)
+	for range 2 * uses {
+		wg.Go(func() {
+			results <- NewStore(store.path).Use(code)
+		})
+	}
+	wg.Wait()
+	close(results)
+
+	used := 0
+	for err := range results {
+		switch {
+		case err == nil:
+			used++
+		case !errors.Is(err, ErrInvalidInvite):
+			t.Errorf("Use() = %v", err)
+		}
+	}
+	if used != uses {
+		t.Errorf("invite used %d times, want %d", used, uses)
+	}
+
+	if _, err := os.Stat(store.path + ".lock"); !os.IsNotExist(err) {
+		t.Error("the lock file was left behind")
+	}
+}
+
+func TestLocked(t *testing.T) {
+	store := newTestStore(t)
+
+	if err := os.WriteFile(store.path+".lock", nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := store.Mint(0, 0, ""); !errors.Is(err, ErrLocked) {
+		t.Errorf("Mint() while locked = %v, want %v", err, ErrLocked)
+	}
+}
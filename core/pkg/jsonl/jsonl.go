@@ -0,0 +1,117 @@
+package jsonl
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// Longest line we read back
+const maxLineSize = 1024 * 1024
+
+// Values of type T kept in a file, one JSON value per line. They are only
+// appended, or all replaced at once.
+type File[T any] struct {
+	path   string
+	synced bool
+	mu     sync.Mutex
+}
+
+// A file at `path`, created on the first append. If `synced`, appends only
+// return once the value is on disk.
+func New[T any](path string, synced bool) *File[T] {
+	return &File[T]{path: path, synced: synced}
+}
+
+func (f *File[T]) Append(value T) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	line, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+
+	if f.synced {
+		return file.Sync()
+	}
+	return nil
+}
+
+// Every value in the file, none if it doesn't exist yet
+func (f *File[T]) All() ([]T, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	file, err := os.Open(f.path)
+	if os.IsNotExist(err) {
+		return []T{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	values := make([]T, 0)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	for scanner.Scan() {
+		var value T
+		if err := json.Unmarshal(scanner.Bytes(), &value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+
+	return values, scanner.Err()
+}
+
+// Writes the new values next to the old ones, then swaps the files,
+// so the file is never left half rewritten
+func (f *File[T]) Replace(values []T) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	tmp := f.path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(file)
+	for _, value := range values {
+		line, err := json.Marshal(value)
+		if err != nil {
+			file.Close()
+			return err
+		}
+		w.Write(append(line, '\n'))
+	}
+
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if f.synced {
+		if err := file.Sync(); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, f.path)
+}
@@ -0,0 +1,57 @@
+package jsonl
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type testValue struct {
+	ID   int    `json:"id"`
+	Text string `json:"text"`
+}
+
+func TestFile(t *testing.T) {
+	for _, synced := range []bool{false, true} {
+		path := filepath.Join(t.TempDir(), "values.jsonl")
+		f := New[testValue](path, synced)
+
+		values, err := f.All()
+		if err != nil || len(values) != 0 {
+			t.Fatalf("All() before the first append = %v, %v", values, err)
+		}
+
+		for i := range 3 {
+			if err := f.Append(testValue{ID: i, Text: "line\nbreak"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// Read back by another instance, like after a restart
+		values, err = New[testValue](path, synced).All()
+		if err != nil || len(values) != 3 || values[2].ID != 2 || values[2].Text != "line\nbreak" {
+			t.Fatalf("All() = %v, %v", values, err)
+		}
+
+		if err := f.Replace([]testValue{{ID: 7}}); err != nil {
+			t.Fatal(err)
+		}
+		if values, err = f.All(); err != nil || len(values) != 1 || values[0].ID != 7 {
+			t.Errorf("All() after Replace() = %v, %v", values, err)
+		}
+		if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+			t.Error("the temporary file was left behind")
+		}
+	}
+}
+
+func TestFileCorrupted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "values.jsonl")
+	if err := os.WriteFile(path, []byte("{\"id\": 1}\nnot json\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := New[testValue](path, false).All(); err == nil {
+		t.Error("All() read a corrupted file")
+	}
+}
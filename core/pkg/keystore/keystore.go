@@ -0,0 +1,138 @@
+package keystore
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"os"
+
+	"github.com/Guilospanck/pqc/core/pkg/cryptography"
+	"github.com/Guilospanck/pqc/core/pkg/pqxdh"
+	"github.com/Guilospanck/pqc/core/pkg/transparency"
+)
+
+// What a client device must remember to still be itself after a restart:
+// its device id, who it was connected as, its identity and the private keys
+// of its prekeys (otherwise messages sent while it was offline can't be read).
+// The file is encrypted with a key derived from a passphrase of the user,
+// like the local history (see pkg/archive).
+
+var (
+	ErrWrongPassphrase = errors.New("wrong passphrase")
+	ErrModeMismatch    = errors.New("identity saved with another FIPS mode")
+)
+
+const saltSize = 16
+
+type State struct {
+	Device         string                     `json:"device"`
+	Username       string                     `json:"username,omitempty"`
+	Color          string                     `json:"color,omitempty"`
+	ReconnectToken string                     `json:"reconnect_token,omitempty"`
+	Seed           []byte                     `json:"seed,omitempty"` // the identity keys are derived from it
+	Prekeys        *pqxdh.KeyRingState        `json:"prekeys,omitempty"`
+	KeyLog         *transparency.MonitorState `json:"key_log,omitempty"` // the log key we pinned and the last tree head we trust
+}
+
+type file struct {
+	KDF        string `json:"kdf"`
+	Salt       []byte `json:"salt"`
+	FIPS       bool   `json:"fips"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+type Store struct {
+	path string
+	file file
+	key  []byte
+}
+
+// Opens the store at `path` with `passphrase`, creating it if it doesn't
+// exist. Returns the state saved in it (empty for a new store).
+func Open(path, passphrase string) (*Store, State, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		store, err := create(path, passphrase)
+		return store, State{}, err
+	}
+	if err != nil {
+		return nil, State{}, err
+	}
+
+	var f file
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, State{}, err
+	}
+
+	if f.FIPS != cryptography.FIPS() {
+		return nil, State{}, ErrModeMismatch
+	}
+
+	key, err := cryptography.DeriveKeyFromPassphrase(f.KDF, passphrase, f.Salt)
+	if err != nil {
+		return nil, State{}, err
+	}
+
+	plaintext, err := cryptography.DecryptMessage(key, f.Nonce, f.Ciphertext)
+	if err != nil {
+		return nil, State{}, ErrWrongPassphrase
+	}
+
+	var state State
+	if err := json.Unmarshal(plaintext, &state); err != nil {
+		return nil, State{}, err
+	}
+
+	return &Store{path: path, file: f, key: key}, state, nil
+}
+
+func create(path, passphrase string) (*Store, error) {
+	f := file{
+		KDF:  cryptography.PassphraseKDF(),
+		Salt: make([]byte, saltSize),
+		FIPS: cryptography.FIPS(),
+	}
+	rand.Read(f.Salt)
+
+	key, err := cryptography.DeriveKeyFromPassphrase(f.KDF, passphrase, f.Salt)
+	if err != nil {
+		return nil, err
+	}
+
+	// Saved right away, so a wrong passphrase is caught the next time
+	store := &Store{path: path, file: f, key: key}
+	return store, store.Save(State{})
+}
+
+// Replaces the saved state with `state`
+func (s *Store) Save(state State) error {
+	plaintext, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	nonce, ciphertext, err := cryptography.EncryptMessage(s.key, plaintext)
+	if err != nil {
+		return err
+	}
+
+	s.file.Nonce, s.file.Ciphertext = nonce, ciphertext
+	return s.write()
+}
+
+// Writes the file next to the old one, then swaps them, so it is never
+// left half written
+func (s *Store) write() error {
+	data, err := json.Marshal(s.file)
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.path)
+}
@@ -0,0 +1,104 @@
+package keystore
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Guilospanck/pqc/core/pkg/cryptography"
+	"github.com/Guilospanck/pqc/core/pkg/pqxdh"
+	"github.com/Guilospanck/pqc/core/pkg/transparency"
+)
+
+func TestOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "identity")
+
+	store, state, err := Open(path, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Device != "" || state.Prekeys != nil {
+		t.Errorf("new store has a state: %+v", state)
+	}
+
+	// Saved as soon as it is created
+	if _, _, err := Open(path, "wrong horse"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("Open() of a new store with a wrong passphrase = %v, want %v", err, ErrWrongPassphrase)
+	}
+
+	keys, err := cryptography.GenerateKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyRing, err := pqxdh.NewKeyRing(keys.Signing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prekeys := keyRing.State()
+
+	keyLog := transparency.MonitorState{LogKey: bytes.Repeat([]byte{1}, 32), TreeHead: transparency.SignedTreeHead{TreeHead: transparency.TreeHead{Size: 3, RootHash: bytes.Repeat([]byte{2}, 32)}}}
+
+	saved := State{
+		Device:         "d1",
+		Username:       "Amazing Koala",
+		Color:          "#E6194B",
+		ReconnectToken: "a secret token",
+		Seed:           bytes.Repeat([]byte{7}, 32),
+		Prekeys:        &prekeys,
+		KeyLog:         &keyLog,
+	}
+	if err := store.Save(saved); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "a secret token") || strings.Contains(string(data), "Amazing Koala") {
+		t.Error("the state is not encrypted")
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Error("the temporary file was left behind")
+	}
+
+	reopened, state, err := Open(path, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Device != saved.Device || state.Username != saved.Username || state.Color != saved.Color ||
+		state.ReconnectToken != saved.ReconnectToken || !bytes.Equal(state.Seed, saved.Seed) {
+		t.Errorf("Open() = %+v, want %+v", state, saved)
+	}
+	if _, err := pqxdh.RestoreKeyRing(keys.Signing, *state.Prekeys); err != nil {
+		t.Errorf("saved prekeys can't be restored: %v", err)
+	}
+	if state.KeyLog == nil || state.KeyLog.TreeHead.Size != 3 || !bytes.Equal(state.KeyLog.LogKey, keyLog.LogKey) {
+		t.Errorf("saved key log = %+v, want %+v", state.KeyLog, keyLog)
+	}
+
+	// Saving again keeps the passphrase
+	if err := reopened.Save(State{Device: "d1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, state, err := Open(path, "correct horse"); err != nil || state.Username != "" {
+		t.Errorf("Open() after saving again = %+v, %v", state, err)
+	}
+	if _, _, err := Open(path, "wrong horse"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("Open() with a wrong passphrase = %v, want %v", err, ErrWrongPassphrase)
+	}
+}
+
+func TestOpenCorrupted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "identity")
+	if err := os.WriteFile(path, []byte("not json"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := Open(path, "correct horse"); err == nil {
+		t.Error("Open() accepted a corrupted file")
+	}
+}
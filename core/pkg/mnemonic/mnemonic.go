@@ -0,0 +1,91 @@
+package mnemonic
+
+import (
+	"crypto/sha256"
+	_ "embed"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Recovery phrases follow BIP-39: the entropy is followed by the first
+// (entropy bits / 32) bits of its SHA-256 as a checksum, and every 11 bits
+// pick one of the 2048 words of the English word list.
+
+var (
+	ErrInvalidLength   = errors.New("invalid number of words")
+	ErrUnknownWord     = errors.New("unknown word")
+	ErrInvalidChecksum = errors.New("invalid checksum")
+)
+
+//go:embed english.txt
+var english string
+
+var words = strings.Split(strings.TrimSpace(english), "\n")
+
+var wordIndex = func() map[string]int64 {
+	index := make(map[string]int64, len(words))
+	for i, word := range words {
+		index[word] = int64(i)
+	}
+	return index
+}()
+
+const bitsPerWord = 11
+
+// Encodes `entropy` (16 to 32 bytes, a multiple of 4) as a phrase
+func Encode(entropy []byte) (string, error) {
+	if len(entropy) < 16 || len(entropy) > 32 || len(entropy)%4 != 0 {
+		return "", fmt.Errorf("invalid entropy length: %d bytes", len(entropy))
+	}
+
+	checksumBits := uint(len(entropy) / 4)
+	sum := sha256.Sum256(entropy)
+
+	data := new(big.Int).SetBytes(entropy)
+	data.Lsh(data, checksumBits)
+	data.Or(data, big.NewInt(int64(sum[0]>>(8-checksumBits))))
+
+	count := (len(entropy)*8 + int(checksumBits)) / bitsPerWord
+	phrase := make([]string, count)
+	mask := big.NewInt(1<<bitsPerWord - 1)
+	for i := count - 1; i >= 0; i-- {
+		phrase[i] = words[new(big.Int).And(data, mask).Int64()]
+		data.Rsh(data, bitsPerWord)
+	}
+
+	return strings.Join(phrase, " "), nil
+}
+
+// Decodes a phrase back into its entropy, checking the checksum.
+// Words are case insensitive and can be separated by any whitespace.
+func Decode(phrase string) ([]byte, error) {
+	fields := strings.Fields(strings.ToLower(phrase))
+	if len(fields) < 12 || len(fields) > 24 || len(fields)%3 != 0 {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidLength, len(fields))
+	}
+
+	data := new(big.Int)
+	for _, word := range fields {
+		index, ok := wordIndex[word]
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownWord, word)
+		}
+
+		data.Lsh(data, bitsPerWord)
+		data.Or(data, big.NewInt(index))
+	}
+
+	checksumBits := uint(len(fields) * bitsPerWord / 33)
+	checksum := new(big.Int).And(data, big.NewInt(1<<checksumBits-1)).Int64()
+	data.Rsh(data, checksumBits)
+
+	entropy := data.FillBytes(make([]byte, int(checksumBits)*4))
+	sum := sha256.Sum256(entropy)
+	if int64(sum[0]>>(8-checksumBits)) != checksum {
+		return nil, ErrInvalidChecksum
+	}
+
+	return entropy, nil
+}
@@ -0,0 +1,116 @@
+package mnemonic
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// From the BIP-39 reference test vectors
+var vectors = []struct {
+	entropy string
+	phrase  string
+}{
+	{
+		"00000000000000000000000000000000",
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about",
+	},
+	{
+		"7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f",
+		"legal winner thank year wave sausage worth useful legal winner thank yellow",
+	},
+	{
+		"80808080808080808080808080808080",
+		"letter advice cage absurd amount doctor acoustic avoid letter advice cage above",
+	},
+	{
+		"ffffffffffffffffffffffffffffffff",
+		"zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo wrong",
+	},
+	{
+		"0000000000000000000000000000000000000000000000000000000000000000",
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon art",
+	},
+	{
+		"ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff",
+		"zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo vote",
+	},
+}
+
+func TestVectors(t *testing.T) {
+	if len(words) != 2048 {
+		t.Fatalf("word list has %d words", len(words))
+	}
+
+	for _, v := range vectors {
+		entropy, _ := hex.DecodeString(v.entropy)
+
+		phrase, err := Encode(entropy)
+		if err != nil || phrase != v.phrase {
+			t.Errorf("Encode(%s) = %q, %v, want %q", v.entropy, phrase, err, v.phrase)
+		}
+
+		decoded, err := Decode(v.phrase)
+		if err != nil || !bytes.Equal(decoded, entropy) {
+			t.Errorf("Decode(%q) = %x, %v, want %s", v.phrase, decoded, err, v.entropy)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for size := 16; size <= 32; size += 4 {
+		entropy := make([]byte, size)
+		for i := range entropy {
+			entropy[i] = byte(i*37 + size)
+		}
+
+		phrase, err := Encode(entropy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count := len(strings.Fields(phrase)); count != size*3/4 {
+			t.Errorf("%d bytes encoded in %d words", size, count)
+		}
+
+		// Case and spacing don't matter
+		decoded, err := Decode("  " + strings.ToUpper(strings.ReplaceAll(phrase, " ", "\n\t")) + " ")
+		if err != nil || !bytes.Equal(decoded, entropy) {
+			t.Errorf("Decode() of %d bytes = %x, %v", size, decoded, err)
+		}
+	}
+}
+
+func TestEncodeInvalidLength(t *testing.T) {
+	for _, size := range []int{0, 12, 15, 17, 30, 36} {
+		if _, err := Encode(make([]byte, size)); err == nil {
+			t.Errorf("Encode() of %d bytes succeeded", size)
+		}
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		phrase string
+		err    error
+	}{
+		{"empty", "", ErrInvalidLength},
+		{"too short", "abandon abandon abandon", ErrInvalidLength},
+		{"not a multiple of 3", strings.Repeat("abandon ", 13), ErrInvalidLength},
+		{"too long", strings.Repeat("abandon ", 27), ErrInvalidLength},
+		{"unknown word", "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon aboot", ErrUnknownWord},
+		{"wrong checksum", "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon", ErrInvalidChecksum},
+		{"swapped words", "winner legal thank year wave sausage worth useful legal winner thank yellow", ErrInvalidChecksum},
+		{"last word changed", "zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo", ErrInvalidChecksum},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Decode(tt.phrase); !errors.Is(err, tt.err) {
+				t.Errorf("Decode() = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package pqxdh
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/Guilospanck/pqc/core/pkg/devices"
+)
+
+type directoryEntry struct {
+	upload        Upload
+	oneTime       []Prekey
+	deliveryToken []byte
+}
+
+const deliveryTokenSize = 16
+
+// How many one-time prekeys we keep for a device. Uploads with more are
+// refused, and the oldest ones are dropped once a device has more.
+const MaxOneTimePrekeys = 100
+
+// Server side storage of the published prekeys of every device
+type Directory struct {
+	entries map[string]*directoryEntry // device address -> its prekeys
+	tokens  map[string]string          // delivery token -> device address
+	mu      sync.Mutex
+}
+
+func NewDirectory() *Directory {
+	return &Directory{
+		entries: make(map[string]*directoryEntry),
+		tokens:  make(map[string]string),
+	}
+}
+
+// Stores the prekeys of the `device` of `username`. The upload must have been verified.
+// If the identity changed, the prekeys of the old identity are dropped.
+func (d *Directory) Publish(username, device string, upload Upload) error {
+	if len(upload.OneTimePrekeys) > MaxOneTimePrekeys {
+		return fmt.Errorf("%w (%d, at most %d)", ErrTooManyPrekeys, len(upload.OneTimePrekeys), MaxOneTimePrekeys)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	address := devices.Address(username, device)
+	entry, ok := d.entries[address]
+	if !ok || !bytes.Equal(entry.upload.IdentityKey, upload.IdentityKey) || !bytes.Equal(entry.upload.SigningKey, upload.SigningKey) {
+		if ok {
+			delete(d.tokens, string(entry.deliveryToken))
+		}
+		entry = &directoryEntry{oneTime: make([]Prekey, 0, len(upload.OneTimePrekeys)), deliveryToken: make([]byte, deliveryTokenSize)}
+		rand.Read(entry.deliveryToken)
+		d.entries[address] = entry
+		d.tokens[string(entry.deliveryToken)] = address
+	}
+
+	entry.oneTime = append(entry.oneTime, upload.OneTimePrekeys...)
+	if extra := len(entry.oneTime) - MaxOneTimePrekeys; extra > 0 {
+		entry.oneTime = slices.Clone(entry.oneTime[extra:])
+	}
+	entry.upload = upload
+	entry.upload.OneTimePrekeys = nil
+
+	return nil
+}
+
+// Returns a bundle for the `device` of `username`, consuming one of its
+// one-time prekeys, and how many one-time prekeys are left.
+func (d *Directory) Bundle(username, device string) (Bundle, int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	entry, ok := d.entries[devices.Address(username, device)]
+	if !ok {
+		return Bundle{}, 0, ErrUnknownUser
+	}
+
+	bundle := Bundle{
+		Username:      username,
+		Device:        device,
+		IdentityKey:   entry.upload.IdentityKey,
+		SigningKey:    entry.upload.SigningKey,
+		SignedPrekey:  entry.upload.SignedPrekey,
+		DeliveryToken: entry.deliveryToken,
+	}
+
+	if len(entry.oneTime) == 0 {
+		bundle.OneTimePrekey = entry.upload.LastResortPrekey
+		bundle.LastResort = true
+		return bundle, 0, nil
+	}
+
+	bundle.OneTimePrekey = entry.oneTime[0]
+	entry.oneTime = entry.oneTime[1:]
+
+	return bundle, len(entry.oneTime), nil
+}
+
+func (d *Directory) Remaining(username, device string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	entry, ok := d.entries[devices.Address(username, device)]
+	if !ok {
+		return 0
+	}
+
+	return len(entry.oneTime)
+}
+
+// Address of the device a sealed message with `token` is for
+func (d *Directory) Recipient(token []byte) (string, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	address, ok := d.tokens[string(token)]
+	return address, ok
+}
@@ -0,0 +1,195 @@
+package pqxdh
+
+import (
+	"crypto/ed25519"
+	"crypto/mlkem"
+	"sync"
+)
+
+type privatePrekey struct {
+	Prekey
+	key *mlkem.DecapsulationKey768
+}
+
+// Private side of the prekeys of a client
+type KeyRing struct {
+	signingKey   ed25519.PrivateKey
+	nextID       uint32
+	signedPrekey privatePrekey
+	lastResort   privatePrekey
+	oneTime      map[uint32]*mlkem.DecapsulationKey768
+	mu           sync.Mutex
+}
+
+func NewKeyRing(signingKey ed25519.PrivateKey) (*KeyRing, error) {
+	kr := &KeyRing{
+		signingKey: signingKey,
+		oneTime:    make(map[uint32]*mlkem.DecapsulationKey768),
+	}
+
+	var err error
+	if kr.signedPrekey, err = kr.newPrekey(); err != nil {
+		return nil, err
+	}
+	if kr.lastResort, err = kr.newPrekey(); err != nil {
+		return nil, err
+	}
+
+	return kr, nil
+}
+
+func (kr *KeyRing) newPrekey() (privatePrekey, error) {
+	key, err := mlkem.GenerateKey768()
+	if err != nil {
+		return privatePrekey{}, err
+	}
+
+	kr.nextID++
+	prekey := Prekey{
+		ID:        kr.nextID,
+		PublicKey: key.EncapsulationKey().Bytes(),
+	}
+	prekey.Signature = ed25519.Sign(kr.signingKey, prekey.signedData())
+
+	return privatePrekey{Prekey: prekey, key: key}, nil
+}
+
+// Creates the upload message with `oneTime` new one-time prekeys
+func (kr *KeyRing) Upload(identityKey []byte, oneTime int) (Upload, error) {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	upload := Upload{
+		IdentityKey:      identityKey,
+		SigningKey:       kr.signingKey.Public().(ed25519.PublicKey),
+		SignedPrekey:     kr.signedPrekey.Prekey,
+		LastResortPrekey: kr.lastResort.Prekey,
+		OneTimePrekeys:   make([]Prekey, 0, oneTime),
+	}
+
+	for range oneTime {
+		prekey, err := kr.newPrekey()
+		if err != nil {
+			return Upload{}, err
+		}
+
+		kr.oneTime[prekey.ID] = prekey.key
+		upload.OneTimePrekeys = append(upload.OneTimePrekeys, prekey.Prekey)
+	}
+
+	return upload, nil
+}
+
+func (kr *KeyRing) signedPrekeyKey(id uint32) (*mlkem.DecapsulationKey768, error) {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	if id != kr.signedPrekey.ID {
+		return nil, ErrUnknownPrekey
+	}
+
+	return kr.signedPrekey.key, nil
+}
+
+// One-time prekeys are deleted as soon as they are used.
+// The last-resort prekey is never deleted.
+func (kr *KeyRing) consumeOneTimeKey(id uint32, lastResort bool) (*mlkem.DecapsulationKey768, error) {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	if lastResort {
+		if id != kr.lastResort.ID {
+			return nil, ErrUnknownPrekey
+		}
+		return kr.lastResort.key, nil
+	}
+
+	key, ok := kr.oneTime[id]
+	if !ok {
+		return nil, ErrUnknownPrekey
+	}
+	delete(kr.oneTime, id)
+
+	return key, nil
+}
+
+// A prekey along with its private key, as saved by its client
+type SavedPrekey struct {
+	Prekey
+	PrivateKey []byte `json:"private_key"` // seed of the ML-KEM key
+}
+
+// Everything a client needs to answer prekey messages sent while it was
+// offline, even after it restarted. It holds private keys: it must only be
+// stored encrypted.
+type KeyRingState struct {
+	NextID       uint32            `json:"next_id"`
+	SignedPrekey SavedPrekey       `json:"signed_prekey"`
+	LastResort   SavedPrekey       `json:"last_resort"`
+	OneTime      map[uint32][]byte `json:"one_time"` // the ones not used yet
+}
+
+func savePrekey(prekey privatePrekey) SavedPrekey {
+	return SavedPrekey{Prekey: prekey.Prekey, PrivateKey: prekey.key.Bytes()}
+}
+
+func restorePrekey(saved SavedPrekey) (privatePrekey, error) {
+	key, err := mlkem.NewDecapsulationKey768(saved.PrivateKey)
+	if err != nil {
+		return privatePrekey{}, err
+	}
+
+	return privatePrekey{Prekey: saved.Prekey, key: key}, nil
+}
+
+func (kr *KeyRing) State() KeyRingState {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	state := KeyRingState{
+		NextID:       kr.nextID,
+		SignedPrekey: savePrekey(kr.signedPrekey),
+		LastResort:   savePrekey(kr.lastResort),
+		OneTime:      make(map[uint32][]byte, len(kr.oneTime)),
+	}
+	for id, key := range kr.oneTime {
+		state.OneTime[id] = key.Bytes()
+	}
+
+	return state
+}
+
+// Brings back the key ring saved in `state`, whose prekeys were signed with `signingKey`
+func RestoreKeyRing(signingKey ed25519.PrivateKey, state KeyRingState) (*KeyRing, error) {
+	kr := &KeyRing{
+		signingKey: signingKey,
+		nextID:     state.NextID,
+		oneTime:    make(map[uint32]*mlkem.DecapsulationKey768, len(state.OneTime)),
+	}
+
+	var err error
+	if kr.signedPrekey, err = restorePrekey(state.SignedPrekey); err != nil {
+		return nil, err
+	}
+	if kr.lastResort, err = restorePrekey(state.LastResort); err != nil {
+		return nil, err
+	}
+
+	for id, seed := range state.OneTime {
+		key, err := mlkem.NewDecapsulationKey768(seed)
+		if err != nil {
+			return nil, err
+		}
+		kr.oneTime[id] = key
+	}
+
+	// Prekeys signed by another identity would be rejected by everyone
+	public := signingKey.Public().(ed25519.PublicKey)
+	for _, prekey := range []Prekey{kr.signedPrekey.Prekey, kr.lastResort.Prekey} {
+		if !ed25519.Verify(public, prekey.signedData(), prekey.Signature) {
+			return nil, ErrInvalidSignature
+		}
+	}
+
+	return kr, nil
+}
@@ -0,0 +1,359 @@
+package pqxdh
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/Guilospanck/pqc/core/pkg/cryptography"
+	"github.com/Guilospanck/pqc/core/pkg/devices"
+)
+
+type device struct {
+	username string
+	device   string
+	keys     cryptography.Keys
+	keyRing  *KeyRing
+}
+
+func newDevice(t *testing.T, username, name string) *device {
+	t.Helper()
+
+	keys, err := cryptography.GenerateKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyRing, err := NewKeyRing(keys.Signing)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &device{username: username, device: name, keys: keys, keyRing: keyRing}
+}
+
+// Publishes `oneTime` prekeys of `d` in `directory`
+func (d *device) publish(t *testing.T, directory *Directory, oneTime int) {
+	t.Helper()
+
+	upload, err := d.keyRing.Upload(d.keys.Public, oneTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := upload.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := directory.Publish(d.username, d.device, upload); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (d *device) bundle(t *testing.T, directory *Directory) Bundle {
+	t.Helper()
+
+	bundle, _, err := directory.Bundle(d.username, d.device)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bundle.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	return bundle
+}
+
+func (d *device) initiate(t *testing.T, bundle Bundle, plaintext string) InitialMessage {
+	t.Helper()
+
+	msg, err := Initiate(d.username, d.device, d.keys.Public, d.keys.Signing, bundle, []byte(plaintext))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return msg
+}
+
+func TestSession(t *testing.T) {
+	directory := NewDirectory()
+	alice, bob := newDevice(t, "alice", "laptop"), newDevice(t, "bob", "phone")
+	bob.publish(t, directory, 2)
+
+	// Two one-time prekeys, then the last-resort one, over and over
+	for i, lastResort := range []bool{false, false, true, true} {
+		bundle := bob.bundle(t, directory)
+		if bundle.LastResort != lastResort {
+			t.Fatalf("bundle %d: last resort %v, want %v", i, bundle.LastResort, lastResort)
+		}
+
+		msg := alice.initiate(t, bundle, "hello bob")
+		if msg.From != "alice" || msg.To != "bob" || msg.ToDevice != "phone" {
+			t.Errorf("initial message from %s to %s/%s", msg.From, msg.To, msg.ToDevice)
+		}
+
+		plaintext, err := bob.keyRing.Respond(bob.keys.Private, msg)
+		if err != nil {
+			t.Fatalf("Respond() to message %d: %v", i, err)
+		}
+		if string(plaintext) != "hello bob" {
+			t.Errorf("Respond() = %q", plaintext)
+		}
+	}
+}
+
+func TestSessionReplay(t *testing.T) {
+	directory := NewDirectory()
+	alice, bob := newDevice(t, "alice", "laptop"), newDevice(t, "bob", "phone")
+	bob.publish(t, directory, 1)
+
+	msg := alice.initiate(t, bob.bundle(t, directory), "hello bob")
+	if _, err := bob.keyRing.Respond(bob.keys.Private, msg); err != nil {
+		t.Fatal(err)
+	}
+
+	// The one-time prekey is gone
+	if _, err := bob.keyRing.Respond(bob.keys.Private, msg); !errors.Is(err, ErrUnknownPrekey) {
+		t.Errorf("Respond() to a replayed message = %v, want %v", err, ErrUnknownPrekey)
+	}
+}
+
+func TestSessionTampered(t *testing.T) {
+	directory := NewDirectory()
+	alice, bob := newDevice(t, "alice", "laptop"), newDevice(t, "bob", "phone")
+	mallory := newDevice(t, "mallory", "laptop")
+	bob.publish(t, directory, 0) // the last-resort prekey can be used by every case
+
+	msg := alice.initiate(t, bob.bundle(t, directory), "hello bob")
+
+	tests := []struct {
+		name   string
+		change func(m *InitialMessage)
+		err    error
+	}{
+		{"sender", func(m *InitialMessage) { m.From = "mallory" }, ErrInvalidMessage},
+		{"recipient", func(m *InitialMessage) { m.To = "carol" }, ErrInvalidMessage},
+		{"ciphertext", func(m *InitialMessage) { m.Ciphertext[0] ^= 1 }, ErrInvalidMessage},
+		{"kem ciphertext", func(m *InitialMessage) { m.IdentityCiphertext[0] ^= 1 }, ErrInvalidMessage},
+		{"prekey id", func(m *InitialMessage) { m.SignedPrekeyID++ }, ErrInvalidMessage},
+		{"signature", func(m *InitialMessage) { m.Signature[0] ^= 1 }, ErrInvalidMessage},
+		{"signing key", func(m *InitialMessage) { m.SenderSigningKey = mallory.keys.Signing.Public().(ed25519.PublicKey) }, ErrInvalidMessage},
+		{"short signing key", func(m *InitialMessage) { m.SenderSigningKey = m.SenderSigningKey[:8] }, ErrInvalidMessage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tampered := relay(t, msg)
+			tt.change(&tampered)
+
+			if _, err := bob.keyRing.Respond(bob.keys.Private, tampered); !errors.Is(err, tt.err) {
+				t.Errorf("Respond() = %v, want %v", err, tt.err)
+			}
+		})
+	}
+
+	t.Run("other recipient", func(t *testing.T) {
+		carol := newDevice(t, "carol", "phone")
+		if _, err := carol.keyRing.Respond(carol.keys.Private, msg); !errors.Is(err, ErrUnexpectedRecipient) {
+			t.Errorf("Respond() = %v, want %v", err, ErrUnexpectedRecipient)
+		}
+	})
+
+	t.Run("re-signed by someone else", func(t *testing.T) {
+		// Mallory can sign the message, but then it's not from alice's keys
+		forged := mallory.initiate(t, bob.bundle(t, directory), "hello bob")
+		forged.From, forged.FromDevice = "alice", "laptop"
+		if _, err := bob.keyRing.Respond(bob.keys.Private, forged); !errors.Is(err, ErrInvalidMessage) {
+			t.Errorf("Respond() = %v, want %v", err, ErrInvalidMessage)
+		}
+	})
+
+	if _, err := bob.keyRing.Respond(bob.keys.Private, msg); err != nil {
+		t.Errorf("Respond() to the untouched message = %v", err)
+	}
+}
+
+func TestUploadVerify(t *testing.T) {
+	bob, mallory := newDevice(t, "bob", "phone"), newDevice(t, "mallory", "laptop")
+
+	upload, err := bob.keyRing.Upload(bob.keys.Public, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := upload.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		change func(u *Upload)
+	}{
+		{"signed prekey", func(u *Upload) { u.SignedPrekey.PublicKey[0] ^= 1 }},
+		{"signed prekey id", func(u *Upload) { u.SignedPrekey.ID++ }},
+		{"last-resort prekey", func(u *Upload) { u.LastResortPrekey.Signature[0] ^= 1 }},
+		{"one-time prekey", func(u *Upload) { u.OneTimePrekeys[2].PublicKey[0] ^= 1 }},
+		{"signing key", func(u *Upload) { u.SigningKey = mallory.keys.Signing.Public().(ed25519.PublicKey) }},
+		{"no signing key", func(u *Upload) { u.SigningKey = nil }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tampered := relay(t, upload)
+			tt.change(&tampered)
+
+			if err := tampered.Verify(); !errors.Is(err, ErrInvalidSignature) {
+				t.Errorf("Verify() = %v, want %v", err, ErrInvalidSignature)
+			}
+		})
+	}
+}
+
+func TestDirectory(t *testing.T) {
+	directory := NewDirectory()
+	bob := newDevice(t, "bob", "phone")
+
+	if _, _, err := directory.Bundle("bob", "phone"); !errors.Is(err, ErrUnknownUser) {
+		t.Errorf("Bundle() of an unknown device = %v, want %v", err, ErrUnknownUser)
+	}
+
+	bob.publish(t, directory, 2)
+	bob.publish(t, directory, 1) // replenishing keeps the previous ones
+	if remaining := directory.Remaining("bob", "phone"); remaining != 3 {
+		t.Fatalf("Remaining() = %d, want 3", remaining)
+	}
+
+	first, remaining, err := directory.Bundle("bob", "phone")
+	if err != nil || remaining != 2 {
+		t.Fatalf("Bundle() = %d remaining, %v", remaining, err)
+	}
+	second := bob.bundle(t, directory)
+	if first.OneTimePrekey.ID == second.OneTimePrekey.ID {
+		t.Error("the same one-time prekey was handed out twice")
+	}
+
+	address, ok := directory.Recipient(first.DeliveryToken)
+	if !ok || address != devices.Address("bob", "phone") {
+		t.Errorf("Recipient() = %q, %v", address, ok)
+	}
+	if _, ok := directory.Recipient([]byte("unknown token")); ok {
+		t.Error("Recipient() of an unknown token found a device")
+	}
+
+	// A new identity drops the prekeys (and the delivery token) of the old one
+	reinstalled := newDevice(t, "bob", "phone")
+	reinstalled.publish(t, directory, 1)
+	if remaining := directory.Remaining("bob", "phone"); remaining != 1 {
+		t.Errorf("Remaining() after a new identity = %d, want 1", remaining)
+	}
+	if _, ok := directory.Recipient(first.DeliveryToken); ok {
+		t.Error("the delivery token of the old identity still works")
+	}
+	if bundle := reinstalled.bundle(t, directory); !bytes.Equal(bundle.IdentityKey, reinstalled.keys.Public) {
+		t.Error("bundle with the old identity key")
+	}
+}
+
+// A device can't make the server keep more than MaxOneTimePrekeys for it
+func TestDirectoryLimit(t *testing.T) {
+	directory := NewDirectory()
+	bob := newDevice(t, "bob", "phone")
+
+	uploads := make([]Upload, 0, 2)
+	for range 2 {
+		upload, err := bob.keyRing.Upload(bob.keys.Public, 60)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := directory.Publish("bob", "phone", upload); err != nil {
+			t.Fatal(err)
+		}
+		uploads = append(uploads, upload)
+	}
+
+	// The oldest ones were dropped
+	if remaining := directory.Remaining("bob", "phone"); remaining != MaxOneTimePrekeys {
+		t.Fatalf("Remaining() = %d, want %d", remaining, MaxOneTimePrekeys)
+	}
+	if bundle := bob.bundle(t, directory); bundle.OneTimePrekey.ID != uploads[0].OneTimePrekeys[20].ID {
+		t.Errorf("Bundle() handed out prekey %d, want the oldest one kept (%d)", bundle.OneTimePrekey.ID, uploads[0].OneTimePrekeys[20].ID)
+	}
+
+	tooMany, err := bob.keyRing.Upload(bob.keys.Public, MaxOneTimePrekeys+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := directory.Publish("bob", "phone", tooMany); !errors.Is(err, ErrTooManyPrekeys) {
+		t.Errorf("Publish() of %d prekeys = %v, want %v", MaxOneTimePrekeys+1, err, ErrTooManyPrekeys)
+	}
+	if remaining := directory.Remaining("bob", "phone"); remaining != MaxOneTimePrekeys-1 {
+		t.Errorf("Remaining() after a refused upload = %d, want %d", remaining, MaxOneTimePrekeys-1)
+	}
+}
+
+func TestRestoreKeyRing(t *testing.T) {
+	directory := NewDirectory()
+	alice, bob := newDevice(t, "alice", "laptop"), newDevice(t, "bob", "phone")
+	bob.publish(t, directory, 3)
+
+	used := alice.initiate(t, bob.bundle(t, directory), "before the restart")
+	if _, err := bob.keyRing.Respond(bob.keys.Private, used); err != nil {
+		t.Fatal(err)
+	}
+	pending := alice.initiate(t, bob.bundle(t, directory), "while bob was away")
+
+	state := relay(t, bob.keyRing.State())
+	restored, err := RestoreKeyRing(bob.keys.Signing, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob.keyRing = restored
+
+	plaintext, err := bob.keyRing.Respond(bob.keys.Private, pending)
+	if err != nil || string(plaintext) != "while bob was away" {
+		t.Errorf("Respond() after the restart = %q, %v", plaintext, err)
+	}
+	if _, err := bob.keyRing.Respond(bob.keys.Private, used); !errors.Is(err, ErrUnknownPrekey) {
+		t.Errorf("Respond() with a prekey used before the restart = %v, want %v", err, ErrUnknownPrekey)
+	}
+
+	// New prekeys don't reuse the ids of the old ones
+	upload, err := bob.keyRing.Upload(bob.keys.Public, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := upload.OneTimePrekeys[0].ID; id <= state.NextID {
+		t.Errorf("new prekey has id %d, the last one before the restart was %d", id, state.NextID)
+	}
+
+	t.Run("other identity", func(t *testing.T) {
+		mallory := newDevice(t, "mallory", "laptop")
+		if _, err := RestoreKeyRing(mallory.keys.Signing, state); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("RestoreKeyRing() = %v, want %v", err, ErrInvalidSignature)
+		}
+	})
+
+	t.Run("corrupted private key", func(t *testing.T) {
+		corrupted := relay(t, state)
+		corrupted.SignedPrekey.PrivateKey = corrupted.SignedPrekey.PrivateKey[:10]
+		if _, err := RestoreKeyRing(bob.keys.Signing, corrupted); err == nil {
+			t.Error("RestoreKeyRing() with a truncated key succeeded")
+		}
+	})
+}
+
+// Goes through JSON like everything the server relays
+func relay[T any](t *testing.T, v T) T {
+	t.Helper()
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var relayed T
+	if err := json.Unmarshal(data, &relayed); err != nil {
+		t.Fatal(err)
+	}
+	return relayed
+}
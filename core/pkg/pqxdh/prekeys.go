@@ -0,0 +1,110 @@
+package pqxdh
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrInvalidSignature = errors.New("invalid prekey signature")
+	ErrUnknownUser      = errors.New("no prekeys published for this user")
+	ErrUnknownPrekey    = errors.New("unknown or already used prekey")
+	ErrTooManyPrekeys   = errors.New("too many one-time prekeys")
+)
+
+// ML-KEM public key signed by the identity signing key of its owner
+type Prekey struct {
+	ID        uint32 `json:"id"`
+	PublicKey []byte `json:"public_key"`
+	Signature []byte `json:"signature"`
+}
+
+func (p Prekey) signedData() []byte {
+	data := []byte("pqxdh-prekey")
+	data = binary.BigEndian.AppendUint32(data, p.ID)
+	data = append(data, p.PublicKey...)
+	return data
+}
+
+func (p Prekey) Verify(signingKey []byte) bool {
+	if len(signingKey) != ed25519.PublicKeySize {
+		return false
+	}
+
+	return ed25519.Verify(ed25519.PublicKey(signingKey), p.signedData(), p.Signature)
+}
+
+// Sent by a client to publish (or replenish) its prekeys.
+// One-time prekeys are appended to the ones the server already has.
+type Upload struct {
+	IdentityKey      []byte   `json:"identity_key"`
+	SigningKey       []byte   `json:"signing_key"`
+	SignedPrekey     Prekey   `json:"signed_prekey"`
+	LastResortPrekey Prekey   `json:"last_resort_prekey"`
+	OneTimePrekeys   []Prekey `json:"one_time_prekeys"`
+}
+
+func (u Upload) Verify() error {
+	if !u.SignedPrekey.Verify(u.SigningKey) {
+		return fmt.Errorf("%w: signed prekey %d", ErrInvalidSignature, u.SignedPrekey.ID)
+	}
+
+	if !u.LastResortPrekey.Verify(u.SigningKey) {
+		return fmt.Errorf("%w: last-resort prekey %d", ErrInvalidSignature, u.LastResortPrekey.ID)
+	}
+
+	for _, p := range u.OneTimePrekeys {
+		if !p.Verify(u.SigningKey) {
+			return fmt.Errorf("%w: one-time prekey %d", ErrInvalidSignature, p.ID)
+		}
+	}
+
+	return nil
+}
+
+// Sent by a client that wants to start a session with `Username`
+type BundleRequest struct {
+	Username string `json:"username"`
+}
+
+// What a sender needs to start a session with one device of `Username`.
+// If the one-time prekeys ran out, `OneTimePrekey` is the last-resort prekey.
+// `DeliveryToken` is what the server needs to deliver sealed messages to the device.
+type Bundle struct {
+	Username      string `json:"username"`
+	Device        string `json:"device"`
+	IdentityKey   []byte `json:"identity_key"`
+	SigningKey    []byte `json:"signing_key"`
+	SignedPrekey  Prekey `json:"signed_prekey"`
+	OneTimePrekey Prekey `json:"one_time_prekey"`
+	LastResort    bool   `json:"last_resort"`
+	DeliveryToken []byte `json:"delivery_token"`
+}
+
+func (b Bundle) Verify() error {
+	if !b.SignedPrekey.Verify(b.SigningKey) {
+		return fmt.Errorf("%w: signed prekey %d of %s", ErrInvalidSignature, b.SignedPrekey.ID, b.Username)
+	}
+
+	if !b.OneTimePrekey.Verify(b.SigningKey) {
+		return fmt.Errorf("%w: one-time prekey %d of %s", ErrInvalidSignature, b.OneTimePrekey.ID, b.Username)
+	}
+
+	return nil
+}
+
+// One bundle for every device of `Username`: a message is sent to all of them.
+// No bundles means the user never published prekeys. `Online` tells whether
+// one of its devices is connected, otherwise the message waits in the server.
+type Bundles struct {
+	Username string   `json:"username"`
+	Bundles  []Bundle `json:"bundles"`
+	Online   bool     `json:"online"`
+}
+
+// Sent by the server when a device is running out of one-time prekeys
+type PrekeyCount struct {
+	Remaining int `json:"remaining"`
+}
@@ -0,0 +1,177 @@
+package pqxdh
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/mlkem"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/Guilospanck/pqc/core/pkg/cryptography"
+)
+
+var (
+	ErrInvalidMessage      = errors.New("invalid prekey message")
+	ErrUnexpectedRecipient = errors.New("prekey message is for another identity")
+)
+
+// First message of a session: everything the recipient needs to derive
+// the session key on its own, plus the first encrypted message.
+type InitialMessage struct {
+	From                    string `json:"from"`
+	FromDevice              string `json:"from_device"`
+	To                      string `json:"to"`
+	ToDevice                string `json:"to_device"`
+	SenderIdentityKey       []byte `json:"sender_identity_key"`
+	SenderSigningKey        []byte `json:"sender_signing_key"`
+	RecipientIdentityKey    []byte `json:"recipient_identity_key"`
+	SignedPrekeyID          uint32 `json:"signed_prekey_id"`
+	OneTimePrekeyID         uint32 `json:"one_time_prekey_id"`
+	LastResort              bool   `json:"last_resort"`
+	IdentityCiphertext      []byte `json:"identity_ciphertext"`
+	SignedPrekeyCiphertext  []byte `json:"signed_prekey_ciphertext"`
+	OneTimePrekeyCiphertext []byte `json:"one_time_prekey_ciphertext"`
+	Nonce                   []byte `json:"nonce"`
+	Ciphertext              []byte `json:"ciphertext"`
+	Signature               []byte `json:"signature"`
+}
+
+// Everything but the signature, length-prefixed
+func (m InitialMessage) transcript() []byte {
+	data := []byte("pqxdh-initial-message")
+
+	appendField := func(field []byte) {
+		data = binary.BigEndian.AppendUint32(data, uint32(len(field)))
+		data = append(data, field...)
+	}
+
+	appendField([]byte(m.From))
+	appendField([]byte(m.FromDevice))
+	appendField([]byte(m.To))
+	appendField([]byte(m.ToDevice))
+	appendField(m.SenderIdentityKey)
+	appendField(m.SenderSigningKey)
+	appendField(m.RecipientIdentityKey)
+	data = binary.BigEndian.AppendUint32(data, m.SignedPrekeyID)
+	data = binary.BigEndian.AppendUint32(data, m.OneTimePrekeyID)
+	if m.LastResort {
+		data = append(data, 1)
+	} else {
+		data = append(data, 0)
+	}
+	appendField(m.IdentityCiphertext)
+	appendField(m.SignedPrekeyCiphertext)
+	appendField(m.OneTimePrekeyCiphertext)
+	appendField(m.Nonce)
+	appendField(m.Ciphertext)
+
+	return data
+}
+
+// The session key is bound to both identities
+func (m InitialMessage) sessionKey(identitySecret, signedPrekeySecret, oneTimePrekeySecret []byte) []byte {
+	secret := make([]byte, 0, 3*mlkem.SharedKeySize)
+	secret = append(secret, identitySecret...)
+	secret = append(secret, signedPrekeySecret...)
+	secret = append(secret, oneTimePrekeySecret...)
+
+	info := []byte("pqxdh-session-key")
+	info = append(info, m.SenderIdentityKey...)
+	info = append(info, m.SenderSigningKey...)
+	info = append(info, m.RecipientIdentityKey...)
+
+	return cryptography.DeriveKeyWithInfo(secret, info)
+}
+
+// Starts a session with the device that owns `bundle` (which must have been verified)
+// and encrypts `plaintext` with the resulting session key.
+func Initiate(from, fromDevice string, identityKey []byte, signingKey ed25519.PrivateKey, bundle Bundle, plaintext []byte) (InitialMessage, error) {
+	msg := InitialMessage{
+		From:                 from,
+		FromDevice:           fromDevice,
+		To:                   bundle.Username,
+		ToDevice:             bundle.Device,
+		SenderIdentityKey:    identityKey,
+		SenderSigningKey:     signingKey.Public().(ed25519.PublicKey),
+		RecipientIdentityKey: bundle.IdentityKey,
+		SignedPrekeyID:       bundle.SignedPrekey.ID,
+		OneTimePrekeyID:      bundle.OneTimePrekey.ID,
+		LastResort:           bundle.LastResort,
+	}
+
+	identitySecret, identityCiphertext, err := encapsulate(bundle.IdentityKey)
+	if err != nil {
+		return InitialMessage{}, fmt.Errorf("identity key of %s: %w", bundle.Username, err)
+	}
+	signedPrekeySecret, signedPrekeyCiphertext, err := encapsulate(bundle.SignedPrekey.PublicKey)
+	if err != nil {
+		return InitialMessage{}, fmt.Errorf("signed prekey of %s: %w", bundle.Username, err)
+	}
+	oneTimePrekeySecret, oneTimePrekeyCiphertext, err := encapsulate(bundle.OneTimePrekey.PublicKey)
+	if err != nil {
+		return InitialMessage{}, fmt.Errorf("one-time prekey of %s: %w", bundle.Username, err)
+	}
+
+	msg.IdentityCiphertext = identityCiphertext
+	msg.SignedPrekeyCiphertext = signedPrekeyCiphertext
+	msg.OneTimePrekeyCiphertext = oneTimePrekeyCiphertext
+
+	key := msg.sessionKey(identitySecret, signedPrekeySecret, oneTimePrekeySecret)
+	msg.Nonce, msg.Ciphertext, err = cryptography.EncryptMessage(key, plaintext)
+	if err != nil {
+		return InitialMessage{}, err
+	}
+
+	msg.Signature = ed25519.Sign(signingKey, msg.transcript())
+
+	return msg, nil
+}
+
+// Derives the session key of an initial message sent to us and decrypts it.
+// The caller must check that the sender keys really belong to `msg.From`.
+func (kr *KeyRing) Respond(identity *mlkem.DecapsulationKey768, msg InitialMessage) ([]byte, error) {
+	if len(msg.SenderSigningKey) != ed25519.PublicKeySize || !ed25519.Verify(ed25519.PublicKey(msg.SenderSigningKey), msg.transcript(), msg.Signature) {
+		return nil, fmt.Errorf("%w: bad signature from %s", ErrInvalidMessage, msg.From)
+	}
+
+	if !bytes.Equal(msg.RecipientIdentityKey, identity.EncapsulationKey().Bytes()) {
+		return nil, ErrUnexpectedRecipient
+	}
+
+	signedPrekey, err := kr.signedPrekeyKey(msg.SignedPrekeyID)
+	if err != nil {
+		return nil, fmt.Errorf("signed prekey %d: %w", msg.SignedPrekeyID, err)
+	}
+	oneTimePrekey, err := kr.consumeOneTimeKey(msg.OneTimePrekeyID, msg.LastResort)
+	if err != nil {
+		return nil, fmt.Errorf("one-time prekey %d: %w", msg.OneTimePrekeyID, err)
+	}
+
+	identitySecret, err := identity.Decapsulate(msg.IdentityCiphertext)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidMessage, err)
+	}
+	signedPrekeySecret, err := signedPrekey.Decapsulate(msg.SignedPrekeyCiphertext)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidMessage, err)
+	}
+	oneTimePrekeySecret, err := oneTimePrekey.Decapsulate(msg.OneTimePrekeyCiphertext)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidMessage, err)
+	}
+
+	key := msg.sessionKey(identitySecret, signedPrekeySecret, oneTimePrekeySecret)
+
+	return cryptography.DecryptMessage(key, msg.Nonce, msg.Ciphertext)
+}
+
+func encapsulate(publicKey []byte) (sharedSecret, ciphertext []byte, err error) {
+	ek, err := mlkem.NewEncapsulationKey768(publicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sharedSecret, ciphertext = ek.Encapsulate()
+	return sharedSecret, ciphertext, nil
+}
@@ -0,0 +1,52 @@
+package sas
+
+import "strings"
+
+type Emoji struct {
+	Symbol string `json:"symbol"`
+	Name   string `json:"name"`
+}
+
+// Same table as the Matrix SAS verification, so the names are easy to read out loud
+var emojiTable = [64]Emoji{
+	{"🐶", "Dog"}, {"🐱", "Cat"}, {"🦁", "Lion"}, {"🐎", "Horse"},
+	{"🦄", "Unicorn"}, {"🐷", "Pig"}, {"🐘", "Elephant"}, {"🐰", "Rabbit"},
+	{"🐼", "Panda"}, {"🐓", "Rooster"}, {"🐧", "Penguin"}, {"🐢", "Turtle"},
+	{"🐟", "Fish"}, {"🐙", "Octopus"}, {"🦋", "Butterfly"}, {"🌷", "Flower"},
+	{"🌳", "Tree"}, {"🌵", "Cactus"}, {"🍄", "Mushroom"}, {"🌏", "Globe"},
+	{"🌙", "Moon"}, {"☁️", "Cloud"}, {"🔥", "Fire"}, {"🍌", "Banana"},
+	{"🍎", "Apple"}, {"🍓", "Strawberry"}, {"🌽", "Corn"}, {"🍕", "Pizza"},
+	{"🎂", "Cake"}, {"❤️", "Heart"}, {"😀", "Smiley"}, {"🤖", "Robot"},
+	{"🎩", "Hat"}, {"👓", "Glasses"}, {"🔧", "Spanner"}, {"🎅", "Santa"},
+	{"👍", "Thumbs Up"}, {"☂️", "Umbrella"}, {"⌛", "Hourglass"}, {"⏰", "Clock"},
+	{"🎁", "Gift"}, {"💡", "Light Bulb"}, {"📕", "Book"}, {"✏️", "Pencil"},
+	{"📎", "Paperclip"}, {"✂️", "Scissors"}, {"🔒", "Lock"}, {"🔑", "Key"},
+	{"🔨", "Hammer"}, {"☎️", "Telephone"}, {"🏁", "Flag"}, {"🚂", "Train"},
+	{"🚲", "Bicycle"}, {"✈️", "Aeroplane"}, {"🚀", "Rocket"}, {"🏆", "Trophy"},
+	{"⚽", "Ball"}, {"🎸", "Guitar"}, {"🎺", "Trumpet"}, {"🔔", "Bell"},
+	{"⚓", "Anchor"}, {"🎧", "Headphones"}, {"📁", "Folder"}, {"📌", "Pin"},
+}
+
+// The first 42 bits of `b` (at least 6 bytes) pick 7 emojis
+func emojis(b []byte) []Emoji {
+	bits := uint64(0)
+	for _, x := range b[:6] {
+		bits = bits<<8 | uint64(x)
+	}
+
+	code := make([]Emoji, 7)
+	for i := range code {
+		code[i] = emojiTable[(bits>>(48-6*(i+1)))&63]
+	}
+
+	return code
+}
+
+// e.g. "🐶 Dog · 🔑 Key · ..."
+func Format(code []Emoji) string {
+	parts := make([]string, len(code))
+	for i, e := range code {
+		parts[i] = e.Symbol + " " + e.Name
+	}
+	return strings.Join(parts, " · ")
+}
@@ -0,0 +1,278 @@
+package sas
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/Guilospanck/pqc/core/pkg/cryptography"
+)
+
+// Short authentication string verification of the identity keys of two devices.
+//
+// 1. The initiator commits to a random nonce (and its keys) in the request;
+// 2. The responder answers with its own nonce in the clear;
+// 3. The initiator reveals its nonce, which must match the commitment.
+//
+// Both sides then derive the same short string from both nonces and both
+// sets of keys, as each of them sees them. A server replacing any key
+// can't pick the nonces so that both strings still match, because the
+// initiator committed to its nonce before seeing the responder's one.
+
+var (
+	ErrUnexpectedMessage = errors.New("unexpected verification message")
+	ErrInvalidCommitment = errors.New("revealed nonce does not match the commitment")
+	ErrInvalidMAC        = errors.New("invalid key confirmation")
+)
+
+const nonceSize = 32
+
+// Identity keys of a device
+type Keys struct {
+	IdentityKey []byte `json:"identity_key"`
+	SigningKey  []byte `json:"signing_key"`
+}
+
+func (k Keys) bytes() []byte {
+	data := binary.BigEndian.AppendUint32(nil, uint32(len(k.IdentityKey)))
+	data = append(data, k.IdentityKey...)
+	data = binary.BigEndian.AppendUint32(data, uint32(len(k.SigningKey)))
+	return append(data, k.SigningKey...)
+}
+
+// Routing information of every verification message.
+// An empty `ToDevice` means every device of `To`.
+type Envelope struct {
+	TransactionID string `json:"transaction_id"`
+	From          string `json:"from"`
+	FromDevice    string `json:"from_device"`
+	To            string `json:"to"`
+	ToDevice      string `json:"to_device"`
+}
+
+type Request struct {
+	Envelope
+	Commitment []byte `json:"commitment"`
+}
+
+type Accept struct {
+	Envelope
+	Nonce []byte `json:"nonce"`
+}
+
+type Reveal struct {
+	Envelope
+	Nonce []byte `json:"nonce"`
+}
+
+// Sent once the user confirmed both strings match.
+// The MAC proves the sender derived the same secret for the same keys.
+type Confirm struct {
+	Envelope
+	MAC []byte `json:"mac"`
+}
+
+type Cancel struct {
+	Envelope
+	Reason string `json:"reason"`
+}
+
+type State int
+
+const (
+	StateRequested State = iota // initiator: waiting for the accept
+	StateAccepted               // responder: waiting for the reveal
+	StateComparing              // waiting for the user to compare the strings
+	StateConfirmed              // the user confirmed, waiting for the other side
+	StateVerified
+	StateCancelled
+)
+
+type Session struct {
+	ID         string
+	Initiator  bool
+	Peer       string
+	PeerDevice string
+
+	username   string
+	device     string
+	state      State
+	ownKeys    Keys
+	peerKeys   Keys
+	nonce      []byte
+	peerNonce  []byte
+	commitment []byte // of the initiator, when we are the responder
+	secret     []byte
+
+	peerConfirmed bool
+}
+
+func commit(transactionID string, nonce []byte, keys Keys) []byte {
+	h := sha256.New()
+	h.Write([]byte("pqc-sas-commitment" + transactionID))
+	h.Write(nonce)
+	h.Write(keys.bytes())
+	return h.Sum(nil)
+}
+
+func randomBytes(size int) []byte {
+	b := make([]byte, size)
+	rand.Read(b)
+	return b
+}
+
+// Starts a verification with `peer` (any of its devices if `peerDevice` is empty)
+func Start(username, device, peer, peerDevice string, ownKeys Keys) (*Session, Request) {
+	s := &Session{
+		ID:         hex.EncodeToString(randomBytes(4)),
+		Initiator:  true,
+		Peer:       peer,
+		PeerDevice: peerDevice,
+		username:   username,
+		device:     device,
+		state:      StateRequested,
+		ownKeys:    ownKeys,
+		nonce:      randomBytes(nonceSize),
+	}
+
+	return s, Request{Envelope: s.envelope(), Commitment: commit(s.ID, s.nonce, ownKeys)}
+}
+
+// Answers a verification request. `peerKeys` are the keys we know for its sender.
+func Respond(username, device string, request Request, ownKeys, peerKeys Keys) (*Session, Accept) {
+	s := &Session{
+		ID:         request.TransactionID,
+		Peer:       request.From,
+		PeerDevice: request.FromDevice,
+		username:   username,
+		device:     device,
+		state:      StateAccepted,
+		ownKeys:    ownKeys,
+		peerKeys:   peerKeys,
+		nonce:      randomBytes(nonceSize),
+		commitment: request.Commitment,
+	}
+
+	return s, Accept{Envelope: s.envelope(), Nonce: s.nonce}
+}
+
+func (s *Session) envelope() Envelope {
+	return Envelope{
+		TransactionID: s.ID,
+		From:          s.username,
+		FromDevice:    s.device,
+		To:            s.Peer,
+		ToDevice:      s.PeerDevice,
+	}
+}
+
+func (s *Session) State() State {
+	return s.state
+}
+
+// The responder answered: now we know which of its devices it is
+func (s *Session) HandleAccept(accept Accept, peerKeys Keys) (Reveal, error) {
+	if s.state != StateRequested || !s.Initiator || len(accept.Nonce) != nonceSize {
+		return Reveal{}, ErrUnexpectedMessage
+	}
+
+	s.PeerDevice = accept.FromDevice
+	s.peerKeys = peerKeys
+	s.peerNonce = accept.Nonce
+	s.deriveSecret()
+	s.state = StateComparing
+
+	return Reveal{Envelope: s.envelope(), Nonce: s.nonce}, nil
+}
+
+func (s *Session) HandleReveal(reveal Reveal) error {
+	if s.state != StateAccepted || s.Initiator {
+		return ErrUnexpectedMessage
+	}
+
+	if !hmac.Equal(commit(s.ID, reveal.Nonce, s.peerKeys), s.commitment) {
+		return ErrInvalidCommitment
+	}
+
+	s.peerNonce = reveal.Nonce
+	s.deriveSecret()
+	s.state = StateComparing
+
+	return nil
+}
+
+func (s *Session) deriveSecret() {
+	initiatorNonce, responderNonce := s.nonce, s.peerNonce
+	initiatorKeys, responderKeys := s.ownKeys, s.peerKeys
+	if !s.Initiator {
+		initiatorNonce, responderNonce = responderNonce, initiatorNonce
+		initiatorKeys, responderKeys = responderKeys, initiatorKeys
+	}
+
+	info := []byte("pqc-sas" + s.ID)
+	info = append(info, initiatorKeys.bytes()...)
+	info = append(info, responderKeys.bytes()...)
+
+	nonces := append(append([]byte{}, initiatorNonce...), responderNonce...)
+	s.secret = cryptography.DeriveKeyWithInfo(nonces, info)
+}
+
+// The short authentication string both users have to compare
+func (s *Session) Code() []Emoji {
+	if s.secret == nil {
+		return nil
+	}
+
+	return emojis(cryptography.DeriveBytes(s.secret, []byte("pqc-sas-emoji"), 6))
+}
+
+func (s *Session) mac(keys Keys) []byte {
+	m := hmac.New(sha256.New, cryptography.DeriveKeyWithInfo(s.secret, []byte("pqc-sas-mac")))
+	m.Write(keys.bytes())
+	return m.Sum(nil)
+}
+
+// The user confirmed that both strings match
+func (s *Session) Confirm() (Confirm, error) {
+	if s.state != StateComparing {
+		return Confirm{}, ErrUnexpectedMessage
+	}
+
+	s.state = StateConfirmed
+	if s.peerConfirmed {
+		s.state = StateVerified
+	}
+
+	return Confirm{Envelope: s.envelope(), MAC: s.mac(s.ownKeys)}, nil
+}
+
+// The other user confirmed: its MAC must cover the keys we know for it
+func (s *Session) HandleConfirm(confirm Confirm) error {
+	if s.state != StateComparing && s.state != StateConfirmed {
+		return ErrUnexpectedMessage
+	}
+
+	if !hmac.Equal(confirm.MAC, s.mac(s.peerKeys)) {
+		return ErrInvalidMAC
+	}
+
+	s.peerConfirmed = true
+	if s.state == StateConfirmed {
+		s.state = StateVerified
+	}
+
+	return nil
+}
+
+func (s *Session) Cancel(reason string) Cancel {
+	s.state = StateCancelled
+	return Cancel{Envelope: s.envelope(), Reason: reason}
+}
+
+func (s *Session) String() string {
+	return fmt.Sprintf("%s (device %s)", s.Peer, s.PeerDevice)
+}
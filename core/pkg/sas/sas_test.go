@@ -0,0 +1,174 @@
+package sas
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"testing"
+)
+
+var (
+	aliceKeys = Keys{IdentityKey: []byte("alice identity"), SigningKey: []byte("alice signing")}
+	bobKeys   = Keys{IdentityKey: []byte("bob identity"), SigningKey: []byte("bob signing")}
+)
+
+// Runs a verification up to the comparison. `aliceSeen` and `bobSeen` are
+// the keys each side knows for the other.
+func compare(t *testing.T, aliceSeen, bobSeen Keys) (*Session, *Session) {
+	t.Helper()
+
+	alice, request := Start("alice", "a1", "bob", "", aliceKeys)
+	if request.Envelope != (Envelope{TransactionID: alice.ID, From: "alice", FromDevice: "a1", To: "bob"}) {
+		t.Errorf("request envelope = %+v", request.Envelope)
+	}
+
+	bob, accept := Respond("bob", "b1", request, bobKeys, bobSeen)
+	if bob.ID != alice.ID || bob.Peer != "alice" || bob.PeerDevice != "a1" {
+		t.Errorf("responder session = %s, %s", bob.ID, bob)
+	}
+
+	reveal, err := alice.HandleAccept(accept, aliceSeen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alice.PeerDevice != "b1" {
+		t.Errorf("initiator peer device = %q, want b1", alice.PeerDevice)
+	}
+
+	if err := bob.HandleReveal(reveal); err != nil {
+		t.Fatal(err)
+	}
+
+	return alice, bob
+}
+
+func TestVerification(t *testing.T) {
+	alice, bob := compare(t, bobKeys, aliceKeys)
+
+	if alice.State() != StateComparing || bob.State() != StateComparing {
+		t.Fatalf("states = %d, %d", alice.State(), bob.State())
+	}
+	if len(alice.Code()) != 7 || !slices.Equal(alice.Code(), bob.Code()) {
+		t.Fatalf("codes differ: %s / %s", Format(alice.Code()), Format(bob.Code()))
+	}
+
+	aliceConfirm, err := alice.Confirm()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alice.State() != StateConfirmed {
+		t.Errorf("state after confirming = %d", alice.State())
+	}
+
+	if err := bob.HandleConfirm(aliceConfirm); err != nil {
+		t.Fatal(err)
+	}
+	bobConfirm, err := bob.Confirm()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bob.State() != StateVerified {
+		t.Errorf("state of the responder = %d", bob.State())
+	}
+
+	if err := alice.HandleConfirm(bobConfirm); err != nil {
+		t.Fatal(err)
+	}
+	if alice.State() != StateVerified {
+		t.Errorf("state of the initiator = %d", alice.State())
+	}
+}
+
+func TestVerificationDetectsReplacedKeys(t *testing.T) {
+	mallory := Keys{IdentityKey: []byte("mallory identity"), SigningKey: []byte("mallory signing")}
+
+	// The server gave alice other keys for bob
+	alice, bob := compare(t, mallory, aliceKeys)
+	if slices.Equal(alice.Code(), bob.Code()) {
+		t.Error("codes match although alice doesn't have the keys of bob")
+	}
+
+	// Users that confirm anyway are caught by the MAC
+	confirm, err := bob.Confirm()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := alice.HandleConfirm(confirm); !errors.Is(err, ErrInvalidMAC) {
+		t.Errorf("HandleConfirm() = %v, want %v", err, ErrInvalidMAC)
+	}
+}
+
+func TestRevealMustMatchCommitment(t *testing.T) {
+	alice, request := Start("alice", "a1", "bob", "b1", aliceKeys)
+	bob, accept := Respond("bob", "b1", request, bobKeys, aliceKeys)
+
+	reveal, err := alice.HandleAccept(accept, bobKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A nonce picked after seeing the one of bob
+	reveal.Nonce = bytes.Repeat([]byte{1}, nonceSize)
+	if err := bob.HandleReveal(reveal); !errors.Is(err, ErrInvalidCommitment) {
+		t.Errorf("HandleReveal() = %v, want %v", err, ErrInvalidCommitment)
+	}
+	if bob.Code() != nil {
+		t.Error("a code was derived from a wrong reveal")
+	}
+}
+
+func TestUnexpectedMessages(t *testing.T) {
+	alice, request := Start("alice", "a1", "bob", "", aliceKeys)
+	bob, accept := Respond("bob", "b1", request, bobKeys, aliceKeys)
+
+	if _, err := alice.Confirm(); !errors.Is(err, ErrUnexpectedMessage) {
+		t.Errorf("Confirm() before comparing = %v", err)
+	}
+	if err := alice.HandleReveal(Reveal{}); !errors.Is(err, ErrUnexpectedMessage) {
+		t.Errorf("HandleReveal() by the initiator = %v", err)
+	}
+	if _, err := bob.HandleAccept(accept, aliceKeys); !errors.Is(err, ErrUnexpectedMessage) {
+		t.Errorf("HandleAccept() by the responder = %v", err)
+	}
+
+	short := accept
+	short.Nonce = short.Nonce[:nonceSize-1]
+	if _, err := alice.HandleAccept(short, bobKeys); !errors.Is(err, ErrUnexpectedMessage) {
+		t.Errorf("HandleAccept() with a short nonce = %v", err)
+	}
+
+	alice.Cancel("user cancelled")
+	if _, err := alice.HandleAccept(accept, bobKeys); !errors.Is(err, ErrUnexpectedMessage) {
+		t.Errorf("HandleAccept() after cancelling = %v", err)
+	}
+}
+
+func TestEmojis(t *testing.T) {
+	tests := []struct {
+		name  string
+		bytes []byte
+		names []string
+	}{
+		{"zeros", make([]byte, 6), []string{"Dog", "Dog", "Dog", "Dog", "Dog", "Dog", "Dog"}},
+		// 111111 000001 000010 000011 000100 000101 000110 (+ 6 unused bits)
+		{"first 42 bits", []byte{0xfc, 0x10, 0x83, 0x10, 0x51, 0x8f}, []string{"Pin", "Cat", "Lion", "Horse", "Unicorn", "Pig", "Elephant"}},
+		{"extra bytes are ignored", []byte{0, 0, 0, 0, 0, 0, 0xff}, []string{"Dog", "Dog", "Dog", "Dog", "Dog", "Dog", "Dog"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code := emojis(tt.bytes)
+			names := make([]string, len(code))
+			for i, e := range code {
+				names[i] = e.Name
+			}
+			if !slices.Equal(names, tt.names) {
+				t.Errorf("emojis() = %v, want %v", names, tt.names)
+			}
+		})
+	}
+
+	if got := Format(emojis([]byte{0xfc, 0, 0, 0, 0, 0})[:2]); got != "📌 Pin · 🐶 Dog" {
+		t.Errorf("Format() = %q", got)
+	}
+}
@@ -0,0 +1,109 @@
+package sealed
+
+import (
+	"crypto/ed25519"
+	"crypto/mlkem"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/Guilospanck/pqc/core/pkg/cryptography"
+	"github.com/Guilospanck/pqc/core/pkg/transparency"
+)
+
+// Sealed sender: the sender identity travels encrypted, next to the message,
+// to the identity key of the recipient device. The server only sees the
+// delivery token of the recipient, and the recipient authenticates the
+// sender after decrypting, with:
+//
+//   - the publication of the sender keys in the key log (its "certificate"),
+//     that the recipient checks like any other publication;
+//   - a signature of the sender over the message and the KEM ciphertext,
+//     so the content can't be sealed again for someone else.
+
+var (
+	ErrInvalidEnvelope  = errors.New("could not open sealed message")
+	ErrInvalidSignature = errors.New("invalid sender signature")
+)
+
+// What the server sees of a sealed message
+type Envelope struct {
+	DeliveryToken []byte `json:"delivery_token"`
+	KEMCiphertext []byte `json:"kem_ciphertext"`
+	Nonce         []byte `json:"nonce"`
+	Ciphertext    []byte `json:"ciphertext"`
+}
+
+// What only the recipient sees
+type Content struct {
+	Sender    transparency.KeyPublication `json:"sender"`
+	Message   []byte                      `json:"message"`
+	Signature []byte                      `json:"signature"`
+}
+
+func signedData(kemCiphertext, message []byte) []byte {
+	data := []byte("pqc-sealed-sender")
+	data = binary.BigEndian.AppendUint32(data, uint32(len(kemCiphertext)))
+	data = append(data, kemCiphertext...)
+	return append(data, message...)
+}
+
+// Seals `message` for the device whose identity key is `recipientKey`.
+// `sender` is the publication of our own keys, `signingKey` must match it.
+func Seal(recipientKey, deliveryToken []byte, sender transparency.KeyPublication, signingKey ed25519.PrivateKey, message []byte) (Envelope, error) {
+	ek, err := mlkem.NewEncapsulationKey768(recipientKey)
+	if err != nil {
+		return Envelope{}, err
+	}
+	sharedSecret, kemCiphertext := ek.Encapsulate()
+
+	content, err := json.Marshal(Content{
+		Sender:    sender,
+		Message:   message,
+		Signature: ed25519.Sign(signingKey, signedData(kemCiphertext, message)),
+	})
+	if err != nil {
+		return Envelope{}, err
+	}
+
+	key := cryptography.DeriveKeyWithInfo(sharedSecret, []byte("pqc-sealed-sender"))
+	nonce, ciphertext, err := cryptography.EncryptMessage(key, content)
+	if err != nil {
+		return Envelope{}, err
+	}
+
+	return Envelope{
+		DeliveryToken: deliveryToken,
+		KEMCiphertext: kemCiphertext,
+		Nonce:         nonce,
+		Ciphertext:    ciphertext,
+	}, nil
+}
+
+// Decrypts a sealed message sent to us and checks the sender signature.
+// The caller must still verify `Content.Sender` against the key log.
+func Open(identity *mlkem.DecapsulationKey768, envelope Envelope) (Content, error) {
+	sharedSecret, err := identity.Decapsulate(envelope.KEMCiphertext)
+	if err != nil {
+		return Content{}, fmt.Errorf("%w: %w", ErrInvalidEnvelope, err)
+	}
+
+	key := cryptography.DeriveKeyWithInfo(sharedSecret, []byte("pqc-sealed-sender"))
+	plaintext, err := cryptography.DecryptMessage(key, envelope.Nonce, envelope.Ciphertext)
+	if err != nil {
+		return Content{}, fmt.Errorf("%w: %w", ErrInvalidEnvelope, err)
+	}
+
+	var content Content
+	if err := json.Unmarshal(plaintext, &content); err != nil {
+		return Content{}, fmt.Errorf("%w: %w", ErrInvalidEnvelope, err)
+	}
+
+	signingKey := content.Sender.Binding.SigningKey
+	if len(signingKey) != ed25519.PublicKeySize || !ed25519.Verify(ed25519.PublicKey(signingKey), signedData(envelope.KEMCiphertext, content.Message), content.Signature) {
+		return Content{}, fmt.Errorf("%w: from %s", ErrInvalidSignature, content.Sender.Binding.Username)
+	}
+
+	return content, nil
+}
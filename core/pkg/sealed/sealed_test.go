@@ -0,0 +1,142 @@
+package sealed
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/Guilospanck/pqc/core/pkg/cryptography"
+	"github.com/Guilospanck/pqc/core/pkg/transparency"
+)
+
+func newKeys(t *testing.T) cryptography.Keys {
+	t.Helper()
+
+	keys, err := cryptography.GenerateKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func publication(username string, keys cryptography.Keys) transparency.KeyPublication {
+	return transparency.KeyPublication{
+		Binding: transparency.Binding{
+			Username:   username,
+			Device:     "laptop",
+			PublicKey:  keys.Public,
+			SigningKey: keys.Signing.Public().(ed25519.PublicKey),
+		},
+	}
+}
+
+func relay(t *testing.T, envelope Envelope) Envelope {
+	t.Helper()
+
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var relayed Envelope
+	if err := json.Unmarshal(data, &relayed); err != nil {
+		t.Fatal(err)
+	}
+	return relayed
+}
+
+func TestSealOpen(t *testing.T) {
+	alice, bob := newKeys(t), newKeys(t)
+	token := []byte("delivery token")
+
+	for _, message := range []string{"", "hello bob", string(make([]byte, 64*1024))} {
+		envelope, err := Seal(bob.Public, token, publication("alice", alice), alice.Signing, []byte(message))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		content, err := Open(bob.Private, relay(t, envelope))
+		if err != nil {
+			t.Fatalf("Open() = %v", err)
+		}
+		if string(content.Message) != message || content.Sender.Binding.Username != "alice" {
+			t.Errorf("Open() = %d bytes from %s", len(content.Message), content.Sender.Binding.Username)
+		}
+		if string(envelope.DeliveryToken) != string(token) {
+			t.Errorf("envelope for token %q", envelope.DeliveryToken)
+		}
+	}
+}
+
+func TestOpenTampered(t *testing.T) {
+	alice, bob, mallory := newKeys(t), newKeys(t), newKeys(t)
+
+	envelope, err := Seal(bob.Public, []byte("token"), publication("alice", alice), alice.Signing, []byte("hello bob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		change func(e *Envelope)
+	}{
+		{"kem ciphertext", func(e *Envelope) { e.KEMCiphertext[0] ^= 1 }},
+		{"short kem ciphertext", func(e *Envelope) { e.KEMCiphertext = e.KEMCiphertext[:16] }},
+		{"nonce", func(e *Envelope) { e.Nonce[0] ^= 1 }},
+		{"ciphertext", func(e *Envelope) { e.Ciphertext[0] ^= 1 }},
+		{"truncated ciphertext", func(e *Envelope) { e.Ciphertext = e.Ciphertext[:len(e.Ciphertext)-1] }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tampered := relay(t, envelope)
+			tt.change(&tampered)
+
+			if _, err := Open(bob.Private, tampered); !errors.Is(err, ErrInvalidEnvelope) {
+				t.Errorf("Open() = %v, want %v", err, ErrInvalidEnvelope)
+			}
+		})
+	}
+
+	t.Run("other recipient", func(t *testing.T) {
+		if _, err := Open(mallory.Private, envelope); !errors.Is(err, ErrInvalidEnvelope) {
+			t.Errorf("Open() = %v, want %v", err, ErrInvalidEnvelope)
+		}
+	})
+}
+
+func TestOpenForgedSender(t *testing.T) {
+	alice, bob, mallory := newKeys(t), newKeys(t), newKeys(t)
+
+	tests := []struct {
+		name       string
+		sender     transparency.KeyPublication
+		signingKey cryptography.Keys
+	}{
+		// Mallory claims to be alice but can't sign with her key
+		{"keys of someone else", publication("alice", alice), mallory},
+		{"no signing key", transparency.KeyPublication{Binding: transparency.Binding{Username: "alice"}}, mallory},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envelope, err := Seal(bob.Public, []byte("token"), tt.sender, tt.signingKey.Signing, []byte("hello bob"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := Open(bob.Private, envelope); !errors.Is(err, ErrInvalidSignature) {
+				t.Errorf("Open() = %v, want %v", err, ErrInvalidSignature)
+			}
+		})
+	}
+}
+
+func TestSealInvalidRecipientKey(t *testing.T) {
+	alice := newKeys(t)
+
+	if _, err := Seal([]byte("not a key"), nil, publication("alice", alice), alice.Signing, []byte("hello")); err == nil {
+		t.Error("Seal() to an invalid key succeeded")
+	}
+}
@@ -0,0 +1,58 @@
+package transparency
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/Guilospanck/pqc/core/pkg/jsonl"
+)
+
+// Where the log keeps its bindings. They are only ever appended.
+type Backend interface {
+	Append(binding Binding) error
+	Bindings() ([]Binding, error)
+}
+
+// Keeps the bindings until the server stops
+type MemoryBackend struct {
+	bindings []Binding
+	mu       sync.Mutex
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{bindings: make([]Binding, 0)}
+}
+
+func (b *MemoryBackend) Append(binding Binding) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.bindings = append(b.bindings, binding)
+	return nil
+}
+
+func (b *MemoryBackend) Bindings() ([]Binding, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return slices.Clone(b.bindings), nil
+}
+
+// Keeps the bindings in a file, one JSON binding per line
+type FileBackend struct {
+	file *jsonl.File[Binding]
+}
+
+// The tree head of a binding is sent right after it is appended, so it must
+// not be lost: appends are synced to disk
+func NewFileBackend(path string) *FileBackend {
+	return &FileBackend{file: jsonl.New[Binding](path, true)}
+}
+
+func (b *FileBackend) Append(binding Binding) error {
+	return b.file.Append(binding)
+}
+
+func (b *FileBackend) Bindings() ([]Binding, error) {
+	return b.file.All()
+}
@@ -0,0 +1,232 @@
+package transparency
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Guilospanck/pqc/core/pkg/devices"
+)
+
+// A (username, device, public key) tuple published by the server.
+// `PublicKey` is the ML-KEM identity key and `SigningKey` the identity signing key.
+type Binding struct {
+	Username   string `json:"username"`
+	Device     string `json:"device"`
+	PublicKey  []byte `json:"public_key"`
+	SigningKey []byte `json:"signing_key"`
+}
+
+// Leaf encoding is length-prefixed so that two different bindings
+// can never produce the same leaf.
+func (b Binding) leafData() []byte {
+	data := make([]byte, 0, 16+len(b.Username)+len(b.Device)+len(b.PublicKey)+len(b.SigningKey))
+	for _, field := range [][]byte{[]byte(b.Username), []byte(b.Device), b.PublicKey, b.SigningKey} {
+		data = binary.BigEndian.AppendUint32(data, uint32(len(field)))
+		data = append(data, field...)
+	}
+	return data
+}
+
+func (b Binding) Equal(other Binding) bool {
+	return b.Username == other.Username &&
+		b.Device == other.Device &&
+		bytes.Equal(b.PublicKey, other.PublicKey) &&
+		bytes.Equal(b.SigningKey, other.SigningKey)
+}
+
+func (b Binding) LeafHash() []byte {
+	return HashLeaf(b.leafData())
+}
+
+type TreeHead struct {
+	Size      uint64 `json:"size"`
+	RootHash  []byte `json:"root_hash"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+func (th TreeHead) signedData() []byte {
+	data := []byte("pqc-key-transparency-v1")
+	data = binary.BigEndian.AppendUint64(data, th.Size)
+	data = binary.BigEndian.AppendUint64(data, uint64(th.Timestamp))
+	data = append(data, th.RootHash...)
+	return data
+}
+
+type SignedTreeHead struct {
+	TreeHead
+	Signature []byte `json:"signature"`
+}
+
+func (sth SignedTreeHead) Verify(publicKey ed25519.PublicKey) bool {
+	return ed25519.Verify(publicKey, sth.signedData(), sth.Signature)
+}
+
+// Append-only Merkle log of every published binding.
+// Clients pin the signing key of the log and check that every tree head
+// extends the ones they saw before, so both must outlive the server: a log
+// kept in memory (or a new signing key) starts a new history, which clients
+// report as a changed log key.
+type Log struct {
+	signingKey ed25519.PrivateKey
+	backend    Backend
+	leaves     [][]byte
+	bindings   []Binding
+	latest     map[string]uint64 // device address -> index of its latest binding
+	mu         sync.RWMutex
+}
+
+// Opens the log kept in `backend`, signing its tree heads with `signingKey`
+func NewLog(signingKey ed25519.PrivateKey, backend Backend) (*Log, error) {
+	bindings, err := backend.Bindings()
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Log{
+		signingKey: signingKey,
+		backend:    backend,
+		leaves:     make([][]byte, 0, len(bindings)),
+		bindings:   make([]Binding, 0, len(bindings)),
+		latest:     make(map[string]uint64),
+	}
+
+	for _, binding := range bindings {
+		l.add(binding)
+	}
+
+	return l, nil
+}
+
+// Reads the signing key of the log saved at `path` (hex encoded seed),
+// generating it if the file doesn't exist. Without a path, the key only
+// lives in memory.
+func LoadSigningKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if path == "" || errors.Is(err, os.ErrNotExist) {
+		return newSigningKey(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	seed, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("invalid log signing key in %s", path)
+	}
+
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+func newSigningKey(path string) (ed25519.PrivateKey, error) {
+	_, signingKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if path == "" {
+		return signingKey, nil
+	}
+
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(signingKey.Seed())+"\n"), 0600); err != nil {
+		return nil, err
+	}
+
+	return signingKey, nil
+}
+
+func (l *Log) PublicKey() ed25519.PublicKey {
+	return l.signingKey.Public().(ed25519.PublicKey)
+}
+
+// Appends a binding and returns its leaf index. The binding is saved
+// before any tree head includes it.
+func (l *Log) Append(binding Binding) (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.backend.Append(binding); err != nil {
+		return 0, err
+	}
+
+	return l.add(binding), nil
+}
+
+// Must be called with l.mu held (or before the log is shared)
+func (l *Log) add(binding Binding) uint64 {
+	index := uint64(len(l.leaves))
+	l.leaves = append(l.leaves, binding.LeafHash())
+	l.bindings = append(l.bindings, binding)
+	l.latest[devices.Address(binding.Username, binding.Device)] = index
+
+	return index
+}
+
+// Returns the latest binding published for the `device` of `username`
+func (l *Log) Lookup(username, device string) (Binding, uint64, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	index, ok := l.latest[devices.Address(username, device)]
+	if !ok {
+		return Binding{}, 0, false
+	}
+
+	return l.bindings[index], index, true
+}
+
+func (l *Log) SignedTreeHead() SignedTreeHead {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.signTreeHead(uint64(len(l.leaves)))
+}
+
+func (l *Log) signTreeHead(size uint64) SignedTreeHead {
+	head := TreeHead{
+		Size:      size,
+		RootHash:  rootHash(l.leaves[:size]),
+		Timestamp: time.Now().UnixMilli(),
+	}
+
+	return SignedTreeHead{
+		TreeHead:  head,
+		Signature: ed25519.Sign(l.signingKey, head.signedData()),
+	}
+}
+
+// Inclusion proof of the leaf at `index` in the current tree,
+// along with the signed tree head it was computed against.
+func (l *Log) InclusionProof(index uint64) ([][]byte, SignedTreeHead, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	size := uint64(len(l.leaves))
+	if index >= size {
+		return nil, SignedTreeHead{}, ErrInvalidIndex
+	}
+
+	return inclusionProof(l.leaves, index), l.signTreeHead(size), nil
+}
+
+func (l *Log) ConsistencyProof(first, second uint64) ([][]byte, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if first > second || second > uint64(len(l.leaves)) {
+		return nil, fmt.Errorf("%w: %d -> %d", ErrInvalidSizes, first, second)
+	}
+
+	if first == 0 {
+		return [][]byte{}, nil
+	}
+
+	return consistencyProof(l.leaves[:second], first), nil
+}
@@ -0,0 +1,413 @@
+package transparency
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func testBinding(i int) Binding {
+	return Binding{
+		Username:   fmt.Sprintf("user %d", i),
+		Device:     fmt.Sprintf("device%d", i),
+		PublicKey:  bytes.Repeat([]byte{byte(i)}, 32),
+		SigningKey: bytes.Repeat([]byte{byte(i + 1)}, ed25519.PublicKeySize),
+	}
+}
+
+func newTestLog(t *testing.T, backend Backend) *Log {
+	t.Helper()
+
+	signingKey, err := LoadSigningKey("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := NewLog(signingKey, backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func appendBindings(t *testing.T, l *Log, from, to int) {
+	t.Helper()
+
+	for i := from; i < to; i++ {
+		index, err := l.Append(testBinding(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if index != uint64(i) {
+			t.Fatalf("binding %d appended at index %d", i, index)
+		}
+	}
+}
+
+// Publication of the `i`th binding, like the server sends it
+func publication(t *testing.T, l *Log, i int) KeyPublication {
+	t.Helper()
+
+	proof, head, err := l.InclusionProof(uint64(i))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return KeyPublication{Binding: testBinding(i), Index: uint64(i), Proof: proof, TreeHead: head, LogKey: l.PublicKey()}
+}
+
+func TestBindingLeafEncoding(t *testing.T) {
+	// Moving bytes from one field to the next must change the leaf
+	a := Binding{Username: "ab", Device: "c"}
+	b := Binding{Username: "a", Device: "bc"}
+
+	if bytes.Equal(a.LeafHash(), b.LeafHash()) {
+		t.Error("different bindings have the same leaf hash")
+	}
+}
+
+func TestLogLookup(t *testing.T) {
+	l := newTestLog(t, NewMemoryBackend())
+	appendBindings(t, l, 0, 3)
+
+	// A new key for the device of user 1
+	updated := testBinding(1)
+	updated.PublicKey = bytes.Repeat([]byte{0xff}, 32)
+	if _, err := l.Append(updated); err != nil {
+		t.Fatal(err)
+	}
+
+	binding, index, ok := l.Lookup("user 1", "device1")
+	if !ok || index != 3 || !binding.Equal(updated) {
+		t.Errorf("Lookup() = %v, %d, %t, want the latest binding at index 3", binding, index, ok)
+	}
+
+	if _, _, ok := l.Lookup("user 1", "device2"); ok {
+		t.Error("Lookup() found a device that never published keys")
+	}
+}
+
+func TestLogInclusionProofs(t *testing.T) {
+	l := newTestLog(t, NewMemoryBackend())
+	appendBindings(t, l, 0, 7)
+
+	for i := range 7 {
+		p := publication(t, l, i)
+
+		if !p.TreeHead.Verify(l.PublicKey()) {
+			t.Fatalf("tree head of binding %d has an invalid signature", i)
+		}
+		if err := NewMonitor().VerifyPublication(p); err != nil {
+			t.Errorf("VerifyPublication(%d) = %v", i, err)
+		}
+	}
+
+	if _, _, err := l.InclusionProof(7); !errors.Is(err, ErrInvalidIndex) {
+		t.Errorf("InclusionProof() out of range = %v, want %v", err, ErrInvalidIndex)
+	}
+}
+
+func TestLogConsistencyProofs(t *testing.T) {
+	l := newTestLog(t, NewMemoryBackend())
+
+	heads := []SignedTreeHead{l.SignedTreeHead()}
+	for i := range 6 {
+		appendBindings(t, l, i, i+1)
+		heads = append(heads, l.SignedTreeHead())
+	}
+
+	for _, first := range heads {
+		for _, second := range heads[first.Size:] {
+			proof, err := l.ConsistencyProof(first.Size, second.Size)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := VerifyConsistency(first.Size, second.Size, first.RootHash, second.RootHash, proof); err != nil {
+				t.Errorf("tree heads %d and %d are not consistent: %v", first.Size, second.Size, err)
+			}
+		}
+	}
+
+	if _, err := l.ConsistencyProof(4, 7); !errors.Is(err, ErrInvalidSizes) {
+		t.Errorf("ConsistencyProof() beyond the log = %v, want %v", err, ErrInvalidSizes)
+	}
+}
+
+func TestSignedTreeHeadTampered(t *testing.T) {
+	l := newTestLog(t, NewMemoryBackend())
+	appendBindings(t, l, 0, 3)
+	head := l.SignedTreeHead()
+
+	_, otherKey, _ := ed25519.GenerateKey(nil)
+
+	tests := []struct {
+		name   string
+		change func(sth *SignedTreeHead)
+		key    ed25519.PublicKey
+	}{
+		{"size", func(sth *SignedTreeHead) { sth.Size++ }, l.PublicKey()},
+		{"root", func(sth *SignedTreeHead) { sth.RootHash = bytes.Clone(sth.RootHash); sth.RootHash[0] ^= 1 }, l.PublicKey()},
+		{"timestamp", func(sth *SignedTreeHead) { sth.Timestamp++ }, l.PublicKey()},
+		{"other log key", func(sth *SignedTreeHead) {}, otherKey.Public().(ed25519.PublicKey)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sth := head
+			tt.change(&sth)
+			if sth.Verify(tt.key) {
+				t.Error("tampered tree head verified")
+			}
+		})
+	}
+}
+
+func TestMonitor(t *testing.T) {
+	l := newTestLog(t, NewMemoryBackend())
+	appendBindings(t, l, 0, 2)
+
+	m := NewMonitor()
+	if check, err := m.ObserveTreeHead(l.PublicKey(), l.SignedTreeHead()); check != nil || err != nil {
+		t.Fatalf("first tree head: %v, %v", check, err)
+	}
+
+	appendBindings(t, l, 2, 5)
+	check, err := m.ObserveTreeHead(l.PublicKey(), l.SignedTreeHead())
+	if err != nil || check == nil || check.First != 2 || check.Second != 5 {
+		t.Fatalf("ObserveTreeHead() = %v, %v, want a check from 2 to 5", check, err)
+	}
+
+	if check.Proof, err = l.ConsistencyProof(check.First, check.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.VerifyConsistency(*check); err != nil {
+		t.Errorf("VerifyConsistency() = %v", err)
+	}
+
+	// The same tree head again needs no proof
+	if check, err := m.ObserveTreeHead(l.PublicKey(), l.SignedTreeHead()); check != nil || err != nil {
+		t.Errorf("known tree head: %v, %v", check, err)
+	}
+}
+
+func TestMonitorDetectsMisbehavingLog(t *testing.T) {
+	l := newTestLog(t, NewMemoryBackend())
+	appendBindings(t, l, 0, 3)
+
+	// Same signing key, but a different history
+	forked, err := NewLog(l.signingKey, NewMemoryBackend())
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendBindings(t, forked, 0, 2)
+	if _, err := forked.Append(testBinding(9)); err != nil {
+		t.Fatal(err)
+	}
+	appendBindings(t, forked, 3, 4)
+
+	other := newTestLog(t, NewMemoryBackend())
+
+	t.Run("log key changed", func(t *testing.T) {
+		m := NewMonitor()
+		m.ObserveTreeHead(l.PublicKey(), l.SignedTreeHead())
+		if _, err := m.ObserveTreeHead(other.PublicKey(), other.SignedTreeHead()); !errors.Is(err, ErrLogKeyChanged) {
+			t.Errorf("ObserveTreeHead() = %v, want %v", err, ErrLogKeyChanged)
+		}
+	})
+
+	t.Run("invalid signature", func(t *testing.T) {
+		sth := l.SignedTreeHead()
+		sth.Signature = bytes.Clone(sth.Signature)
+		sth.Signature[0] ^= 1
+		if _, err := NewMonitor().ObserveTreeHead(l.PublicKey(), sth); !errors.Is(err, ErrInvalidTreeHead) {
+			t.Errorf("ObserveTreeHead() = %v, want %v", err, ErrInvalidTreeHead)
+		}
+	})
+
+	t.Run("two roots for the same size", func(t *testing.T) {
+		rewritten, err := NewLog(l.signingKey, NewMemoryBackend())
+		if err != nil {
+			t.Fatal(err)
+		}
+		appendBindings(t, rewritten, 0, 2)
+		rewritten.Append(testBinding(9))
+
+		m := NewMonitor()
+		m.ObserveTreeHead(l.PublicKey(), l.SignedTreeHead())
+		if _, err := m.ObserveTreeHead(rewritten.PublicKey(), rewritten.SignedTreeHead()); !errors.Is(err, ErrForkedLog) {
+			t.Errorf("ObserveTreeHead() = %v, want %v", err, ErrForkedLog)
+		}
+	})
+
+	t.Run("inconsistent history", func(t *testing.T) {
+		m := NewMonitor()
+		m.ObserveTreeHead(l.PublicKey(), l.SignedTreeHead())
+
+		check, err := m.ObserveTreeHead(forked.PublicKey(), forked.SignedTreeHead())
+		if err != nil || check == nil {
+			t.Fatalf("ObserveTreeHead() = %v, %v", check, err)
+		}
+		if check.Proof, err = forked.ConsistencyProof(check.First, check.Second); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.VerifyConsistency(*check); !errors.Is(err, ErrInvalidProof) {
+			t.Errorf("VerifyConsistency() = %v, want %v", err, ErrInvalidProof)
+		}
+	})
+
+	t.Run("unknown tree head", func(t *testing.T) {
+		m := NewMonitor()
+		m.ObserveTreeHead(l.PublicKey(), l.SignedTreeHead())
+		if err := m.VerifyConsistency(ConsistencyCheck{First: 3, Second: 4}); !errors.Is(err, ErrUnknownTreeHead) {
+			t.Errorf("VerifyConsistency() = %v, want %v", err, ErrUnknownTreeHead)
+		}
+	})
+
+	t.Run("binding not in the tree", func(t *testing.T) {
+		p := publication(t, l, 1)
+		p.Binding = testBinding(2)
+		if err := NewMonitor().VerifyPublication(p); !errors.Is(err, ErrInvalidProof) {
+			t.Errorf("VerifyPublication() = %v, want %v", err, ErrInvalidProof)
+		}
+	})
+}
+
+// A monitor restored from its saved state goes on checking the log from the
+// tree head it trusted
+func TestMonitorRestored(t *testing.T) {
+	l := newTestLog(t, NewMemoryBackend())
+	appendBindings(t, l, 0, 3)
+
+	if _, ok := NewMonitor().State(); ok {
+		t.Error("a new monitor has a state to save")
+	}
+
+	m := NewMonitor()
+	m.ObserveTreeHead(l.PublicKey(), l.SignedTreeHead())
+	state, ok := m.State()
+	if !ok || state.TreeHead.Size != 3 {
+		t.Fatalf("State() = %+v, %t", state, ok)
+	}
+
+	forked, err := NewLog(l.signingKey, NewMemoryBackend())
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendBindings(t, forked, 0, 2)
+	forked.Append(testBinding(9))
+	appendBindings(t, forked, 3, 5)
+
+	appendBindings(t, l, 3, 5)
+
+	t.Run("consistent", func(t *testing.T) {
+		restored, err := RestoreMonitor(state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check, err := restored.ObserveTreeHead(l.PublicKey(), l.SignedTreeHead())
+		if err != nil || check == nil || check.First != 3 || check.Second != 5 {
+			t.Fatalf("ObserveTreeHead() = %v, %v, want a check from 3 to 5", check, err)
+		}
+		if check.Proof, err = l.ConsistencyProof(check.First, check.Second); err != nil {
+			t.Fatal(err)
+		}
+		if err := restored.VerifyConsistency(*check); err != nil {
+			t.Errorf("VerifyConsistency() = %v", err)
+		}
+	})
+
+	t.Run("forked while away", func(t *testing.T) {
+		restored, err := RestoreMonitor(state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check, err := restored.ObserveTreeHead(forked.PublicKey(), forked.SignedTreeHead())
+		if err != nil || check == nil {
+			t.Fatalf("ObserveTreeHead() = %v, %v", check, err)
+		}
+		if check.Proof, err = forked.ConsistencyProof(check.First, check.Second); err != nil {
+			t.Fatal(err)
+		}
+		if err := restored.VerifyConsistency(*check); !errors.Is(err, ErrInvalidProof) {
+			t.Errorf("VerifyConsistency() = %v, want %v", err, ErrInvalidProof)
+		}
+	})
+
+	t.Run("log key changed", func(t *testing.T) {
+		restored, err := RestoreMonitor(state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		other := newTestLog(t, NewMemoryBackend())
+		if _, err := restored.ObserveTreeHead(other.PublicKey(), other.SignedTreeHead()); !errors.Is(err, ErrLogKeyChanged) {
+			t.Errorf("ObserveTreeHead() = %v, want %v", err, ErrLogKeyChanged)
+		}
+	})
+
+	t.Run("tampered state", func(t *testing.T) {
+		tampered := state
+		tampered.TreeHead.Size = 4
+		if _, err := RestoreMonitor(tampered); !errors.Is(err, ErrInvalidTreeHead) {
+			t.Errorf("RestoreMonitor() = %v, want %v", err, ErrInvalidTreeHead)
+		}
+
+		tampered = state
+		tampered.LogKey = tampered.LogKey[:16]
+		if _, err := RestoreMonitor(tampered); !errors.Is(err, ErrInvalidLogKey) {
+			t.Errorf("RestoreMonitor() = %v, want %v", err, ErrInvalidLogKey)
+		}
+	})
+}
+
+// A log kept in a file, opened again with the same key, extends the history
+// clients already saw
+func TestLogSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	file, keyFile := filepath.Join(dir, "key-log.jsonl"), filepath.Join(dir, "key-log.key")
+
+	open := func() *Log {
+		t.Helper()
+
+		signingKey, err := LoadSigningKey(keyFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l, err := NewLog(signingKey, NewFileBackend(file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return l
+	}
+
+	l := open()
+	appendBindings(t, l, 0, 3)
+
+	m := NewMonitor()
+	m.ObserveTreeHead(l.PublicKey(), l.SignedTreeHead())
+
+	restarted := open()
+	if !bytes.Equal(restarted.PublicKey(), l.PublicKey()) {
+		t.Fatal("the signing key changed")
+	}
+	if head := restarted.SignedTreeHead(); head.Size != 3 || !bytes.Equal(head.RootHash, l.SignedTreeHead().RootHash) {
+		t.Fatalf("restarted log has %d leaves and root %x", head.Size, head.RootHash)
+	}
+	if _, index, ok := restarted.Lookup("user 2", "device2"); !ok || index != 2 {
+		t.Errorf("Lookup() after restart = %d, %t", index, ok)
+	}
+
+	appendBindings(t, restarted, 3, 5)
+	check, err := m.ObserveTreeHead(restarted.PublicKey(), restarted.SignedTreeHead())
+	if err != nil || check == nil {
+		t.Fatalf("ObserveTreeHead() = %v, %v", check, err)
+	}
+	if check.Proof, err = restarted.ConsistencyProof(check.First, check.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.VerifyConsistency(*check); err != nil {
+		t.Errorf("VerifyConsistency() across restarts = %v", err)
+	}
+}
@@ -0,0 +1,193 @@
+package transparency
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"math/bits"
+)
+
+// Hashing follows RFC 6962/9162: leaves and interior nodes are
+// domain-separated so a leaf can never be passed off as a node.
+const (
+	leafPrefix byte = 0x00
+	nodePrefix byte = 0x01
+)
+
+var (
+	ErrInvalidProof = errors.New("invalid merkle proof")
+	ErrInvalidIndex = errors.New("leaf index out of range")
+	ErrInvalidSizes = errors.New("invalid tree sizes")
+)
+
+func HashLeaf(data []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{leafPrefix})
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+func hashChildren(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{nodePrefix})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// Largest power of two strictly smaller than n (n > 1)
+func splitPoint(n uint64) uint64 {
+	return 1 << (bits.Len64(n-1) - 1)
+}
+
+// Merkle Tree Hash (MTH) over already hashed leaves
+func rootHash(leaves [][]byte) []byte {
+	switch n := uint64(len(leaves)); n {
+	case 0:
+		empty := sha256.Sum256(nil)
+		return empty[:]
+	case 1:
+		return leaves[0]
+	default:
+		k := splitPoint(n)
+		return hashChildren(rootHash(leaves[:k]), rootHash(leaves[k:]))
+	}
+}
+
+// Audit path for the leaf at `index` in the tree made of `leaves`
+func inclusionProof(leaves [][]byte, index uint64) [][]byte {
+	n := uint64(len(leaves))
+	if n <= 1 {
+		return [][]byte{}
+	}
+
+	k := splitPoint(n)
+	if index < k {
+		return append(inclusionProof(leaves[:k], index), rootHash(leaves[k:]))
+	}
+	return append(inclusionProof(leaves[k:], index-k), rootHash(leaves[:k]))
+}
+
+// Proof that the first `size` leaves are a prefix of `leaves`
+func consistencyProof(leaves [][]byte, size uint64) [][]byte {
+	return subProof(leaves, size, true)
+}
+
+func subProof(leaves [][]byte, m uint64, complete bool) [][]byte {
+	n := uint64(len(leaves))
+	if m == n {
+		if complete {
+			return [][]byte{}
+		}
+		return [][]byte{rootHash(leaves)}
+	}
+
+	k := splitPoint(n)
+	if m <= k {
+		return append(subProof(leaves[:k], m, complete), rootHash(leaves[k:]))
+	}
+	return append(subProof(leaves[k:], m-k, false), rootHash(leaves[:k]))
+}
+
+// Verifies that `leafHash` is the leaf at `index` of a tree of `size` leaves
+// whose root is `root` (RFC 9162, section 2.1.3.2).
+func VerifyInclusion(leafHash []byte, index, size uint64, proof [][]byte, root []byte) error {
+	if index >= size {
+		return ErrInvalidIndex
+	}
+
+	fn, sn := index, size-1
+	r := leafHash
+
+	for _, p := range proof {
+		if sn == 0 {
+			return ErrInvalidProof
+		}
+
+		if fn&1 == 1 || fn == sn {
+			r = hashChildren(p, r)
+			if fn&1 == 0 {
+				for fn&1 == 0 && fn != 0 {
+					fn >>= 1
+					sn >>= 1
+				}
+			}
+		} else {
+			r = hashChildren(r, p)
+		}
+
+		fn >>= 1
+		sn >>= 1
+	}
+
+	if sn != 0 || !bytes.Equal(r, root) {
+		return ErrInvalidProof
+	}
+
+	return nil
+}
+
+// Verifies that the tree of size `first` and root `firstRoot` is a prefix
+// of the tree of size `second` and root `secondRoot` (RFC 9162, section 2.1.4.2).
+func VerifyConsistency(first, second uint64, firstRoot, secondRoot []byte, proof [][]byte) error {
+	if first > second {
+		return ErrInvalidSizes
+	}
+
+	if first == second {
+		if len(proof) != 0 || !bytes.Equal(firstRoot, secondRoot) {
+			return ErrInvalidProof
+		}
+		return nil
+	}
+
+	// Every tree is consistent with the empty tree
+	if first == 0 {
+		if len(proof) != 0 {
+			return ErrInvalidProof
+		}
+		return nil
+	}
+
+	if first&(first-1) == 0 {
+		proof = append([][]byte{firstRoot}, proof...)
+	}
+	if len(proof) == 0 {
+		return ErrInvalidProof
+	}
+
+	fn, sn := first-1, second-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+
+	fr, sr := proof[0], proof[0]
+	for _, c := range proof[1:] {
+		if sn == 0 {
+			return ErrInvalidProof
+		}
+
+		if fn&1 == 1 || fn == sn {
+			fr = hashChildren(c, fr)
+			sr = hashChildren(c, sr)
+			if fn&1 == 0 {
+				for fn&1 == 0 && fn != 0 {
+					fn >>= 1
+					sn >>= 1
+				}
+			}
+		} else {
+			sr = hashChildren(sr, c)
+		}
+
+		fn >>= 1
+		sn >>= 1
+	}
+
+	if sn != 0 || !bytes.Equal(fr, firstRoot) || !bytes.Equal(sr, secondRoot) {
+		return ErrInvalidProof
+	}
+
+	return nil
+}
@@ -0,0 +1,272 @@
+package transparency
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+// Test vectors of RFC 9162 (the ones of the certificate-transparency
+// reference implementation): 8 leaves, with the roots of every prefix.
+var vectorLeaves = []string{
+	"",
+	"00",
+	"10",
+	"2021",
+	"3031",
+	"40414243",
+	"5051525354555657",
+	"606162636465666768696a6b6c6d6e6f",
+}
+
+var vectorRoots = []string{
+	"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", // empty tree
+	"6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d",
+	"fac54203e7cc696cf0dfcb42c92a1d9dbaf70ad9e621f4bd8d98662f00e3c125",
+	"aeb6bcfe274b70a14fb067a5e5578264db0fa9b51af5e0ba159158f329e06e77",
+	"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+	"4e3bbb1f7b478dcfe71fb631631519a3bca12c9aefca1612bfce4c13a86264d4",
+	"76e67dadbcdf1e10e1b74ddc608abd2f98dfb16fbce75277b5232a127f2087ef",
+	"ddb89be403809e325750d3d263cd78929c2942b7942a34b77e122c9594a74c8c",
+	"5dc9da79a70659a9ad559cb701ded9a2ab9d823aad2f4960cfe370eff4604328",
+}
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("invalid hex %q: %s", s, err)
+	}
+	return b
+}
+
+func unhexAll(t *testing.T, ss ...string) [][]byte {
+	t.Helper()
+
+	all := make([][]byte, 0, len(ss))
+	for _, s := range ss {
+		all = append(all, unhex(t, s))
+	}
+	return all
+}
+
+func vectorLeafHashes(t *testing.T) [][]byte {
+	t.Helper()
+
+	hashes := make([][]byte, 0, len(vectorLeaves))
+	for _, leaf := range vectorLeaves {
+		hashes = append(hashes, HashLeaf(unhex(t, leaf)))
+	}
+	return hashes
+}
+
+func TestRootHash(t *testing.T) {
+	leaves := vectorLeafHashes(t)
+
+	for size, want := range vectorRoots {
+		if got := rootHash(leaves[:size]); !bytes.Equal(got, unhex(t, want)) {
+			t.Errorf("root of %d leaves = %x, want %s", size, got, want)
+		}
+	}
+}
+
+func TestInclusionProof(t *testing.T) {
+	leaves := vectorLeafHashes(t)
+
+	tests := []struct {
+		index uint64
+		size  uint64
+		proof [][]byte
+	}{
+		{0, 1, [][]byte{}},
+		{0, 8, unhexAll(t,
+			"96a296d224f285c67bee93c30f8a309157f0daa35dc5b87e410b78630a09cfc7",
+			"5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+			"6b47aaf29ee3c2af9af889bc1fb9254dabd31177f16232dd6aab035ca39bf6e4",
+		)},
+		{5, 8, unhexAll(t,
+			"bc1a0643b12e4d2d7c77918f44e0f4f79a838b6cf9ec5b5c283e1f4d88599e6b",
+			"ca854ea128ed050b41b35ffc1b87b8eb2bde461e9e3b5596ece6b9d5975a0ae0",
+			"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+		)},
+		{2, 3, unhexAll(t,
+			"fac54203e7cc696cf0dfcb42c92a1d9dbaf70ad9e621f4bd8d98662f00e3c125",
+		)},
+		{1, 5, unhexAll(t,
+			"6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d",
+			"5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+			"bc1a0643b12e4d2d7c77918f44e0f4f79a838b6cf9ec5b5c283e1f4d88599e6b",
+		)},
+	}
+
+	for _, tt := range tests {
+		proof := inclusionProof(leaves[:tt.size], tt.index)
+		if len(proof) != len(tt.proof) {
+			t.Errorf("inclusion proof of %d in %d has %d nodes, want %d", tt.index, tt.size, len(proof), len(tt.proof))
+			continue
+		}
+		for i := range proof {
+			if !bytes.Equal(proof[i], tt.proof[i]) {
+				t.Errorf("inclusion proof of %d in %d: node %d = %x, want %x", tt.index, tt.size, i, proof[i], tt.proof[i])
+			}
+		}
+
+		root := unhex(t, vectorRoots[tt.size])
+		if err := VerifyInclusion(leaves[tt.index], tt.index, tt.size, tt.proof, root); err != nil {
+			t.Errorf("VerifyInclusion(%d, %d) = %v", tt.index, tt.size, err)
+		}
+	}
+}
+
+// Every leaf of every tree size, with the proofs we generate
+func TestVerifyInclusionAllSizes(t *testing.T) {
+	leaves := vectorLeafHashes(t)
+
+	for size := uint64(1); size <= uint64(len(leaves)); size++ {
+		root := rootHash(leaves[:size])
+		for index := range size {
+			proof := inclusionProof(leaves[:size], index)
+			if err := VerifyInclusion(leaves[index], index, size, proof, root); err != nil {
+				t.Errorf("VerifyInclusion(%d, %d) = %v", index, size, err)
+			}
+		}
+	}
+}
+
+func TestVerifyInclusionTampered(t *testing.T) {
+	leaves := vectorLeafHashes(t)
+	root := unhex(t, vectorRoots[8])
+	proof := inclusionProof(leaves, 5)
+
+	flipped := func(b []byte) []byte {
+		c := bytes.Clone(b)
+		c[0] ^= 1
+		return c
+	}
+
+	tests := []struct {
+		name  string
+		leaf  []byte
+		index uint64
+		size  uint64
+		proof [][]byte
+		root  []byte
+		err   error
+	}{
+		{"wrong leaf", leaves[4], 5, 8, proof, root, ErrInvalidProof},
+		{"wrong index", leaves[5], 4, 8, proof, root, ErrInvalidProof},
+		{"wrong size", leaves[5], 5, 16, proof, root, ErrInvalidProof},
+		{"index out of range", leaves[5], 8, 8, proof, root, ErrInvalidIndex},
+		{"wrong root", leaves[5], 5, 8, proof, flipped(root), ErrInvalidProof},
+		{"tampered node", leaves[5], 5, 8, [][]byte{proof[0], flipped(proof[1]), proof[2]}, root, ErrInvalidProof},
+		{"missing node", leaves[5], 5, 8, proof[:2], root, ErrInvalidProof},
+		{"extra node", leaves[5], 5, 8, append(proof, proof[0]), root, ErrInvalidProof},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := VerifyInclusion(tt.leaf, tt.index, tt.size, tt.proof, tt.root); !errors.Is(err, tt.err) {
+				t.Errorf("VerifyInclusion() = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestConsistencyProof(t *testing.T) {
+	leaves := vectorLeafHashes(t)
+
+	tests := []struct {
+		first  uint64
+		second uint64
+		proof  [][]byte
+	}{
+		{1, 1, [][]byte{}},
+		{1, 8, unhexAll(t,
+			"96a296d224f285c67bee93c30f8a309157f0daa35dc5b87e410b78630a09cfc7",
+			"5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+			"6b47aaf29ee3c2af9af889bc1fb9254dabd31177f16232dd6aab035ca39bf6e4",
+		)},
+		{6, 8, unhexAll(t,
+			"0ebc5d3437fbe2db158b9f126a1d118e308181031d0a949f8dededebc558ef6a",
+			"ca854ea128ed050b41b35ffc1b87b8eb2bde461e9e3b5596ece6b9d5975a0ae0",
+			"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+		)},
+		{2, 5, unhexAll(t,
+			"5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+			"bc1a0643b12e4d2d7c77918f44e0f4f79a838b6cf9ec5b5c283e1f4d88599e6b",
+		)},
+	}
+
+	for _, tt := range tests {
+		proof := consistencyProof(leaves[:tt.second], tt.first)
+		if len(proof) != len(tt.proof) {
+			t.Errorf("consistency proof %d -> %d has %d nodes, want %d", tt.first, tt.second, len(proof), len(tt.proof))
+			continue
+		}
+		for i := range proof {
+			if !bytes.Equal(proof[i], tt.proof[i]) {
+				t.Errorf("consistency proof %d -> %d: node %d = %x, want %x", tt.first, tt.second, i, proof[i], tt.proof[i])
+			}
+		}
+
+		first, second := unhex(t, vectorRoots[tt.first]), unhex(t, vectorRoots[tt.second])
+		if err := VerifyConsistency(tt.first, tt.second, first, second, tt.proof); err != nil {
+			t.Errorf("VerifyConsistency(%d, %d) = %v", tt.first, tt.second, err)
+		}
+	}
+}
+
+func TestVerifyConsistencyAllSizes(t *testing.T) {
+	leaves := vectorLeafHashes(t)
+
+	for second := uint64(1); second <= uint64(len(leaves)); second++ {
+		for first := uint64(0); first <= second; first++ {
+			var proof [][]byte
+			if first > 0 {
+				proof = consistencyProof(leaves[:second], first)
+			}
+
+			if err := VerifyConsistency(first, second, rootHash(leaves[:first]), rootHash(leaves[:second]), proof); err != nil {
+				t.Errorf("VerifyConsistency(%d, %d) = %v", first, second, err)
+			}
+		}
+	}
+}
+
+func TestVerifyConsistencyTampered(t *testing.T) {
+	leaves := vectorLeafHashes(t)
+	proof := consistencyProof(leaves, 6)
+	first, second := unhex(t, vectorRoots[6]), unhex(t, vectorRoots[8])
+
+	// A log that rewrote its 6th leaf
+	forked := append(vectorLeafHashes(t)[:5], HashLeaf([]byte("forged")), leaves[6], leaves[7])
+
+	tests := []struct {
+		name   string
+		first  uint64
+		second uint64
+		old    []byte
+		new    []byte
+		proof  [][]byte
+		err    error
+	}{
+		{"wrong first size", 5, 8, first, second, proof, ErrInvalidProof},
+		{"wrong second size", 6, 16, first, second, proof, ErrInvalidProof},
+		{"first bigger than second", 8, 6, second, first, proof, ErrInvalidSizes},
+		{"forked history", 6, 8, first, rootHash(forked), consistencyProof(forked, 6), ErrInvalidProof},
+		{"missing node", 6, 8, first, second, proof[:2], ErrInvalidProof},
+		{"no proof", 6, 8, first, second, nil, ErrInvalidProof},
+		{"same size, different roots", 8, 8, first, second, [][]byte{}, ErrInvalidProof},
+		{"empty tree with a proof", 0, 8, unhex(t, vectorRoots[0]), second, proof, ErrInvalidProof},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := VerifyConsistency(tt.first, tt.second, tt.old, tt.new, tt.proof); !errors.Is(err, tt.err) {
+				t.Errorf("VerifyConsistency() = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package transparency
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/Guilospanck/pqc/core/pkg/devices"
+)
+
+var (
+	ErrLogKeyChanged   = errors.New("log signing key changed")
+	ErrInvalidTreeHead = errors.New("invalid tree head signature")
+	ErrForkedLog       = errors.New("two different tree heads for the same size")
+	ErrUnknownTreeHead = errors.New("consistency proof for an unknown tree head")
+	ErrInvalidLogKey   = errors.New("invalid log signing key")
+)
+
+// Sent by the server every time a binding is published (or re-sent to a newcomer)
+type KeyPublication struct {
+	Binding  Binding        `json:"binding"`
+	Index    uint64         `json:"index"`
+	Proof    [][]byte       `json:"proof"`
+	TreeHead SignedTreeHead `json:"tree_head"`
+	LogKey   []byte         `json:"log_key"`
+}
+
+// Current tree head of the log, sent by the server when a client connects
+type TreeHeadAnnouncement struct {
+	TreeHead SignedTreeHead `json:"tree_head"`
+	LogKey   []byte         `json:"log_key"`
+}
+
+// Used both as the request (no proof) and the response (with proof)
+type ConsistencyCheck struct {
+	First  uint64   `json:"first"`
+	Second uint64   `json:"second"`
+	Proof  [][]byte `json:"proof,omitempty"`
+}
+
+// Client side view of the log. It pins the log key on first use,
+// remembers every tree head it has seen and makes sure they all
+// belong to the same append-only history.
+type Monitor struct {
+	logKey  ed25519.PublicKey
+	trusted *SignedTreeHead
+	heads   map[uint64]SignedTreeHead // every tree head seen, by size
+	keys    map[string]Binding        // device address -> last verified binding
+	mu      sync.Mutex
+}
+
+func NewMonitor() *Monitor {
+	return &Monitor{
+		heads: make(map[uint64]SignedTreeHead),
+		keys:  make(map[string]Binding),
+	}
+}
+
+// What a monitor must remember across restarts: the log key it pinned and
+// the last tree head it trusts. Without it, a restarted client would trust
+// whatever log the server shows it next.
+type MonitorState struct {
+	LogKey   []byte         `json:"log_key"`
+	TreeHead SignedTreeHead `json:"tree_head"`
+}
+
+// The state to save, false if no tree head was trusted yet
+func (m *Monitor) State() (MonitorState, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.trusted == nil {
+		return MonitorState{}, false
+	}
+
+	return MonitorState{LogKey: bytes.Clone(m.logKey), TreeHead: *m.trusted}, true
+}
+
+// Brings back the monitor saved in `state`. The next tree heads must be
+// consistent with the saved one, and signed with the same key.
+func RestoreMonitor(state MonitorState) (*Monitor, error) {
+	if len(state.LogKey) != ed25519.PublicKeySize {
+		return nil, ErrInvalidLogKey
+	}
+
+	logKey := ed25519.PublicKey(bytes.Clone(state.LogKey))
+	if !state.TreeHead.Verify(logKey) {
+		return nil, ErrInvalidTreeHead
+	}
+
+	m := NewMonitor()
+	m.logKey = logKey
+	m.trusted = &state.TreeHead
+	m.heads[state.TreeHead.Size] = state.TreeHead
+
+	return m, nil
+}
+
+// Verifies the signature of a tree head and compares it with the trusted one.
+// If a consistency proof is needed, it returns the check to ask the server for.
+func (m *Monitor) ObserveTreeHead(logKey []byte, sth SignedTreeHead) (*ConsistencyCheck, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.logKey == nil {
+		m.logKey = ed25519.PublicKey(bytes.Clone(logKey))
+	} else if !bytes.Equal(m.logKey, logKey) {
+		return nil, ErrLogKeyChanged
+	}
+
+	if !sth.Verify(m.logKey) {
+		return nil, ErrInvalidTreeHead
+	}
+
+	if m.trusted == nil {
+		m.trusted = &sth
+		m.heads[sth.Size] = sth
+		return nil, nil
+	}
+
+	if known, ok := m.heads[sth.Size]; ok {
+		if !bytes.Equal(known.RootHash, sth.RootHash) {
+			return nil, fmt.Errorf("%w (size %d)", ErrForkedLog, sth.Size)
+		}
+		return nil, nil
+	}
+
+	m.heads[sth.Size] = sth
+
+	// Tree heads can arrive out of order, so we always prove
+	// that the smaller tree is a prefix of the bigger one.
+	check := ConsistencyCheck{First: m.trusted.Size, Second: sth.Size}
+	if sth.Size < m.trusted.Size {
+		check = ConsistencyCheck{First: sth.Size, Second: m.trusted.Size}
+	}
+
+	return &check, nil
+}
+
+func (m *Monitor) VerifyConsistency(check ConsistencyCheck) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	first, ok := m.heads[check.First]
+	if !ok {
+		return fmt.Errorf("%w (size %d)", ErrUnknownTreeHead, check.First)
+	}
+	second, ok := m.heads[check.Second]
+	if !ok {
+		return fmt.Errorf("%w (size %d)", ErrUnknownTreeHead, check.Second)
+	}
+
+	if err := VerifyConsistency(first.Size, second.Size, first.RootHash, second.RootHash, check.Proof); err != nil {
+		return fmt.Errorf("tree heads %d and %d are not consistent: %w", first.Size, second.Size, err)
+	}
+
+	if second.Size > m.trusted.Size {
+		m.trusted = &second
+	}
+
+	return nil
+}
+
+// Verifies that the published binding is included in the tree head it came with.
+// The tree head itself must be checked with `ObserveTreeHead`.
+func (m *Monitor) VerifyPublication(publication KeyPublication) error {
+	binding := publication.Binding
+	if err := VerifyInclusion(binding.LeafHash(), publication.Index, publication.TreeHead.Size, publication.Proof, publication.TreeHead.RootHash); err != nil {
+		return fmt.Errorf("binding for %s (device %s) is not included in tree head %d: %w", binding.Username, binding.Device, publication.TreeHead.Size, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.keys[devices.Address(binding.Username, binding.Device)] = binding
+
+	return nil
+}
+
+// Last binding verified for the `device` of `username`
+func (m *Monitor) Binding(username, device string) (Binding, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	binding, ok := m.keys[devices.Address(username, device)]
+	return binding, ok
+}
@@ -0,0 +1,161 @@
+package transport
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/Guilospanck/pqc/core/pkg/cryptography"
+)
+
+// Both sides only speak TLS 1.3 and only agree on the hybrid
+// X25519 + ML-KEM-768 key exchange, so the transport is also
+// protected against "harvest now, decrypt later".
+var hybridGroups = []tls.CurveID{tls.X25519MLKEM768}
+
+// TLS 1.3 cipher suites approved by FIPS 140-3
+var fipsCipherSuites = []uint16{tls.TLS_AES_128_GCM_SHA256, tls.TLS_AES_256_GCM_SHA384}
+
+var (
+	ErrNotTLS      = errors.New("not a TLS connection")
+	ErrNotApproved = errors.New("cipher suite not approved in FIPS mode")
+)
+
+// How long a generated development certificate is valid
+const selfSignedValidity = 365 * 24 * time.Hour
+
+// TLS configuration of the server. If the certificate or the key don't
+// exist, a self-signed certificate for localhost is generated and saved
+// there, so clients can be told to trust it.
+func ServerConfig(certFile, keyFile string) (*tls.Config, error) {
+	if !exists(certFile) || !exists(keyFile) {
+		if err := generateSelfSigned(certFile, keyFile); err != nil {
+			return nil, fmt.Errorf("could not generate self-signed certificate: %w", err)
+		}
+		log.Printf("Generated a self-signed certificate (for development only) at %s\n", certFile)
+	}
+
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return restrictToFIPS(&tls.Config{
+		Certificates:     []tls.Certificate{certificate},
+		MinVersion:       tls.VersionTLS13,
+		CurvePreferences: hybridGroups,
+	}), nil
+}
+
+// TLS configuration of the client. Servers are verified against `caFile`
+// (e.g. the self-signed certificate of a development server) if given,
+// otherwise against the system roots.
+func ClientConfig(caFile string) (*tls.Config, error) {
+	config := restrictToFIPS(&tls.Config{
+		MinVersion:       tls.VersionTLS13,
+		CurvePreferences: hybridGroups,
+	})
+
+	if caFile == "" {
+		return config, nil
+	}
+
+	ca, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	config.RootCAs = x509.NewCertPool()
+	if !config.RootCAs.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificate found in %s", caFile)
+	}
+
+	return config, nil
+}
+
+// TLS 1.3 cipher suites can't be configured, so in FIPS mode we refuse
+// the connection after the handshake if it didn't use AES-GCM.
+func restrictToFIPS(config *tls.Config) *tls.Config {
+	if !cryptography.FIPS() {
+		return config
+	}
+
+	config.VerifyConnection = func(state tls.ConnectionState) error {
+		if !slices.Contains(fipsCipherSuites, state.CipherSuite) {
+			return fmt.Errorf("%w: %s", ErrNotApproved, tls.CipherSuiteName(state.CipherSuite))
+		}
+		return nil
+	}
+
+	return config
+}
+
+// Version, key exchange group and cipher suite negotiated on `conn`,
+// e.g. "TLS 1.3, X25519MLKEM768, TLS_AES_128_GCM_SHA256"
+func Describe(conn net.Conn) (string, error) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return "", ErrNotTLS
+	}
+
+	state := tlsConn.ConnectionState()
+	return fmt.Sprintf("%s, %s, %s", tls.VersionName(state.Version), state.CurveID, tls.CipherSuiteName(state.CipherSuite)), nil
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func generateSelfSigned(certFile, keyFile string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"pqc development"}},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+
+	certificate, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+
+	privateKey, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate}), 0644); err != nil {
+		return err
+	}
+
+	return os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateKey}), 0600)
+}
@@ -0,0 +1,180 @@
+package transport
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestServerConfig(t *testing.T) (*tls.Config, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	config, err := ServerConfig(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config, certFile
+}
+
+// Handshakes over a local connection, and returns the client end once done
+func handshake(t *testing.T, server, client *tls.Config) (*tls.Conn, error) {
+	t.Helper()
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.(*tls.Conn).Handshake()
+	}()
+
+	conn, err := net.DialTimeout("tcp", listener.Addr().String(), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	tlsConn := tls.Client(conn, client)
+	return tlsConn, tlsConn.Handshake()
+}
+
+func TestHybridHandshake(t *testing.T) {
+	server, certFile := newTestServerConfig(t)
+
+	client, err := ClientConfig(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.ServerName = "localhost"
+
+	conn, err := handshake(t, server, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	description, err := Describe(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(description, "TLS 1.3, X25519MLKEM768, ") {
+		t.Errorf("Describe() = %q", description)
+	}
+
+	if _, err := Describe(&net.TCPConn{}); !errors.Is(err, ErrNotTLS) {
+		t.Errorf("Describe() of a plain connection = %v, want %v", err, ErrNotTLS)
+	}
+}
+
+func TestClassicalKeyExchangeRefused(t *testing.T) {
+	server, certFile := newTestServerConfig(t)
+
+	client, err := ClientConfig(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.ServerName = "localhost"
+	client.CurvePreferences = []tls.CurveID{tls.X25519}
+
+	if _, err := handshake(t, server, client); err == nil {
+		t.Error("the server agreed on X25519 only")
+	}
+}
+
+func TestOlderVersionsRefused(t *testing.T) {
+	server, certFile := newTestServerConfig(t)
+
+	client, err := ClientConfig(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.ServerName = "localhost"
+	client.MinVersion = tls.VersionTLS12
+	client.MaxVersion = tls.VersionTLS12
+
+	if _, err := handshake(t, server, client); err == nil {
+		t.Error("the server agreed on TLS 1.2")
+	}
+}
+
+func TestUntrustedServerRefused(t *testing.T) {
+	server, _ := newTestServerConfig(t)
+
+	// Trusts another development server
+	_, otherCert := newTestServerConfig(t)
+	client, err := ClientConfig(otherCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.ServerName = "localhost"
+
+	if _, err := handshake(t, server, client); err == nil {
+		t.Error("the client trusted a certificate it wasn't given")
+	}
+}
+
+func TestServerConfigKeepsItsCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	if _, err := ServerConfig(certFile, keyFile); err != nil {
+		t.Fatal(err)
+	}
+	generated, err := os.ReadFile(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ServerConfig(certFile, keyFile); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := os.ReadFile(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(generated) != string(loaded) {
+		t.Error("the certificate was generated again")
+	}
+
+	info, err := os.Stat(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("key saved with mode %s", info.Mode().Perm())
+	}
+}
+
+func TestClientConfigErrors(t *testing.T) {
+	if _, err := ClientConfig(filepath.Join(t.TempDir(), "missing.pem")); err == nil {
+		t.Error("ClientConfig() accepted a missing CA file")
+	}
+
+	notPEM := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ClientConfig(notPEM); err == nil {
+		t.Error("ClientConfig() accepted a file without certificates")
+	}
+
+	config, err := ClientConfig("")
+	if err != nil || config.RootCAs != nil {
+		t.Errorf("ClientConfig() without CA = %v, %v", config.RootCAs, err)
+	}
+}
@@ -1,5 +1,7 @@
 package types
 
+import "time"
+
 // INFO: all types here will be transpiled into Typescript (file `generated-types.ts` in tui/)
 // using `tygo` if you run the `just start-server` (or `just generate-types`) recipes.
 
@@ -12,17 +14,281 @@ const (
 	MessageTypeReconnecting  MessageType = "reconnecting"
 	MessageTypeKeysExchanged MessageType = "keys_exchanged"
 	MessageTypeMessage       MessageType = "message"
+	MessageTypeTransport     MessageType = "transport" // TLS version and key exchange group
+	MessageTypeFIPS          MessageType = "fips"      // FIPS 140-3 mode, only sent when enabled
+
+	MessageTypeKeyTransparencyAlert MessageType = "key_transparency_alert"
+	MessageTypeMail                 MessageType = "mail"
+	MessageTypeGroupEpoch           MessageType = "group_epoch"
+	MessageTypeOwnMessage           MessageType = "own_message" // sent by another device of ours
+	MessageTypeRecoveryPhrase       MessageType = "recovery_phrase"
+	MessageTypeIdentityRestored     MessageType = "identity_restored"
+	MessageTypeHistoryUnlocked      MessageType = "history_unlocked"
+	MessageTypeHistoryResults       MessageType = "history_results"
+	MessageTypeMessageStatus        MessageType = "message_status" // status update of a message we sent
+	MessageTypeMessageEdited        MessageType = "message_edited"
+	MessageTypeMessageDeleted       MessageType = "message_deleted"
+	MessageTypeMessageReactions     MessageType = "message_reactions" // value is a MessageReactions
+	MessageTypeDirectMessage        MessageType = "direct_message"    // sent to (or by) us only
+	MessageTypeLoggedIn             MessageType = "logged_in"         // value is an AccountResult (without token)
 
 	// Go <-> Go (ws) and Go to TUI
+	MessageTypeError           MessageType = "error"
 	MessageTypeUserEnteredChat MessageType = "user_entered_chat"
 	MessageTypeUserLeftChat    MessageType = "user_left_chat"
 	MessageTypeCurrentUsers    MessageType = "current_users"
+	MessageTypeTyping          MessageType = "typing"       // value is a Typing
+	MessageTypePresence        MessageType = "presence"     // value is a Presence
+	MessageTypeUserRenamed     MessageType = "user_renamed" // value is a UserRenamed
+	MessageTypeModeration      MessageType = "moderation"   // value is a Moderation, done by `Actor`
+	MessageTypeRoomInfo        MessageType = "room_info"    // value is a RoomInfo
+	MessageTypeAnnouncement    MessageType = "announcement" // value is the text, from the admins of the server
+	MessageTypeGoingAway       MessageType = "going_away"   // value is a GoingAway, right before the server closes the connection
 
 	// Go <-> Go (ws)
 	MessageTypeExchangeKeys     MessageType = "exchange_keys"
 	MessageTypeEncryptedMessage MessageType = "encrypted_message"
+	MessageTypeMessageAck       MessageType = "message_ack"      // the server accepted a message
+	MessageTypeMessageReceipt   MessageType = "message_receipt"  // delivery and read receipts
+	MessageTypeProfileChange    MessageType = "profile_change"   // value is a ProfileChange
+	MessageTypeReconnectToken   MessageType = "reconnect_token"  // shown by the device when it connects again
+	MessageTypeAccountRegister  MessageType = "account_register" // value is Credentials, encrypted
+	MessageTypeAccountLogin     MessageType = "account_login"    // value is Credentials, encrypted
+	MessageTypeAccountResult    MessageType = "account_result"   // value is an AccountResult
+	MessageTypeModerate         MessageType = "moderate"         // value is a Moderation, asked by the sender
+
+	// Key transparency
+	MessageTypeKeyPublished      MessageType = "key_published"
+	MessageTypeKeyLogTreeHead    MessageType = "key_log_tree_head"
+	MessageTypeKeyLogConsistency MessageType = "key_log_consistency"
+
+	// Prekeys (messages to offline users)
+	MessageTypePrekeyUpload  MessageType = "prekey_upload"
+	MessageTypePrekeyBundle  MessageType = "prekey_bundle"
+	MessageTypePrekeysLow    MessageType = "prekeys_low"
+	MessageTypePrekeyMessage MessageType = "prekey_message"
+	MessageTypeSealedMessage MessageType = "sealed_message" // prekey message with a hidden sender
+
+	// Group key agreement (TreeKEM)
+	MessageTypeGroupKeyPackage     MessageType = "group_key_package"
+	MessageTypeGroupCreate         MessageType = "group_create"
+	MessageTypeGroupProposals      MessageType = "group_proposals"
+	MessageTypeGroupCommit         MessageType = "group_commit"
+	MessageTypeGroupCommitRejected MessageType = "group_commit_rejected"
+	MessageTypeGroupWelcome        MessageType = "group_welcome"
+	MessageTypeGroupMessage        MessageType = "group_message"
+	MessageTypeHistoryCopy         MessageType = "history_copy" // readable copy of a group message, for the server history
+
+	// Devices (Go <-> Go (ws) and Go to TUI)
+	MessageTypeDevicePending         MessageType = "device_pending"
+	MessageTypeDeviceApprovalRequest MessageType = "device_approval_request"
+	MessageTypeDeviceApproval        MessageType = "device_approval"
+	MessageTypeDeviceList            MessageType = "device_list"
+	MessageTypeDeviceRemove          MessageType = "device_remove"
+
+	// Short authentication string verification (Go <-> Go (ws) and Go to TUI)
+	MessageTypeSASRequest  MessageType = "sas_request"
+	MessageTypeSASAccept   MessageType = "sas_accept"
+	MessageTypeSASReveal   MessageType = "sas_reveal"
+	MessageTypeSASCode     MessageType = "sas_code" // Go to TUI only
+	MessageTypeSASConfirm  MessageType = "sas_confirm"
+	MessageTypeSASVerified MessageType = "sas_verified" // Go to TUI only
+	MessageTypeSASCancel   MessageType = "sas_cancel"
 
 	// TUI to Go
-	MessageTypeConnect MessageType = "connect"
-	MessageTypeSend    MessageType = "send"
+	MessageTypeConnect         MessageType = "connect"
+	MessageTypeSend            MessageType = "send"
+	MessageTypeExportIdentity  MessageType = "export_identity"
+	MessageTypeRestoreIdentity MessageType = "restore_identity"
+	MessageTypeHistoryUnlock   MessageType = "history_unlock"
+	MessageTypeHistorySearch   MessageType = "history_search"
+	MessageTypeMessageSeen     MessageType = "message_seen"   // the user saw a message (value is its id)
+	MessageTypeEditMessage     MessageType = "edit_message"   // value is a MessageEdit
+	MessageTypeDeleteMessage   MessageType = "delete_message" // value is the id of the message
+	MessageTypeReplyMessage    MessageType = "reply_message"  // value is a MessageReply
+	MessageTypeReact           MessageType = "react"          // value is a MessageReaction
+	MessageTypeUnreact         MessageType = "unreact"        // value is a MessageReaction
+	MessageTypeUserTyping      MessageType = "user_typing"    // the user pressed a key in the input
+	MessageTypeSetStatus       MessageType = "set_status"     // value is a Presence
+)
+
+type ContentType = string
+
+const (
+	ContentTypeText ContentType = "text/plain"
+)
+
+// What a chat message carries inside its ciphertext, whichever way it
+// travels (relayed by the server, to the group or as mail)
+type ChatMessage struct {
+	Version     int         `json:"version"`
+	ID          string      `json:"id"`
+	Sender      string      `json:"sender"`
+	Room        string      `json:"room"`                // empty for mail and direct messages
+	Recipient   string      `json:"recipient,omitempty"` // only for direct messages
+	Timestamp   time.Time   `json:"timestamp"`
+	Body        string      `json:"body"`
+	ReplyTo     string      `json:"reply_to,omitempty"`
+	ContentType ContentType `json:"content_type"`
+	Action      ChatAction  `json:"action,omitempty"` // if set, the message changes `Target`
+	Target      string      `json:"target,omitempty"`
+}
+
+type ChatAction = string
+
+const (
+	ChatActionEdit    ChatAction = "edit"    // `Body` replaces the body of the target
+	ChatActionDelete  ChatAction = "delete"  // the target is removed
+	ChatActionReact   ChatAction = "react"   // `Body` is an emoji added to the target
+	ChatActionUnreact ChatAction = "unreact" // `Body` is an emoji removed from the target
 )
+
+// Asks the Go client to edit one of our messages
+type MessageEdit struct {
+	ID   string `json:"id"`
+	Body string `json:"body"`
+}
+
+// Asks the Go client to reply to the message `ID`
+type MessageReply struct {
+	ID   string `json:"id"`
+	Body string `json:"body"`
+}
+
+// Asks the Go client to add (or remove) a reaction to the message `ID`
+type MessageReaction struct {
+	ID    string `json:"id"`
+	Emoji string `json:"emoji"`
+}
+
+// How many people reacted to a message with an emoji
+type ReactionCount struct {
+	Emoji string `json:"emoji"`
+	Count int    `json:"count"`
+	Mine  bool   `json:"mine"` // we are one of them
+}
+
+// Every reaction to the message `ID`, most used first
+type MessageReactions struct {
+	ID        string          `json:"id"`
+	Reactions []ReactionCount `json:"reactions"`
+}
+
+type MessageStatus = string
+
+const (
+	MessageStatusSent      MessageStatus = "sent"      // written to the server
+	MessageStatusAccepted  MessageStatus = "accepted"  // acknowledged by the server
+	MessageStatusDelivered MessageStatus = "delivered" // received by a recipient
+	MessageStatusRead      MessageStatus = "read"      // seen by a recipient
+)
+
+// Status update of a message we sent. The first one ("sent") also
+// carries the message itself, for the UI to know its id.
+type MessageStatusUpdate struct {
+	ID      string        `json:"id"`
+	Status  MessageStatus `json:"status"`
+	By      string        `json:"by,omitempty"` // recipient, for receipts
+	Message *ChatMessage  `json:"message,omitempty"`
+}
+
+type PresenceStatus = string
+
+const (
+	PresenceOnline PresenceStatus = "online"
+	PresenceAway   PresenceStatus = "away"
+	PresenceBusy   PresenceStatus = "busy"
+)
+
+// Status set by a user (or automatically, after some idle time)
+type Presence struct {
+	Username string         `json:"username"`
+	Status   PresenceStatus `json:"status"`
+	Text     string         `json:"text,omitempty"` // custom status, e.g. "in a meeting"
+}
+
+// A user started (or stopped) typing in the room
+type Typing struct {
+	Username string `json:"username"`
+	Typing   bool   `json:"typing"`
+}
+
+// Asks the server for a new username and/or color (empty fields don't change)
+type ProfileChange struct {
+	Username string `json:"username,omitempty"`
+	Color    string `json:"color,omitempty"`
+}
+
+// A user changed its username (`NewUsername` is then different) or its color
+type UserRenamed struct {
+	Username    string `json:"username"`
+	NewUsername string `json:"new_username"`
+	Color       string `json:"color"`
+}
+
+// Username and password of a registered user
+type Credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// The device registered or logged in as `Username`. It connects again
+// with `Token` as its reconnect token if it wasn't connected as them yet.
+type AccountResult struct {
+	Username   string `json:"username"`
+	Color      string `json:"color"`
+	Registered bool   `json:"registered"` // a new account, not a login
+	Token      string `json:"token,omitempty"`
+}
+
+type RoomRole = string
+
+const (
+	RoomRoleOwner     RoomRole = "owner"
+	RoomRoleModerator RoomRole = "moderator"
+	RoomRoleMember    RoomRole = "member"
+)
+
+type ModerationAction = string
+
+const (
+	ModerationKick       ModerationAction = "kick"
+	ModerationBan        ModerationAction = "ban"
+	ModerationBanIP      ModerationAction = "ban_ip" // bans the addresses `Target` is connected from too
+	ModerationUnban      ModerationAction = "unban"
+	ModerationMute       ModerationAction = "mute"
+	ModerationUnmute     ModerationAction = "unmute"
+	ModerationTopic      ModerationAction = "topic"       // `Value` is the new topic
+	ModerationRole       ModerationAction = "role"        // `Value` is the new RoomRole of `Target`
+	ModerationInviteOnly ModerationAction = "invite_only" // `Value` is "on" or "off"
+	ModerationInvite     ModerationAction = "invite"      // lets `Target` in while the room is invite-only
+	ModerationUninvite   ModerationAction = "uninvite"
+)
+
+// Something done to a room (or to one of its members). Asked by a client,
+// and announced to the members once the server allowed and applied it.
+type Moderation struct {
+	Room     string           `json:"room"`
+	Action   ModerationAction `json:"action"`
+	Actor    string           `json:"actor,omitempty"` // set by the server
+	Target   string           `json:"target,omitempty"`
+	Value    string           `json:"value,omitempty"`
+	Reason   string           `json:"reason,omitempty"`
+	Duration int64            `json:"duration,omitempty"` // of a mute, in seconds
+	Until    time.Time        `json:"until,omitzero"`     // end of a mute, set by the server
+}
+
+// What a member needs to know about a room when it connects
+type RoomInfo struct {
+	Room       string               `json:"room"`
+	Topic      string               `json:"topic,omitempty"`
+	InviteOnly bool                 `json:"invite_only"`
+	Roles      map[string]RoomRole  `json:"roles"` // owners and moderators, by username
+	Muted      map[string]time.Time `json:"muted"` // until when, by username
+}
+
+// The server is going away (shutting down or draining)
+type GoingAway struct {
+	Reason     string `json:"reason"`
+	RetryAfter int64  `json:"retry_after"` // seconds to wait before connecting again
+}
@@ -11,19 +11,42 @@ type UIMessage struct {
 	Type  types.MessageType `json:"type"`
 	Value string            `json:"value"`
 	Color string            `json:"color"`
+
+	// Only for chat messages, `Value` is then their body
+	Message *types.ChatMessage `json:"message,omitempty"`
+}
+
+// Get every message emitted to the UI, e.g. to keep a history of them
+var observers []func(UIMessage)
+
+// Must be called before anything is emitted
+func Observe(observer func(UIMessage)) {
+	observers = append(observers, observer)
 }
 
 // We talk to the UI via stdout
 func EmitToUI(msgType types.MessageType, value, color string) {
-	var messageColor string = color
-	if len(color) == 0 {
-		messageColor = "#7ee787"
-	}
-
-	msg := UIMessage{
+	emit(UIMessage{
 		Type:  msgType,
 		Value: value,
-		Color: string(messageColor),
+		Color: color,
+	})
+}
+
+// Emits a chat message with all of its fields, so the UI doesn't have
+// to parse them out of the text
+func EmitChatMessage(msgType types.MessageType, message types.ChatMessage, color string) {
+	emit(UIMessage{
+		Type:    msgType,
+		Value:   message.Body,
+		Color:   color,
+		Message: &message,
+	})
+}
+
+func emit(msg UIMessage) {
+	if len(msg.Color) == 0 {
+		msg.Color = "#7ee787"
 	}
 
 	msgMarshalled, err := json.Marshal(msg)
@@ -33,4 +56,8 @@ func EmitToUI(msgType types.MessageType, value, color string) {
 	}
 
 	fmt.Println(string(msgMarshalled))
+
+	for _, observer := range observers {
+		observer(msg)
+	}
 }
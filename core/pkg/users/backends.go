@@ -0,0 +1,82 @@
+package users
+
+import (
+	"encoding/json"
+	"os"
+	"slices"
+	"sync"
+)
+
+// Keeps the users until the server stops
+type MemoryBackend struct {
+	users []User
+	mu    sync.Mutex
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{users: make([]User, 0)}
+}
+
+func (b *MemoryBackend) Load() ([]User, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return slices.Clone(b.users), nil
+}
+
+func (b *MemoryBackend) Save(users []User) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.users = slices.Clone(users)
+	return nil
+}
+
+// Keeps the users in a JSON file
+type FileBackend struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewFileBackend(path string) *FileBackend {
+	return &FileBackend{path: path}
+}
+
+func (b *FileBackend) Load() ([]User, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	data, err := os.ReadFile(b.path)
+	if os.IsNotExist(err) {
+		return []User{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var users []User
+	if err := json.Unmarshal(data, &users); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// Writes the users next to the old file, then swaps the files,
+// so it is never left half written
+func (b *FileBackend) Save(users []User) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	data, err := json.MarshalIndent(users, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := b.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, b.path)
+}
@@ -0,0 +1,219 @@
+package users
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Guilospanck/pqc/core/pkg/cryptography"
+)
+
+// Registered users of the server. Passwords are never kept, only a hash
+// derived with the passphrase KDF (Argon2id, or PBKDF2 in FIPS mode) and
+// a random salt. Usernames are unique whatever their case. Each hash takes
+// tens of MiB of memory, so only a few are computed at once.
+
+var (
+	ErrUsernameTaken      = errors.New("username already registered")
+	ErrInvalidCredentials = errors.New("wrong username or password")
+	ErrInvalidPassword    = fmt.Errorf("passwords have %d to %d characters", MinPasswordLength, MaxPasswordLength)
+)
+
+const (
+	MinPasswordLength = 8
+	MaxPasswordLength = 256
+
+	saltSize = 16
+
+	// Hashes computed at once, the others wait
+	concurrentHashes = 2
+)
+
+type User struct {
+	Username string    `json:"username"`
+	Color    string    `json:"color"`
+	KDF      string    `json:"kdf"`
+	Salt     []byte    `json:"salt"`
+	Hash     []byte    `json:"hash"`
+	Created  time.Time `json:"created"`
+}
+
+// Where the users are kept
+type Backend interface {
+	Load() ([]User, error)
+	Save(users []User) error
+}
+
+// Users are loaded once and written through to the backend
+type Store struct {
+	backend Backend
+	users   map[string]User // by lowercase username
+	mu      sync.RWMutex
+	hashing chan struct{} // one slot for each hash being computed
+}
+
+func NewStore(backend Backend) (*Store, error) {
+	users, err := backend.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{
+		backend: backend,
+		users:   make(map[string]User, len(users)),
+		hashing: make(chan struct{}, concurrentHashes),
+	}
+	for _, user := range users {
+		s.users[key(user.Username)] = user
+	}
+
+	return s, nil
+}
+
+func key(username string) string {
+	return strings.ToLower(username)
+}
+
+// Whether `username` (whatever its case) is registered
+func (s *Store) Exists(username string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.users[key(username)]
+	return ok
+}
+
+// How many users are registered
+func (s *Store) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.users)
+}
+
+// The user registered as `username`, whatever its case
+func (s *Store) Get(username string) (User, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[key(username)]
+	return user, ok
+}
+
+func (s *Store) Register(username, password, color string) (User, error) {
+	if err := validPassword(password); err != nil {
+		return User{}, err
+	}
+
+	// Hashing is slow, we don't hold the lock for it
+	if s.Exists(username) {
+		return User{}, ErrUsernameTaken
+	}
+
+	kdf := cryptography.PassphraseKDF()
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return User{}, err
+	}
+
+	hash, err := s.hash(kdf, password, salt)
+	if err != nil {
+		return User{}, err
+	}
+
+	user := User{Username: username, Color: color, KDF: kdf, Salt: salt, Hash: hash, Created: time.Now()}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[key(username)]; ok {
+		return User{}, ErrUsernameTaken
+	}
+	s.users[key(username)] = user
+
+	if err := s.save(); err != nil {
+		delete(s.users, key(username))
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+// Checks the password of `username`. Unknown usernames take as long as
+// wrong passwords, so they can't be told apart.
+func (s *Store) Authenticate(username, password string) (User, error) {
+	s.mu.RLock()
+	user, ok := s.users[key(username)]
+	s.mu.RUnlock()
+
+	if !ok {
+		salt := make([]byte, saltSize)
+		s.hash(cryptography.PassphraseKDF(), password, salt)
+		return User{}, ErrInvalidCredentials
+	}
+
+	hash, err := s.hash(user.KDF, password, user.Salt)
+	if err != nil {
+		return User{}, err
+	}
+
+	if subtle.ConstantTimeCompare(hash, user.Hash) != 1 {
+		return User{}, ErrInvalidCredentials
+	}
+
+	return user, nil
+}
+
+// Waits for a free slot to hash `password`
+func (s *Store) hash(kdf, password string, salt []byte) ([]byte, error) {
+	s.hashing <- struct{}{}
+	defer func() { <-s.hashing }()
+
+	return cryptography.DeriveKeyFromPassphrase(kdf, password, salt)
+}
+
+func (s *Store) SetColor(username, color string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[key(username)]
+	if !ok {
+		return ErrInvalidCredentials
+	}
+
+	previous := user.Color
+	user.Color = color
+	s.users[key(username)] = user
+
+	if err := s.save(); err != nil {
+		user.Color = previous
+		s.users[key(username)] = user
+		return err
+	}
+
+	return nil
+}
+
+// Must be called with s.mu held
+func (s *Store) save() error {
+	users := make([]User, 0, len(s.users))
+	for _, user := range s.users {
+		users = append(users, user)
+	}
+	slices.SortFunc(users, func(a, b User) int { return strings.Compare(key(a.Username), key(b.Username)) })
+
+	return s.backend.Save(users)
+}
+
+func validPassword(password string) error {
+	if len(password) < MinPasswordLength || len(password) > MaxPasswordLength {
+		return ErrInvalidPassword
+	}
+
+	return nil
+}
@@ -0,0 +1,148 @@
+package users
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+
+	store, err := NewStore(NewMemoryBackend())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestRegister(t *testing.T) {
+	store := newTestStore(t)
+
+	user, err := store.Register("Alice", "correct horse", "#ff0000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Username != "Alice" || user.Color != "#ff0000" || len(user.Salt) != saltSize || len(user.Hash) == 0 {
+		t.Errorf("Register() = %+v", user)
+	}
+	if string(user.Hash) == "correct horse" {
+		t.Error("the password is stored as is")
+	}
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		err      error
+	}{
+		{"same username", "Alice", "another password", ErrUsernameTaken},
+		{"other case", "aLICE", "another password", ErrUsernameTaken},
+		{"short password", "bob", strings.Repeat("a", MinPasswordLength-1), ErrInvalidPassword},
+		{"long password", "bob", strings.Repeat("a", MaxPasswordLength+1), ErrInvalidPassword},
+		{"empty password", "bob", "", ErrInvalidPassword},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := store.Register(tt.username, tt.password, ""); !errors.Is(err, tt.err) {
+				t.Errorf("Register() = %v, want %v", err, tt.err)
+			}
+		})
+	}
+
+	if store.Len() != 1 || !store.Exists("ALICE") || store.Exists("bob") {
+		t.Errorf("%d users after the failed registrations", store.Len())
+	}
+
+	// Same password, different salt
+	bob, err := store.Register("bob", "correct horse", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bob.Hash) == string(user.Hash) {
+		t.Error("two users with the same password have the same hash")
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	store := newTestStore(t)
+	if _, err := store.Register("Alice", "correct horse", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		err      error
+	}{
+		{"right password", "Alice", "correct horse", nil},
+		{"other case", "alice", "correct horse", nil},
+		{"wrong password", "Alice", "correct horse!", ErrInvalidCredentials},
+		{"password case", "Alice", "Correct horse", ErrInvalidCredentials},
+		{"empty password", "Alice", "", ErrInvalidCredentials},
+		{"unknown user", "bob", "correct horse", ErrInvalidCredentials},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, err := store.Authenticate(tt.username, tt.password)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Authenticate() = %v, want %v", err, tt.err)
+			}
+			if err == nil && user.Username != "Alice" {
+				t.Errorf("Authenticate() = %q, want the registered username", user.Username)
+			}
+		})
+	}
+}
+
+func TestSetColor(t *testing.T) {
+	store := newTestStore(t)
+	if _, err := store.Register("alice", "correct horse", "#ff0000"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.SetColor("ALICE", "#00ff00"); err != nil {
+		t.Fatal(err)
+	}
+	if user, _ := store.Get("alice"); user.Color != "#00ff00" {
+		t.Errorf("color is %q after SetColor()", user.Color)
+	}
+
+	if err := store.SetColor("bob", "#00ff00"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("SetColor() of an unknown user = %v, want %v", err, ErrInvalidCredentials)
+	}
+}
+
+func TestFileBackend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.json")
+
+	store, err := NewStore(NewFileBackend(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.Len() != 0 {
+		t.Fatalf("new store has %d users", store.Len())
+	}
+	if _, err := store.Register("alice", "correct horse", "#ff0000"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetColor("alice", "#00ff00"); err != nil {
+		t.Fatal(err)
+	}
+
+	// As after a restart
+	reopened, err := NewStore(NewFileBackend(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user, ok := reopened.Get("Alice"); !ok || user.Color != "#00ff00" {
+		t.Errorf("Get() after reopening = %+v, %v", user, ok)
+	}
+	if _, err := reopened.Authenticate("alice", "correct horse"); err != nil {
+		t.Errorf("Authenticate() after reopening = %v", err)
+	}
+}
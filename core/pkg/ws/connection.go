@@ -4,7 +4,9 @@ import (
 	"context"
 	"errors"
 	"log"
+	"time"
 
+	"github.com/Guilospanck/pqc/core/pkg/chat"
 	"github.com/Guilospanck/pqc/core/pkg/cryptography"
 	"github.com/Guilospanck/pqc/core/pkg/types"
 	"github.com/Guilospanck/pqc/core/pkg/ui"
@@ -12,6 +14,22 @@ import (
 	"github.com/gorilla/websocket"
 )
 
+// Close code of a session ended because its device connected again.
+// Codes 4000-4999 are for applications to use.
+const CloseSessionReplaced = 4000
+
+// Close codes of a user removed from the room by a moderator
+const (
+	CloseKicked = 4001
+	CloseBanned = 4002
+)
+
+// Close code of a device removed by another device of its user
+const CloseDeviceRemoved = 4003
+
+// Close code of a session ended to come back under a new username
+const CloseRenamed = 4004
+
 type WriteMessageRequest struct {
 	msgType int // websocket.TextMessage, websocket.PingMessage
 	text    []byte
@@ -22,6 +40,7 @@ type Connection struct {
 	Keys     cryptography.Keys
 	Conn     *websocket.Conn
 	Metadata WSMetadata
+	Since    time.Time // when it was opened
 
 	WriteMessageReq chan WriteMessageRequest
 
@@ -113,6 +132,21 @@ func (ws *Connection) WriteMessage(text string, msgType int) error {
 	}
 }
 
+// Tells the other side why the connection is being closed, then closes it
+func (ws *Connection) Close(code int, reason string) {
+	ws.SendClose(code, reason)
+	ws.Conn.Close()
+}
+
+// Tells the other side why the connection is being closed. It answers with
+// its own close message, which ends our read loop.
+func (ws *Connection) SendClose(code int, reason string) {
+	message := websocket.FormatCloseMessage(code, reason)
+	if err := ws.WriteMessage(string(message), websocket.CloseMessage); err != nil {
+		log.Printf("Could not send close message: %s\n", err.Error())
+	}
+}
+
 func (ws *Connection) ReadMessage() ([]byte, error) {
 	_, msg, err := ws.Conn.ReadMessage()
 	return msg, err
@@ -154,7 +188,6 @@ func (connection *Connection) HandleClientMessage(msg WSMessage) []byte {
 			log.Printf("Could not decrypt message from client (%s): %s\n", connection.Metadata.Username, err.Error())
 			return nil
 		}
-		log.Printf("Decrypted message (%s): \"%s\"\n", connection.Metadata.Username, decrypted)
 
 		return decrypted
 
@@ -188,15 +221,16 @@ func (connection *Connection) RelayMessage(message, fromUsername, fromColor stri
 
 }
 
-// To be handled by the client
-func (connection *Connection) HandleServerMessage(msg WSMessage) {
+// To be handled by the client. Chat messages are returned, for the client
+// to check and show them.
+func (connection *Connection) HandleServerMessage(msg WSMessage) *types.ChatMessage {
 	switch msg.Type {
 	case types.MessageTypeExchangeKeys:
 		ciphertext := msg.Value
 		sharedSecret, err := connection.Keys.Private.Decapsulate(ciphertext)
 		if err != nil {
 			log.Printf("Could not get shared secret from ciphertext: %s\n", err.Error())
-			return
+			return nil
 		}
 
 		// Now the client also have the shared secret
@@ -213,10 +247,16 @@ func (connection *Connection) HandleServerMessage(msg WSMessage) {
 		decrypted, err := cryptography.DecryptMessage(connection.Keys.SharedSecret, nonce, ciphertext)
 		if err != nil {
 			log.Printf("Could not decrypt message from server: %s\n", err.Error())
-			return
+			return nil
+		}
+
+		payload, err := chat.Parse(decrypted)
+		if err != nil {
+			log.Printf("Could not read message from server: %s\n", err.Error())
+			return nil
 		}
 
-		ui.EmitToUI(types.MessageTypeMessage, string(decrypted), msg.Metadata.Color)
+		return &payload
 	case types.MessageTypeUserEnteredChat:
 		metadata := msg.Metadata
 		ui.EmitToUI(types.MessageTypeUserEnteredChat, string(metadata.Username), metadata.Color)
@@ -230,4 +270,6 @@ func (connection *Connection) HandleServerMessage(msg WSMessage) {
 	default:
 		log.Printf("Received a message with an unknown type: %s\n", msg.Type)
 	}
+
+	return nil
 }
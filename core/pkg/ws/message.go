@@ -11,6 +11,10 @@ import (
 type WSMetadata struct {
 	Username string `json:"username"`
 	Color    string `json:"color"`
+	Device   string `json:"device,omitempty"`
+
+	// Only in the `current_users` snapshot
+	Presence *types.Presence `json:"presence,omitempty"`
 }
 
 type WSMessage struct {
@@ -18,6 +22,10 @@ type WSMessage struct {
 	Value    []byte            `json:"value"`
 	Nonce    []byte            `json:"nonce"`
 	Metadata WSMetadata        `json:"metadata"`
+
+	// Id of the chat message inside, when the server can't read it
+	// (group messages and mail) but has to acknowledge it
+	ID string `json:"id,omitempty"`
 }
 
 // This function panics if marshalling goes wrong
@@ -2,4 +2,5 @@ export const COLORS = {
   tuiMessage: "#7ee787",
   userMessage: "#79c0ff",
   timestamp: "#8b949e",
+  directMessage: "#d2a8ff",
 };
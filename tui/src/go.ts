@@ -6,12 +6,30 @@ import {
   type TUIMessage,
 } from "./types/shared-types";
 import { EventHandler } from "./singletons/event-handler";
+import {
+  deleteMessage,
+  editMessage,
+  setReactions,
+  updateMessageStatus,
+} from "./message";
+import { COLORS } from "./constants";
 import {
   addConnectedUser,
   addMultipleConnectedUsers,
   removeConnectedUser,
+  renameConnectedUser,
   State,
+  updateConnectedUser,
 } from "./singletons/state";
+import type {
+  AccountResult,
+  GoingAway,
+  Moderation,
+  Presence,
+  RoomInfo,
+  Typing,
+  UserRenamed,
+} from "./types/generated-types";
 
 let goProcess:
   | ChildProcessByStdio<Stream.Writable, Stream.Readable, Stream.Readable>
@@ -21,9 +39,56 @@ const addMessage = (message: Omit<TUIMessage, "timestamp">) => {
   EventHandler().notify("add_message", { ...message });
 };
 
+const withReason = (text: string, reason?: string): string =>
+  reason ? `${text} (${reason}).` : `${text}.`;
+
+// "alice muted bob until 10:30 (spam)." for moderation events
+const moderationText = (event: Moderation): string => {
+  const { actor, target, value, reason } = event;
+  switch (event.action) {
+    case "kick":
+      return withReason(`${actor} kicked ${target}`, reason);
+    case "ban":
+      return withReason(`${actor} banned ${target}`, reason);
+    case "ban_ip":
+      return withReason(`${actor} banned ${target} and their address`, reason);
+    case "unban":
+      return `${actor} unbanned ${target}.`;
+    case "mute": {
+      const until = new Date(event.until).toLocaleTimeString();
+      return withReason(`${actor} muted ${target} until ${until}`, reason);
+    }
+    case "unmute":
+      return `${actor} unmuted ${target}.`;
+    case "topic":
+      return value
+        ? `${actor} set the topic of ${event.room}: ${value}`
+        : `${actor} cleared the topic of ${event.room}.`;
+    case "role":
+      return `${actor} made ${target} a ${value}.`;
+    case "invite_only":
+      return `${actor} turned invite-only ${value} for ${event.room}.`;
+    case "invite":
+      return `${actor} invited ${target} to ${event.room}.`;
+    case "uninvite":
+      return `${actor} withdrew the invite of ${target} to ${event.room}.`;
+  }
+
+  return `${actor} did ${event.action} in ${event.room}.`;
+};
+
+// "sender: body" for chat messages
+const chatText = (message: TUIGoCommunication): string => {
+  if (!message.message) {
+    return message.value;
+  }
+
+  return `${message.message.sender}: ${message.message.body}`;
+};
+
 export function setupGo(): void {
-  // start go client
-  goProcess = spawn("../core/client", [], {
+  // start go client (our own arguments, like `-link`, are passed along)
+  goProcess = spawn("../core/client", process.argv.slice(2), {
     stdio: ["pipe", "pipe", "pipe"],
   });
 
@@ -87,6 +152,20 @@ export function setupGo(): void {
 
           break;
         }
+        case "fips": {
+          addMessage({
+            ...tuiMessage,
+            text: `${message.value}.`,
+          });
+          break;
+        }
+        case "transport": {
+          addMessage({
+            ...tuiMessage,
+            text: `Connection secured with ${message.value}.`,
+          });
+          break;
+        }
         case "keys_exchanged": {
           addMessage({
             ...tuiMessage,
@@ -95,12 +174,225 @@ export function setupGo(): void {
           break;
         }
         case "message": {
+          addMessage({
+            ...tuiMessage,
+            text: chatText(message),
+            id: message.message?.id,
+            replyTo: message.message?.reply_to,
+          });
+          // It is on screen as soon as it arrives
+          if (message.message) {
+            sendToGo("message_seen", message.message.id);
+          }
+          break;
+        }
+        case "message_edited": {
+          const edit = message.message;
+          if (edit?.target) {
+            // Our own messages are shown without the sender
+            const own = edit.sender === State.username;
+            editMessage(edit.target, own ? edit.body : chatText(message));
+          }
+          break;
+        }
+        case "message_deleted": {
+          if (message.message?.target) {
+            deleteMessage(message.message.target);
+          }
+          break;
+        }
+        case "message_reactions": {
+          try {
+            setReactions(JSON.parse(message.value));
+          } catch (err) {
+            console.error("Failed to parse reactions:", err);
+          }
+          break;
+        }
+        case "message_status": {
+          try {
+            updateMessageStatus(JSON.parse(message.value));
+          } catch (err) {
+            console.error("Failed to parse message status:", err);
+          }
+          break;
+        }
+        case "direct_message": {
+          const dm = message.message;
+          if (!dm) break;
+
+          // Sent to us, or by another device of ours
+          const own = dm.sender === State.username;
+          addMessage({
+            ...tuiMessage,
+            text: own ? dm.body : chatText(message),
+            isSent: own,
+            color: own ? COLORS.userMessage : message.color,
+            id: dm.id,
+            conversation: own ? dm.recipient : dm.sender,
+          });
+          if (!own) {
+            sendToGo("message_seen", dm.id);
+          }
+          break;
+        }
+        case "own_message": {
+          // Sent by another device of ours
           addMessage({
             ...tuiMessage,
             text: message.value,
+            isSent: true,
+            color: COLORS.userMessage,
+            id: message.message?.id,
+            replyTo: message.message?.reply_to,
+          });
+          break;
+        }
+        case "device_pending": {
+          addMessage({
+            ...tuiMessage,
+            text: `Waiting for one of your devices to approve this one (${message.value})...`,
+          });
+          break;
+        }
+        case "device_approval_request": {
+          addMessage({
+            ...tuiMessage,
+            text: `Device ${message.value} wants to connect as you. Type /approve ${message.value} or /deny ${message.value}.`,
+          });
+          break;
+        }
+        case "device_list": {
+          addMessage({
+            ...tuiMessage,
+            text: `Your devices: ${message.value}`,
+          });
+          break;
+        }
+        case "recovery_phrase": {
+          addMessage({
+            ...tuiMessage,
+            text: `Recovery phrase (write it down and keep it secret): ${message.value}. It only brings back your keys: on another machine, log in to your account (or have one of your devices approve it) to be you again.`,
+          });
+          break;
+        }
+        case "identity_restored": {
+          addMessage({
+            ...tuiMessage,
+            text: "Identity restored from the recovery phrase.",
+          });
+          break;
+        }
+        case "sas_request": {
+          addMessage({
+            ...tuiMessage,
+            text: `${message.value} wants to verify your keys.`,
+          });
+          break;
+        }
+        case "sas_code": {
+          addMessage({
+            ...tuiMessage,
+            text: `Verification ${message.value}. Compare the emojis with the other user, then type /match or /mismatch followed by the verification id.`,
           });
           break;
         }
+        case "sas_verified": {
+          addMessage({
+            ...tuiMessage,
+            text: `Keys of ${message.value} verified.`,
+          });
+          break;
+        }
+        case "sas_cancel": {
+          addMessage({
+            ...tuiMessage,
+            text: `Verification cancelled ${message.value}.`,
+          });
+          break;
+        }
+        case "history_unlocked": {
+          addMessage({
+            ...tuiMessage,
+            text: `History unlocked (${message.value} messages).`,
+          });
+          break;
+        }
+        case "history_results": {
+          try {
+            const page = JSON.parse(message.value) as {
+              page: number;
+              pages: number;
+              total: number;
+              entries: Array<{ from: string; text: string; time: string }>;
+            };
+            const lines = page.entries.map(
+              (entry) =>
+                `[${new Date(entry.time).toLocaleString()}] ${entry.from}: ${entry.text}`,
+            );
+            addMessage({
+              ...tuiMessage,
+              text: [
+                `Page ${page.page}/${Math.max(page.pages, 1)} (${page.total} results)`,
+                ...lines,
+              ].join("\n"),
+            });
+          } catch (err) {
+            console.error("Failed to parse history results:", err);
+          }
+          break;
+        }
+        case "key_transparency_alert": {
+          addMessage({
+            ...tuiMessage,
+            text: `Key transparency alert: ${message.value}`,
+          });
+          break;
+        }
+        case "group_epoch": {
+          addMessage({
+            ...tuiMessage,
+            text: `Group key updated (epoch ${message.value}).`,
+          });
+          break;
+        }
+        case "error": {
+          addMessage({
+            ...tuiMessage,
+            text: `Error: ${message.value}`,
+          });
+          break;
+        }
+        case "going_away": {
+          try {
+            const goingAway = JSON.parse(message.value) as GoingAway;
+            addMessage({
+              ...tuiMessage,
+              text: `${goingAway.reason} Connecting again in ${goingAway.retry_after}s or so.`,
+            });
+          } catch (err) {
+            console.error("Failed to parse going away:", err);
+          }
+          break;
+        }
+        case "announcement": {
+          addMessage({
+            ...tuiMessage,
+            text: `📣 ${message.value}`,
+          });
+          break;
+        }
+        case "mail": {
+          addMessage({
+            ...tuiMessage,
+            text: `✉ ${chatText(message)}`,
+            id: message.message?.id,
+          });
+          if (message.message) {
+            sendToGo("message_seen", message.message.id);
+          }
+          break;
+        }
         case "user_entered_chat": {
           addConnectedUser({ username: message.value, color: message.color });
           EventHandler().notify("update_users_panel", {});
@@ -114,6 +406,102 @@ export function setupGo(): void {
           EventHandler().notify("update_users_panel", {});
           break;
         }
+        case "user_renamed": {
+          try {
+            const renamed = JSON.parse(message.value) as UserRenamed;
+            const who =
+              renamed.username === State.username
+                ? "You are"
+                : `${renamed.username} is`;
+            addMessage({
+              ...tuiMessage,
+              text:
+                renamed.new_username === renamed.username
+                  ? `${renamed.username} changed color.`
+                  : `${who} now known as ${renamed.new_username}.`,
+            });
+
+            if (renamed.username === State.username) {
+              // We connect again under the new name
+              State.username = renamed.new_username;
+              State.userColor = renamed.color;
+              EventHandler().notify("update_current_user_text", {});
+            } else {
+              renameConnectedUser(renamed);
+              EventHandler().notify("update_users_panel", {});
+            }
+          } catch (err) {
+            console.error("Failed to parse rename:", err);
+          }
+          break;
+        }
+        case "moderation": {
+          try {
+            const event = JSON.parse(message.value) as Moderation;
+            addMessage({ ...tuiMessage, text: moderationText(event) });
+          } catch (err) {
+            console.error("Failed to parse moderation:", err);
+          }
+          break;
+        }
+        case "room_info": {
+          try {
+            const info = JSON.parse(message.value) as RoomInfo;
+            const roles = Object.entries(info.roles ?? {})
+              .map(([username, role]) => `${username} (${role})`)
+              .join(", ");
+            const lines = [
+              info.topic
+                ? `Topic of ${info.room}: ${info.topic}`
+                : `${info.room} has no topic.`,
+            ];
+            if (roles) lines.push(`Run by ${roles}.`);
+            if (info.invite_only) lines.push(`${info.room} is invite-only.`);
+            addMessage({ ...tuiMessage, text: lines.join(" ") });
+          } catch (err) {
+            console.error("Failed to parse room info:", err);
+          }
+          break;
+        }
+        case "logged_in": {
+          try {
+            const result = JSON.parse(message.value) as AccountResult;
+            addMessage({
+              ...tuiMessage,
+              text: result.registered
+                ? `Registered as ${result.username}.`
+                : `Logged in as ${result.username}.`,
+            });
+          } catch (err) {
+            console.error("Failed to parse account result:", err);
+          }
+          break;
+        }
+        case "presence": {
+          try {
+            const presence = JSON.parse(message.value) as Presence;
+            if (presence.username === State.username) {
+              State.userPresence = presence;
+              EventHandler().notify("update_current_user_text", {});
+            } else {
+              updateConnectedUser(presence.username, { presence });
+              EventHandler().notify("update_users_panel", {});
+            }
+          } catch (err) {
+            console.error("Failed to parse status:", err);
+          }
+          break;
+        }
+        case "typing": {
+          try {
+            const typing = JSON.parse(message.value) as Typing;
+            updateConnectedUser(typing.username, { typing: typing.typing });
+            EventHandler().notify("update_users_panel", {});
+          } catch (err) {
+            console.error("Failed to parse typing indicator:", err);
+          }
+          break;
+        }
         case "current_users": {
           let users: Array<ConnectedUser> = [];
           try {
@@ -138,7 +526,10 @@ export function setupGo(): void {
 }
 
 // We talk to the go process via stdin/stdout
-export function sendToGo(type: "connect" | "send", message: string) {
+export function sendToGo(
+  type: "connect" | "send" | "message_seen" | "user_typing",
+  message: string,
+) {
   if (!goProcess) return;
 
   const msg = {
@@ -12,7 +12,7 @@ import {
   updateUsersPanel,
 } from "./ui";
 import { sendToGo, setupGo } from "./go";
-import { addMessage, isMessage } from "./message";
+import { addMessage, hideSecrets, isMessage } from "./message";
 import { State } from "./singletons/state";
 import { setupKeyInputs } from "./key-listener";
 import { EventHandler } from "./singletons/event-handler";
@@ -90,7 +90,7 @@ function sendMessage(): void {
   if (!State.currentInput.trim()) return;
 
   addMessage({
-    text: State.currentInput,
+    text: hideSecrets(State.currentInput),
     isSent: true,
     color: COLORS.userMessage,
   });
@@ -1,5 +1,6 @@
 import { EventHandler } from "./singletons/event-handler";
 import { State } from "./singletons/state";
+import { sendToGo } from "./go";
 
 export function setupKeyInputs() {
   if (!State.renderer) return;
@@ -66,6 +67,9 @@ export function setupKeyInputs() {
         State.currentInput.slice(State.inputCursorPosition);
       State.inputCursorPosition++;
       eventHandler.notify("update_input_bar");
+
+      // The Go client decides when to tell others we are typing
+      sendToGo("user_typing", "");
     }
   });
 }
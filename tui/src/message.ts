@@ -1,4 +1,9 @@
 import type { TUIMessage } from "./types/shared-types";
+import type {
+  MessageReactions,
+  MessageStatus,
+  MessageStatusUpdate,
+} from "./types/generated-types";
 import { EventHandler } from "./singletons/event-handler";
 import { State } from "./singletons/state";
 
@@ -18,6 +23,26 @@ export function isMessage(
   );
 }
 
+// Commands carrying a password (or passphrase) are shown without it
+export function hideSecrets(text: string): string {
+  const [command = ""] = text.split(" ", 1);
+  const args = text.slice(command.length).trim();
+
+  switch (command) {
+    case "/unlock":
+      return `${command} ********`;
+    case "/login":
+    case "/register": {
+      const username = args.startsWith('"')
+        ? args.slice(0, args.indexOf('"', 1) + 1)
+        : args.split(" ", 1)[0];
+      return `${command} ${username} ********`;
+    }
+    default:
+      return text;
+  }
+}
+
 export function addMessage(msg: Omit<TUIMessage, "timestamp">): void {
   State.messages.push({
     ...msg,
@@ -31,3 +56,76 @@ export function addMessage(msg: Omit<TUIMessage, "timestamp">): void {
 
   EventHandler().notify("update_message_area");
 }
+
+const STATUS_ORDER: Array<MessageStatus> = [
+  "sent",
+  "accepted",
+  "delivered",
+  "read",
+];
+
+// Statuses only move forward (a late ack doesn't undo a read receipt)
+export function updateMessageStatus(update: MessageStatusUpdate): void {
+  let msg: TUIMessage | undefined;
+
+  if (update.status === "sent" && update.message) {
+    // The first status tells us the id of a message we typed
+    // (replies and direct messages were typed as a /reply or /msg command)
+    const { body, reply_to: replyTo, recipient } = update.message;
+    msg = State.messages.findLast(
+      (m) =>
+        m.isSent &&
+        m.id === undefined &&
+        (m.text === body ||
+          (!!replyTo && m.text === `/reply ${body}`) ||
+          (!!recipient &&
+            m.text.startsWith("/msg ") &&
+            m.text.endsWith(` ${body}`))),
+    );
+    if (msg) {
+      msg.id = update.id;
+      msg.text = body;
+      msg.replyTo = replyTo;
+      msg.conversation = recipient;
+    }
+  } else {
+    msg = State.messages.find((m) => m.id === update.id);
+  }
+
+  if (!msg) return;
+
+  const current = msg.status ? STATUS_ORDER.indexOf(msg.status) : -1;
+  if (STATUS_ORDER.indexOf(update.status) > current) {
+    msg.status = update.status;
+    EventHandler().notify("update_message_area");
+  }
+}
+
+// Edited by its sender: `text` is what we show for it now
+export function editMessage(id: string, text: string): void {
+  const msg = State.messages.find((m) => m.id === id);
+  if (!msg) return;
+
+  msg.text = text;
+  msg.edited = true;
+  EventHandler().notify("update_message_area");
+}
+
+export function deleteMessage(id: string): void {
+  const index = State.messages.findIndex((m) => m.id === id);
+  if (index === -1) return;
+
+  State.messages.splice(index, 1);
+  EventHandler().notify("update_message_area");
+}
+
+// e.g. "👍 2 🎉 1", most used first
+export function setReactions(update: MessageReactions): void {
+  const msg = State.messages.find((m) => m.id === update.id);
+  if (!msg) return;
+
+  msg.reactions = update.reactions
+    .map((reaction) => `${reaction.emoji} ${reaction.count}`)
+    .join(" ");
+  EventHandler().notify("update_message_area");
+}
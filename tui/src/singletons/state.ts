@@ -1,5 +1,6 @@
 import type { CliRenderer } from "@opentui/core";
 import type { TUIMessage, ConnectedUser } from "../types/shared-types";
+import type { Presence, UserRenamed } from "../types/generated-types";
 
 type ConnectedUserKey = string;
 
@@ -11,6 +12,7 @@ type StateType = {
   connectedUsers: Map<ConnectedUserKey, ConnectedUser>;
   username: string;
   userColor: string;
+  userPresence: Presence | undefined;
   isConnected: boolean;
 };
 
@@ -22,6 +24,7 @@ export const State: StateType = {
   connectedUsers: new Map(),
   username: "",
   userColor: "",
+  userPresence: undefined,
   isConnected: false,
 };
 
@@ -32,6 +35,7 @@ export function ClearState(): void {
   State.connectedUsers = new Map();
   State.username = "";
   State.userColor = "";
+  State.userPresence = undefined;
   State.isConnected = false;
 }
 
@@ -55,3 +59,30 @@ export function removeConnectedUser(user: ConnectedUser): void {
 
   State.connectedUsers.delete(key(user));
 }
+
+// Presence and typing indicators only know the username
+export function updateConnectedUser(
+  username: string,
+  change: Partial<ConnectedUser>,
+): void {
+  for (const user of State.connectedUsers.values()) {
+    if (user.username === username) {
+      Object.assign(user, change);
+    }
+  }
+}
+
+// A user changed its username or color (the key of the map depends on both)
+export function renameConnectedUser(renamed: UserRenamed): void {
+  for (const [userKey, user] of State.connectedUsers) {
+    if (user.username !== renamed.username) continue;
+
+    State.connectedUsers.delete(userKey);
+    const updated = {
+      ...user,
+      username: renamed.new_username,
+      color: renamed.color,
+    };
+    State.connectedUsers.set(key(updated), updated);
+  }
+}
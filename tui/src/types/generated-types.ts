@@ -11,20 +11,280 @@ export const MessageTypeDisconnected = "disconnected";
 export const MessageTypeReconnecting = "reconnecting";
 export const MessageTypeKeysExchanged = "keys_exchanged";
 export const MessageTypeMessage = "message";
+export const MessageTypeTransport = "transport";
+export const MessageTypeFIPS = "fips";
+export const MessageTypeKeyTransparencyAlert = "key_transparency_alert";
+export const MessageTypeMail = "mail";
+export const MessageTypeGroupEpoch = "group_epoch";
+export const MessageTypeOwnMessage = "own_message";
+export const MessageTypeRecoveryPhrase = "recovery_phrase";
+export const MessageTypeIdentityRestored = "identity_restored";
+export const MessageTypeHistoryUnlocked = "history_unlocked";
+export const MessageTypeHistoryResults = "history_results";
+export const MessageTypeMessageStatus = "message_status";
+export const MessageTypeMessageEdited = "message_edited";
+export const MessageTypeMessageDeleted = "message_deleted";
+export const MessageTypeMessageReactions = "message_reactions";
+export const MessageTypeDirectMessage = "direct_message";
+export const MessageTypeLoggedIn = "logged_in";
 /**
  * Go <-> Go (ws) and Go to TUI
  */
+export const MessageTypeError = "error";
 export const MessageTypeUserEnteredChat = "user_entered_chat";
 export const MessageTypeUserLeftChat = "user_left_chat";
 export const MessageTypeCurrentUsers = "current_users";
+export const MessageTypeTyping = "typing";
+export const MessageTypePresence = "presence";
+export const MessageTypeUserRenamed = "user_renamed";
+export const MessageTypeModeration = "moderation";
+export const MessageTypeRoomInfo = "room_info";
+export const MessageTypeAnnouncement = "announcement";
+export const MessageTypeGoingAway = "going_away";
 /**
  * Go <-> Go (ws)
  */
 export const MessageTypeExchangeKeys = "exchange_keys";
 export const MessageTypeEncryptedMessage = "encrypted_message";
+export const MessageTypeMessageAck = "message_ack";
+export const MessageTypeMessageReceipt = "message_receipt";
+export const MessageTypeProfileChange = "profile_change";
+export const MessageTypeReconnectToken = "reconnect_token";
+export const MessageTypeAccountRegister = "account_register";
+export const MessageTypeAccountLogin = "account_login";
+export const MessageTypeAccountResult = "account_result";
+export const MessageTypeModerate = "moderate";
+/**
+ * Key transparency
+ */
+export const MessageTypeKeyPublished = "key_published";
+export const MessageTypeKeyLogTreeHead = "key_log_tree_head";
+export const MessageTypeKeyLogConsistency = "key_log_consistency";
+/**
+ * Prekeys (messages to offline users)
+ */
+export const MessageTypePrekeyUpload = "prekey_upload";
+export const MessageTypePrekeyBundle = "prekey_bundle";
+export const MessageTypePrekeysLow = "prekeys_low";
+export const MessageTypePrekeyMessage = "prekey_message";
+export const MessageTypeSealedMessage = "sealed_message";
+/**
+ * Group key agreement (TreeKEM)
+ */
+export const MessageTypeGroupKeyPackage = "group_key_package";
+export const MessageTypeGroupCreate = "group_create";
+export const MessageTypeGroupProposals = "group_proposals";
+export const MessageTypeGroupCommit = "group_commit";
+export const MessageTypeGroupCommitRejected = "group_commit_rejected";
+export const MessageTypeGroupWelcome = "group_welcome";
+export const MessageTypeGroupMessage = "group_message";
+export const MessageTypeHistoryCopy = "history_copy";
+/**
+ * Devices (Go <-> Go (ws) and Go to TUI)
+ */
+export const MessageTypeDevicePending = "device_pending";
+export const MessageTypeDeviceApprovalRequest = "device_approval_request";
+export const MessageTypeDeviceApproval = "device_approval";
+export const MessageTypeDeviceList = "device_list";
+export const MessageTypeDeviceRemove = "device_remove";
+/**
+ * Short authentication string verification (Go <-> Go (ws) and Go to TUI)
+ */
+export const MessageTypeSASRequest = "sas_request";
+export const MessageTypeSASAccept = "sas_accept";
+export const MessageTypeSASReveal = "sas_reveal";
+export const MessageTypeSASCode = "sas_code";
+export const MessageTypeSASConfirm = "sas_confirm";
+export const MessageTypeSASVerified = "sas_verified";
+export const MessageTypeSASCancel = "sas_cancel";
 /**
  * TUI to Go
  */
 export const MessageTypeConnect = "connect";
 export const MessageTypeSend = "send";
-export type MessageType = typeof MessageTypeConnected | typeof MessageTypeDisconnected | typeof MessageTypeReconnecting | typeof MessageTypeKeysExchanged | typeof MessageTypeMessage | typeof MessageTypeUserEnteredChat | typeof MessageTypeUserLeftChat | typeof MessageTypeCurrentUsers | typeof MessageTypeExchangeKeys | typeof MessageTypeEncryptedMessage | typeof MessageTypeConnect | typeof MessageTypeSend;
+export const MessageTypeExportIdentity = "export_identity";
+export const MessageTypeRestoreIdentity = "restore_identity";
+export const MessageTypeHistoryUnlock = "history_unlock";
+export const MessageTypeHistorySearch = "history_search";
+export const MessageTypeMessageSeen = "message_seen";
+export const MessageTypeEditMessage = "edit_message";
+export const MessageTypeDeleteMessage = "delete_message";
+export const MessageTypeReplyMessage = "reply_message";
+export const MessageTypeReact = "react";
+export const MessageTypeUnreact = "unreact";
+export const MessageTypeUserTyping = "user_typing";
+export const MessageTypeSetStatus = "set_status";
+export type MessageType = typeof MessageTypeConnected | typeof MessageTypeDisconnected | typeof MessageTypeReconnecting | typeof MessageTypeKeysExchanged | typeof MessageTypeMessage | typeof MessageTypeTransport | typeof MessageTypeFIPS | typeof MessageTypeKeyTransparencyAlert | typeof MessageTypeMail | typeof MessageTypeGroupEpoch | typeof MessageTypeOwnMessage | typeof MessageTypeRecoveryPhrase | typeof MessageTypeIdentityRestored | typeof MessageTypeHistoryUnlocked | typeof MessageTypeHistoryResults | typeof MessageTypeMessageStatus | typeof MessageTypeMessageEdited | typeof MessageTypeMessageDeleted | typeof MessageTypeMessageReactions | typeof MessageTypeDirectMessage | typeof MessageTypeLoggedIn | typeof MessageTypeError | typeof MessageTypeUserEnteredChat | typeof MessageTypeUserLeftChat | typeof MessageTypeCurrentUsers | typeof MessageTypeTyping | typeof MessageTypePresence | typeof MessageTypeUserRenamed | typeof MessageTypeModeration | typeof MessageTypeRoomInfo | typeof MessageTypeAnnouncement | typeof MessageTypeGoingAway | typeof MessageTypeExchangeKeys | typeof MessageTypeEncryptedMessage | typeof MessageTypeMessageAck | typeof MessageTypeMessageReceipt | typeof MessageTypeProfileChange | typeof MessageTypeReconnectToken | typeof MessageTypeAccountRegister | typeof MessageTypeAccountLogin | typeof MessageTypeAccountResult | typeof MessageTypeModerate | typeof MessageTypeKeyPublished | typeof MessageTypeKeyLogTreeHead | typeof MessageTypeKeyLogConsistency | typeof MessageTypePrekeyUpload | typeof MessageTypePrekeyBundle | typeof MessageTypePrekeysLow | typeof MessageTypePrekeyMessage | typeof MessageTypeSealedMessage | typeof MessageTypeGroupKeyPackage | typeof MessageTypeGroupCreate | typeof MessageTypeGroupProposals | typeof MessageTypeGroupCommit | typeof MessageTypeGroupCommitRejected | typeof MessageTypeGroupWelcome | typeof MessageTypeGroupMessage | typeof MessageTypeHistoryCopy | typeof MessageTypeDevicePending | typeof MessageTypeDeviceApprovalRequest | typeof MessageTypeDeviceApproval | typeof MessageTypeDeviceList | typeof MessageTypeDeviceRemove | typeof MessageTypeSASRequest | typeof MessageTypeSASAccept | typeof MessageTypeSASReveal | typeof MessageTypeSASCode | typeof MessageTypeSASConfirm | typeof MessageTypeSASVerified | typeof MessageTypeSASCancel | typeof MessageTypeConnect | typeof MessageTypeSend | typeof MessageTypeExportIdentity | typeof MessageTypeRestoreIdentity | typeof MessageTypeHistoryUnlock | typeof MessageTypeHistorySearch | typeof MessageTypeMessageSeen | typeof MessageTypeEditMessage | typeof MessageTypeDeleteMessage | typeof MessageTypeReplyMessage | typeof MessageTypeReact | typeof MessageTypeUnreact | typeof MessageTypeUserTyping | typeof MessageTypeSetStatus;
+export const ContentTypeText = "text/plain";
+export type ContentType = typeof ContentTypeText;
+/**
+ * What a chat message carries inside its ciphertext, whichever way it
+ * travels (relayed by the server, to the group or as mail)
+ */
+export interface ChatMessage {
+  version: number /* int */;
+  id: string;
+  sender: string;
+  room: string; // empty for mail and direct messages
+  recipient?: string; // only for direct messages
+  timestamp: string /* RFC3339 */;
+  body: string;
+  reply_to?: string;
+  content_type: ContentType;
+  action?: ChatAction; // if set, the message changes `Target`
+  target?: string;
+}
+export const ChatActionEdit = "edit";
+export const ChatActionDelete = "delete";
+export const ChatActionReact = "react";
+export const ChatActionUnreact = "unreact";
+export type ChatAction = typeof ChatActionEdit | typeof ChatActionDelete | typeof ChatActionReact | typeof ChatActionUnreact;
+/**
+ * Asks the Go client to edit one of our messages
+ */
+export interface MessageEdit {
+  id: string;
+  body: string;
+}
+/**
+ * Asks the Go client to reply to the message `ID`
+ */
+export interface MessageReply {
+  id: string;
+  body: string;
+}
+/**
+ * Asks the Go client to add (or remove) a reaction to the message `ID`
+ */
+export interface MessageReaction {
+  id: string;
+  emoji: string;
+}
+/**
+ * How many people reacted to a message with an emoji
+ */
+export interface ReactionCount {
+  emoji: string;
+  count: number /* int */;
+  mine: boolean; // we are one of them
+}
+/**
+ * Every reaction to the message `ID`, most used first
+ */
+export interface MessageReactions {
+  id: string;
+  reactions: []ReactionCount;
+}
+export const MessageStatusSent = "sent";
+export const MessageStatusAccepted = "accepted";
+export const MessageStatusDelivered = "delivered";
+export const MessageStatusRead = "read";
+export type MessageStatus = typeof MessageStatusSent | typeof MessageStatusAccepted | typeof MessageStatusDelivered | typeof MessageStatusRead;
+/**
+ * Status update of a message we sent. The first one ("sent") also
+ * carries the message itself, for the UI to know its id.
+ */
+export interface MessageStatusUpdate {
+  id: string;
+  status: MessageStatus;
+  by?: string; // recipient, for receipts
+  message?: ChatMessage;
+}
+export const PresenceOnline = "online";
+export const PresenceAway = "away";
+export const PresenceBusy = "busy";
+export type PresenceStatus = typeof PresenceOnline | typeof PresenceAway | typeof PresenceBusy;
+/**
+ * Status set by a user (or automatically, after some idle time)
+ */
+export interface Presence {
+  username: string;
+  status: PresenceStatus;
+  text?: string; // custom status, e.g. "in a meeting"
+}
+/**
+ * A user started (or stopped) typing in the room
+ */
+export interface Typing {
+  username: string;
+  typing: boolean;
+}
+/**
+ * Asks the server for a new username and/or color (empty fields don't change)
+ */
+export interface ProfileChange {
+  username?: string;
+  color?: string;
+}
+/**
+ * A user changed its username (`NewUsername` is then different) or its color
+ */
+export interface UserRenamed {
+  username: string;
+  new_username: string;
+  color: string;
+}
+/**
+ * Username and password of a registered user
+ */
+export interface Credentials {
+  username: string;
+  password: string;
+}
+/**
+ * The device registered or logged in as `Username`. It connects again
+ * with `Token` as its reconnect token if it wasn't connected as them yet.
+ */
+export interface AccountResult {
+  username: string;
+  color: string;
+  registered: boolean; // a new account, not a login
+  token?: string;
+}
+export const RoomRoleOwner = "owner";
+export const RoomRoleModerator = "moderator";
+export const RoomRoleMember = "member";
+export type RoomRole = typeof RoomRoleOwner | typeof RoomRoleModerator | typeof RoomRoleMember;
+export const ModerationKick = "kick";
+export const ModerationBan = "ban";
+export const ModerationBanIP = "ban_ip";
+export const ModerationUnban = "unban";
+export const ModerationMute = "mute";
+export const ModerationUnmute = "unmute";
+export const ModerationTopic = "topic";
+export const ModerationRole = "role";
+export const ModerationInviteOnly = "invite_only";
+export const ModerationInvite = "invite";
+export const ModerationUninvite = "uninvite";
+export type ModerationAction = typeof ModerationKick | typeof ModerationBan | typeof ModerationBanIP | typeof ModerationUnban | typeof ModerationMute | typeof ModerationUnmute | typeof ModerationTopic | typeof ModerationRole | typeof ModerationInviteOnly | typeof ModerationInvite | typeof ModerationUninvite;
+/**
+ * Something done to a room (or to one of its members). Asked by a client,
+ * and announced to the members once the server allowed and applied it.
+ */
+export interface Moderation {
+  room: string;
+  action: ModerationAction;
+  actor?: string; // set by the server
+  target?: string;
+  value?: string;
+  reason?: string;
+  duration?: number /* int64 */; // of a mute, in seconds
+  until: string /* RFC3339 */; // end of a mute, set by the server
+}
+/**
+ * What a member needs to know about a room when it connects
+ */
+export interface RoomInfo {
+  room: string;
+  topic?: string;
+  invite_only: boolean;
+  roles: map[string]RoomRole; // owners and moderators, by username
+  muted: map[string]time.Time; // until when, by username
+}
+/**
+ * The server is going away (shutting down or draining)
+ */
+export interface GoingAway {
+  reason: string;
+  retry_after: number /* int64 */; // seconds to wait before connecting again
+}
@@ -1,14 +1,23 @@
-import type { MessageType } from "./generated-types";
+import type {
+  ChatMessage,
+  MessageStatus,
+  MessageType,
+  Presence,
+} from "./generated-types";
 
 export type TUIGoCommunication = {
   type: MessageType;
   value: string;
   color: string;
+  // Only for chat messages, `value` is then their body
+  message?: ChatMessage;
 };
 
 export type ConnectedUser = {
   username: string;
   color: string;
+  presence?: Presence;
+  typing?: boolean;
 };
 
 export type TUIMessage = {
@@ -16,4 +25,12 @@ export type TUIMessage = {
   isSent: boolean;
   timestamp: Date;
   color: string;
+  // Chat messages only
+  id?: string;
+  status?: MessageStatus;
+  edited?: boolean;
+  replyTo?: string;
+  reactions?: string;
+  // The other user of a direct message
+  conversation?: string;
 };
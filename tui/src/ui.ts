@@ -6,6 +6,45 @@ import {
 } from "@opentui/core";
 import { ClearState, State } from "./singletons/state";
 import { COLORS } from "./constants";
+import type {
+  MessageStatus,
+  Presence,
+  PresenceStatus,
+} from "./types/generated-types";
+
+// How much of a message is quoted above its replies
+const REPLY_QUOTE_LENGTH = 40;
+
+// Shown before the names of the users
+const PRESENCE_MARKS: Record<PresenceStatus, string> = {
+  online: "●",
+  away: "◐",
+  busy: "⊘",
+};
+
+// e.g. "◐ Amazing Koala (lunch)"
+function presenceNodes(
+  username: string,
+  color: string,
+  presence?: Presence,
+): TextNodeRenderable[] {
+  const mark = PRESENCE_MARKS[presence?.status ?? "online"];
+  const text = presence?.text ? ` (${presence.text})` : "";
+
+  return [
+    TextNodeRenderable.fromString(`${mark} `, { fg: color }),
+    TextNodeRenderable.fromString(username, { fg: color }),
+    TextNodeRenderable.fromString(text, { fg: COLORS.timestamp }),
+  ];
+}
+
+// Shown after the messages we sent
+const STATUS_MARKS: Record<MessageStatus, string> = {
+  sent: "·",
+  accepted: "✓",
+  delivered: "✓✓",
+  read: "✓✓ read",
+};
 
 let messageArea: ScrollBoxRenderable | null = null;
 let usersPanel: TextRenderable | null = null;
@@ -32,6 +71,29 @@ export function updateMessageArea(): void {
       minute: "2-digit",
     });
 
+    // Replies show (the start of) the message they answer
+    if (msg.replyTo) {
+      const parent = State.messages.find((m) => m.id === msg.replyTo);
+      const quote = parent
+        ? parent.text.slice(0, REPLY_QUOTE_LENGTH)
+        : "an older message";
+      messageNodes.push(
+        TextNodeRenderable.fromString(`      ↳ ${quote}\n`, {
+          fg: COLORS.timestamp,
+        }),
+      );
+    }
+
+    // Direct messages are tagged with the other user of the conversation
+    if (msg.conversation) {
+      messageNodes.push(
+        TextNodeRenderable.fromString(`[DM ${msg.conversation}] `, {
+          fg: COLORS.directMessage,
+          attributes: 1,
+        }),
+      );
+    }
+
     if (msg.isSent) {
       // Sent message - blue
       const messageNode = TextNodeRenderable.fromNodes([
@@ -41,6 +103,10 @@ export function updateMessageArea(): void {
           attributes: 1,
         }),
         TextNodeRenderable.fromString(msg.text, { fg: msg.color }),
+        TextNodeRenderable.fromString(
+          `${msg.edited ? " (edited)" : ""}${msg.status ? ` ${STATUS_MARKS[msg.status]}` : ""}${msg.reactions ? `  ${msg.reactions}` : ""}`,
+          { fg: COLORS.timestamp },
+        ),
       ]);
       messageNodes.push(messageNode);
     } else {
@@ -48,6 +114,10 @@ export function updateMessageArea(): void {
       const messageNode = TextNodeRenderable.fromNodes([
         TextNodeRenderable.fromString(`${timeStr} `, { fg: COLORS.timestamp }),
         TextNodeRenderable.fromString(msg.text, { fg: msg.color }),
+        TextNodeRenderable.fromString(
+          `${msg.edited ? " (edited)" : ""}${msg.reactions ? `  ${msg.reactions}` : ""}`,
+          { fg: COLORS.timestamp },
+        ),
       ]);
       messageNodes.push(messageNode);
     }
@@ -186,11 +256,9 @@ export function updateUsersPanel(): void {
       if (user.username === State.username) return;
 
       const userNode = TextNodeRenderable.fromNodes([
-        TextNodeRenderable.fromString("● ", {
-          fg: user.color,
-        }),
-        TextNodeRenderable.fromString(user.username, {
-          fg: user.color,
+        ...presenceNodes(user.username, user.color, user.presence),
+        TextNodeRenderable.fromString(user.typing ? " typing..." : "", {
+          fg: COLORS.timestamp,
         }),
       ]);
       userNodes.push(userNode);
@@ -228,14 +296,9 @@ export function updateCurrentUser(): void {
 
   currentUserText.clear();
 
-  const userNode = TextNodeRenderable.fromNodes([
-    TextNodeRenderable.fromString("● ", {
-      fg: State.userColor,
-    }),
-    TextNodeRenderable.fromString(State.username, {
-      fg: State.userColor,
-    }),
-  ]);
+  const userNode = TextNodeRenderable.fromNodes(
+    presenceNodes(State.username, State.userColor, State.userPresence),
+  );
 
   const containerNode = TextNodeRenderable.fromNodes([userNode]);
   currentUserText.add(containerNode);